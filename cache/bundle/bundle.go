@@ -0,0 +1,99 @@
+// Package bundle reads and writes portable archives of cache entries, used to seed one cache from another.
+//
+// A bundle is a tar archive, optionally compressed with zstd. It starts with manifest.json, followed by
+// two files per entry: entries/<key>.json with the entry's metadata and the SHA-256 of its body, and
+// entries/<key>.body with the body as stored in the cache.
+package bundle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"reservoir/cache"
+	"strings"
+	"time"
+)
+
+const (
+	formatVersion = 1
+	manifestName  = "manifest.json"
+	entriesDir    = "entries/"
+	metadataExt   = ".json"
+	bodyExt       = ".body"
+)
+
+var (
+	ErrInvalidBundle    = errors.New("invalid cache bundle")
+	ErrChecksumMismatch = errors.New("cache bundle entry failed its integrity check")
+	ErrUnknownFormat    = errors.New("unknown cache bundle format")
+)
+
+type Format string
+
+const (
+	FormatTar     Format = "tar"
+	FormatTarZstd Format = "tar.zst"
+)
+
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case "", FormatTarZstd:
+		return FormatTarZstd, nil
+	case FormatTar:
+		return FormatTar, nil
+	default:
+		return "", fmt.Errorf("%w '%s', expected %s or %s", ErrUnknownFormat, value, FormatTar, FormatTarZstd)
+	}
+}
+
+func (f Format) ContentType() string {
+	if f == FormatTar {
+		return "application/x-tar"
+	}
+	return "application/zstd"
+}
+
+// Returns the file extension of the format, including the leading dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+type manifest struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+type entryHeader[MetadataT any] struct {
+	Key      string                         `json:"key"`
+	SHA256   string                         `json:"sha256"`
+	Metadata cache.EntryMetadata[MetadataT] `json:"metadata"`
+}
+
+// An entry read from a bundle.
+type Entry[MetadataT any] struct {
+	Key      cache.CacheKey
+	Metadata *cache.EntryMetadata[MetadataT]
+	// Fails with ErrChecksumMismatch once fully read if the body doesn't match its size or checksum.
+	Body io.Reader
+}
+
+func metadataName(key cache.CacheKey) string {
+	return entriesDir + key.Hex + metadataExt
+}
+
+func bodyName(key cache.CacheKey) string {
+	return entriesDir + key.Hex + bodyExt
+}
+
+func keyFromName(name string, ext string) (cache.CacheKey, bool) {
+	hexKey, ok := strings.CutPrefix(name, entriesDir)
+	if !ok {
+		return cache.CacheKey{}, false
+	}
+	hexKey, ok = strings.CutSuffix(hexKey, ext)
+	if !ok {
+		return cache.CacheKey{}, false
+	}
+	key, err := cache.ParseKey(hexKey)
+	return key, err == nil
+}
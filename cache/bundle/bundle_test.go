@@ -0,0 +1,115 @@
+package bundle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"reservoir/cache"
+	"testing"
+	"time"
+)
+
+type testMeta struct {
+	URL string
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func writeTestBundle(t *testing.T, format Format, bodies map[string]string) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	w, err := NewWriter[testMeta](&buf, format)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+	for url, body := range bodies {
+		meta := &cache.EntryMetadata[testMeta]{
+			Expires: time.Now().Add(time.Hour),
+			Size:    int64(len(body)),
+			Pinned:  true,
+			Object:  testMeta{URL: url},
+		}
+		if err := w.Add(cache.FromString(url), meta, sha256Hex([]byte(body)), bytes.NewReader([]byte(body))); err != nil {
+			t.Fatalf("Add failed: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestBundleRoundTrip(t *testing.T) {
+	bodies := map[string]string{
+		"http://deb.debian.org/debian/dists/stable/InRelease": "release",
+		"http://deb.debian.org/debian/pool/a.deb":             "package",
+	}
+
+	for _, format := range []Format{FormatTar, FormatTarZstd} {
+		t.Run(string(format), func(t *testing.T) {
+			data := writeTestBundle(t, format, bodies)
+
+			read := make(map[string]string)
+			err := Read(bytes.NewReader(data), func(entry Entry[testMeta]) error {
+				if entry.Key != cache.FromString(entry.Metadata.Object.URL) {
+					t.Fatalf("unexpected key %s for %s", entry.Key.Hex, entry.Metadata.Object.URL)
+				}
+				if !entry.Metadata.Pinned {
+					t.Fatal("expected metadata to be preserved")
+				}
+				if size, ok := cache.ReaderSizeHint(entry.Body); !ok || size != entry.Metadata.Size {
+					t.Fatalf("expected body size hint %d, got %d", entry.Metadata.Size, size)
+				}
+				body, err := io.ReadAll(entry.Body)
+				if err != nil {
+					return err
+				}
+				read[entry.Metadata.Object.URL] = string(body)
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Read failed: %v", err)
+			}
+			if len(read) != len(bodies) {
+				t.Fatalf("expected %d entries, got %d", len(bodies), len(read))
+			}
+			for url, body := range bodies {
+				if read[url] != body {
+					t.Fatalf("expected body %q for %s, got %q", body, url, read[url])
+				}
+			}
+		})
+	}
+}
+
+func TestBundleDetectsCorruptedBody(t *testing.T) {
+	data := writeTestBundle(t, FormatTar, map[string]string{"http://example.com/file": "original"})
+	corrupted := bytes.Replace(data, []byte("original"), []byte("modified"), 1)
+
+	err := Read(bytes.NewReader(corrupted), func(entry Entry[testMeta]) error {
+		_, err := io.ReadAll(entry.Body)
+		return err
+	})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+
+	// Entries the callback doesn't read are verified too.
+	err = Read(bytes.NewReader(corrupted), func(entry Entry[testMeta]) error { return nil })
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected checksum mismatch for a skipped entry, got %v", err)
+	}
+}
+
+func TestBundleRejectsOtherArchives(t *testing.T) {
+	err := Read(bytes.NewReader([]byte("not a bundle")), func(entry Entry[testMeta]) error { return nil })
+	if !errors.Is(err, ErrInvalidBundle) {
+		t.Fatalf("expected invalid bundle error, got %v", err)
+	}
+}
@@ -0,0 +1,154 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const maxMetadataBytes = 16 << 20
+
+var zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+
+// Reads the entries of a bundle in either format and calls fn for each of them.
+// The body of an entry is only valid until fn returns. Reading stops at the first error.
+func Read[MetadataT any](r io.Reader, fn func(entry Entry[MetadataT]) error) error {
+	buffered := bufio.NewReader(r)
+	magic, _ := buffered.Peek(len(zstdMagic))
+
+	var source io.Reader = buffered
+	if bytes.Equal(magic, zstdMagic) {
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return err
+		}
+		defer decoder.Close()
+		source = decoder
+	}
+
+	tr := tar.NewReader(source)
+	if err := readManifest(tr); err != nil {
+		return err
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		key, ok := keyFromName(header.Name, metadataExt)
+		if !ok {
+			return fmt.Errorf("%w: unexpected file '%s'", ErrInvalidBundle, header.Name)
+		}
+		var entry entryHeader[MetadataT]
+		if err := decodeJSON(tr, header, &entry); err != nil {
+			return err
+		}
+		if entry.Key != key.Hex {
+			return fmt.Errorf("%w: metadata of '%s' belongs to '%s'", ErrInvalidBundle, key.Hex, entry.Key)
+		}
+
+		header, err = tr.Next()
+		if err != nil {
+			return fmt.Errorf("%w: missing body of '%s': %v", ErrInvalidBundle, key.Hex, err)
+		}
+		if bodyKey, ok := keyFromName(header.Name, bodyExt); !ok || bodyKey != key {
+			return fmt.Errorf("%w: expected body of '%s', got '%s'", ErrInvalidBundle, key.Hex, header.Name)
+		}
+		if header.Size != entry.Metadata.Size {
+			return fmt.Errorf("%w: body of '%s' has %d bytes instead of %d", ErrChecksumMismatch, key.Hex, header.Size, entry.Metadata.Size)
+		}
+
+		body := newVerifyingReader(tr, header.Size, entry.SHA256)
+		if err := fn(Entry[MetadataT]{Key: key, Metadata: &entry.Metadata, Body: body}); err != nil {
+			return err
+		}
+		// Entries that fn skipped still have to be intact.
+		if _, err := io.Copy(io.Discard, body); err != nil {
+			return err
+		}
+	}
+}
+
+func readManifest(tr *tar.Reader) error {
+	header, err := tr.Next()
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	if header.Name != manifestName {
+		return fmt.Errorf("%w: expected %s first, got '%s'", ErrInvalidBundle, manifestName, header.Name)
+	}
+
+	var m manifest
+	if err := decodeJSON(tr, header, &m); err != nil {
+		return err
+	}
+	if m.Version != formatVersion {
+		return fmt.Errorf("%w: unsupported version %d", ErrInvalidBundle, m.Version)
+	}
+	return nil
+}
+
+func decodeJSON(tr *tar.Reader, header *tar.Header, value any) error {
+	if header.Size > maxMetadataBytes {
+		return fmt.Errorf("%w: '%s' is too large", ErrInvalidBundle, header.Name)
+	}
+	if err := json.NewDecoder(tr).Decode(value); err != nil {
+		return fmt.Errorf("%w: failed to decode '%s': %v", ErrInvalidBundle, header.Name, err)
+	}
+	return nil
+}
+
+// Reads an entry body, failing at its end if the size or SHA-256 don't match.
+type verifyingReader struct {
+	reader   io.Reader
+	hasher   hash.Hash
+	expected string
+	size     int64
+	read     int64
+	err      error
+}
+
+func newVerifyingReader(r io.Reader, size int64, sha256Hex string) *verifyingReader {
+	return &verifyingReader{reader: r, hasher: sha256.New(), expected: sha256Hex, size: size}
+}
+
+func (r *verifyingReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	r.hasher.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		if r.read != r.size {
+			r.err = fmt.Errorf("%w: read %d bytes instead of %d", ErrChecksumMismatch, r.read, r.size)
+		} else if actual := hex.EncodeToString(r.hasher.Sum(nil)); actual != r.expected {
+			r.err = fmt.Errorf("%w: SHA-256 is %s instead of %s", ErrChecksumMismatch, actual, r.expected)
+		} else {
+			r.err = io.EOF
+		}
+		return n, r.err
+	}
+	return n, err
+}
+
+// Lets caches place the body by its size without buffering it first.
+func (r *verifyingReader) SizeHint() int64 {
+	return r.size
+}
@@ -0,0 +1,94 @@
+package bundle
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reservoir/cache"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Writes entries to a bundle. Close has to be called to finish the archive.
+type Writer[MetadataT any] struct {
+	tar  *tar.Writer
+	zstd *zstd.Encoder
+	now  time.Time
+}
+
+func NewWriter[MetadataT any](w io.Writer, format Format) (*Writer[MetadataT], error) {
+	bw := &Writer[MetadataT]{now: time.Now()}
+	switch format {
+	case FormatTar:
+		bw.tar = tar.NewWriter(w)
+	case FormatTarZstd:
+		encoder, err := zstd.NewWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		bw.zstd = encoder
+		bw.tar = tar.NewWriter(encoder)
+	default:
+		return nil, fmt.Errorf("%w '%s'", ErrUnknownFormat, format)
+	}
+
+	if err := bw.writeJSON(manifestName, manifest{Version: formatVersion, Created: bw.now}); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// Adds an entry. The body has to be exactly meta.Size bytes long and hash to sha256Hex.
+func (w *Writer[MetadataT]) Add(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT], sha256Hex string, body io.Reader) error {
+	header := entryHeader[MetadataT]{Key: key.Hex, SHA256: sha256Hex, Metadata: *meta}
+	header.Metadata.ContentHash = "" // Backend specific, the importing cache sets its own.
+	if err := w.writeJSON(metadataName(key), header); err != nil {
+		return err
+	}
+
+	if err := w.tar.WriteHeader(w.fileHeader(bodyName(key), meta.Size)); err != nil {
+		return err
+	}
+	written, err := io.Copy(w.tar, body)
+	if err != nil {
+		return err
+	}
+	if written != meta.Size {
+		return fmt.Errorf("%w: body of '%s' has %d bytes instead of %d", ErrChecksumMismatch, key.Hex, written, meta.Size)
+	}
+	return nil
+}
+
+func (w *Writer[MetadataT]) Close() error {
+	if err := w.tar.Close(); err != nil {
+		return err
+	}
+	if w.zstd != nil {
+		return w.zstd.Close()
+	}
+	return nil
+}
+
+func (w *Writer[MetadataT]) writeJSON(name string, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if err := w.tar.WriteHeader(w.fileHeader(name, int64(len(data)))); err != nil {
+		return err
+	}
+	_, err = w.tar.Write(data)
+	return err
+}
+
+func (w *Writer[MetadataT]) fileHeader(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  w.now,
+	}
+}
@@ -33,6 +33,13 @@ type Cache[MetadataT any] interface {
 	// Modifies the metadata of an entry in the cache.
 	UpdateMetadata(key CacheKey, modifier func(*EntryMetadata[MetadataT])) error
 
+	// Iterates over the entries in the cache, yielding a copy of each entry's metadata.
+	// Entries added or removed during the iteration may or may not be yielded.
+	Entries(yield func(key CacheKey, metadata *EntryMetadata[MetadataT]) bool)
+
+	// Returns the keys of the entries tagged with tag, see Tagged.
+	KeysByTag(tag string) []CacheKey
+
 	// Calls any cleanup operations that might be necessary. The cache must not be used after this method is called.
 	Destroy()
 }
@@ -42,4 +49,5 @@ type Stats struct {
 	Bytes          int64
 	MaxBytes       int64
 	MemoryCapBytes int64
+	DedupBytes     int64 // Bytes saved by storing identical bodies only once.
 }
@@ -33,11 +33,28 @@ func MakeFromRequest(r *http.Request) CacheKey {
 	}
 	normHost := strings.ToLower(r.Host)
 	normPath := path.Clean(r.URL.Path)
-	stringKey := fmt.Sprintf("%s|%s|%s|%s|%s", scheme, r.Method, normHost, normPath, r.URL.RawQuery)
+	stringKey := fmt.Sprintf("%s|%s|%s|%s|%s", scheme, keyMethod(r.Method), normHost, normPath, r.URL.RawQuery)
 	slog.Debug("Creating cache key", "key", stringKey)
 	return FromString(stringKey)
 }
 
+// HEAD requests share the GET key so they can be answered from the stored GET entry.
+func keyMethod(method string) string {
+	if method == http.MethodHead {
+		return http.MethodGet
+	}
+	return method
+}
+
+// Parses a key from its hex representation, as returned by String.
+func ParseKey(hexKey string) (CacheKey, error) {
+	hashBytes, err := hex.DecodeString(hexKey)
+	if err != nil || len(hashBytes) != blake2b.Size256 {
+		return CacheKey{}, fmt.Errorf("invalid cache key '%s'", hexKey)
+	}
+	return CacheKey{Hex: strings.ToLower(hexKey)}, nil
+}
+
 func (ck *CacheKey) String() string {
 	return ck.Hex
 }
@@ -5,11 +5,24 @@ import (
 	"time"
 )
 
+// The storage tier holding an entry.
+type Tier string
+
+const (
+	TierMemory        Tier = "memory"
+	TierFile          Tier = "file"
+	TierObjectStorage Tier = "object_storage"
+)
+
 type EntryMetadata[MetadataT any] struct {
 	TimeWritten time.Time `json:"time_written"`
 	LastAccess  time.Time `json:"last_access"`
 	Expires     time.Time `json:"expires"`
 	Size        int64     `json:"file_size"`
+	ContentHash string    `json:"content_hash,omitempty"` // Hash of the body, set by backends that store bodies by content.
+	Hits        int64     `json:"hits"`                   // How often the entry was read from the cache.
+	Pinned      bool      `json:"pinned,omitempty"`       // Pinned entries are neither evicted nor removed once they expire.
+	Tier        Tier      `json:"-"`                      // Set on the metadata copies handed out by the backends.
 	Object      MetadataT `json:"object"`
 }
 
@@ -0,0 +1,183 @@
+package cache
+
+import (
+	"math"
+	"reservoir/config"
+	"time"
+)
+
+// How long it takes an LFU entry that isn't accessed to lose half of its frequency.
+const lfuHalfLife = 24 * time.Hour
+
+// A snapshot of an entry the janitor may evict.
+type EvictionCandidate struct {
+	Key         CacheKey
+	Size        int64
+	TimeWritten time.Time
+	LastAccess  time.Time
+	Hits        int64
+}
+
+// Counts the store as the first access, so entries that were never hit still have a frequency.
+func (c *EvictionCandidate) frequency() float64 {
+	return float64(c.Hits + 1)
+}
+
+// Decides in which order entries are evicted once the cache exceeds its size. Policies keep their order
+// up to date as entries are written and accessed, so an eviction only touches the entries it evicts.
+// The janitor serializes all calls.
+type EvictionPolicy interface {
+	// Adds an entry, or updates it after it was written again or accessed.
+	Update(candidate EvictionCandidate)
+
+	// Forgets an entry that was removed from the cache other than by eviction.
+	Remove(key CacheKey)
+
+	// Removes and returns the entry to evict next. It is either evicted, or added back with Update or Remove.
+	Pop() (EvictionCandidate, bool)
+
+	// Called for every evicted candidate, in the order they were evicted.
+	Evicted(candidate EvictionCandidate)
+}
+
+func NewEvictionPolicy(policy config.EvictionPolicy) EvictionPolicy {
+	switch policy {
+	case config.EvictionPolicyLFU:
+		return newLFUPolicy()
+	case config.EvictionPolicyGDSF:
+		return newGDSFPolicy()
+	case config.EvictionPolicyARC:
+		return newARCPolicy()
+	default:
+		return newLRUPolicy()
+	}
+}
+
+func lessLastAccess(a, b EvictionCandidate) bool {
+	return a.LastAccess.Before(b.LastAccess)
+}
+
+// Evicts the least recently accessed entries first.
+type lruPolicy struct {
+	entries *keyedHeap[EvictionCandidate]
+}
+
+func newLRUPolicy() *lruPolicy {
+	return &lruPolicy{entries: newKeyedHeap(lessLastAccess)}
+}
+
+func (p *lruPolicy) Update(candidate EvictionCandidate) {
+	p.entries.set(candidate.Key, candidate)
+}
+
+func (p *lruPolicy) Remove(key CacheKey) {
+	p.entries.remove(key)
+}
+
+func (p *lruPolicy) Pop() (EvictionCandidate, bool) {
+	_, candidate, ok := p.entries.pop()
+	return candidate, ok
+}
+
+func (p *lruPolicy) Evicted(candidate EvictionCandidate) {}
+
+// Evicts the least frequently accessed entries first. The frequency decays with the time since the
+// last access, so entries that were popular once don't stay cached forever.
+type lfuPolicy struct {
+	entries *keyedHeap[lfuEntry]
+}
+
+type lfuEntry struct {
+	candidate EvictionCandidate
+	score     float64
+}
+
+func newLFUPolicy() *lfuPolicy {
+	return &lfuPolicy{entries: newKeyedHeap(func(a, b lfuEntry) bool {
+		if a.score != b.score {
+			return a.score < b.score
+		}
+		return lessLastAccess(a.candidate, b.candidate)
+	})}
+}
+
+func (p *lfuPolicy) Update(candidate EvictionCandidate) {
+	p.entries.set(candidate.Key, lfuEntry{candidate: candidate, score: lfuScore(&candidate)})
+}
+
+func (p *lfuPolicy) Remove(key CacheKey) {
+	p.entries.remove(key)
+}
+
+func (p *lfuPolicy) Pop() (EvictionCandidate, bool) {
+	_, entry, ok := p.entries.pop()
+	return entry.candidate, ok
+}
+
+func (p *lfuPolicy) Evicted(candidate EvictionCandidate) {}
+
+// The frequency aged until now is frequency * 2^-((now - last access) / half-life). Its logarithm without
+// the term all entries share is log2(frequency) + last access / half-life, which doesn't change over time.
+func lfuScore(c *EvictionCandidate) float64 {
+	return math.Log2(c.frequency()) + float64(c.LastAccess.UnixMilli())/float64(lfuHalfLife.Milliseconds())
+}
+
+// Greedy-Dual-Size-Frequency: evicts the entries with the lowest frequency per byte first. Every eviction
+// raises the inflation value that new and re-accessed entries start from, so entries that aren't accessed
+// anymore age out even if they were popular.
+type gdsfPolicy struct {
+	inflation float64
+	entries   *keyedHeap[gdsfEntry]
+	popped    map[CacheKey]gdsfEntry
+}
+
+type gdsfEntry struct {
+	candidate EvictionCandidate
+	priority  float64
+}
+
+func newGDSFPolicy() *gdsfPolicy {
+	return &gdsfPolicy{
+		entries: newKeyedHeap(func(a, b gdsfEntry) bool {
+			if a.priority != b.priority {
+				return a.priority < b.priority
+			}
+			return lessLastAccess(a.candidate, b.candidate)
+		}),
+		popped: make(map[CacheKey]gdsfEntry),
+	}
+}
+
+func (p *gdsfPolicy) Update(candidate EvictionCandidate) {
+	previous, ok := p.entries.get(candidate.Key)
+	if popped, wasPopped := p.popped[candidate.Key]; wasPopped {
+		previous, ok = popped, true
+		delete(p.popped, candidate.Key)
+	}
+	entry := gdsfEntry{candidate: candidate, priority: previous.priority}
+	// The priority is only recomputed after an access, like GDSF does on every hit.
+	if !ok || previous.candidate.Hits != candidate.Hits || !previous.candidate.TimeWritten.Equal(candidate.TimeWritten) {
+		entry.priority = p.inflation + candidate.frequency()/float64(max(candidate.Size, 1))
+	}
+	p.entries.set(candidate.Key, entry)
+}
+
+func (p *gdsfPolicy) Remove(key CacheKey) {
+	p.entries.remove(key)
+	delete(p.popped, key)
+}
+
+func (p *gdsfPolicy) Pop() (EvictionCandidate, bool) {
+	key, entry, ok := p.entries.pop()
+	if ok {
+		p.popped[key] = entry
+	}
+	return entry.candidate, ok
+}
+
+func (p *gdsfPolicy) Evicted(candidate EvictionCandidate) {
+	if entry, ok := p.popped[candidate.Key]; ok {
+		p.inflation = max(p.inflation, entry.priority)
+		delete(p.popped, candidate.Key)
+	}
+}
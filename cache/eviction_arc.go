@@ -0,0 +1,172 @@
+package cache
+
+import "slices"
+
+// Adaptive Replacement Cache: splits the entries into a recency list of entries that were only stored
+// and a frequency list of entries that were accessed again, both sized in bytes.
+// Ghost lists remember recently evicted keys. When an evicted entry is cached again, the recency list's
+// share of the cache grows or shrinks depending on which list it was evicted from.
+type arcPolicy struct {
+	target         int64 // Bytes the recency list may hold before the frequency list is evicted from, p in ARC.
+	recent         *keyedHeap[EvictionCandidate]
+	frequent       *keyedHeap[EvictionCandidate]
+	recentBytes    int64
+	frequentBytes  int64
+	recentGhosts   ghostList
+	frequentGhosts ghostList
+	returned       map[CacheKey]struct{} // Entries cached again after their eviction, these count as frequent.
+	popped         map[CacheKey]bool     // Whether a popped entry came from the frequency list.
+}
+
+func newARCPolicy() *arcPolicy {
+	return &arcPolicy{
+		recent:         newKeyedHeap(lessLastAccess),
+		frequent:       newKeyedHeap(lessLastAccess),
+		recentGhosts:   newGhostList(),
+		frequentGhosts: newGhostList(),
+		returned:       make(map[CacheKey]struct{}),
+		popped:         make(map[CacheKey]bool),
+	}
+}
+
+func (p *arcPolicy) Update(candidate EvictionCandidate) {
+	key := candidate.Key
+	_, wasPopped := p.popped[key]
+	delete(p.popped, key)
+	isNew := !wasPopped && !p.remove(key)
+
+	if isNew {
+		total := p.recentBytes + p.frequentBytes + candidate.Size
+		if size, ok := p.recentGhosts.remove(key); ok {
+			// Evicted too early from the recency list, give it more room.
+			p.target = min(total, p.target+scaledDelta(size, p.frequentGhosts.bytes, p.recentGhosts.bytes))
+			p.returned[key] = struct{}{}
+		} else if size, ok := p.frequentGhosts.remove(key); ok {
+			p.target = max(0, p.target-scaledDelta(size, p.recentGhosts.bytes, p.frequentGhosts.bytes))
+			p.returned[key] = struct{}{}
+		}
+	}
+
+	if _, wasReturned := p.returned[key]; candidate.Hits > 0 || wasReturned {
+		p.frequent.set(key, candidate)
+		p.frequentBytes += candidate.Size
+	} else {
+		p.recent.set(key, candidate)
+		p.recentBytes += candidate.Size
+	}
+
+	if isNew {
+		tracked := p.recent.len() + p.frequent.len()
+		p.recentGhosts.trim(tracked)
+		p.frequentGhosts.trim(tracked)
+	}
+}
+
+// Removes the key from both lists and reports whether it was in one of them.
+func (p *arcPolicy) remove(key CacheKey) bool {
+	if candidate, ok := p.recent.remove(key); ok {
+		p.recentBytes -= candidate.Size
+		return true
+	}
+	if candidate, ok := p.frequent.remove(key); ok {
+		p.frequentBytes -= candidate.Size
+		return true
+	}
+	return false
+}
+
+func (p *arcPolicy) Remove(key CacheKey) {
+	p.remove(key)
+	delete(p.returned, key)
+	delete(p.popped, key)
+}
+
+// Takes from the recency list while it holds more than its target, like ARC's REPLACE.
+func (p *arcPolicy) Pop() (EvictionCandidate, bool) {
+	if p.recent.len() > 0 && (p.recentBytes > p.target || p.frequent.len() == 0) {
+		_, candidate, _ := p.recent.pop()
+		p.recentBytes -= candidate.Size
+		p.popped[candidate.Key] = false
+		return candidate, true
+	}
+	_, candidate, ok := p.frequent.pop()
+	if ok {
+		p.frequentBytes -= candidate.Size
+		p.popped[candidate.Key] = true
+	}
+	return candidate, ok
+}
+
+func (p *arcPolicy) Evicted(candidate EvictionCandidate) {
+	if p.popped[candidate.Key] {
+		p.frequentGhosts.add(candidate.Key, candidate.Size)
+	} else {
+		p.recentGhosts.add(candidate.Key, candidate.Size)
+	}
+	delete(p.popped, candidate.Key)
+	delete(p.returned, candidate.Key)
+}
+
+// Scales size by the ratio of the ghost lists, but at least by one.
+func scaledDelta(size int64, numerator int64, denominator int64) int64 {
+	if denominator <= 0 || numerator <= denominator {
+		return size
+	}
+	return int64(float64(size) * float64(numerator) / float64(denominator))
+}
+
+// Keys of evicted entries in eviction order, without their data.
+type ghostList struct {
+	order   []ghostKey
+	entries map[CacheKey]ghostKey
+	bytes   int64
+	seq     uint64
+}
+
+type ghostKey struct {
+	key  CacheKey
+	size int64
+	seq  uint64
+}
+
+func newGhostList() ghostList {
+	return ghostList{entries: make(map[CacheKey]ghostKey)}
+}
+
+func (g *ghostList) add(key CacheKey, size int64) {
+	g.remove(key)
+	g.seq++
+	ghost := ghostKey{key: key, size: size, seq: g.seq}
+	g.order = append(g.order, ghost)
+	g.entries[key] = ghost
+	g.bytes += size
+}
+
+func (g *ghostList) remove(key CacheKey) (int64, bool) {
+	ghost, ok := g.entries[key]
+	if !ok {
+		return 0, false
+	}
+	// The key stays in order until trim drops it.
+	delete(g.entries, key)
+	g.bytes -= ghost.size
+	return ghost.size, true
+}
+
+// Forgets the oldest keys until at most maxEntries are left.
+func (g *ghostList) trim(maxEntries int) {
+	for len(g.entries) > maxEntries && len(g.order) > 0 {
+		oldest := g.order[0]
+		g.order = g.order[1:]
+		if current, ok := g.entries[oldest.key]; ok && current.seq == oldest.seq {
+			g.remove(oldest.key)
+		}
+	}
+	// Drop keys that were removed already, so order doesn't grow without bound.
+	if len(g.order) > 2*max(len(g.entries), 1) {
+		g.order = slices.DeleteFunc(g.order, func(ghost ghostKey) bool {
+			current, ok := g.entries[ghost.key]
+			return !ok || current.seq != ghost.seq
+		})
+	}
+}
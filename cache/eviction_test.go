@@ -0,0 +1,139 @@
+package cache
+
+import (
+	"reservoir/config"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Adds the candidates to the policy and pops all of them, returning their keys in eviction order.
+func orderCandidates(policy EvictionPolicy, candidates ...EvictionCandidate) []string {
+	for _, c := range candidates {
+		policy.Update(c)
+	}
+	order := make([]string, 0, len(candidates))
+	for {
+		c, ok := policy.Pop()
+		if !ok {
+			return order
+		}
+		order = append(order, c.Key.Hex)
+	}
+}
+
+func candidate(name string, size int64, hits int64, lastAccess time.Time) EvictionCandidate {
+	return EvictionCandidate{Key: CacheKey{Hex: name}, Size: size, Hits: hits, LastAccess: lastAccess}
+}
+
+func TestEvictionPolicy_LRUEvictsLeastRecentlyAccessed(t *testing.T) {
+	now := time.Now()
+	got := orderCandidates(NewEvictionPolicy(config.EvictionPolicyLRU),
+		candidate("recent", 1, 100, now),
+		candidate("old", 1, 0, now.Add(-time.Hour)),
+	)
+	if want := []string{"old", "recent"}; !slices.Equal(got, want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+}
+
+func TestEvictionPolicy_LFUAgesFrequency(t *testing.T) {
+	now := time.Now()
+	got := orderCandidates(NewEvictionPolicy(config.EvictionPolicyLFU),
+		candidate("hot", 1, 10, now.Add(-time.Hour)),
+		candidate("once", 1, 0, now),
+		candidate("formerly-hot", 1, 10, now.Add(-10*lfuHalfLife)),
+	)
+	if want := []string{"formerly-hot", "once", "hot"}; !slices.Equal(got, want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+}
+
+func TestEvictionPolicy_GDSFWeighsFrequencyBySize(t *testing.T) {
+	now := time.Now()
+	policy := NewEvictionPolicy(config.EvictionPolicyGDSF)
+	hotLarge := candidate("hot-large", 1000, 149, now)
+	coldLarge := candidate("cold-large", 1000, 0, now)
+	coldSmall := candidate("cold-small", 10, 0, now)
+
+	got := orderCandidates(policy, hotLarge, coldLarge, coldSmall)
+	if want := []string{"cold-large", "cold-small", "hot-large"}; !slices.Equal(got, want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+
+	// The evictions inflate the priority of entries stored or accessed afterwards,
+	// so the large entry that isn't accessed anymore goes before the new one.
+	policy.Evicted(coldLarge)
+	policy.Evicted(coldSmall)
+	newSmall := candidate("new-small", 10, 0, now)
+	got = orderCandidates(policy, newSmall, hotLarge)
+	if want := []string{"hot-large", "new-small"}; !slices.Equal(got, want) {
+		t.Fatalf("expected order %v after inflation, got %v", want, got)
+	}
+}
+
+func TestEvictionPolicy_ARCAdaptsToReturningEntries(t *testing.T) {
+	now := time.Now()
+	policy := NewEvictionPolicy(config.EvictionPolicyARC)
+	scan := candidate("scan", 100, 0, now)
+	frequent := candidate("frequent", 100, 5, now.Add(-time.Hour))
+
+	// Entries seen only once are evicted first, even if they were accessed more recently.
+	got := orderCandidates(policy, frequent, scan)
+	if want := []string{"scan", "frequent"}; !slices.Equal(got, want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+	policy.Evicted(scan)
+
+	// Cached again after its eviction, the entry counts as frequent and grows the recency target,
+	// so the recency list is protected up to that size.
+	newcomer := candidate("newcomer", 100, 0, now)
+	got = orderCandidates(policy, frequent, scan, newcomer)
+	if want := []string{"frequent", "scan", "newcomer"}; !slices.Equal(got, want) {
+		t.Fatalf("expected order %v, got %v", want, got)
+	}
+	if arc := policy.(*arcPolicy); arc.target != 100 {
+		t.Fatalf("expected the recency target to grow to 100 bytes, got %d", arc.target)
+	}
+}
+
+func TestJanitor_EvictsToLowWaterMark(t *testing.T) {
+	now := time.Now()
+	backend := newJanitorTestBackend()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		backend.put(FromString(name), 250, now.Add(time.Hour), now.Add(time.Duration(i)*time.Minute))
+	}
+
+	cfg := config.NewDefault()
+	cfg.Cache.Eviction.LowWaterMarkPercent.Overwrite(50)
+	j := NewJanitor(cfg, time.Hour, backend.janitorFunctions(), false)
+	t.Cleanup(j.subs.UnsubscribeAll)
+	j.Evict(1000)
+
+	if got := backend.cachedBytes(); got != 500 {
+		t.Fatalf("expected eviction down to 500 bytes, got %d", got)
+	}
+	if backend.has(FromString("a")) || !backend.has(FromString("d")) {
+		t.Fatal("expected the least recently accessed entries to be evicted")
+	}
+}
+
+func TestJanitor_SwitchesEvictionPolicy(t *testing.T) {
+	now := time.Now()
+	hotKey := FromString("hot")
+	coldKey := FromString("cold")
+	backend := newJanitorTestBackend()
+	backend.put(hotKey, 600, now.Add(time.Hour), now.Add(-time.Hour))
+	backend.put(coldKey, 600, now.Add(time.Hour), now)
+	backend.hit(hotKey, 50)
+
+	cfg := config.NewDefault()
+	j := NewJanitor(cfg, time.Hour, backend.janitorFunctions(), false)
+	t.Cleanup(j.subs.UnsubscribeAll)
+	cfg.Cache.Eviction.Policy.Overwrite(config.EvictionPolicyLFU)
+	j.Evict(1024)
+
+	if !backend.has(hotKey) || backend.has(coldKey) {
+		t.Fatal("expected LFU to keep the frequently hit entry")
+	}
+}
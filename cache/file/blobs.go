@@ -0,0 +1,199 @@
+package file
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reservoir/cache"
+	"reservoir/config"
+	"strings"
+)
+
+// Bodies are stored once per content hash under this directory, shared by every cache key with the same body.
+const blobDirName = "blobs"
+
+func (c *Cache[MetadataT]) blobDir() string {
+	return filepath.Join(c.rootDir.Path, blobDirName)
+}
+
+func (c *Cache[MetadataT]) blobPath(hash string) string {
+	return shardedPath(c.blobDir(), hash)
+}
+
+// Writes data to a temporary file in the blob directory while hashing it.
+// IMPORTANT: The caller is responsible for committing or removing the temporary file.
+func (c *Cache[MetadataT]) writeTempBlob(data io.Reader) (tempName string, hash string, size int64, err error) {
+	file, err := os.CreateTemp(c.blobDir(), "*"+tempFileSuffix)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("%w: failed to create temporary blob file: %v", ErrCreate, err)
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	size, err = io.Copy(io.MultiWriter(file, hasher), data)
+	if err != nil {
+		os.Remove(file.Name())
+		return "", "", 0, fmt.Errorf("%w: failed to write temporary blob file: %v", ErrWrite, err)
+	}
+	// Without the sync, a crash shortly after the rename can leave a truncated body under the final name.
+	if c.cfg.Cache.File.Fsync.Read() != config.FsyncPolicyNone {
+		if err := file.Sync(); err != nil {
+			os.Remove(file.Name())
+			return "", "", 0, fmt.Errorf("%w: failed to sync temporary blob file: %v", ErrWrite, err)
+		}
+	}
+
+	return file.Name(), hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+// Adds a reference to the blob with the given hash, moving the written temporary file into place if the blob is new.
+// If an identical blob is already stored, the temporary file is discarded instead.
+func (c *Cache[MetadataT]) commitBlob(tempName string, hash string, size int64) error {
+	c.blobMu.Lock()
+	defer c.blobMu.Unlock()
+
+	if c.blobRefs[hash] > 0 {
+		os.Remove(tempName)
+		c.blobRefs[hash]++
+		slog.Debug("Deduplicated cache body", "hash", hash, "size", size, "refs", c.blobRefs[hash])
+		return nil
+	}
+
+	blobPath := c.blobPath(hash)
+	createdShard, err := ensureShardDir(blobPath)
+	if err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("%w: failed to create shard directory for blob '%s': %v", ErrWrite, hash, err)
+	}
+	if err := os.Rename(tempName, blobPath); err != nil {
+		os.Remove(tempName)
+		return fmt.Errorf("%w: failed to move blob '%s' into place: %v", ErrWrite, hash, err)
+	}
+	if c.cfg.Cache.File.Fsync.Read() == config.FsyncPolicyDataDir {
+		c.syncBlobDirs(blobPath, createdShard)
+	}
+	c.blobRefs[hash] = 1
+	cache.AddCacheSize(&c.byteSize, size)
+	return nil
+}
+
+// Makes the rename of a blob durable by syncing the directory it was moved into, and the directories above it
+// if its shard directory was just created. The blob is in place either way, so failures are only logged.
+func (c *Cache[MetadataT]) syncBlobDirs(blobPath string, createdShard bool) {
+	dirs := []string{filepath.Dir(blobPath)}
+	if createdShard {
+		dirs = append(dirs, filepath.Dir(dirs[0]), c.blobDir())
+	}
+	for _, dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			slog.Warn("Failed to sync blob directory", "path", dir, "error", err)
+		}
+	}
+}
+
+// Drops a reference to the blob with the given hash, removing the blob once nothing references it anymore.
+func (c *Cache[MetadataT]) releaseBlob(hash string, size int64) error {
+	c.blobMu.Lock()
+	defer c.blobMu.Unlock()
+
+	refs, exists := c.blobRefs[hash]
+	if !exists {
+		return nil
+	}
+	if refs > 1 {
+		c.blobRefs[hash] = refs - 1
+		return nil
+	}
+
+	delete(c.blobRefs, hash)
+	cache.DecrementCacheSize(&c.byteSize, size)
+	if err := removeIfExists(c.blobPath(hash)); err != nil {
+		slog.Error("Failed to remove blob file", "hash", hash, "error", err)
+		return fmt.Errorf("%w: failed to remove blob '%s'", ErrRemove, hash)
+	}
+	slog.Debug("Removed unreferenced blob", "hash", hash)
+	return nil
+}
+
+// Registers a reference to a blob found on disk while loading the entries.
+func (c *Cache[MetadataT]) restoreBlobRef(hash string, size int64) {
+	c.blobMu.Lock()
+	defer c.blobMu.Unlock()
+
+	if c.blobRefs[hash] == 0 {
+		cache.AddCacheSize(&c.byteSize, size)
+	}
+	c.blobRefs[hash]++
+}
+
+// Reports whether the blob's content still hashes to its name. Each blob is only read once per startup.
+func (c *Cache[MetadataT]) verifyBlob(hash string) bool {
+	if intact, ok := c.verifiedBlobs[hash]; ok {
+		return intact
+	}
+
+	intact := false
+	if file, err := os.Open(c.blobPath(hash)); err == nil {
+		hasher := sha256.New()
+		_, err = io.Copy(hasher, file)
+		file.Close()
+		intact = err == nil && hex.EncodeToString(hasher.Sum(nil)) == hash
+	}
+	c.verifiedBlobs[hash] = intact
+	return intact
+}
+
+// Moves a data file of the previous one-file-per-key layout into the blob store.
+func (c *Cache[MetadataT]) migrateLegacyDataFile(key cache.CacheKey) (hash string, size int64, err error) {
+	legacyPath := c.dataPath(key)
+	legacyFile, err := os.Open(legacyPath)
+	if err != nil {
+		return "", 0, err
+	}
+
+	tempName, hash, size, err := c.writeTempBlob(legacyFile)
+	legacyFile.Close()
+	if err != nil {
+		return "", 0, err
+	}
+	if err := c.commitBlob(tempName, hash, size); err != nil {
+		return "", 0, err
+	}
+
+	_ = removeIfExists(legacyPath)
+	slog.Info("Migrated cache entry to content-addressed storage", "key", key.Hex, "hash", hash)
+	return hash, size, nil
+}
+
+// Removes blobs that no entry references and temporary files left behind by interrupted writes.
+func (c *Cache[MetadataT]) removeUnreferencedBlobs() {
+	files, err := os.ReadDir(c.blobDir())
+	if err != nil {
+		slog.Error("Failed to read blob directory", "path", c.blobDir(), "error", err)
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), tempFileSuffix) {
+			_ = removeIfExists(filepath.Join(c.blobDir(), file.Name()))
+		}
+	}
+
+	c.blobMu.Lock()
+	defer c.blobMu.Unlock()
+
+	err = walkShards(c.blobDir(), func(path string, name string) {
+		if !isCacheDataFileName(name) || c.blobRefs[name] > 0 {
+			return
+		}
+		if err := removeIfExists(path); err != nil {
+			slog.Error("Failed to remove unreferenced blob", "file", name, "error", err)
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to read blob directory", "path", c.blobDir(), "error", err)
+	}
+}
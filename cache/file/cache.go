@@ -2,7 +2,10 @@ package file
 
 import (
 	"context"
+	"io"
+	"log/slog"
 	"maps"
+	"os"
 	"path/filepath"
 	"reservoir/cache"
 	"reservoir/config"
@@ -13,39 +16,92 @@ import (
 	"time"
 )
 
+const (
+	tempFileSuffix        = ".tmp"
+	metadataSuffix        = ".meta.json"
+	cleanShutdownFileName = "clean_shutdown" // Written when the cache is destroyed, and removed when it is loaded.
+)
+
 type Cache[MetadataT any] struct {
+	cfg             *config.Config
 	rootDir         assertedpath.AssertedPath
 	entriesMetadata map[cache.CacheKey]*cache.EntryMetadata[MetadataT]
+	tags            cache.TagIndex // Guarded by mu, rebuilt from the index on startup.
+	index           *metadataIndex
 	mu              sync.RWMutex
 	locks           []sync.RWMutex
-	byteSize        atomics.Int64
+	byteSize        atomics.Int64 // Bytes stored on disk, counting shared blobs once.
+	referencedBytes atomics.Int64 // Bytes referenced by entries, counting shared blobs once per entry.
 	maxCacheSize    atomics.Int64
+	blobRefs        map[string]int
+	blobMu          sync.Mutex
+	verifiedBlobs   map[string]bool // Whether blobs match their checksum, only set while loading after an unclean shutdown.
 	janitor         *cache.Janitor[MetadataT]
+	demote          DemoteFunc[MetadataT] // Receives fresh entries evicted from a hybrid tier, nil if there is no tier below.
 	subs            config.ConfigSubscriber
 }
 
-func New[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) *Cache[MetadataT] {
-	return newWithAggregateMetrics[MetadataT](cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx, true)
+// Writes an entry that is evicted from a tier to the next tier.
+type DemoteFunc[MetadataT any] func(key cache.CacheKey, data io.Reader, meta *cache.EntryMetadata[MetadataT]) error
+
+type options[MetadataT any] struct {
+	trackAggregateMetrics bool
+	followMaxCacheSize    bool
+	demote                DemoteFunc[MetadataT]
+}
+
+func New[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) (*Cache[MetadataT], error) {
+	return newCache(cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx, options[MetadataT]{
+		trackAggregateMetrics: true,
+		followMaxCacheSize:    true,
+	})
 }
 
-func NewTier[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) *Cache[MetadataT] {
-	return newWithAggregateMetrics[MetadataT](cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx, false)
+func NewTier[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) (*Cache[MetadataT], error) {
+	return newCache(cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx, options[MetadataT]{
+		followMaxCacheSize: true,
+	})
 }
 
-func newWithAggregateMetrics[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context, trackAggregateMetrics bool) *Cache[MetadataT] {
+// Creates a hybrid tier with its own capacity, which doesn't follow cache.max_cache_size. Fresh entries it evicts
+// are passed to demote, unless it is nil.
+func NewSizedTier[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context, demote DemoteFunc[MetadataT]) (*Cache[MetadataT], error) {
+	return newCache(cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx, options[MetadataT]{
+		demote: demote,
+	})
+}
+
+func newCache[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context, opts options[MetadataT]) (*Cache[MetadataT], error) {
 	c := &Cache[MetadataT]{
+		cfg:             cfg,
 		rootDir:         assertedpath.AssertDirectory(rootDir),
 		entriesMetadata: make(map[cache.CacheKey]*cache.EntryMetadata[MetadataT]),
+		tags:            cache.NewTagIndex(),
 		locks:           make([]sync.RWMutex, shardCount),
 		byteSize:        atomics.NewInt64(0),
+		referencedBytes: atomics.NewInt64(0),
 		maxCacheSize:    atomics.NewInt64(maxCacheSize),
+		blobRefs:        make(map[string]int),
+		demote:          opts.demote,
 	}
 
-	c.subs.Add(cfg.Cache.MaxCacheSize.OnChange(func(newSize bytesize.ByteSize) {
-		c.maxCacheSize.Set(newSize.Bytes())
-	}))
+	if err := os.MkdirAll(c.blobDir(), 0755); err != nil {
+		slog.Error("Failed to create blob directory", "path", c.blobDir(), "error", err)
+	}
 
-	c.loadMetadataSidecars()
+	index, err := openOrResetMetadataIndex(c.rootDir.Path)
+	if err != nil {
+		return nil, err
+	}
+	c.index = index
+
+	if opts.followMaxCacheSize {
+		c.subs.Add(cfg.Cache.MaxCacheSize.OnChange(func(newSize bytesize.ByteSize) {
+			c.maxCacheSize.Set(newSize.Bytes())
+		}))
+	}
+
+	c.loadEntries()
 
 	c.janitor = cache.NewJanitor(cfg, cleanupInterval, cache.JanitorFunctions[MetadataT]{
 		Iterate: func(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
@@ -62,34 +118,47 @@ func newWithAggregateMetrics[MetadataT any](cfg *config.Config, rootDir string,
 		Size: func() int64 {
 			return c.byteSize.Get()
 		},
-		Len: func() int {
-			c.mu.RLock()
-			defer c.mu.RUnlock()
-			return len(c.entriesMetadata)
-		},
 		Remove: func(key cache.CacheKey) error {
-			return c.ensureRemove(key)
+			return c.removeOrDemote(key)
 		},
 		Lock: func(key cache.CacheKey) *sync.RWMutex {
 			return cache.GetLock(c.locks, key)
 		},
-	}, trackAggregateMetrics)
+	}, opts.trackAggregateMetrics)
 	if c.byteSize.Get() >= c.maxCacheSize.Get() {
 		c.janitor.Evict(c.maxCacheSize.Get())
 	}
 	c.janitor.Start(ctx)
-	return c
+	return c, nil
 }
 
 func (c *Cache[MetadataT]) Destroy() {
 	c.janitor.Stop()
 	c.subs.UnsubscribeAll()
+	c.index.close()
+	c.writeCleanShutdownMarker()
 }
 
+// Path of a data file in the previous one-file-per-key layout. Only used to migrate and clean up old entries.
 func (c *Cache[MetadataT]) dataPath(key cache.CacheKey) string {
 	return filepath.Join(c.rootDir.Path, key.Hex)
 }
 
-func (c *Cache[MetadataT]) metadataPath(key cache.CacheKey) string {
-	return filepath.Join(c.rootDir.Path, key.Hex+".meta.json")
+// Reports whether the cache was destroyed properly the last time it was used, and removes the marker.
+func (c *Cache[MetadataT]) consumeCleanShutdownMarker() bool {
+	path := filepath.Join(c.rootDir.Path, cleanShutdownFileName)
+	if _, err := os.Stat(path); err != nil {
+		return false
+	}
+	if err := removeIfExists(path); err != nil {
+		slog.Error("Failed to remove clean shutdown marker", "path", path, "error", err)
+	}
+	return true
+}
+
+func (c *Cache[MetadataT]) writeCleanShutdownMarker() {
+	path := filepath.Join(c.rootDir.Path, cleanShutdownFileName)
+	if err := os.WriteFile(path, nil, 0644); err != nil {
+		slog.Error("Failed to write clean shutdown marker", "path", path, "error", err)
+	}
 }
@@ -2,11 +2,17 @@ package file
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"io"
 	"os"
+	"path/filepath"
 	"reservoir/cache"
 	"reservoir/config"
+	"strings"
 	"testing"
 	"time"
 )
@@ -15,6 +21,26 @@ type TestMeta struct {
 	ID string
 }
 
+func newTestCache[MetadataT any](t *testing.T, cfg *config.Config, dir string, maxCacheSize int64, cleanupInterval time.Duration, ctx context.Context) *Cache[MetadataT] {
+	t.Helper()
+
+	c, err := New[MetadataT](cfg, dir, maxCacheSize, cleanupInterval, 16, ctx)
+	if err != nil {
+		t.Fatalf("Failed to create cache: %v", err)
+	}
+	return c
+}
+
+func isIndexed(t *testing.T, c *Cache[TestMeta], key cache.CacheKey) bool {
+	t.Helper()
+
+	var rows int
+	if err := c.index.db.Get(&rows, "SELECT COUNT(*) FROM entries WHERE key = ?", key.Hex); err != nil {
+		t.Fatalf("failed to query the index: %v", err)
+	}
+	return rows > 0
+}
+
 func TestFileCache_Basic(t *testing.T) {
 	ctx := t.Context()
 	cfg := config.NewDefault()
@@ -25,7 +51,7 @@ func TestFileCache_Basic(t *testing.T) {
 	}
 	defer os.RemoveAll(tmpDir)
 
-	c := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Minute, 16, ctx)
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Minute, ctx)
 	defer c.Destroy()
 
 	key := cache.FromString("test-key")
@@ -69,7 +95,7 @@ func TestFileCache_OverwriteUpdatesAccounting(t *testing.T) {
 	cfg := config.NewDefault()
 
 	tmpDir := t.TempDir()
-	c := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Minute, 16, ctx)
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Minute, ctx)
 	defer c.Destroy()
 
 	key := cache.FromString("overwrite-key")
@@ -126,7 +152,7 @@ func TestFileCache_ReturnsMetadataSnapshots(t *testing.T) {
 	cfg := config.NewDefault()
 
 	tmpDir := t.TempDir()
-	c := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Minute, 16, ctx)
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Minute, ctx)
 	defer c.Destroy()
 
 	key := cache.FromString("metadata-snapshot-key")
@@ -161,7 +187,7 @@ func TestFileCache_DeleteMissingReturnsNotFound(t *testing.T) {
 	cfg := config.NewDefault()
 
 	tmpDir := t.TempDir()
-	c := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Minute, 16, ctx)
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Minute, ctx)
 	defer c.Destroy()
 
 	if err := c.Delete(cache.FromString("missing-delete-key")); !errors.Is(err, cache.ErrCacheEntryNotFound) {
@@ -169,13 +195,13 @@ func TestFileCache_DeleteMissingReturnsNotFound(t *testing.T) {
 	}
 }
 
-func TestFileCache_ClearRemovesEntriesAndSidecars(t *testing.T) {
+func TestFileCache_ClearRemovesEntriesAndMetadata(t *testing.T) {
 	ctx := t.Context()
 	cfg := config.NewDefault()
 	maxCacheSize := int64(1024 * 1024 * 1024)
 
 	tmpDir := t.TempDir()
-	c := New[TestMeta](cfg, tmpDir, maxCacheSize, time.Minute, 16, ctx)
+	c := newTestCache[TestMeta](t, cfg, tmpDir, maxCacheSize, time.Minute, ctx)
 	defer c.Destroy()
 
 	firstKey := cache.FromString("clear-file-first")
@@ -230,8 +256,8 @@ func TestFileCache_ClearRemovesEntriesAndSidecars(t *testing.T) {
 	if _, err := os.Stat(c.dataPath(firstKey)); !errors.Is(err, os.ErrNotExist) {
 		t.Fatalf("expected first data file to be removed, got %v", err)
 	}
-	if _, err := os.Stat(c.metadataPath(firstKey)); !errors.Is(err, os.ErrNotExist) {
-		t.Fatalf("expected first metadata sidecar to be removed, got %v", err)
+	if isIndexed(t, c, firstKey) {
+		t.Fatal("expected first entry to be removed from the index")
 	}
 	if err := c.Clear(); err != nil {
 		t.Fatalf("clear on empty cache failed: %v", err)
@@ -247,7 +273,7 @@ func TestFileCache_LoadsMetadataSidecarsOnRestart(t *testing.T) {
 	data := []byte("restart body")
 	expires := time.Now().Add(time.Hour)
 
-	firstCache := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Hour, 16, ctx)
+	firstCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
 	firstEntry, err := firstCache.Cache(key, bytes.NewReader(data), expires, TestMeta{ID: "restart-meta"})
 	if err != nil {
 		t.Fatalf("cache before restart failed: %v", err)
@@ -255,7 +281,7 @@ func TestFileCache_LoadsMetadataSidecarsOnRestart(t *testing.T) {
 	firstEntry.Data.Close()
 	firstCache.Destroy()
 
-	secondCache := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Hour, 16, ctx)
+	secondCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
 	defer secondCache.Destroy()
 
 	retrieved, err := secondCache.Get(key)
@@ -279,7 +305,7 @@ func TestFileCache_LoadsMetadataSidecarsOnRestart(t *testing.T) {
 	}
 }
 
-func TestFileCache_RemovesExpiredSidecarsOnStartup(t *testing.T) {
+func TestFileCache_RemovesExpiredEntriesOnStartup(t *testing.T) {
 	ctx := t.Context()
 	cfg := config.NewDefault()
 
@@ -287,7 +313,7 @@ func TestFileCache_RemovesExpiredSidecarsOnStartup(t *testing.T) {
 	key := cache.FromString("expired-restart-key")
 	data := []byte("expired body")
 
-	firstCache := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Hour, 16, ctx)
+	firstCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
 	firstEntry, err := firstCache.Cache(key, bytes.NewReader(data), time.Now().Add(-time.Hour), TestMeta{ID: "expired"})
 	if err != nil {
 		t.Fatalf("cache expired entry failed: %v", err)
@@ -295,7 +321,7 @@ func TestFileCache_RemovesExpiredSidecarsOnStartup(t *testing.T) {
 	firstEntry.Data.Close()
 	firstCache.Destroy()
 
-	secondCache := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Hour, 16, ctx)
+	secondCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
 	defer secondCache.Destroy()
 
 	if _, err := secondCache.Get(key); !errors.Is(err, cache.ErrCacheEntryNotFound) {
@@ -304,7 +330,457 @@ func TestFileCache_RemovesExpiredSidecarsOnStartup(t *testing.T) {
 	if _, err := os.Stat(secondCache.dataPath(key)); !errors.Is(err, os.ErrNotExist) {
 		t.Fatalf("expected expired data file to be removed, got %v", err)
 	}
-	if _, err := os.Stat(secondCache.metadataPath(key)); !errors.Is(err, os.ErrNotExist) {
-		t.Fatalf("expected expired metadata sidecar to be removed, got %v", err)
+	if isIndexed(t, secondCache, key) {
+		t.Fatal("expected expired entry to be removed from the index")
+	}
+}
+
+func TestFileCache_KeepsPinnedEntriesOnRestart(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	key := cache.FromString("pinned-restart-key")
+	data := []byte("pinned body")
+
+	firstCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	firstEntry, err := firstCache.Cache(key, bytes.NewReader(data), time.Now().Add(-time.Hour), TestMeta{ID: "pinned"})
+	if err != nil {
+		t.Fatalf("cache pinned entry failed: %v", err)
+	}
+	firstEntry.Data.Close()
+	if err := firstCache.UpdateMetadata(key, func(meta *cache.EntryMetadata[TestMeta]) { meta.Pinned = true }); err != nil {
+		t.Fatalf("pin failed: %v", err)
+	}
+	firstCache.Destroy()
+
+	secondCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer secondCache.Destroy()
+
+	retrieved, err := secondCache.Get(key)
+	if err != nil {
+		t.Fatalf("expected pinned entry to survive the restart even though it expired, got %v", err)
+	}
+	retrieved.Data.Close()
+	if !retrieved.Metadata.Pinned || !retrieved.Stale {
+		t.Fatalf("expected a pinned stale entry, got pinned=%t stale=%t", retrieved.Metadata.Pinned, retrieved.Stale)
+	}
+
+	replaced, err := secondCache.Cache(key, bytes.NewReader([]byte("refreshed body")), time.Now().Add(time.Hour), TestMeta{ID: "refreshed"})
+	if err != nil {
+		t.Fatalf("refreshing pinned entry failed: %v", err)
+	}
+	replaced.Data.Close()
+	if !replaced.Metadata.Pinned {
+		t.Fatal("expected refreshed entry to stay pinned")
+	}
+}
+
+type taggedMeta struct {
+	Tags []string
+}
+
+func (m taggedMeta) CacheTags() []string {
+	return m.Tags
+}
+
+func TestFileCache_RebuildsTagIndexOnRestart(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	key := cache.FromString("tagged-restart-key")
+
+	firstCache := newTestCache[taggedMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	firstEntry, err := firstCache.Cache(key, bytes.NewReader([]byte("tagged body")), time.Now().Add(time.Hour), taggedMeta{Tags: []string{"noble"}})
+	if err != nil {
+		t.Fatalf("cache tagged entry failed: %v", err)
+	}
+	firstEntry.Data.Close()
+	firstCache.Destroy()
+
+	secondCache := newTestCache[taggedMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer secondCache.Destroy()
+
+	if keys := secondCache.KeysByTag("noble"); len(keys) != 1 || keys[0] != key {
+		t.Fatalf("expected tag index to be restored from the sidecar, got %v", keys)
+	}
+
+	if err := secondCache.Delete(key); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if got := len(secondCache.KeysByTag("noble")); got != 0 {
+		t.Fatalf("expected deleted entry to be removed from the tag index, got %d", got)
+	}
+}
+
+type failingReader struct {
+	data []byte
+	err  error
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestFileCache_FailedWriteKeepsPreviousEntry(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Minute, ctx)
+	defer c.Destroy()
+
+	key := cache.FromString("failed-write-key")
+	data := []byte("previous body")
+
+	entry, err := c.Cache(key, bytes.NewReader(data), time.Now().Add(time.Hour), TestMeta{ID: "previous"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+
+	readErr := errors.New("integrity check failed")
+	_, err = c.Cache(key, &failingReader{data: []byte("partial"), err: readErr}, time.Now().Add(time.Hour), TestMeta{ID: "failed"})
+	if !errors.Is(err, ErrWrite) {
+		t.Fatalf("Expected ErrWrite, got %v", err)
+	}
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed after rejected write: %v", err)
+	}
+	content, _ := io.ReadAll(retrieved.Data)
+	retrieved.Data.Close()
+	if !bytes.Equal(content, data) {
+		t.Errorf("Expected previous data %q, got %q", data, content)
+	}
+	if retrieved.Metadata.Object.ID != "previous" {
+		t.Errorf("Expected previous metadata, got %q", retrieved.Metadata.Object.ID)
+	}
+
+	files, err := os.ReadDir(c.blobDir())
+	if err != nil {
+		t.Fatalf("Failed to read blob dir: %v", err)
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file.Name(), tempFileSuffix) {
+			t.Errorf("Expected temporary file %q to be removed", file.Name())
+		}
+	}
+}
+
+func TestFileCache_DeduplicatesIdenticalBodies(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Minute, ctx)
+	defer c.Destroy()
+
+	firstKey := cache.FromString("http://mirror-a/pool/main/r/reservoir.deb")
+	secondKey := cache.FromString("http://mirror-b/pool/main/r/reservoir.deb")
+	data := []byte("identical package body")
+	expires := time.Now().Add(time.Hour)
+
+	first, err := c.Cache(firstKey, bytes.NewReader(data), expires, TestMeta{ID: "first"})
+	if err != nil {
+		t.Fatalf("first cache failed: %v", err)
+	}
+	first.Data.Close()
+
+	second, err := c.Cache(secondKey, bytes.NewReader(data), expires, TestMeta{ID: "second"})
+	if err != nil {
+		t.Fatalf("second cache failed: %v", err)
+	}
+	second.Data.Close()
+
+	if first.Metadata.ContentHash != second.Metadata.ContentHash {
+		t.Fatalf("expected identical bodies to share a content hash, got %q and %q", first.Metadata.ContentHash, second.Metadata.ContentHash)
+	}
+
+	stats := c.Stats()
+	if stats.Entries != 2 {
+		t.Fatalf("expected 2 entries, got %d", stats.Entries)
+	}
+	if stats.Bytes != int64(len(data)) {
+		t.Fatalf("expected body to be stored once (%d bytes), got %d", len(data), stats.Bytes)
+	}
+	if stats.DedupBytes != int64(len(data)) {
+		t.Fatalf("expected %d deduplicated bytes, got %d", len(data), stats.DedupBytes)
+	}
+
+	if err := c.Delete(firstKey); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+
+	retrieved, err := c.Get(secondKey)
+	if err != nil {
+		t.Fatalf("get of remaining reference failed: %v", err)
+	}
+	content, _ := io.ReadAll(retrieved.Data)
+	retrieved.Data.Close()
+	if !bytes.Equal(content, data) {
+		t.Fatalf("expected shared body %q, got %q", data, content)
+	}
+
+	if err := c.Delete(secondKey); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if _, err := os.Stat(c.blobPath(second.Metadata.ContentHash)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected blob to be removed with its last reference, got %v", err)
+	}
+	if stats := c.Stats(); stats.Bytes != 0 || stats.DedupBytes != 0 {
+		t.Fatalf("expected empty cache after deleting all references, got %+v", stats)
+	}
+}
+
+func TestFileCache_RestoresBlobReferencesOnRestart(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	data := []byte("shared restart body")
+	expires := time.Now().Add(time.Hour)
+	keys := []cache.CacheKey{cache.FromString("restart-ref-first"), cache.FromString("restart-ref-second")}
+
+	firstCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	for _, key := range keys {
+		entry, err := firstCache.Cache(key, bytes.NewReader(data), expires, TestMeta{ID: key.Hex})
+		if err != nil {
+			t.Fatalf("cache before restart failed: %v", err)
+		}
+		entry.Data.Close()
+	}
+	firstCache.Destroy()
+
+	secondCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer secondCache.Destroy()
+
+	if stats := secondCache.Stats(); stats.Bytes != int64(len(data)) || stats.DedupBytes != int64(len(data)) {
+		t.Fatalf("expected restored shared blob accounting, got %+v", stats)
+	}
+
+	if err := secondCache.Delete(keys[0]); err != nil {
+		t.Fatalf("delete after restart failed: %v", err)
+	}
+	retrieved, err := secondCache.Get(keys[1])
+	if err != nil {
+		t.Fatalf("expected remaining reference to survive, got %v", err)
+	}
+	retrieved.Data.Close()
+}
+
+func TestFileCache_MigratesLegacyDataFiles(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	key := cache.FromString("legacy-key")
+	data := []byte("legacy body")
+
+	if err := os.WriteFile(filepath.Join(tmpDir, key.Hex), data, 0644); err != nil {
+		t.Fatalf("failed to write legacy data file: %v", err)
+	}
+	legacyMeta := cache.EntryMetadata[TestMeta]{
+		TimeWritten: time.Now(),
+		LastAccess:  time.Now(),
+		Expires:     time.Now().Add(time.Hour),
+		Size:        int64(len(data)),
+		Object:      TestMeta{ID: "legacy"},
+	}
+	metaBytes, err := json.Marshal(legacyMeta)
+	if err != nil {
+		t.Fatalf("failed to encode legacy metadata: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, key.Hex+".meta.json"), metaBytes, 0644); err != nil {
+		t.Fatalf("failed to write legacy metadata sidecar: %v", err)
+	}
+
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer c.Destroy()
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("get of migrated entry failed: %v", err)
+	}
+	content, _ := io.ReadAll(retrieved.Data)
+	retrieved.Data.Close()
+	if !bytes.Equal(content, data) {
+		t.Fatalf("expected migrated body %q, got %q", data, content)
+	}
+	if retrieved.Metadata.ContentHash == "" {
+		t.Fatal("expected migrated entry to reference a blob")
+	}
+	if _, err := os.Stat(c.dataPath(key)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected legacy data file to be moved, got %v", err)
+	}
+}
+
+func TestFileCache_MigratesFlatLayout(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	key := cache.FromString("flat-key")
+	data := []byte("flat body")
+	hash := sha256.Sum256(data)
+	hashHex := hex.EncodeToString(hash[:])
+
+	if err := os.MkdirAll(filepath.Join(tmpDir, blobDirName), 0755); err != nil {
+		t.Fatalf("failed to create flat blob directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, blobDirName, hashHex), data, 0644); err != nil {
+		t.Fatalf("failed to write flat blob: %v", err)
+	}
+	flatMeta := cache.EntryMetadata[TestMeta]{
+		TimeWritten: time.Now(),
+		LastAccess:  time.Now(),
+		Expires:     time.Now().Add(time.Hour),
+		Size:        int64(len(data)),
+		ContentHash: hashHex,
+		Object:      TestMeta{ID: "flat"},
+	}
+	metaBytes, err := json.Marshal(flatMeta)
+	if err != nil {
+		t.Fatalf("failed to encode flat metadata: %v", err)
+	}
+	flatSidecar := filepath.Join(tmpDir, key.Hex+metadataSuffix)
+	if err := os.WriteFile(flatSidecar, metaBytes, 0644); err != nil {
+		t.Fatalf("failed to write flat metadata sidecar: %v", err)
+	}
+
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer c.Destroy()
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("get of migrated entry failed: %v", err)
+	}
+	content, _ := io.ReadAll(retrieved.Data)
+	retrieved.Data.Close()
+	if !bytes.Equal(content, data) {
+		t.Fatalf("expected migrated body %q, got %q", data, content)
+	}
+
+	wantBlob := filepath.Join(tmpDir, blobDirName, hashHex[0:2], hashHex[2:4], hashHex)
+	if _, err := os.Stat(wantBlob); err != nil {
+		t.Fatalf("expected the blob to be moved into its shard directory: %v", err)
+	}
+	for _, path := range []string{flatSidecar, filepath.Join(tmpDir, blobDirName, hashHex)} {
+		if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+			t.Fatalf("expected flat file %s to be gone, got %v", path, err)
+		}
+	}
+	if !isIndexed(t, c, key) {
+		t.Fatal("expected the sidecar to be imported into the index")
+	}
+}
+
+func TestFileCache_RecreatesCorruptedIndex(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, indexFileName), []byte("not a database"), 0644); err != nil {
+		t.Fatalf("failed to write corrupted index: %v", err)
+	}
+
+	c := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer c.Destroy()
+
+	key := cache.FromString("after-reset-key")
+	entry, err := c.Cache(key, bytes.NewReader([]byte("body")), time.Now().Add(time.Hour), TestMeta{ID: "reset"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+	if !isIndexed(t, c, key) {
+		t.Fatal("expected the entry to be written to the recreated index")
+	}
+}
+
+func TestFileCache_ReturnsErrorIfIndexCantBeRecreated(t *testing.T) {
+	cfg := config.NewDefault()
+
+	// A non-empty directory in place of the index can neither be opened nor removed.
+	tmpDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(tmpDir, indexFileName, "blocked"), 0755); err != nil {
+		t.Fatalf("failed to create directory in place of the index: %v", err)
+	}
+
+	if _, err := New[TestMeta](cfg, tmpDir, 1024*1024*1024, time.Hour, 16, t.Context()); err == nil {
+		t.Fatal("expected an error for an index that can't be recreated")
+	}
+}
+
+func TestFileCache_DiscardsTruncatedBodiesOnRestart(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+	cfg.Cache.File.Fsync.Overwrite(config.FsyncPolicyDataDir)
+
+	tmpDir := t.TempDir()
+	key := cache.FromString("truncated-key")
+
+	firstCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	entry, err := firstCache.Cache(key, bytes.NewReader([]byte("complete body")), time.Now().Add(time.Hour), TestMeta{ID: "truncated"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+	firstCache.Destroy()
+
+	if err := os.Truncate(firstCache.blobPath(entry.Metadata.ContentHash), 4); err != nil {
+		t.Fatalf("failed to truncate blob: %v", err)
+	}
+
+	secondCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer secondCache.Destroy()
+
+	if _, err := secondCache.Get(key); !errors.Is(err, cache.ErrCacheEntryNotFound) {
+		t.Fatalf("expected the truncated entry to be discarded, got %v", err)
+	}
+	if isIndexed(t, secondCache, key) {
+		t.Fatal("expected the truncated entry to be removed from the index")
+	}
+}
+
+func TestFileCache_VerifiesChecksumsAfterUncleanShutdown(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	tmpDir := t.TempDir()
+	key := cache.FromString("corrupted-key")
+	data := []byte("original body")
+
+	firstCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	entry, err := firstCache.Cache(key, bytes.NewReader(data), time.Now().Add(time.Hour), TestMeta{ID: "corrupted"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+	firstCache.Destroy()
+
+	// Same size, different content, so only the checksum can tell.
+	if err := os.WriteFile(firstCache.blobPath(entry.Metadata.ContentHash), bytes.Repeat([]byte{0}, len(data)), 0644); err != nil {
+		t.Fatalf("failed to corrupt blob: %v", err)
+	}
+	if err := os.Remove(filepath.Join(tmpDir, cleanShutdownFileName)); err != nil {
+		t.Fatalf("expected a clean shutdown marker: %v", err)
+	}
+
+	secondCache := newTestCache[TestMeta](t, cfg, tmpDir, 1024*1024*1024, time.Hour, ctx)
+	defer secondCache.Destroy()
+
+	if _, err := secondCache.Get(key); !errors.Is(err, cache.ErrCacheEntryNotFound) {
+		t.Fatalf("expected the corrupted entry to be discarded, got %v", err)
+	}
+	if _, err := os.Stat(secondCache.blobPath(entry.Metadata.ContentHash)); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the corrupted blob to be removed, got %v", err)
 	}
 }
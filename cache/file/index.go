@@ -0,0 +1,112 @@
+package file
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"reservoir/db"
+)
+
+//go:embed migrations/*.sql
+var indexMigrations embed.FS
+
+const (
+	indexFileName    = "index.db"
+	indexBusyTimeout = 5000 // ms
+)
+
+// The metadata of every entry, stored in an SQLite database in the cache directory. Updating an entry writes a
+// single row and startup reads one table, instead of a JSON file per entry. SQLite's write-ahead log keeps the
+// index consistent if the process is killed while writing.
+type metadataIndex struct {
+	db db.Database
+}
+
+type indexRow struct {
+	Key      string `db:"key"`
+	Metadata []byte `db:"metadata"`
+}
+
+func openMetadataIndex(dir string) (*metadataIndex, error) {
+	database, err := db.Open(filepath.ToSlash(filepath.Join(dir, indexFileName)), indexBusyTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := database.MigrateFS(indexMigrations); err != nil {
+		database.Close()
+		return nil, err
+	}
+	return &metadataIndex{db: database}, nil
+}
+
+// Opens the index in dir. An index that can't be opened, e.g. because it was corrupted, is discarded and
+// recreated. The entries it held are dropped and their bodies removed as unreferenced.
+// Returns an error if the index can't be recreated either, e.g. because the directory isn't writable.
+func openOrResetMetadataIndex(dir string) (*metadataIndex, error) {
+	index, err := openMetadataIndex(dir)
+	if err == nil {
+		return index, nil
+	}
+	slog.Error("Failed to open file cache index, recreating it", "path", dir, "error", err)
+
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		if removeErr := removeIfExists(filepath.Join(dir, indexFileName+suffix)); removeErr != nil {
+			err = errors.Join(err, removeErr)
+		}
+	}
+	index, retryErr := openMetadataIndex(dir)
+	if retryErr != nil {
+		return nil, fmt.Errorf("failed to open file cache index in '%s': %w", dir, errors.Join(err, retryErr))
+	}
+	return index, nil
+}
+
+func (x *metadataIndex) put(keyHex string, metadata []byte) error {
+	return x.db.Exec(
+		`
+		INSERT INTO entries (key, metadata) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET metadata = excluded.metadata;
+		`,
+		keyHex,
+		metadata,
+	)
+}
+
+// Writes all rows in a single transaction.
+func (x *metadataIndex) putAll(rows []indexRow) error {
+	return x.db.WithTransaction(func(tx *db.Tx) error {
+		for _, row := range rows {
+			if err := tx.Exec(
+				`
+				INSERT INTO entries (key, metadata) VALUES (?, ?)
+				ON CONFLICT(key) DO UPDATE SET metadata = excluded.metadata;
+				`,
+				row.Key,
+				row.Metadata,
+			); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (x *metadataIndex) delete(keyHex string) error {
+	return x.db.Exec("DELETE FROM entries WHERE key = ?", keyHex)
+}
+
+func (x *metadataIndex) all() ([]indexRow, error) {
+	var rows []indexRow
+	if err := x.db.Select(&rows, "SELECT key, metadata FROM entries"); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+func (x *metadataIndex) close() {
+	if err := x.db.Close(); err != nil {
+		slog.Error("Failed to close file cache index", "error", err)
+	}
+}
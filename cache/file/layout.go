@@ -0,0 +1,101 @@
+package file
+
+import (
+	"encoding/hex"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Blobs are spread over two levels of directories named after the first four hex digits of their hash,
+// e.g. ab/cd/abcd..., so no single directory grows large enough to slow down the filesystem.
+func shardedPath(dir string, name string) string {
+	return filepath.Join(dir, name[0:2], name[2:4], name)
+}
+
+// Creates the shard directory of path, and reports whether it didn't exist before.
+func ensureShardDir(path string) (bool, error) {
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); err == nil {
+		return false, nil
+	}
+	return true, os.MkdirAll(dir, 0755)
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
+
+func isShardDirName(name string) bool {
+	if len(name) != 2 {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
+
+// Calls fn for every file in the shard directories below dir. Files directly in dir are skipped.
+func walkShards(dir string, fn func(path string, name string)) error {
+	return filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		depth := strings.Count(rel, string(filepath.Separator)) + 1
+		if entry.IsDir() {
+			if depth > 2 || !isShardDirName(entry.Name()) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if depth == 3 {
+			fn(path, entry.Name())
+		}
+		return nil
+	})
+}
+
+// Moves blobs written by versions that stored them directly in the blob directory into their shard directories.
+func (c *Cache[MetadataT]) migrateFlatLayout() {
+	blobs, err := os.ReadDir(c.blobDir())
+	if err != nil {
+		slog.Error("Failed to read blob directory", "path", c.blobDir(), "error", err)
+		return
+	}
+
+	migrated := 0
+	for _, blob := range blobs {
+		if blob.IsDir() || !isCacheDataFileName(blob.Name()) {
+			continue
+		}
+		if moveIntoShard(filepath.Join(c.blobDir(), blob.Name()), c.blobPath(blob.Name())) {
+			migrated++
+		}
+	}
+
+	if migrated > 0 {
+		slog.Info("Moved file cache blobs into shard directories", "blobs", migrated)
+	}
+}
+
+func moveIntoShard(from string, to string) bool {
+	if _, err := ensureShardDir(to); err != nil {
+		slog.Error("Failed to create shard directory", "path", filepath.Dir(to), "error", err)
+		return false
+	}
+	if err := os.Rename(from, to); err != nil {
+		slog.Error("Failed to move file into shard directory", "from", from, "to", to, "error", err)
+		return false
+	}
+	return true
+}
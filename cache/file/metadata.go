@@ -6,10 +6,12 @@ import (
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"reservoir/cache"
 	"reservoir/metrics"
+	"slices"
 	"strings"
 	"time"
 )
@@ -19,97 +21,214 @@ func metadataSnapshot[MetadataT any](meta *cache.EntryMetadata[MetadataT]) *cach
 		return nil
 	}
 	snapshot := *meta
+	snapshot.Tier = cache.TierFile
 	return &snapshot
 }
 
-func (c *Cache[MetadataT]) loadMetadataSidecars() {
+// Restores the entries of the index, and of sidecars left by earlier versions, and removes everything on disk
+// that no entry references anymore.
+func (c *Cache[MetadataT]) loadEntries() {
+	c.migrateFlatLayout()
+
+	// Bodies written shortly before a crash may not have reached the disk completely.
+	if !c.consumeCleanShutdownMarker() {
+		c.verifiedBlobs = make(map[string]bool)
+		defer func() {
+			if len(c.verifiedBlobs) > 0 {
+				slog.Info("Verified cached bodies after an unclean shutdown", "blobs", len(c.verifiedBlobs))
+			}
+			c.verifiedBlobs = nil
+		}()
+	}
+
+	now := time.Now()
+	c.loadIndexedEntries(now)
+	c.importMetadataSidecars(now)
+
 	files, err := os.ReadDir(c.rootDir.Path)
 	if err != nil {
 		slog.Error("Failed to read file cache directory", "path", c.rootDir.Path, "error", err)
 		return
 	}
-
-	loaded := make(map[string]struct{})
-	now := time.Now()
 	for _, file := range files {
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".meta.json") {
+		if !file.IsDir() && strings.HasSuffix(file.Name(), tempFileSuffix) {
+			// Left behind by a write that was interrupted before it completed.
+			_ = removeIfExists(filepath.Join(c.rootDir.Path, file.Name()))
 			continue
 		}
-
-		keyHex := strings.TrimSuffix(file.Name(), ".meta.json")
-		if !isCacheDataFileName(keyHex) {
-			_ = removeIfExists(filepath.Join(c.rootDir.Path, file.Name()))
+		// Data files of the layout before blobs were moved into the blob store while importing their sidecar.
+		if file.IsDir() || !isCacheDataFileName(file.Name()) {
 			continue
 		}
+		if err := removeIfExists(filepath.Join(c.rootDir.Path, file.Name())); err != nil {
+			slog.Error("Failed to remove orphaned cache data file", "file", file.Name(), "error", err)
+		}
+	}
 
-		key := cache.CacheKey{Hex: keyHex}
-		if c.loadMetadataSidecar(key, now) {
-			loaded[keyHex] = struct{}{}
+	c.removeUnreferencedBlobs()
+}
+
+func (c *Cache[MetadataT]) loadIndexedEntries(now time.Time) {
+	rows, err := c.index.all()
+	if err != nil {
+		slog.Error("Failed to read file cache index", "path", c.rootDir.Path, "error", err)
+		return
+	}
+
+	for _, row := range rows {
+		var meta cache.EntryMetadata[MetadataT]
+		err := json.Unmarshal(row.Metadata, &meta)
+		if err != nil {
+			slog.Error("Failed to decode indexed cache metadata", "key", row.Key, "error", err)
+		}
+		if err != nil || !isCacheDataFileName(row.Key) || !c.restoreEntry(cache.CacheKey{Hex: row.Key}, &meta, now) {
+			if err := c.index.delete(row.Key); err != nil {
+				slog.Error("Failed to remove cache entry from the index", "key", row.Key, "error", err)
+			}
 		}
 	}
+}
 
-	for _, file := range files {
-		if file.IsDir() || !isCacheDataFileName(file.Name()) {
-			continue
+// Adds an entry loaded from disk, unless it expired or its body is missing, has a different size than recorded
+// or, after an unclean shutdown, doesn't match its checksum.
+func (c *Cache[MetadataT]) restoreEntry(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT], now time.Time) bool {
+	if meta.Expires.Before(now) && !meta.Pinned {
+		return false
+	}
+	if !isCacheDataFileName(meta.ContentHash) {
+		return false
+	}
+	blobStat, err := os.Stat(c.blobPath(meta.ContentHash))
+	if err != nil || blobStat.Size() == 0 {
+		return false
+	}
+	if blobStat.Size() != meta.Size {
+		slog.Warn("Discarding cache entry whose body has a different size than recorded", "key", key.Hex, "size", blobStat.Size(), "recorded_size", meta.Size)
+		return false
+	}
+	if c.verifiedBlobs != nil && !c.verifyBlob(meta.ContentHash) {
+		slog.Warn("Discarding cache entry whose body doesn't match its checksum", "key", key.Hex, "hash", meta.ContentHash)
+		return false
+	}
+	c.restoreBlobRef(meta.ContentHash, meta.Size)
+	c.addLoadedEntry(key, meta)
+	return true
+}
+
+func (c *Cache[MetadataT]) addLoadedEntry(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
+	c.entriesMetadata[key] = meta
+	c.tags.Add(key, cache.TagsOf(meta.Object))
+	c.referencedBytes.Add(meta.Size)
+	cache.IncrementCacheEntries()
+}
+
+// Moves the metadata that earlier versions stored in a JSON sidecar next to each entry into the index.
+// Sidecars were written directly into the cache directory, and later into shard directories like the blobs.
+// They are only removed once the index holds their entries.
+func (c *Cache[MetadataT]) importMetadataSidecars(now time.Time) {
+	sidecars := make([]string, 0)
+	rows := make([]indexRow, 0)
+	importSidecar := func(path string, name string) {
+		keyHex, isSidecar := strings.CutSuffix(name, metadataSuffix)
+		if !isSidecar {
+			return
 		}
-		if _, ok := loaded[file.Name()]; ok {
-			continue
+		sidecars = append(sidecars, path)
+		if !isCacheDataFileName(keyHex) {
+			return
 		}
-		if err := removeIfExists(filepath.Join(c.rootDir.Path, file.Name())); err != nil {
-			slog.Error("Failed to remove orphaned cache data file", "file", file.Name(), "error", err)
+
+		meta, ok := c.loadMetadataSidecar(cache.CacheKey{Hex: keyHex}, path, now)
+		if !ok {
+			return
+		}
+		encoded, err := json.Marshal(meta)
+		if err != nil {
+			slog.Error("Failed to encode cache metadata", "key", keyHex, "error", err)
+			return
+		}
+		rows = append(rows, indexRow{Key: keyHex, Metadata: encoded})
+	}
+
+	files, err := os.ReadDir(c.rootDir.Path)
+	if err != nil {
+		slog.Error("Failed to read file cache directory", "path", c.rootDir.Path, "error", err)
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			importSidecar(filepath.Join(c.rootDir.Path, file.Name()), file.Name())
 		}
 	}
+	if err := walkShards(c.rootDir.Path, importSidecar); err != nil {
+		slog.Error("Failed to read file cache directory", "path", c.rootDir.Path, "error", err)
+	}
+	if len(sidecars) == 0 {
+		return
+	}
+
+	if err := c.index.putAll(rows); err != nil {
+		slog.Error("Failed to import cache metadata sidecars into the index", "error", err)
+		return
+	}
+	for _, path := range sidecars {
+		_ = removeIfExists(path)
+	}
+	slog.Info("Imported cache metadata sidecars into the index", "entries", len(rows), "sidecars", len(sidecars))
 }
 
-func (c *Cache[MetadataT]) loadMetadataSidecar(key cache.CacheKey, now time.Time) bool {
-	metaPath := c.metadataPath(key)
+func (c *Cache[MetadataT]) loadMetadataSidecar(key cache.CacheKey, metaPath string, now time.Time) (*cache.EntryMetadata[MetadataT], bool) {
 	dataPath := c.dataPath(key)
 
 	metaBytes, err := os.ReadFile(metaPath)
 	if err != nil {
 		slog.Error("Failed to read cache metadata sidecar", "key", key.Hex, "error", err)
-		_ = removeIfExists(dataPath)
-		_ = removeIfExists(metaPath)
-		return false
+		return nil, false
 	}
 
 	var meta cache.EntryMetadata[MetadataT]
 	if err := json.Unmarshal(metaBytes, &meta); err != nil {
 		slog.Error("Failed to decode cache metadata sidecar", "key", key.Hex, "error", err)
-		_ = removeIfExists(dataPath)
-		_ = removeIfExists(metaPath)
-		return false
+		return nil, false
 	}
 
+	if meta.ContentHash != "" {
+		return &meta, c.restoreEntry(key, &meta, now)
+	}
+	if meta.Expires.Before(now) && !meta.Pinned {
+		return nil, false
+	}
+
+	// Written before bodies were stored by content, so the body still lives in its own data file.
 	dataStat, err := os.Stat(dataPath)
-	if err != nil || dataStat.Size() == 0 || meta.Expires.Before(now) {
-		_ = removeIfExists(dataPath)
-		_ = removeIfExists(metaPath)
-		return false
+	if err != nil || dataStat.Size() == 0 {
+		return nil, false
 	}
 
-	meta.Size = dataStat.Size()
-	c.entriesMetadata[key] = &meta
-	cache.AddCacheSize(&c.byteSize, meta.Size)
-	cache.IncrementCacheEntries()
-	return true
+	hash, size, err := c.migrateLegacyDataFile(key)
+	if err != nil {
+		slog.Error("Failed to migrate cache entry to content-addressed storage", "key", key.Hex, "error", err)
+		return nil, false
+	}
+	meta.ContentHash = hash
+	meta.Size = size
+	c.addLoadedEntry(key, &meta)
+	return &meta, true
 }
 
-func (c *Cache[MetadataT]) writeMetadataSidecar(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
-	metaFile, err := os.Create(c.metadataPath(key))
+func (c *Cache[MetadataT]) writeMetadata(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
+	encoded, err := json.Marshal(meta)
 	if err != nil {
-		slog.Error("Failed to create cache metadata sidecar", "key", key.Hex, "error", err)
+		slog.Error("Failed to encode cache metadata", "key", key.Hex, "error", err)
 		return
 	}
-	defer metaFile.Close()
-
-	if err := json.NewEncoder(metaFile).Encode(meta); err != nil {
-		slog.Error("Failed to write cache metadata sidecar", "key", key.Hex, "error", err)
+	if err := c.index.put(key.Hex, encoded); err != nil {
+		slog.Error("Failed to write cache metadata to the index", "key", key.Hex, "error", err)
 	}
 }
 
-func (c *Cache[MetadataT]) removeMetadataSidecar(key cache.CacheKey) error {
-	return removeIfExists(c.metadataPath(key))
+func (c *Cache[MetadataT]) removeMetadata(key cache.CacheKey) error {
+	return c.index.delete(key.Hex)
 }
 
 func removeIfExists(path string) error {
@@ -146,7 +265,8 @@ func (c *Cache[MetadataT]) updateMetadata(key cache.CacheKey, modifier func(*cac
 
 	modifier(meta)
 	meta.LastAccess = time.Now()
-	c.writeMetadataSidecar(key, meta)
+	c.writeMetadata(key, meta)
+	c.janitor.Track(key, meta)
 
 	if recordMetrics {
 		metrics.Global.Cache.CacheHits.Increment()
@@ -164,6 +284,40 @@ func (c *Cache[MetadataT]) UpdateMetadataQuiet(key cache.CacheKey, modifier func
 	return c.updateMetadata(key, modifier, false)
 }
 
+// Carries the hit count and pin of an entry over from another tier.
+func (c *Cache[MetadataT]) InheritAccessState(key cache.CacheKey, previous *cache.EntryMetadata[MetadataT]) {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	meta.Hits += previous.Hits
+	meta.Pinned = meta.Pinned || previous.Pinned
+	c.writeMetadata(key, meta)
+	c.janitor.Track(key, meta)
+}
+
+// Returns the metadata of an entry without counting it as an access.
+func (c *Cache[MetadataT]) PeekMetadata(key cache.CacheKey) (*cache.EntryMetadata[MetadataT], bool) {
+	lock := cache.GetLock(c.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if !exists {
+		return nil, false
+	}
+	return metadataSnapshot(meta), true
+}
+
 func (c *Cache[MetadataT]) getMetadata(key cache.CacheKey, recordMetrics bool) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
 	lock := cache.GetLock(c.locks, key)
 	lock.Lock() // Upgraded from RLock to Lock to prevent data race on LastAccess
@@ -191,6 +345,7 @@ func (c *Cache[MetadataT]) getMetadata(key cache.CacheKey, recordMetrics bool) (
 	}
 
 	metaPtr.LastAccess = time.Now() // Now safe because we have a full Lock
+	c.janitor.Track(key, metaPtr)
 	metaSnapshot := metadataSnapshot(metaPtr)
 
 	slog.Debug("Successfully retrieved metadata", "key", key.Hex)
@@ -204,3 +359,36 @@ func (c *Cache[MetadataT]) GetMetadata(key cache.CacheKey) (meta *cache.EntryMet
 func (c *Cache[MetadataT]) GetMetadataQuiet(key cache.CacheKey) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
 	return c.getMetadata(key, false)
 }
+
+func (c *Cache[MetadataT]) Entries(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
+	c.mu.RLock()
+	keys := slices.Collect(maps.Keys(c.entriesMetadata))
+	c.mu.RUnlock()
+
+	for _, key := range keys {
+		lock := cache.GetLock(c.locks, key)
+		lock.RLock()
+		c.mu.RLock()
+		metaPtr, exists := c.entriesMetadata[key]
+		c.mu.RUnlock()
+
+		var meta *cache.EntryMetadata[MetadataT]
+		if exists {
+			meta = metadataSnapshot(metaPtr)
+		}
+		lock.RUnlock()
+
+		if !exists {
+			continue
+		}
+		if !yield(key, meta) {
+			break
+		}
+	}
+}
+
+func (c *Cache[MetadataT]) KeysByTag(tag string) []cache.CacheKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tags.Keys(tag)
+}
@@ -0,0 +1,4 @@
+CREATE TABLE IF NOT EXISTS entries (
+	key      TEXT PRIMARY KEY,
+	metadata BLOB NOT NULL -- JSON-encoded cache.EntryMetadata
+) WITHOUT ROWID;
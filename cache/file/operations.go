@@ -28,7 +28,7 @@ func (c *Cache[MetadataT]) get(key cache.CacheKey, recordMetrics bool) (*cache.E
 		return nil, cache.ErrCacheEntryNotFound
 	}
 
-	fileName := c.dataPath(key)
+	fileName := c.blobPath(entryMeta.ContentHash)
 	dataFile, err := os.Open(fileName)
 	if err != nil {
 		if recordMetrics {
@@ -45,6 +45,8 @@ func (c *Cache[MetadataT]) get(key cache.CacheKey, recordMetrics bool) (*cache.E
 	}
 
 	entryMeta.LastAccess = time.Now()
+	entryMeta.Hits++
+	c.janitor.Track(key, entryMeta)
 	metaSnapshot := metadataSnapshot(entryMeta)
 
 	if recordMetrics {
@@ -71,37 +73,26 @@ func (c *Cache[MetadataT]) Cache(key cache.CacheKey, data io.Reader, expires tim
 	lock.Lock()
 	defer lock.Unlock()
 
-	c.mu.RLock()
-	oldMeta, replacing := c.entriesMetadata[key]
-	oldSize := int64(0)
-	if replacing {
-		oldSize = oldMeta.Size
-	}
-	c.mu.RUnlock()
-
-	fileName := c.dataPath(key)
-	file, err := os.Create(fileName)
-	if err != nil {
-		metrics.Global.Cache.CacheErrors.Increment()
-		slog.Error("Failed to create cache file", "key", key.Hex, "error", err)
-		return nil, fmt.Errorf("%w: failed to create cache file '%s'", ErrCreate, fileName)
-	}
-
-	fileSize, err := io.Copy(file, data)
+	// The data is written to a temporary file first and only becomes visible once it was written completely.
+	// A failed or rejected write therefore never leaves a truncated entry behind or affects the previous one.
+	tempName, hash, fileSize, err := c.writeTempBlob(data)
 	if err != nil {
-		file.Close()
-		os.Remove(fileName)
 		metrics.Global.Cache.CacheErrors.Increment()
 		slog.Error("Failed to write cache file", "key", key.Hex, "error", err)
-		return nil, fmt.Errorf("%w: failed to write cache file '%s'", ErrWrite, fileName)
+		return nil, err
 	}
 
 	if fileSize == 0 {
-		file.Close()
-		os.Remove(fileName)
+		os.Remove(tempName)
 		metrics.Global.Cache.CacheErrors.Increment()
 		slog.Error("Cache file is empty", "key", key.Hex, "file_size", fileSize)
-		return nil, fmt.Errorf("%w: wrote 0 bytes to cache file '%s'", ErrEmpty, fileName)
+		return nil, fmt.Errorf("%w: wrote 0 bytes to cache file for key '%s'", ErrEmpty, key.Hex)
+	}
+
+	if err := c.commitBlob(tempName, hash, fileSize); err != nil {
+		metrics.Global.Cache.CacheErrors.Increment()
+		slog.Error("Failed to store cache file", "key", key.Hex, "error", err)
+		return nil, err
 	}
 
 	now := time.Now()
@@ -110,35 +101,45 @@ func (c *Cache[MetadataT]) Cache(key cache.CacheKey, data io.Reader, expires tim
 		LastAccess:  now,
 		Expires:     expires,
 		Size:        fileSize,
+		ContentHash: hash,
 		Object:      metadata,
 	}
 
-	maxCacheSize := c.maxCacheSize.Get()
-	if c.byteSize.Get()-oldSize+fileSize >= maxCacheSize {
-		c.janitor.Evict(maxCacheSize)
-	}
-
 	c.mu.Lock()
 	previousMeta, replaced := c.entriesMetadata[key]
+	if replaced {
+		meta.Pinned = previousMeta.Pinned // A refreshed entry stays pinned.
+		c.tags.Remove(key, cache.TagsOf(previousMeta.Object))
+	}
 	c.entriesMetadata[key] = meta
+	c.tags.Add(key, cache.TagsOf(metadata))
 	c.mu.Unlock()
 
+	c.referencedBytes.Add(fileSize)
 	if replaced {
-		cache.DecrementCacheSize(&c.byteSize, previousMeta.Size)
+		c.referencedBytes.Sub(previousMeta.Size)
+		if err := c.releaseBlob(previousMeta.ContentHash, previousMeta.Size); err != nil {
+			slog.Error("Failed to release replaced cache body", "key", key.Hex, "error", err)
+		}
 	} else {
 		cache.IncrementCacheEntries()
 	}
-	cache.AddCacheSize(&c.byteSize, fileSize)
-	c.writeMetadataSidecar(key, meta)
+	c.writeMetadata(key, meta)
+	c.janitor.Track(key, meta)
 
-	slog.Debug("Successfully cached data", "key", key.Hex, "size", fileSize)
+	maxCacheSize := c.maxCacheSize.Get()
+	if c.byteSize.Get() >= maxCacheSize {
+		c.janitor.Evict(maxCacheSize)
+	}
 
-	slog.Debug("Seeking to the beginning of written cache file...", "key", key.Hex)
-	_, err = file.Seek(0, io.SeekStart)
+	slog.Debug("Successfully cached data", "key", key.Hex, "size", fileSize, "hash", hash)
+
+	blobPath := c.blobPath(hash)
+	file, err := os.Open(blobPath)
 	if err != nil {
 		metrics.Global.Cache.CacheErrors.Increment()
-		slog.Error("Failed to seek to start of cache file", "key", key.Hex, "error", err)
-		return nil, fmt.Errorf("%w: failed to seek to start of cache file '%s'", ErrRead, fileName)
+		slog.Error("Failed to open written cache file", "key", key.Hex, "error", err)
+		return nil, fmt.Errorf("%w: failed to open cache file '%s'", ErrRead, blobPath)
 	}
 
 	return &cache.Entry[MetadataT]{
@@ -179,9 +180,9 @@ func (c *Cache[MetadataT]) ensureRemove(key cache.CacheKey) error {
 		slog.Error("Failed to remove cached file", "key", key.Hex, "error", fileErr)
 		return fmt.Errorf("%w: failed to remove cached file '%s'", fileErr, c.dataPath(key))
 	}
-	if metaErr := c.removeMetadataSidecar(key); metaErr != nil {
-		slog.Error("Failed to remove cache metadata sidecar", "key", key.Hex, "error", metaErr)
-		return fmt.Errorf("%w: failed to remove cache metadata sidecar for key '%s'", ErrRemove, key.Hex)
+	if metaErr := c.removeMetadata(key); metaErr != nil {
+		slog.Error("Failed to remove cache metadata from the index", "key", key.Hex, "error", metaErr)
+		return fmt.Errorf("%w: failed to remove cache metadata for key '%s'", ErrRemove, key.Hex)
 	}
 	if !exists {
 		return cache.ErrCacheEntryNotFound
@@ -189,12 +190,15 @@ func (c *Cache[MetadataT]) ensureRemove(key cache.CacheKey) error {
 
 	c.mu.Lock()
 	delete(c.entriesMetadata, key)
+	c.tags.Remove(key, cache.TagsOf(meta.Object))
 	c.mu.Unlock()
+	c.janitor.Untrack(key)
 
 	cache.DecrementCacheEntries()
-	cache.DecrementCacheSize(&c.byteSize, meta.Size)
+	c.referencedBytes.Sub(meta.Size)
 
-	return nil
+	// The body itself is only removed once no other entry references it.
+	return c.releaseBlob(meta.ContentHash, meta.Size)
 }
 
 func (c *Cache[MetadataT]) Stats() cache.Stats {
@@ -202,10 +206,12 @@ func (c *Cache[MetadataT]) Stats() cache.Stats {
 	entries := len(c.entriesMetadata)
 	c.mu.RUnlock()
 
+	bytes := c.byteSize.Get()
 	return cache.Stats{
-		Entries:  entries,
-		Bytes:    c.byteSize.Get(),
-		MaxBytes: c.maxCacheSize.Get(),
+		Entries:    entries,
+		Bytes:      bytes,
+		MaxBytes:   c.maxCacheSize.Get(),
+		DedupBytes: max(0, c.referencedBytes.Get()-bytes),
 	}
 }
 
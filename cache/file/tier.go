@@ -0,0 +1,77 @@
+package file
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reservoir/cache"
+	"time"
+)
+
+func (c *Cache[MetadataT]) DemotionCandidates(cutoff time.Time) []cache.CacheKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	keys := make([]cache.CacheKey, 0)
+	for key, meta := range c.entriesMetadata {
+		if meta.LastAccess.Before(cutoff) {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (c *Cache[MetadataT]) DemoteEntry(key cache.CacheKey, cutoff time.Time, write func(data io.Reader, meta *cache.EntryMetadata[MetadataT]) error) error {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if !exists || !meta.LastAccess.Before(cutoff) {
+		return nil
+	}
+	if meta.Expires.Before(time.Now()) && !meta.Pinned {
+		return c.ensureRemove(key)
+	}
+
+	if err := c.writeEntryTo(meta, write); err != nil {
+		return err
+	}
+	return c.ensureRemove(key)
+}
+
+// Removes an entry for the janitor, which holds its lock. Evicted entries that are still fresh are moved to
+// the next tier first.
+func (c *Cache[MetadataT]) removeOrDemote(key cache.CacheKey) error {
+	if c.demote == nil {
+		return c.ensureRemove(key)
+	}
+
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if !exists || meta.Expires.Before(time.Now()) {
+		return c.ensureRemove(key)
+	}
+
+	if err := c.writeEntryTo(meta, func(data io.Reader, meta *cache.EntryMetadata[MetadataT]) error {
+		return c.demote(key, data, meta)
+	}); err != nil {
+		slog.Warn("Failed to move evicted entry to the next cache tier", "key", key.Hex, "error", err)
+	}
+	return c.ensureRemove(key)
+}
+
+func (c *Cache[MetadataT]) writeEntryTo(meta *cache.EntryMetadata[MetadataT], write func(data io.Reader, meta *cache.EntryMetadata[MetadataT]) error) error {
+	blobPath := c.blobPath(meta.ContentHash)
+	data, err := os.Open(blobPath)
+	if err != nil {
+		return fmt.Errorf("%w: failed to open cache file '%s'", ErrRead, blobPath)
+	}
+	defer data.Close()
+
+	return write(data, metadataSnapshot(meta))
+}
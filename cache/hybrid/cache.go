@@ -7,6 +7,7 @@ import (
 	"reservoir/cache"
 	filecache "reservoir/cache/file"
 	memorycache "reservoir/cache/memory"
+	s3cache "reservoir/cache/s3"
 	"reservoir/config"
 	"reservoir/metrics"
 	"reservoir/utils/atomics"
@@ -17,7 +18,8 @@ import (
 )
 
 type Cache[MetadataT any] struct {
-	file         *filecache.Cache[MetadataT]
+	file         storageCache[MetadataT]   // The first tier below memory, which receives entries that don't fit there.
+	tiers        []*storageTier[MetadataT] // The tiers below memory, fastest first.
 	memory       *memorycache.Cache[MetadataT]
 	maxCacheSize atomics.Int64
 	demoteAfter  atomics.Int64
@@ -29,15 +31,27 @@ type Cache[MetadataT any] struct {
 	subs        config.ConfigSubscriber
 }
 
-func New[MetadataT any](cfg *config.Config, rootDir string, memoryBudgetPercent int, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) *Cache[MetadataT] {
+type storageTier[MetadataT any] struct {
+	cache       storageCache[MetadataT]
+	demoteAfter time.Duration // 0 if entries only leave the tier when it is full.
+}
+
+// The tiers below memory are taken from cache.hybrid.tiers. Without them, a single file tier in rootDir shares maxCacheSize with memory.
+func New[MetadataT any](cfg *config.Config, rootDir string, memoryBudgetPercent int, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) (*Cache[MetadataT], error) {
+	tiers, err := newStorageTiers[MetadataT](cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	c := &Cache[MetadataT]{
-		file:         filecache.NewTier[MetadataT](cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx),
+		tiers:        tiers,
 		memory:       memorycache.NewTier[MetadataT](cfg, memoryBudgetPercent, maxCacheSize, cleanupInterval, shardCount, ctx),
 		maxCacheSize: atomics.NewInt64(maxCacheSize),
 		demoteAfter:  atomics.NewInt64(int64(cfg.Cache.Hybrid.DemoteAfter.Read().Cast())),
 		stopDemoter:  make(chan struct{}),
 		demoterDone:  make(chan struct{}),
 	}
+	c.file = c.tiers[0].cache
 
 	c.subs.Add(cfg.Cache.MaxCacheSize.OnChange(func(newSize bytesize.ByteSize) {
 		c.maxCacheSize.Set(newSize.Bytes())
@@ -48,7 +62,45 @@ func New[MetadataT any](cfg *config.Config, rootDir string, memoryBudgetPercent
 	}))
 
 	c.startDemoter(ctx)
-	return c
+	return c, nil
+}
+
+// Creates the tiers from the slowest up, so every tier can hand its evicted entries to the one below.
+// If a tier can't be created, the ones created before it are destroyed again.
+func newStorageTiers[MetadataT any](cfg *config.Config, rootDir string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) ([]*storageTier[MetadataT], error) {
+	rawTiers := cfg.Cache.Hybrid.Tiers.Read().Values()
+	if len(rawTiers) == 0 {
+		file, err := filecache.NewTier[MetadataT](cfg, rootDir, maxCacheSize, cleanupInterval, shardCount, ctx)
+		if err != nil {
+			return nil, err
+		}
+		return []*storageTier[MetadataT]{{cache: file}}, nil
+	}
+
+	tiers := make([]*storageTier[MetadataT], len(rawTiers))
+	var demote filecache.DemoteFunc[MetadataT]
+	for i := len(rawTiers) - 1; i >= 0; i-- {
+		// The tiers were checked when the config was verified.
+		tierCfg, _ := config.ParseHybridTier(rawTiers[i])
+		tier := &storageTier[MetadataT]{demoteAfter: tierCfg.DemoteAfter}
+		if bucket, prefix, ok := tierCfg.ObjectStorage(); ok {
+			tier.cache = s3cache.NewTier[MetadataT](cfg, bucket, prefix, tierCfg.MaxSize, cleanupInterval, shardCount, ctx)
+		} else {
+			file, err := filecache.NewSizedTier(cfg, tierCfg.Dir, tierCfg.MaxSize, cleanupInterval, shardCount, ctx, demote)
+			if err != nil {
+				for _, created := range tiers[i+1:] {
+					created.cache.Destroy()
+				}
+				return nil, err
+			}
+			tier.cache = file
+		}
+		tiers[i] = tier
+		demote = func(key cache.CacheKey, data io.Reader, meta *cache.EntryMetadata[MetadataT]) error {
+			return writeToTier(tier.cache, key, data, meta)
+		}
+	}
+	return tiers, nil
 }
 
 func (c *Cache[MetadataT]) startDemoter(ctx context.Context) {
@@ -56,8 +108,7 @@ func (c *Cache[MetadataT]) startDemoter(ctx context.Context) {
 		defer close(c.demoterDone)
 
 		for {
-			demoteAfter := time.Duration(c.demoteAfter.Get())
-			timer := time.NewTimer(demotionInterval(demoteAfter))
+			timer := time.NewTimer(demotionInterval(c.shortestDemoteAfter()))
 
 			select {
 			case <-timer.C:
@@ -80,7 +131,9 @@ func (c *Cache[MetadataT]) Destroy() {
 	})
 	c.subs.UnsubscribeAll()
 	c.memory.Destroy()
-	c.file.Destroy()
+	for _, tier := range c.tiers {
+		tier.cache.Destroy()
+	}
 }
 
 func (c *Cache[MetadataT]) recordCacheHit() {
@@ -104,101 +157,140 @@ func (c *Cache[MetadataT]) Get(key cache.CacheKey) (*cache.Entry[MetadataT], err
 		return nil, err
 	}
 
-	entry, err := c.file.GetQuiet(key)
-	if err != nil {
+	for i, tier := range c.tiers {
+		entry, err := tier.cache.GetQuiet(key)
 		if errors.Is(err, cache.ErrCacheEntryNotFound) {
-			c.recordCacheMiss()
-		} else {
+			continue
+		}
+		if err != nil {
 			c.recordCacheError()
+			return nil, err
 		}
-		return nil, err
-	}
 
-	c.recordCacheHit()
-	if promoted, ok := c.promoteToMemory(key, entry); ok {
-		return promoted, nil
+		c.recordCacheHit()
+		return c.promote(key, i, entry), nil
 	}
-	return entry, nil
+
+	c.recordCacheMiss()
+	return nil, cache.ErrCacheEntryNotFound
 }
 
 func (c *Cache[MetadataT]) Cache(key cache.CacheKey, data io.Reader, expires time.Time, metadata MetadataT) (*cache.Entry[MetadataT], error) {
+	// The tiers only keep the pin of an entry they replace themselves, but the new copy may land in the other tier.
+	pinned := c.isPinned(key)
+
+	var entry *cache.Entry[MetadataT]
+	var err error
 	if sizeHint, ok := cache.ReaderSizeHint(data); ok {
-		return c.cacheKnownSize(key, data, sizeHint, expires, metadata)
+		entry, err = c.cacheKnownSize(key, data, sizeHint, expires, metadata)
+	} else {
+		entry, err = c.cacheUnknownSize(key, data, expires, metadata)
+	}
+	if err != nil || !pinned {
+		return entry, err
 	}
-	return c.cacheUnknownSize(key, data, expires, metadata)
+
+	pin := func(meta *cache.EntryMetadata[MetadataT]) { meta.Pinned = true }
+	_ = c.memory.UpdateMetadataQuiet(key, pin)
+	_ = c.file.UpdateMetadataQuiet(key, pin)
+	entry.Metadata.Pinned = true
+	return entry, nil
+}
+
+func (c *Cache[MetadataT]) isPinned(key cache.CacheKey) bool {
+	if meta, ok := c.memory.PeekMetadata(key); ok && meta.Pinned {
+		return true
+	}
+	for _, tier := range c.tiers {
+		if meta, ok := tier.cache.PeekMetadata(key); ok && meta.Pinned {
+			return true
+		}
+	}
+	return false
 }
 
 func (c *Cache[MetadataT]) Delete(key cache.CacheKey) error {
-	memoryErr := c.memory.Delete(key)
-	fileErr := c.file.Delete(key)
+	found := false
+	var errs []error
+	for _, tier := range c.allTiers() {
+		err := tier.Delete(key)
+		if errors.Is(err, cache.ErrCacheEntryNotFound) {
+			continue
+		}
+		found = true
+		errs = append(errs, err)
+	}
 
-	if errors.Is(memoryErr, cache.ErrCacheEntryNotFound) && errors.Is(fileErr, cache.ErrCacheEntryNotFound) {
+	if !found {
 		return cache.ErrCacheEntryNotFound
 	}
-	if errors.Is(memoryErr, cache.ErrCacheEntryNotFound) {
-		memoryErr = nil
-	}
-	if errors.Is(fileErr, cache.ErrCacheEntryNotFound) {
-		fileErr = nil
-	}
-	return errors.Join(memoryErr, fileErr)
+	return errors.Join(errs...)
 }
 
 func (c *Cache[MetadataT]) Stats() cache.Stats {
 	memoryStats := c.memory.Stats()
-	fileStats := c.file.Stats()
-
-	return cache.Stats{
-		Entries:        memoryStats.Entries + fileStats.Entries,
-		Bytes:          memoryStats.Bytes + fileStats.Bytes,
+	stats := cache.Stats{
+		Entries:        memoryStats.Entries,
+		Bytes:          memoryStats.Bytes,
 		MaxBytes:       c.maxCacheSize.Get(),
 		MemoryCapBytes: memoryStats.MemoryCapBytes,
 	}
+	for _, tier := range c.tiers {
+		fileStats := tier.cache.Stats()
+		stats.Entries += fileStats.Entries
+		stats.Bytes += fileStats.Bytes
+		stats.DedupBytes += fileStats.DedupBytes
+	}
+	return stats
 }
 
 func (c *Cache[MetadataT]) Clear() error {
-	memoryErr := c.memory.Clear()
-	fileErr := c.file.Clear()
-
-	if errors.Is(memoryErr, cache.ErrCacheEntryNotFound) {
-		memoryErr = nil
+	var errs []error
+	for _, tier := range c.allTiers() {
+		if err := tier.Clear(); !errors.Is(err, cache.ErrCacheEntryNotFound) {
+			errs = append(errs, err)
+		}
 	}
-	return errors.Join(fileErr, memoryErr)
+	return errors.Join(errs...)
 }
 
 func (c *Cache[MetadataT]) GetMetadata(key cache.CacheKey) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
-	if meta, stale, err := c.memory.GetMetadataQuiet(key); err == nil {
-		c.recordCacheHit()
-		return meta, stale, nil
-	} else if !errors.Is(err, cache.ErrCacheEntryNotFound) {
-		c.recordCacheError()
-		return nil, false, err
-	}
-
-	meta, stale, err = c.file.GetMetadataQuiet(key)
-	if err != nil {
+	for _, tier := range c.allTiers() {
+		meta, stale, err := tier.GetMetadataQuiet(key)
 		if errors.Is(err, cache.ErrCacheEntryNotFound) {
-			c.recordCacheMiss()
-		} else {
+			continue
+		}
+		if err != nil {
 			c.recordCacheError()
+			return nil, false, err
 		}
-		return nil, false, err
+
+		c.recordCacheHit()
+		return meta, stale, nil
 	}
 
-	c.recordCacheHit()
-	return meta, stale, nil
+	c.recordCacheMiss()
+	return nil, false, cache.ErrCacheEntryNotFound
 }
 
+// Updates the entry in all tiers, so a copy shadowed by a faster tier doesn't fall behind.
 func (c *Cache[MetadataT]) UpdateMetadata(key cache.CacheKey, modifier func(*cache.EntryMetadata[MetadataT])) error {
-	if err := c.memory.UpdateMetadataQuiet(key, modifier); err == nil {
-		c.recordCacheHit()
-		return nil
-	} else if !errors.Is(err, cache.ErrCacheEntryNotFound) {
-		c.recordCacheError()
-		return err
+	found := false
+	var errs []error
+	for _, tier := range c.allTiers() {
+		err := tier.UpdateMetadataQuiet(key, modifier)
+		if errors.Is(err, cache.ErrCacheEntryNotFound) {
+			continue
+		}
+		found = true
+		errs = append(errs, err)
 	}
 
-	if err := c.file.UpdateMetadataQuiet(key, modifier); err != nil {
+	if !found {
+		c.recordCacheError()
+		return cache.ErrCacheEntryNotFound
+	}
+	if err := errors.Join(errs...); err != nil {
 		c.recordCacheError()
 		return err
 	}
@@ -206,3 +298,34 @@ func (c *Cache[MetadataT]) UpdateMetadata(key cache.CacheKey, modifier func(*cac
 	c.recordCacheHit()
 	return nil
 }
+
+// Yields the fastest tier first. Entries that are present in several tiers are only yielded once.
+func (c *Cache[MetadataT]) Entries(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
+	seen := make(map[cache.CacheKey]struct{})
+	for _, tier := range c.allTiers() {
+		for key, meta := range tier.Entries {
+			if _, ok := seen[key]; ok {
+				continue
+			}
+			seen[key] = struct{}{}
+			if !yield(key, meta) {
+				return
+			}
+		}
+	}
+}
+
+// Entries that are present in several tiers are only returned once.
+func (c *Cache[MetadataT]) KeysByTag(tag string) []cache.CacheKey {
+	keys := make([]cache.CacheKey, 0)
+	seen := make(map[cache.CacheKey]struct{})
+	for _, tier := range c.allTiers() {
+		for _, key := range tier.KeysByTag(tag) {
+			if _, ok := seen[key]; !ok {
+				seen[key] = struct{}{}
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
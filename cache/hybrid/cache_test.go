@@ -7,6 +7,7 @@ import (
 	"reservoir/config"
 	"reservoir/metrics"
 	"reservoir/utils/duration"
+	"reservoir/utils/stringlist"
 	"testing"
 	"time"
 )
@@ -37,7 +38,10 @@ func newTestHybridCacheWithMax(t *testing.T, demoteAfter time.Duration, maxCache
 	cfg := config.NewDefault()
 	cfg.Cache.Hybrid.DemoteAfter.Overwrite(duration.Duration(demoteAfter))
 
-	c := New[TestMeta](cfg, t.TempDir(), 50, maxCacheSize, time.Hour, 16, t.Context())
+	c, err := New[TestMeta](cfg, t.TempDir(), 50, maxCacheSize, time.Hour, 16, t.Context())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
 	t.Cleanup(c.Destroy)
 	return c
 }
@@ -541,3 +545,165 @@ func TestHybridCache_FileTierEvictionPreservesGlobalByteMetrics(t *testing.T) {
 		t.Fatalf("expected hybrid stats bytes %d after file-tier eviction, got %d", expectedBytes, stats.Bytes)
 	}
 }
+
+func TestHybridCache_HitsSurviveDemotionAndPromotion(t *testing.T) {
+	c := newTestHybridCache(t, time.Hour)
+
+	key := cache.FromString("hybrid-hits-key")
+	entry, err := c.Cache(key, bytes.NewReader([]byte("hits body")), time.Now().Add(time.Hour), TestMeta{ID: "hits"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+
+	for range 2 {
+		retrieved, err := c.Get(key)
+		if err != nil {
+			t.Fatalf("Get failed: %v", err)
+		}
+		retrieved.Data.Close()
+	}
+
+	setHybridMemoryLastAccess(t, c, key, time.Now().Add(-2*time.Hour))
+	c.demoteIdleEntries()
+
+	for _, meta := range c.Entries {
+		if meta.Tier != cache.TierFile || meta.Hits != 2 {
+			t.Fatalf("expected demoted file entry with 2 hits, got tier %q with %d hits", meta.Tier, meta.Hits)
+		}
+	}
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get after demotion failed: %v", err)
+	}
+	retrieved.Data.Close()
+	if retrieved.Metadata.Hits != 3 {
+		t.Fatalf("expected promoted entry to keep its hits, got %d", retrieved.Metadata.Hits)
+	}
+
+	yielded := 0
+	for _, meta := range c.Entries {
+		yielded++
+		if meta.Tier != cache.TierMemory || meta.Hits != 3 {
+			t.Fatalf("expected promoted memory entry with 3 hits, got tier %q with %d hits", meta.Tier, meta.Hits)
+		}
+	}
+	if yielded != 1 {
+		t.Fatalf("expected an entry shadowed in both tiers to be yielded once, got %d", yielded)
+	}
+}
+
+func newTestTieredHybridCache(t *testing.T, tiers ...string) *Cache[TestMeta] {
+	t.Helper()
+
+	cfg := config.NewDefault()
+	cfg.Cache.Hybrid.DemoteAfter.Overwrite(duration.Duration(time.Hour))
+	cfg.Cache.Hybrid.Tiers.Overwrite(stringlist.New(tiers...))
+
+	c, err := New[TestMeta](cfg, t.TempDir(), 50, 1024*1024*1024, time.Hour, 16, t.Context())
+	if err != nil {
+		t.Fatalf("failed to create cache: %v", err)
+	}
+	t.Cleanup(c.Destroy)
+	return c
+}
+
+func TestHybridCache_FileTierEvictionMovesEntriesToTheNextTier(t *testing.T) {
+	c := newTestTieredHybridCache(t, t.TempDir()+"=1000,1h", t.TempDir()+"=10000")
+	setHybridMemoryCap(c, 100)
+
+	oldKey := cache.FromString("hybrid-tier-evicted-key")
+	oldData := bytes.Repeat([]byte("o"), 600)
+	oldEntry, err := c.Cache(oldKey, bytes.NewReader(oldData), time.Now().Add(time.Hour), TestMeta{ID: "old"})
+	if err != nil {
+		t.Fatalf("Cache old entry failed: %v", err)
+	}
+	oldEntry.Data.Close()
+
+	newEntry, err := c.Cache(cache.FromString("hybrid-tier-kept-key"), bytes.NewReader(bytes.Repeat([]byte("n"), 600)), time.Now().Add(time.Hour), TestMeta{ID: "new"})
+	if err != nil {
+		t.Fatalf("Cache new entry failed: %v", err)
+	}
+	newEntry.Data.Close()
+
+	if stats := c.tiers[0].cache.Stats(); stats.Entries != 1 {
+		t.Fatalf("expected the full first tier to keep 1 entry, got %d", stats.Entries)
+	}
+	lowerEntry, err := c.tiers[1].cache.Get(oldKey)
+	if err != nil {
+		t.Fatalf("expected the evicted entry in the second tier: %v", err)
+	}
+	if content := readEntryData(t, lowerEntry); !bytes.Equal(content, oldData) {
+		t.Fatalf("expected moved data %q, got %q", oldData, content)
+	}
+	if stats := c.Stats(); stats.Entries != 2 {
+		t.Fatalf("expected both entries to stay cached, got %d", stats.Entries)
+	}
+}
+
+func TestHybridCache_DemotesIdleFileEntriesToTheNextTier(t *testing.T) {
+	c := newTestTieredHybridCache(t, t.TempDir()+"=1M,1h", t.TempDir()+"=10M")
+
+	key := cache.FromString("hybrid-tier-idle-key")
+	entry, err := c.Cache(key, bytes.NewReader([]byte("idle body")), time.Now().Add(time.Hour), TestMeta{ID: "idle"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+	setHybridMemoryLastAccess(t, c, key, time.Now().Add(-2*time.Hour))
+	c.demoteIdleEntries()
+
+	if _, ok := c.tiers[0].cache.PeekMetadata(key); !ok {
+		t.Fatalf("expected idle memory entry in the first file tier")
+	}
+
+	c.demoteFileEntriesOlderThan(0, time.Now().Add(time.Minute))
+
+	if _, ok := c.tiers[0].cache.PeekMetadata(key); ok {
+		t.Fatalf("expected idle entry to leave the first file tier")
+	}
+	meta, ok := c.tiers[1].cache.PeekMetadata(key)
+	if !ok {
+		t.Fatalf("expected idle entry in the second file tier")
+	}
+	if meta.Object.ID != "idle" {
+		t.Fatalf("expected demoted metadata ID idle, got %q", meta.Object.ID)
+	}
+}
+
+func TestHybridCache_HitPromotesLargeEntryToTheTierAbove(t *testing.T) {
+	c := newTestTieredHybridCache(t, t.TempDir()+"=1M,1h", t.TempDir()+"=10M")
+	setHybridMemoryCap(c, 100)
+
+	key := cache.FromString("hybrid-tier-promote-key")
+	data := bytes.Repeat([]byte("p"), 500)
+	entry, err := c.Cache(key, bytes.NewReader(data), time.Now().Add(time.Hour), TestMeta{ID: "promote"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+	c.demoteFileEntriesOlderThan(0, time.Now().Add(time.Minute))
+	if _, ok := c.tiers[1].cache.PeekMetadata(key); !ok {
+		t.Fatalf("expected entry in the second file tier before the hit")
+	}
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if content := readEntryData(t, retrieved); !bytes.Equal(content, data) {
+		t.Fatalf("expected promoted data %q, got %q", data, content)
+	}
+
+	if stats := c.memory.Stats(); stats.Entries != 0 {
+		t.Fatalf("expected entry too large for memory to skip it, got %d memory entries", stats.Entries)
+	}
+	meta, ok := c.tiers[0].cache.PeekMetadata(key)
+	if !ok {
+		t.Fatalf("expected hit to promote the entry to the first file tier")
+	}
+	if meta.Hits != 1 {
+		t.Fatalf("expected promoted entry to keep its hit, got %d hits", meta.Hits)
+	}
+}
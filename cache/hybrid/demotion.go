@@ -3,6 +3,8 @@ package hybrid
 import (
 	"io"
 	"log/slog"
+	"reservoir/cache"
+	filecache "reservoir/cache/file"
 	"time"
 )
 
@@ -21,26 +23,40 @@ func demotionInterval(demoteAfter time.Duration) time.Duration {
 }
 
 func (c *Cache[MetadataT]) demoteIdleEntries() {
-	demoteAfter := time.Duration(c.demoteAfter.Get())
-	if demoteAfter <= 0 {
-		return
+	if demoteAfter := time.Duration(c.demoteAfter.Get()); demoteAfter > 0 {
+		c.demoteEntriesOlderThan(time.Now().Add(-demoteAfter))
+	}
+	for i, tier := range c.tiers {
+		if tier.demoteAfter > 0 && i+1 < len(c.tiers) {
+			c.demoteFileEntriesOlderThan(i, time.Now().Add(-tier.demoteAfter))
+		}
 	}
-
-	cutoff := time.Now().Add(-demoteAfter)
-	c.demoteEntriesOlderThan(cutoff)
 	c.enforceMaxCacheSize()
 }
 
 func (c *Cache[MetadataT]) demoteEntriesOlderThan(cutoff time.Time) {
 	for _, key := range c.memory.DemotionCandidates(cutoff) {
-		if err := c.memory.DemoteEntry(key, cutoff, func(data io.Reader, expires time.Time, metadata MetadataT) error {
-			demoted, err := c.file.Cache(key, data, expires, metadata)
-			if demoted != nil && demoted.Data != nil {
-				_ = demoted.Data.Close()
-			}
-			return err
+		if err := c.memory.DemoteEntry(key, cutoff, func(data io.Reader, meta *cache.EntryMetadata[MetadataT]) error {
+			return writeToTier(c.file, key, data, meta)
 		}); err != nil {
 			slog.Debug("Failed to demote memory cache entry", "key", key.Hex, "error", err)
 		}
 	}
 }
+
+// Moves the idle entries of a file tier to the next one.
+func (c *Cache[MetadataT]) demoteFileEntriesOlderThan(tier int, cutoff time.Time) {
+	// Object storage is always the last tier, so only file tiers demote.
+	from, ok := c.tiers[tier].cache.(*filecache.Cache[MetadataT])
+	if !ok {
+		return
+	}
+	to := c.tiers[tier+1].cache
+	for _, key := range from.DemotionCandidates(cutoff) {
+		if err := from.DemoteEntry(key, cutoff, func(data io.Reader, meta *cache.EntryMetadata[MetadataT]) error {
+			return writeToTier(to, key, data, meta)
+		}); err != nil {
+			slog.Debug("Failed to demote file cache entry", "key", key.Hex, "tier", tier, "error", err)
+		}
+	}
+}
@@ -25,23 +25,28 @@ func (r writeResult[MetadataT]) memoryShadowsFile() bool {
 	return r.placement == placementMemoryShadowingFile
 }
 
+// Evicts from the slowest file tier until all tiers together fit in the maximum cache size. The faster tiers
+// are bounded by their own capacity.
 func (c *Cache[MetadataT]) enforceMaxCacheSize() {
 	maxCacheSize := c.maxCacheSize.Get()
 	if maxCacheSize <= 0 {
 		return
 	}
 
-	memoryStats := c.memory.Stats()
-	fileStats := c.file.Stats()
-	if memoryStats.Bytes+fileStats.Bytes <= maxCacheSize {
+	last := c.tiers[len(c.tiers)-1].cache
+	otherBytes := c.memory.Stats().Bytes
+	for _, tier := range c.tiers[:len(c.tiers)-1] {
+		otherBytes += tier.cache.Stats().Bytes
+	}
+	if otherBytes+last.Stats().Bytes <= maxCacheSize {
 		return
 	}
 
-	allowedFileBytes := maxCacheSize - memoryStats.Bytes
+	allowedFileBytes := maxCacheSize - otherBytes
 	if allowedFileBytes < 0 {
 		allowedFileBytes = 0
 	}
-	c.file.EvictTo(allowedFileBytes)
+	last.EvictTo(allowedFileBytes)
 }
 
 func (c *Cache[MetadataT]) memoryLimit() int64 {
@@ -7,6 +7,48 @@ import (
 	"reservoir/cache"
 )
 
+// Moves an entry that was found in a file tier up. It goes to memory if it fits there, otherwise to the file tier
+// above. The copy it was read from stays behind, shadowed, until it is replaced or evicted.
+func (c *Cache[MetadataT]) promote(key cache.CacheKey, tier int, fileEntry *cache.Entry[MetadataT]) *cache.Entry[MetadataT] {
+	if promoted, ok := c.promoteToMemory(key, fileEntry); ok {
+		return promoted
+	}
+	if tier == 0 {
+		return fileEntry
+	}
+	if promoted, ok := c.promoteToFileTier(key, tier-1, fileEntry); ok {
+		return promoted
+	}
+	return fileEntry
+}
+
+func (c *Cache[MetadataT]) promoteToFileTier(key cache.CacheKey, tier int, fileEntry *cache.Entry[MetadataT]) (*cache.Entry[MetadataT], bool) {
+	if fileEntry == nil || fileEntry.Data == nil || fileEntry.Metadata == nil || fileEntry.Stale {
+		return fileEntry, false
+	}
+	if _, err := fileEntry.Data.Seek(0, io.SeekStart); err != nil {
+		slog.Debug("Skipping file tier promotion because cached data could not be rewound", "key", key.Hex, "error", err)
+		return fileEntry, false
+	}
+
+	target := c.tiers[tier].cache
+	promoted, err := target.Cache(key, fileEntry.Data, fileEntry.Metadata.Expires, fileEntry.Metadata.Object)
+	if err != nil {
+		if _, seekErr := fileEntry.Data.Seek(0, io.SeekStart); seekErr != nil {
+			slog.Warn("Failed to rewind file cache entry after file tier promotion failure", "key", key.Hex, "error", seekErr)
+		}
+		slog.Debug("Failed to promote file cache entry to a faster tier", "key", key.Hex, "tier", tier, "error", err)
+		return fileEntry, false
+	}
+
+	_ = fileEntry.Data.Close()
+	target.InheritAccessState(key, fileEntry.Metadata)
+	promoted.Metadata.Hits += fileEntry.Metadata.Hits
+	promoted.Metadata.Pinned = promoted.Metadata.Pinned || fileEntry.Metadata.Pinned
+	c.enforceMaxCacheSize()
+	return promoted, true
+}
+
 func (c *Cache[MetadataT]) promoteToMemory(key cache.CacheKey, fileEntry *cache.Entry[MetadataT]) (*cache.Entry[MetadataT], bool) {
 	if fileEntry == nil || fileEntry.Data == nil || fileEntry.Metadata == nil || fileEntry.Stale {
 		return fileEntry, false
@@ -42,6 +84,9 @@ func (c *Cache[MetadataT]) promoteToMemory(key cache.CacheKey, fileEntry *cache.
 	}
 
 	_ = fileEntry.Data.Close()
+	c.memory.InheritAccessState(key, fileEntry.Metadata)
+	promoted.Metadata.Hits += fileEntry.Metadata.Hits
+	promoted.Metadata.Pinned = promoted.Metadata.Pinned || fileEntry.Metadata.Pinned
 	c.enforceMaxCacheSize()
 	return promoted, true
 }
@@ -0,0 +1,62 @@
+package hybrid
+
+import (
+	"io"
+	"reservoir/cache"
+	"time"
+)
+
+// The operations all tiers have in common.
+type tierCache[MetadataT any] interface {
+	Delete(key cache.CacheKey) error
+	Clear() error
+	GetMetadataQuiet(key cache.CacheKey) (*cache.EntryMetadata[MetadataT], bool, error)
+	UpdateMetadataQuiet(key cache.CacheKey, modifier func(*cache.EntryMetadata[MetadataT])) error
+	Entries(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool)
+	KeysByTag(tag string) []cache.CacheKey
+}
+
+// A tier below memory, either a file cache or object storage.
+type storageCache[MetadataT any] interface {
+	tierCache[MetadataT]
+	Get(key cache.CacheKey) (*cache.Entry[MetadataT], error)
+	GetQuiet(key cache.CacheKey) (*cache.Entry[MetadataT], error)
+	Cache(key cache.CacheKey, data io.Reader, expires time.Time, metadata MetadataT) (*cache.Entry[MetadataT], error)
+	PeekMetadata(key cache.CacheKey) (*cache.EntryMetadata[MetadataT], bool)
+	InheritAccessState(key cache.CacheKey, previous *cache.EntryMetadata[MetadataT])
+	Stats() cache.Stats
+	EvictTo(maxCacheBytes int64)
+	Destroy()
+}
+
+// Returns the memory tier followed by the storage tiers, fastest first.
+func (c *Cache[MetadataT]) allTiers() []tierCache[MetadataT] {
+	tiers := make([]tierCache[MetadataT], 0, len(c.tiers)+1)
+	tiers = append(tiers, c.memory)
+	for _, tier := range c.tiers {
+		tiers = append(tiers, tier.cache)
+	}
+	return tiers
+}
+
+func (c *Cache[MetadataT]) shortestDemoteAfter() time.Duration {
+	shortest := time.Duration(c.demoteAfter.Get())
+	for _, tier := range c.tiers {
+		if tier.demoteAfter > 0 && (shortest <= 0 || tier.demoteAfter < shortest) {
+			shortest = tier.demoteAfter
+		}
+	}
+	return shortest
+}
+
+// Writes an entry that moves down from a faster tier, keeping its hits and pin.
+func writeToTier[MetadataT any](tier storageCache[MetadataT], key cache.CacheKey, data io.Reader, meta *cache.EntryMetadata[MetadataT]) error {
+	written, err := tier.Cache(key, data, meta.Expires, meta.Object)
+	if written != nil && written.Data != nil {
+		_ = written.Data.Close()
+	}
+	if err == nil {
+		tier.InheritAccessState(key, meta)
+	}
+	return err
+}
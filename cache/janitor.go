@@ -1,7 +1,6 @@
 package cache
 
 import (
-	"cmp"
 	"context"
 	"iter"
 	"log/slog"
@@ -9,16 +8,14 @@ import (
 	"reservoir/metrics"
 	"reservoir/utils/bytesize"
 	"reservoir/utils/duration"
-	"slices"
 	"sync"
 	"time"
 )
 
 type JanitorFunctions[MetadataT any] struct {
-	Iterate iter.Seq2[CacheKey, *EntryMetadata[MetadataT]]
+	Iterate iter.Seq2[CacheKey, *EntryMetadata[MetadataT]] // Only used once, to index the entries present when the janitor is created.
 	Remove  func(key CacheKey) error
 	Size    func() int64
-	Len     func() int
 	Lock    func(key CacheKey) *sync.RWMutex
 }
 
@@ -30,6 +27,8 @@ type Janitor[MetadataT any] struct {
 
 	functions             JanitorFunctions[MetadataT]
 	trackAggregateMetrics bool
+	evictMu               sync.Mutex // Serializes evictions.
+	index                 *entryIndex
 	subs                  config.ConfigSubscriber
 	cfg                   *config.Config
 }
@@ -41,17 +40,38 @@ func NewJanitor[MetadataT any](cfg *config.Config, interval time.Duration, funct
 		interval:              interval,
 		functions:             functions,
 		trackAggregateMetrics: trackAggregateMetrics,
+		index:                 newEntryIndex(NewEvictionPolicy(cfg.Cache.Eviction.Policy.Read())),
 		cfg:                   cfg,
 	}
+	for key, meta := range functions.Iterate {
+		j.Track(key, meta)
+	}
 
 	j.subs.Add(cfg.Cache.CleanupInterval.OnChange(func(newInterval duration.Duration) {
 		slog.Info("Cache cleanup interval changed", "new_interval", newInterval)
 		j.updateInterval(newInterval.Cast())
 	}))
+	j.subs.Add(cfg.Cache.Eviction.Policy.OnChange(func(newPolicy config.EvictionPolicy) {
+		slog.Info("Cache eviction policy changed", "new_policy", newPolicy)
+		j.evictMu.Lock()
+		defer j.evictMu.Unlock()
+		j.index.setPolicy(NewEvictionPolicy(newPolicy))
+	}))
 
 	return j
 }
 
+// Records that an entry was written, accessed or its metadata changed. Backends call this with the
+// entry's current metadata, so expired and evicted entries can be found without scanning the cache.
+func (j *Janitor[MetadataT]) Track(key CacheKey, meta *EntryMetadata[MetadataT]) {
+	j.index.update(key, indexedEntryOf(key, meta))
+}
+
+// Records that an entry was removed from the cache.
+func (j *Janitor[MetadataT]) Untrack(key CacheKey) {
+	j.index.remove(key)
+}
+
 func (j *Janitor[MetadataT]) updateInterval(newInterval time.Duration) {
 	select {
 	case j.intervalChanged <- newInterval:
@@ -122,38 +142,39 @@ func (j *Janitor[MetadataT]) cleanExpiredEntries() {
 
 	startCacheSize := j.functions.Size()
 
-	keysToRemove := make([]CacheKey, 0)
-
-	for key, meta := range j.functions.Iterate {
-		expired := meta.Expires.Before(time.Now())
-
-		if !expired {
-			continue
+	now := time.Now()
+	skipped := make([]CacheKey, 0)
+	for {
+		key, ok := j.index.popExpired(now)
+		if !ok {
+			break
 		}
-
-		slog.Info("Found expired cache entry for key", "key", key.Hex)
-		keysToRemove = append(keysToRemove, key)
-	}
-
-	for _, key := range keysToRemove {
 		slog.Info("Removing expired cache entry for key", "key", key.Hex)
 
 		lock := j.functions.Lock(key)
 		locked := lock.TryLock()
 		if !locked {
 			slog.Info("Failed to acquire lock for key", "key", key.Hex)
+			skipped = append(skipped, key)
+			continue
+		}
+		if !j.index.isExpired(key, now) {
+			lock.Unlock()
 			continue
 		}
 
 		if err := j.functions.Remove(key); err != nil {
 			lock.Unlock()
 			slog.Info("Failed to remove expired cache entry for key", "key", key.Hex, "error", err)
+			skipped = append(skipped, key)
 			continue
 		}
+		j.Untrack(key)
 		lock.Unlock()
 
 		slog.Info("Removed expired cache entry for key", "key", key.Hex)
 	}
+	j.index.restoreExpiry(skipped)
 
 	endCacheSize := j.functions.Size()
 	if j.trackAggregateMetrics {
@@ -164,65 +185,52 @@ func (j *Janitor[MetadataT]) cleanExpiredEntries() {
 	slog.Info("Cache cleanup complete", "new_size", endCacheSize)
 }
 
-// Evict entries until 80% of maxCacheBytes is reached
+// Evicts entries in the order of the eviction policy until the low-water mark of maxCacheBytes is reached.
 func (j *Janitor[MetadataT]) Evict(maxCacheBytes int64) {
-	type entryForEviction struct {
-		key      CacheKey
-		meta     *EntryMetadata[MetadataT]
-		priority int64 // Higher = evict first
-	}
-
-	candidates := make([]entryForEviction, 0, j.functions.Len())
-	now := time.Now()
+	j.evictMu.Lock()
+	defer j.evictMu.Unlock()
 
-	for key, meta := range j.functions.Iterate {
-		timeSinceAccess := now.Sub(meta.LastAccess).Milliseconds()
-		sizeWeight := meta.Size / bytesize.UnitM
-
-		// Calculate eviction priority (highest = evict first)
-		// Factors: age since last access + file size weight
-		priority := timeSinceAccess + (sizeWeight * 100) // Give size significant weight
-
-		candidates = append(candidates, entryForEviction{
-			key:      key,
-			meta:     meta,
-			priority: priority,
-		})
-	}
-
-	// Sort by priority (highest = evict first)
-	slices.SortFunc(candidates, func(x, y entryForEviction) int {
-		return cmp.Compare(y.priority, x.priority) // Swapped x and y for descending order
-	})
-
-	// Evict entries until we're under the limit
-	targetSize := int64(float64(maxCacheBytes) * 0.8) // Evict to 80% to avoid thrashing
+	// Evict below the limit to avoid thrashing
+	targetSize := maxCacheBytes * int64(j.cfg.Cache.Eviction.LowWaterMarkPercent.Read()) / 100
 
 	startCacheSize := j.functions.Size()
 
 	slog.Info("Target size for eviction", "target_size", bytesize.ByteSize(targetSize))
 	evictions := 0
-	for _, candidate := range candidates {
-		if j.functions.Size() <= targetSize {
+	skipped := make([]EvictionCandidate, 0)
+	for j.functions.Size() > targetSize {
+		candidate, ok := j.index.popEviction()
+		if !ok {
 			break
 		}
 
-		lock := j.functions.Lock(candidate.key)
-		if lock.TryLock() {
-			slog.Info("Evicting cache entry", "key", candidate.key.Hex, "size", candidate.meta.Size, "last_access", candidate.meta.LastAccess)
-
-			if err := j.functions.Remove(candidate.key); err != nil {
-				slog.Info("Failed to evict cache entry", "key", candidate.key.Hex, "error", err)
-			} else {
-				metrics.Global.Cache.CacheEvictions.Increment()
-			}
-			evictions++
+		lock := j.functions.Lock(candidate.Key)
+		if !lock.TryLock() {
+			slog.Info("Failed to acquire lock for cache entry", "key", candidate.Key.Hex)
+			j.index.skipEviction()
+			skipped = append(skipped, candidate)
+			continue
+		}
+		if !j.index.isEvictable(candidate.Key) {
+			j.index.skipEviction()
+			skipped = append(skipped, candidate)
 			lock.Unlock()
-		} else {
-			slog.Info("Failed to acquire lock for cache entry", "key", candidate.key.Hex)
 			continue
 		}
+
+		slog.Info("Evicting cache entry", "key", candidate.Key.Hex, "size", candidate.Size, "last_access", candidate.LastAccess, "hits", candidate.Hits)
+		if err := j.functions.Remove(candidate.Key); err != nil {
+			slog.Info("Failed to evict cache entry", "key", candidate.Key.Hex, "error", err)
+			j.index.skipEviction()
+			skipped = append(skipped, candidate)
+		} else {
+			j.index.evicted(candidate)
+			metrics.Global.Cache.CacheEvictions.Increment()
+		}
+		evictions++
+		lock.Unlock()
 	}
+	j.index.restoreEvictions(skipped)
 
 	endCacheSize := j.functions.Size()
 	if j.trackAggregateMetrics {
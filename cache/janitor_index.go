@@ -0,0 +1,172 @@
+package cache
+
+import (
+	"sync"
+	"time"
+)
+
+// Keeps the entries of a backend ordered by expiry and by the eviction policy. The backends report every
+// write, access and removal, so cleanups and evictions only touch the entries they remove instead of
+// iterating over the whole cache.
+type entryIndex struct {
+	mu       sync.Mutex
+	entries  map[CacheKey]indexedEntry
+	expiry   *keyedHeap[time.Time] // Unpinned entries by expiry.
+	policy   EvictionPolicy        // Unpinned entries in eviction order.
+	evicting *CacheKey             // The popped candidate being evicted, its removal is finished by evicted.
+}
+
+type indexedEntry struct {
+	candidate EvictionCandidate
+	expires   time.Time
+	pinned    bool
+}
+
+func newEntryIndex(policy EvictionPolicy) *entryIndex {
+	return &entryIndex{
+		entries: make(map[CacheKey]indexedEntry),
+		expiry:  newKeyedHeap(func(a, b time.Time) bool { return a.Before(b) }),
+		policy:  policy,
+	}
+}
+
+func indexedEntryOf[MetadataT any](key CacheKey, meta *EntryMetadata[MetadataT]) indexedEntry {
+	return indexedEntry{
+		candidate: EvictionCandidate{
+			Key:         key,
+			Size:        meta.Size,
+			TimeWritten: meta.TimeWritten,
+			LastAccess:  meta.LastAccess,
+			Hits:        meta.Hits,
+		},
+		expires: meta.Expires,
+		pinned:  meta.Pinned,
+	}
+}
+
+func (x *entryIndex) update(key CacheKey, entry indexedEntry) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.entries[key] = entry
+	if entry.pinned {
+		x.expiry.remove(key)
+		x.policy.Remove(key)
+		return
+	}
+	x.expiry.set(key, entry.expires)
+	x.policy.Update(entry.candidate)
+}
+
+func (x *entryIndex) remove(key CacheKey) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	delete(x.entries, key)
+	x.expiry.remove(key)
+	if x.evicting == nil || *x.evicting != key {
+		x.policy.Remove(key)
+	}
+}
+
+// Replaces the eviction policy and fills it with the indexed entries.
+func (x *entryIndex) setPolicy(policy EvictionPolicy) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.policy = policy
+	for _, entry := range x.entries {
+		if !entry.pinned {
+			policy.Update(entry.candidate)
+		}
+	}
+}
+
+// Removes and returns the next expired entry, if there is one.
+func (x *entryIndex) popExpired(now time.Time) (CacheKey, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	key, expires, ok := x.expiry.peek()
+	if !ok || !expires.Before(now) {
+		return CacheKey{}, false
+	}
+	x.expiry.pop()
+	return key, true
+}
+
+// Reports whether the entry is still expired and unpinned, it may have been refreshed since it was popped.
+func (x *entryIndex) isExpired(key CacheKey, now time.Time) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	entry, ok := x.entries[key]
+	return ok && !entry.pinned && entry.expires.Before(now)
+}
+
+// Adds popped entries that weren't removed back to the expiry order.
+func (x *entryIndex) restoreExpiry(keys []CacheKey) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, key := range keys {
+		if entry, ok := x.entries[key]; ok && !entry.pinned {
+			x.expiry.set(key, entry.expires)
+		}
+	}
+}
+
+// Removes and returns the entry the policy evicts next, and marks it as being evicted.
+func (x *entryIndex) popEviction() (EvictionCandidate, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	candidate, ok := x.policy.Pop()
+	if ok {
+		x.evicting = &candidate.Key
+	}
+	return candidate, ok
+}
+
+// Reports whether the entry may still be evicted, it may have been pinned since it was popped.
+func (x *entryIndex) isEvictable(key CacheKey) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	entry, ok := x.entries[key]
+	return ok && !entry.pinned
+}
+
+func (x *entryIndex) evicted(candidate EvictionCandidate) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.evicting = nil
+	delete(x.entries, candidate.Key)
+	x.expiry.remove(candidate.Key)
+	x.policy.Evicted(candidate)
+	// The entry may have been accessed, and added back, while it was evicted.
+	x.policy.Remove(candidate.Key)
+}
+
+// Ends the eviction of a popped entry that wasn't removed. It is added back once the eviction is done.
+func (x *entryIndex) skipEviction() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	x.evicting = nil
+}
+
+// Adds popped entries that weren't evicted back to the policy.
+func (x *entryIndex) restoreEvictions(candidates []EvictionCandidate) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	for _, candidate := range candidates {
+		if entry, ok := x.entries[candidate.Key]; ok && !entry.pinned {
+			x.policy.Update(entry.candidate)
+		} else {
+			x.policy.Remove(candidate.Key)
+		}
+	}
+}
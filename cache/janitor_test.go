@@ -42,6 +42,20 @@ func (b *janitorTestBackend) put(key CacheKey, size int64, expires time.Time, la
 	b.size += size
 }
 
+func (b *janitorTestBackend) pin(key CacheKey) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[key].Pinned = true
+}
+
+func (b *janitorTestBackend) hit(key CacheKey, hits int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[key].Hits += hits
+}
+
 func (b *janitorTestBackend) has(key CacheKey) bool {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
@@ -57,13 +71,6 @@ func (b *janitorTestBackend) cachedBytes() int64 {
 	return b.size
 }
 
-func (b *janitorTestBackend) len() int {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-
-	return len(b.entries)
-}
-
 func (b *janitorTestBackend) remove(key CacheKey) error {
 	b.mu.Lock()
 	defer b.mu.Unlock()
@@ -96,7 +103,6 @@ func (b *janitorTestBackend) janitorFunctions() JanitorFunctions[janitorTestMeta
 		},
 		Remove: b.remove,
 		Size:   b.cachedBytes,
-		Len:    b.len,
 		Lock: func(key CacheKey) *sync.RWMutex {
 			return GetLock(b.locks, key)
 		},
@@ -152,3 +158,111 @@ func TestJanitor_EvictsByPriorityUntilUnderTarget(t *testing.T) {
 		t.Fatalf("expected cached bytes to be 600 after eviction, got %d", got)
 	}
 }
+
+func TestJanitor_SkipsPinnedEntries(t *testing.T) {
+	now := time.Now()
+	pinnedExpiredKey := FromString("pinned-expired-key")
+	pinnedOldKey := FromString("pinned-old-key")
+	oldKey := FromString("old-key")
+	backend := newJanitorTestBackend()
+	backend.put(pinnedExpiredKey, 400, now.Add(-time.Second), now)
+	backend.put(pinnedOldKey, 600, now.Add(time.Hour), now.Add(-2*time.Hour))
+	backend.put(oldKey, 600, now.Add(time.Hour), now.Add(-time.Hour))
+	backend.pin(pinnedExpiredKey)
+	backend.pin(pinnedOldKey)
+
+	j := newTestJanitor(t, backend)
+	j.cleanExpiredEntries()
+	j.Evict(1024)
+
+	if !backend.has(pinnedExpiredKey) {
+		t.Fatal("expected pinned expired entry to remain")
+	}
+	if !backend.has(pinnedOldKey) {
+		t.Fatal("expected pinned entry to be exempt from eviction")
+	}
+	if backend.has(oldKey) {
+		t.Fatal("expected unpinned entry to be evicted instead")
+	}
+}
+
+func (b *janitorTestBackend) metadata(key CacheKey) *EntryMetadata[janitorTestMeta] {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	meta := *b.entries[key]
+	return &meta
+}
+
+func (b *janitorTestBackend) touch(key CacheKey, lastAccess time.Time, expires time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.entries[key].LastAccess = lastAccess
+	b.entries[key].Expires = expires
+}
+
+func TestJanitor_TracksChangesWithoutRescanning(t *testing.T) {
+	now := time.Now()
+	accessedKey := FromString("accessed-key")
+	idleKey := FromString("idle-key")
+	refreshedKey := FromString("refreshed-key")
+	backend := newJanitorTestBackend()
+	backend.put(accessedKey, 400, now.Add(time.Hour), now.Add(-time.Hour))
+	backend.put(idleKey, 400, now.Add(time.Hour), now)
+	backend.put(refreshedKey, 400, now.Add(-time.Second), now)
+
+	functions := backend.janitorFunctions()
+	iterations := 0
+	iterate := functions.Iterate
+	functions.Iterate = func(yield func(CacheKey, *EntryMetadata[janitorTestMeta]) bool) {
+		iterations++
+		iterate(yield)
+	}
+	j := NewJanitor(config.NewDefault(), time.Hour, functions, false)
+	t.Cleanup(j.subs.UnsubscribeAll)
+
+	backend.touch(accessedKey, now.Add(time.Minute), now.Add(time.Hour))
+	j.Track(accessedKey, backend.metadata(accessedKey))
+	backend.touch(refreshedKey, now.Add(time.Minute), now.Add(time.Hour))
+	j.Track(refreshedKey, backend.metadata(refreshedKey))
+
+	j.cleanExpiredEntries()
+	if !backend.has(refreshedKey) {
+		t.Fatal("expected the refreshed entry to survive the cleanup")
+	}
+
+	j.Evict(1000)
+	if backend.has(idleKey) || !backend.has(accessedKey) {
+		t.Fatal("expected the entry accessed after the janitor was created to be kept")
+	}
+	if iterations != 1 {
+		t.Fatalf("expected the entries to be iterated once on creation, got %d iterations", iterations)
+	}
+}
+
+func TestJanitor_RestoresEntriesThatCouldNotBeEvicted(t *testing.T) {
+	now := time.Now()
+	lockedKey := FromString("locked-key")
+	otherKey := FromString("other-key")
+	backend := newJanitorTestBackend()
+	backend.put(lockedKey, 600, now.Add(time.Hour), now.Add(-time.Hour))
+	backend.put(otherKey, 600, now.Add(time.Hour), now)
+
+	j := newTestJanitor(t, backend)
+	lock := GetLock(backend.locks, lockedKey)
+	lock.Lock()
+	j.Evict(1024)
+	lock.Unlock()
+
+	if !backend.has(lockedKey) || backend.has(otherKey) {
+		t.Fatal("expected the locked entry to be skipped")
+	}
+
+	backend.put(otherKey, 600, now.Add(time.Hour), now)
+	j.Track(otherKey, backend.metadata(otherKey))
+	j.Evict(1024)
+	if backend.has(lockedKey) {
+		t.Fatal("expected the skipped entry to be evicted once it isn't locked anymore")
+	}
+}
@@ -0,0 +1,114 @@
+package cache
+
+import "container/heap"
+
+// A min-heap of values by cache key. It remembers the position of every key, so values can be updated
+// and removed in O(log n) when entries are written, accessed or removed.
+type keyedHeap[T any] struct {
+	items keyedItems[T]
+	byKey map[CacheKey]*keyedItem[T]
+}
+
+type keyedItem[T any] struct {
+	key   CacheKey
+	value T
+	index int
+}
+
+func newKeyedHeap[T any](less func(a, b T) bool) *keyedHeap[T] {
+	return &keyedHeap[T]{
+		items: keyedItems[T]{less: less},
+		byKey: make(map[CacheKey]*keyedItem[T]),
+	}
+}
+
+func (h *keyedHeap[T]) len() int {
+	return len(h.items.items)
+}
+
+func (h *keyedHeap[T]) get(key CacheKey) (T, bool) {
+	item, ok := h.byKey[key]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return item.value, true
+}
+
+// Adds the key or moves it to the position of its new value.
+func (h *keyedHeap[T]) set(key CacheKey, value T) {
+	if item, ok := h.byKey[key]; ok {
+		item.value = value
+		heap.Fix(&h.items, item.index)
+		return
+	}
+	item := &keyedItem[T]{key: key, value: value}
+	h.byKey[key] = item
+	heap.Push(&h.items, item)
+}
+
+func (h *keyedHeap[T]) remove(key CacheKey) (T, bool) {
+	item, ok := h.byKey[key]
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	heap.Remove(&h.items, item.index)
+	delete(h.byKey, key)
+	return item.value, true
+}
+
+// Returns the smallest value without removing it.
+func (h *keyedHeap[T]) peek() (CacheKey, T, bool) {
+	if h.len() == 0 {
+		var zero T
+		return CacheKey{}, zero, false
+	}
+	item := h.items.items[0]
+	return item.key, item.value, true
+}
+
+// Removes and returns the smallest value.
+func (h *keyedHeap[T]) pop() (CacheKey, T, bool) {
+	if h.len() == 0 {
+		var zero T
+		return CacheKey{}, zero, false
+	}
+	item := heap.Pop(&h.items).(*keyedItem[T])
+	delete(h.byKey, item.key)
+	return item.key, item.value, true
+}
+
+// Implements heap.Interface.
+type keyedItems[T any] struct {
+	items []*keyedItem[T]
+	less  func(a, b T) bool
+}
+
+func (s *keyedItems[T]) Len() int {
+	return len(s.items)
+}
+
+func (s *keyedItems[T]) Less(i, j int) bool {
+	return s.less(s.items[i].value, s.items[j].value)
+}
+
+func (s *keyedItems[T]) Swap(i, j int) {
+	s.items[i], s.items[j] = s.items[j], s.items[i]
+	s.items[i].index = i
+	s.items[j].index = j
+}
+
+func (s *keyedItems[T]) Push(x any) {
+	item := x.(*keyedItem[T])
+	item.index = len(s.items)
+	s.items = append(s.items, item)
+}
+
+func (s *keyedItems[T]) Pop() any {
+	last := len(s.items) - 1
+	item := s.items[last]
+	s.items[last] = nil
+	s.items = s.items[:last]
+	return item
+}
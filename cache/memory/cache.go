@@ -4,7 +4,6 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"maps"
 	"reservoir/cache"
 	"reservoir/config"
 	"reservoir/metrics"
@@ -17,7 +16,9 @@ import (
 )
 
 type Cache[MetadataT any] struct {
+	cfg          *config.Config
 	entries      map[cache.CacheKey]*memoryInternalEntry[MetadataT]
+	tags         cache.TagIndex // Guarded by mu.
 	mu           sync.RWMutex
 	locks        []sync.RWMutex
 	memoryCap    atomics.Int64
@@ -43,7 +44,9 @@ func newWithAggregateMetrics[MetadataT any](cfg *config.Config, memoryBudgetPerc
 	}
 
 	c := &Cache[MetadataT]{
+		cfg:          cfg,
 		entries:      make(map[cache.CacheKey]*memoryInternalEntry[MetadataT]),
+		tags:         cache.NewTagIndex(),
 		locks:        make([]sync.RWMutex, shardCount),
 		memoryCap:    atomics.NewInt64(int64(sysMem.Total) * int64(memoryBudgetPercent) / 100),
 		maxCacheSize: atomics.NewInt64(maxCacheSize),
@@ -60,44 +63,16 @@ func newWithAggregateMetrics[MetadataT any](cfg *config.Config, memoryBudgetPerc
 		slog.Info("Memory budget changed", "new_percent", newPercent, "new_cap", bytesize.ByteSize(newCap))
 	}))
 
+	c.restoreSnapshot()
+
 	c.janitor = cache.NewJanitor(cfg, cleanupInterval, cache.JanitorFunctions[MetadataT]{
-		Iterate: func(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
-			c.mu.RLock()
-			snapshot := maps.Clone(c.entries)
-			c.mu.RUnlock()
-
-			for key := range snapshot {
-				lock := cache.GetLock(c.locks, key)
-				lock.RLock()
-				c.mu.RLock()
-				entry, ok := c.entries[key]
-				c.mu.RUnlock()
-
-				var meta *cache.EntryMetadata[MetadataT]
-				if ok {
-					meta = entry.metadataSnapshot()
-				}
-				lock.RUnlock()
-
-				if !ok {
-					continue
-				}
-				if !yield(key, meta) {
-					break
-				}
-			}
-		},
+		Iterate: c.Entries,
 		Remove: func(key cache.CacheKey) error {
 			return c.deleteInternal(key)
 		},
 		Size: func() int64 {
 			return c.byteSize.Get()
 		},
-		Len: func() int {
-			c.mu.RLock()
-			defer c.mu.RUnlock()
-			return len(c.entries)
-		},
 		Lock: func(key cache.CacheKey) *sync.RWMutex {
 			return cache.GetLock(c.locks, key)
 		},
@@ -121,5 +96,6 @@ func (c *Cache[MetadataT]) recordCacheError() {
 
 func (c *Cache[MetadataT]) Destroy() {
 	c.janitor.Stop()
+	c.writeSnapshot()
 	c.subs.UnsubscribeAll()
 }
@@ -3,8 +3,11 @@ package memory
 import (
 	"bytes"
 	"io"
+	"os"
+	"path/filepath"
 	"reservoir/cache"
 	"reservoir/config"
+	"reservoir/utils/bytesize"
 	"testing"
 	"time"
 )
@@ -121,6 +124,43 @@ func TestMemoryCache_OverwriteUpdatesAccounting(t *testing.T) {
 	}
 }
 
+func TestMemoryCache_EvictsByAccessOrder(t *testing.T) {
+	cfg := config.NewDefault()
+	c := New[TestMeta](cfg, 100, 300, time.Hour, 16, t.Context())
+	defer c.Destroy()
+
+	data := bytes.Repeat([]byte("x"), 100)
+	expires := time.Now().Add(time.Hour)
+	keys := []cache.CacheKey{cache.FromString("first"), cache.FromString("second"), cache.FromString("third")}
+	for _, key := range keys {
+		entry, err := c.Cache(key, bytes.NewReader(data), expires, TestMeta{ID: key.Hex})
+		if err != nil {
+			t.Fatalf("Cache failed: %v", err)
+		}
+		entry.Data.Close()
+		time.Sleep(time.Millisecond)
+	}
+
+	// Reading the oldest entry moves it behind the others.
+	entry, err := c.Get(keys[0])
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	entry.Data.Close()
+
+	c.EvictTo(300)
+	if _, err := c.Get(keys[1]); err != cache.ErrCacheEntryNotFound {
+		t.Fatalf("expected the least recently accessed entry to be evicted, got %v", err)
+	}
+	for _, key := range []cache.CacheKey{keys[0], keys[2]} {
+		entry, err := c.Get(key)
+		if err != nil {
+			t.Fatalf("expected entry %s to remain: %v", key.Hex, err)
+		}
+		entry.Data.Close()
+	}
+}
+
 func TestMemoryCache_ReturnsMetadataSnapshots(t *testing.T) {
 	ctx := t.Context()
 	cfg := config.NewDefault()
@@ -205,3 +245,145 @@ func TestMemoryCache_ClearRemovesEntriesAndUpdatesStats(t *testing.T) {
 		t.Fatalf("clear on empty cache failed: %v", err)
 	}
 }
+
+type taggedMeta struct {
+	Tags []string
+}
+
+func (m taggedMeta) CacheTags() []string {
+	return m.Tags
+}
+
+func TestMemoryCache_IndexesTags(t *testing.T) {
+	ctx := t.Context()
+	cfg := config.NewDefault()
+
+	c := New[taggedMeta](cfg, 1, 1024*1024*1024, time.Minute, 16, ctx)
+	defer c.Destroy()
+
+	firstKey := cache.FromString("first-tagged-key")
+	secondKey := cache.FromString("second-tagged-key")
+	expires := time.Now().Add(time.Hour)
+
+	for key, tags := range map[cache.CacheKey][]string{firstKey: {"noble", "dists"}, secondKey: {"noble"}} {
+		entry, err := c.Cache(key, bytes.NewReader([]byte("body")), expires, taggedMeta{Tags: tags})
+		if err != nil {
+			t.Fatalf("cache failed: %v", err)
+		}
+		entry.Data.Close()
+	}
+
+	if got := len(c.KeysByTag("noble")); got != 2 {
+		t.Fatalf("expected 2 entries tagged noble, got %d", got)
+	}
+
+	replaced, err := c.Cache(firstKey, bytes.NewReader([]byte("new body")), expires, taggedMeta{Tags: []string{"jammy"}})
+	if err != nil {
+		t.Fatalf("replacing cache entry failed: %v", err)
+	}
+	replaced.Data.Close()
+
+	if keys := c.KeysByTag("noble"); len(keys) != 1 || keys[0] != secondKey {
+		t.Fatalf("expected replacement to drop the old tags, got %v", keys)
+	}
+	if got := len(c.KeysByTag("dists")); got != 0 {
+		t.Fatalf("expected no entries tagged dists, got %d", got)
+	}
+
+	if err := c.Delete(secondKey); err != nil {
+		t.Fatalf("delete failed: %v", err)
+	}
+	if got := len(c.KeysByTag("noble")); got != 0 {
+		t.Fatalf("expected deleted entry to be removed from the tag index, got %d", got)
+	}
+}
+
+func newSnapshotTestConfig(t *testing.T) *config.Config {
+	t.Helper()
+
+	cfg := config.NewDefault()
+	cfg.Cache.Memory.Snapshot.Enabled.Overwrite(true)
+	cfg.Cache.Memory.Snapshot.Path.Overwrite(filepath.Join(t.TempDir(), "memory.snapshot"))
+	return cfg
+}
+
+func TestMemoryCache_RestoresSnapshotAfterRestart(t *testing.T) {
+	cfg := newSnapshotTestConfig(t)
+
+	c := New[TestMeta](cfg, 1, 1024*1024*1024, time.Minute, 16, t.Context())
+	freshKey := cache.FromString("snapshot-fresh-key")
+	expiredKey := cache.FromString("snapshot-expired-key")
+	for key, expires := range map[cache.CacheKey]time.Time{freshKey: time.Now().Add(time.Hour), expiredKey: time.Now().Add(50 * time.Millisecond)} {
+		entry, err := c.Cache(key, bytes.NewReader([]byte("snapshot body")), expires, TestMeta{ID: key.Hex})
+		if err != nil {
+			t.Fatalf("Cache failed: %v", err)
+		}
+		entry.Data.Close()
+	}
+	retrieved, err := c.Get(freshKey)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	retrieved.Data.Close()
+	time.Sleep(100 * time.Millisecond)
+	c.Destroy()
+
+	restored := New[TestMeta](cfg, 1, 1024*1024*1024, time.Minute, 16, t.Context())
+	defer restored.Destroy()
+
+	entry, err := restored.Get(freshKey)
+	if err != nil {
+		t.Fatalf("expected fresh entry to be restored: %v", err)
+	}
+	if content := readAll(t, entry); content != "snapshot body" {
+		t.Fatalf("expected restored body %q, got %q", "snapshot body", content)
+	}
+	if entry.Metadata.Object.ID != freshKey.Hex || entry.Metadata.Hits != 2 {
+		t.Fatalf("expected restored metadata with 2 hits, got ID %q with %d hits", entry.Metadata.Object.ID, entry.Metadata.Hits)
+	}
+	if _, err := restored.Get(expiredKey); err != cache.ErrCacheEntryNotFound {
+		t.Fatalf("expected expired entry to be skipped, got %v", err)
+	}
+	if _, err := os.Stat(cfg.Cache.Memory.Snapshot.Path.Read()); !os.IsNotExist(err) {
+		t.Fatalf("expected snapshot to be removed once restored, got %v", err)
+	}
+}
+
+func TestMemoryCache_SnapshotKeepsMostRecentEntriesWithinSizeBudget(t *testing.T) {
+	cfg := newSnapshotTestConfig(t)
+	cfg.Cache.Memory.Snapshot.MaxSize.Overwrite(bytesize.ByteSize(150))
+
+	c := New[TestMeta](cfg, 1, 1024*1024*1024, time.Minute, 16, t.Context())
+	oldKey := cache.FromString("snapshot-old-key")
+	recentKey := cache.FromString("snapshot-recent-key")
+	for _, key := range []cache.CacheKey{oldKey, recentKey} {
+		entry, err := c.Cache(key, bytes.NewReader(bytes.Repeat([]byte("s"), 100)), time.Now().Add(time.Hour), TestMeta{})
+		if err != nil {
+			t.Fatalf("Cache failed: %v", err)
+		}
+		entry.Data.Close()
+	}
+	c.OverrideEntryLastAccessForTesting(oldKey, time.Now().Add(-time.Hour))
+	c.Destroy()
+
+	restored := New[TestMeta](cfg, 1, 1024*1024*1024, time.Minute, 16, t.Context())
+	defer restored.Destroy()
+
+	if _, ok := restored.PeekMetadata(recentKey); !ok {
+		t.Fatalf("expected the most recently accessed entry to be restored")
+	}
+	if _, ok := restored.PeekMetadata(oldKey); ok {
+		t.Fatalf("expected the older entry to be left out of the size budget")
+	}
+}
+
+func readAll(t *testing.T, entry *cache.Entry[TestMeta]) string {
+	t.Helper()
+	defer entry.Data.Close()
+
+	content, err := io.ReadAll(entry.Data)
+	if err != nil {
+		t.Fatalf("failed to read cached data: %v", err)
+	}
+	return string(content)
+}
@@ -23,6 +23,7 @@ type memoryInternalEntry[MetadataT any] struct {
 	data       []byte
 	meta       *cache.EntryMetadata[MetadataT]
 	lastAccess atomics.Time
+	hits       atomics.Int64
 }
 
 func newMemoryInternalEntry[MetadataT any](data []byte, meta *cache.EntryMetadata[MetadataT]) *memoryInternalEntry[MetadataT] {
@@ -30,6 +31,7 @@ func newMemoryInternalEntry[MetadataT any](data []byte, meta *cache.EntryMetadat
 		data:       data,
 		meta:       meta,
 		lastAccess: atomics.NewAtomicTime(meta.LastAccess),
+		hits:       atomics.NewInt64(meta.Hits),
 	}
 }
 
@@ -45,5 +47,7 @@ func (e *memoryInternalEntry[MetadataT]) setLastAccess(now time.Time) {
 func (e *memoryInternalEntry[MetadataT]) metadataSnapshot() *cache.EntryMetadata[MetadataT] {
 	meta := *e.meta
 	meta.LastAccess = e.lastAccess.Get()
+	meta.Hits = e.hits.Get()
+	meta.Tier = cache.TierMemory
 	return &meta
 }
@@ -1,6 +1,7 @@
 package memory
 
 import (
+	"maps"
 	"reservoir/cache"
 	"time"
 )
@@ -23,6 +24,7 @@ func (c *Cache[MetadataT]) updateMetadata(key cache.CacheKey, modifier func(*cac
 
 	modifier(entry.meta)
 	entry.setLastAccess(time.Now())
+	c.janitor.Track(key, entry.metadataSnapshot())
 
 	if recordMetrics {
 		c.recordCacheHit()
@@ -60,11 +62,13 @@ func (c *Cache[MetadataT]) getMetadata(key cache.CacheKey, recordMetrics bool) (
 	}
 
 	entry.touch(time.Now())
+	meta = entry.metadataSnapshot()
+	c.janitor.Track(key, meta)
 	if recordMetrics {
 		c.recordCacheHit()
 	}
 
-	return entry.metadataSnapshot(), stale, nil
+	return meta, stale, nil
 }
 
 func (c *Cache[MetadataT]) GetMetadata(key cache.CacheKey) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
@@ -74,3 +78,36 @@ func (c *Cache[MetadataT]) GetMetadata(key cache.CacheKey) (meta *cache.EntryMet
 func (c *Cache[MetadataT]) GetMetadataQuiet(key cache.CacheKey) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
 	return c.getMetadata(key, false)
 }
+
+func (c *Cache[MetadataT]) Entries(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
+	c.mu.RLock()
+	snapshot := maps.Clone(c.entries)
+	c.mu.RUnlock()
+
+	for key := range snapshot {
+		lock := cache.GetLock(c.locks, key)
+		lock.RLock()
+		c.mu.RLock()
+		entry, ok := c.entries[key]
+		c.mu.RUnlock()
+
+		var meta *cache.EntryMetadata[MetadataT]
+		if ok {
+			meta = entry.metadataSnapshot()
+		}
+		lock.RUnlock()
+
+		if !ok {
+			continue
+		}
+		if !yield(key, meta) {
+			break
+		}
+	}
+}
+
+func (c *Cache[MetadataT]) KeysByTag(tag string) []cache.CacheKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tags.Keys(tag)
+}
@@ -30,13 +30,16 @@ func (c *Cache[MetadataT]) get(key cache.CacheKey, recordMetrics bool) (*cache.E
 	}
 
 	entry.touch(time.Now())
+	entry.hits.Increment()
+	meta := entry.metadataSnapshot()
+	c.janitor.Track(key, meta)
 	if recordMetrics {
 		c.recordCacheHit()
 	}
 
 	return &cache.Entry[MetadataT]{
 		Data:     &memoryReadSeekCloser{bytes.NewReader(entry.data)},
-		Metadata: entry.metadataSnapshot(),
+		Metadata: meta,
 		Stale:    stale,
 	}, nil
 }
@@ -99,7 +102,12 @@ func (c *Cache[MetadataT]) cacheBytesInternal(key cache.CacheKey, dataBytes []by
 
 	c.mu.Lock()
 	previousEntry, replaced := c.entries[key]
+	if replaced {
+		meta.Pinned = previousEntry.meta.Pinned // A refreshed entry stays pinned.
+		c.tags.Remove(key, cache.TagsOf(previousEntry.meta.Object))
+	}
 	c.entries[key] = internalEntry
+	c.tags.Add(key, cache.TagsOf(metadata))
 	c.mu.Unlock()
 
 	if replaced {
@@ -109,9 +117,11 @@ func (c *Cache[MetadataT]) cacheBytesInternal(key cache.CacheKey, dataBytes []by
 	}
 	cache.AddCacheSize(&c.byteSize, int64(count))
 
+	snapshot := internalEntry.metadataSnapshot()
+	c.janitor.Track(key, snapshot)
 	return &cache.Entry[MetadataT]{
 		Data:     &memoryReadSeekCloser{bytes.NewReader(dataBytes)},
-		Metadata: internalEntry.metadataSnapshot(),
+		Metadata: snapshot,
 	}, nil
 }
 
@@ -198,7 +208,9 @@ func (c *Cache[MetadataT]) deleteInternal(key cache.CacheKey) error {
 		return cache.ErrCacheEntryNotFound
 	}
 	delete(c.entries, key)
+	c.tags.Remove(key, cache.TagsOf(entry.meta.Object))
 	c.mu.Unlock()
+	c.janitor.Untrack(key)
 
 	cache.DecrementCacheEntries()
 	cache.DecrementCacheSize(&c.byteSize, entry.meta.Size)
@@ -0,0 +1,251 @@
+package memory
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log/slog"
+	"os"
+	"reservoir/cache"
+	"reservoir/utils/bytesize"
+	"slices"
+	"time"
+)
+
+// A snapshot starts with snapshotMagic, followed by one record per entry: the key, the JSON metadata and the body,
+// each prefixed with its length as a uvarint, and a CRC-32 of the three. A snapshot cut short is restored up to
+// its last complete record.
+const snapshotMagic = "RSVMEM1\n"
+
+// Guards against allocating huge buffers for the key and metadata of a corrupt record.
+const maxSnapshotFieldSize = 1 << 20
+
+var (
+	errSnapshotCorrupt       = errors.New("memory cache snapshot is corrupt")
+	errSnapshotEntryTooLarge = errors.New("memory cache snapshot entry doesn't fit in memory")
+)
+
+type snapshotEntry[MetadataT any] struct {
+	key  cache.CacheKey
+	data []byte
+	meta *cache.EntryMetadata[MetadataT]
+}
+
+// Writes the most recently accessed entries to the snapshot file, within the size and time budget.
+func (c *Cache[MetadataT]) writeSnapshot() {
+	snapshotCfg := &c.cfg.Cache.Memory.Snapshot
+	if !snapshotCfg.Enabled.Read() {
+		return
+	}
+	path := snapshotCfg.Path.Read()
+	maxBytes := snapshotCfg.MaxSize.Read().Bytes()
+	deadline := time.Now().Add(snapshotCfg.Timeout.Read().Cast())
+
+	tempPath := path + ".tmp"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		slog.Error("Failed to create memory cache snapshot", "path", tempPath, "error", err)
+		return
+	}
+	defer os.Remove(tempPath)
+
+	entries, bytes := 0, int64(0)
+	w := bufio.NewWriter(file)
+	_, err = w.WriteString(snapshotMagic)
+	for _, entry := range c.snapshotEntries() {
+		if err != nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			slog.Warn("Memory cache snapshot ran out of time, skipping the remaining entries", "entries", entries)
+			break
+		}
+		if bytes+entry.meta.Size > maxBytes {
+			continue
+		}
+		if err = writeSnapshotRecord(w, entry); err == nil {
+			entries++
+			bytes += entry.meta.Size
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, path)
+	}
+	if err != nil {
+		slog.Error("Failed to write memory cache snapshot", "path", path, "error", err)
+		return
+	}
+
+	slog.Info("Wrote memory cache snapshot", "path", path, "entries", entries, "size", bytesize.ByteSize(bytes))
+}
+
+// Returns the entries worth keeping, most recently accessed first.
+func (c *Cache[MetadataT]) snapshotEntries() []snapshotEntry[MetadataT] {
+	now := time.Now()
+
+	c.mu.RLock()
+	entries := make([]snapshotEntry[MetadataT], 0, len(c.entries))
+	for key, entry := range c.entries {
+		meta := entry.metadataSnapshot()
+		if meta.Expires.Before(now) && !meta.Pinned {
+			continue
+		}
+		entries = append(entries, snapshotEntry[MetadataT]{key: key, data: entry.data, meta: meta})
+	}
+	c.mu.RUnlock()
+
+	slices.SortFunc(entries, func(a, b snapshotEntry[MetadataT]) int {
+		return b.meta.LastAccess.Compare(a.meta.LastAccess)
+	})
+	return entries
+}
+
+func writeSnapshotRecord[MetadataT any](w io.Writer, entry snapshotEntry[MetadataT]) error {
+	meta, err := json.Marshal(entry.meta)
+	if err != nil {
+		return err
+	}
+
+	checksum := crc32.NewIEEE()
+	for _, field := range [][]byte{[]byte(entry.key.Hex), meta, entry.data} {
+		if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(field)))); err != nil {
+			return err
+		}
+		if _, err := w.Write(field); err != nil {
+			return err
+		}
+		checksum.Write(field)
+	}
+	_, err = w.Write(checksum.Sum(nil))
+	return err
+}
+
+// Restores the entries of the snapshot file that haven't expired and fit in memory, then removes the file.
+// A snapshot is only restored once, so entries deleted after it was restored don't come back after a crash.
+func (c *Cache[MetadataT]) restoreSnapshot() {
+	snapshotCfg := &c.cfg.Cache.Memory.Snapshot
+	if !snapshotCfg.Enabled.Read() {
+		return
+	}
+	path := snapshotCfg.Path.Read()
+	deadline := time.Now().Add(snapshotCfg.Timeout.Read().Cast())
+
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		slog.Error("Failed to open memory cache snapshot", "path", path, "error", err)
+		return
+	}
+	defer func() {
+		file.Close()
+		if err := os.Remove(path); err != nil {
+			slog.Error("Failed to remove restored memory cache snapshot", "path", path, "error", err)
+		}
+	}()
+
+	r := bufio.NewReader(file)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != snapshotMagic {
+		slog.Warn("Ignoring memory cache snapshot with an unknown format", "path", path)
+		return
+	}
+
+	now := time.Now()
+	limit := c.CapacityBytes()
+	restored, skipped := 0, 0
+	for {
+		if time.Now().After(deadline) {
+			slog.Warn("Memory cache snapshot restore ran out of time, skipping the remaining entries", "restored", restored)
+			break
+		}
+		entry, err := readSnapshotRecord[MetadataT](r, limit)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, errSnapshotEntryTooLarge) {
+			skipped++
+			continue
+		}
+		if err != nil {
+			slog.Warn("Stopped restoring memory cache snapshot", "path", path, "restored", restored, "error", err)
+			break
+		}
+		if (entry.meta.Expires.Before(now) && !entry.meta.Pinned) || c.byteSize.Get()+entry.meta.Size > limit {
+			skipped++
+			continue
+		}
+		c.addRestoredEntry(entry)
+		restored++
+	}
+
+	slog.Info("Restored memory cache snapshot", "path", path, "entries", restored, "skipped", skipped, "size", bytesize.ByteSize(c.byteSize.Get()))
+}
+
+// Reads the next record. Bodies larger than maxDataSize are skipped without reading them into memory.
+func readSnapshotRecord[MetadataT any](r *bufio.Reader, maxDataSize int64) (snapshotEntry[MetadataT], error) {
+	fields := make([][]byte, 3)
+	checksum := crc32.NewIEEE()
+	for i := range fields {
+		size, err := binary.ReadUvarint(r)
+		if err != nil {
+			if i == 0 && errors.Is(err, io.EOF) {
+				return snapshotEntry[MetadataT]{}, io.EOF
+			}
+			return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: %w", errSnapshotCorrupt, err)
+		}
+		if i < 2 && size > maxSnapshotFieldSize {
+			return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: field of %d bytes", errSnapshotCorrupt, size)
+		}
+		if i == 2 && size > uint64(max(maxDataSize, 0)) {
+			if _, err := r.Discard(int(size) + crc32.Size); err != nil {
+				return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: %w", errSnapshotCorrupt, err)
+			}
+			return snapshotEntry[MetadataT]{}, errSnapshotEntryTooLarge
+		}
+		fields[i] = make([]byte, size)
+		if _, err := io.ReadFull(r, fields[i]); err != nil {
+			return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: %w", errSnapshotCorrupt, err)
+		}
+		checksum.Write(fields[i])
+	}
+
+	sum := make([]byte, crc32.Size)
+	if _, err := io.ReadFull(r, sum); err != nil {
+		return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: %w", errSnapshotCorrupt, err)
+	}
+	if string(sum) != string(checksum.Sum(nil)) {
+		return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: checksum mismatch", errSnapshotCorrupt)
+	}
+
+	var meta cache.EntryMetadata[MetadataT]
+	if err := json.Unmarshal(fields[1], &meta); err != nil {
+		return snapshotEntry[MetadataT]{}, fmt.Errorf("%w: %w", errSnapshotCorrupt, err)
+	}
+	meta.Size = int64(len(fields[2]))
+	return snapshotEntry[MetadataT]{key: cache.CacheKey{Hex: string(fields[0])}, data: fields[2], meta: &meta}, nil
+}
+
+func (c *Cache[MetadataT]) addRestoredEntry(entry snapshotEntry[MetadataT]) {
+	c.mu.Lock()
+	c.entries[entry.key] = newMemoryInternalEntry(entry.data, entry.meta)
+	c.tags.Add(entry.key, cache.TagsOf(entry.meta.Object))
+	c.mu.Unlock()
+
+	cache.IncrementCacheEntries()
+	cache.AddCacheSize(&c.byteSize, entry.meta.Size)
+}
@@ -70,6 +70,7 @@ func (c *Cache[MetadataT]) OverrideEntryLastAccessForTesting(key cache.CacheKey,
 	}
 
 	entry.setLastAccess(lastAccess)
+	c.janitor.Track(key, entry.metadataSnapshot())
 	return true
 }
 
@@ -97,7 +98,40 @@ func (c *Cache[MetadataT]) DemotionCandidates(cutoff time.Time) []cache.CacheKey
 	return keys
 }
 
-func (c *Cache[MetadataT]) DemoteEntry(key cache.CacheKey, cutoff time.Time, write func(data io.Reader, expires time.Time, metadata MetadataT) error) error {
+// Carries the hit count and pin of an entry over from another tier.
+func (c *Cache[MetadataT]) InheritAccessState(key cache.CacheKey, previous *cache.EntryMetadata[MetadataT]) {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		return
+	}
+
+	entry.hits.Add(previous.Hits)
+	entry.meta.Pinned = entry.meta.Pinned || previous.Pinned
+	c.janitor.Track(key, entry.metadataSnapshot())
+}
+
+// Returns the metadata of an entry without counting it as an access.
+func (c *Cache[MetadataT]) PeekMetadata(key cache.CacheKey) (*cache.EntryMetadata[MetadataT], bool) {
+	lock := cache.GetLock(c.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return entry.metadataSnapshot(), true
+}
+
+func (c *Cache[MetadataT]) DemoteEntry(key cache.CacheKey, cutoff time.Time, write func(data io.Reader, meta *cache.EntryMetadata[MetadataT]) error) error {
 	lock := cache.GetLock(c.locks, key)
 	lock.Lock()
 	defer lock.Unlock()
@@ -112,11 +146,11 @@ func (c *Cache[MetadataT]) DemoteEntry(key cache.CacheKey, cutoff time.Time, wri
 	if lastAccess.After(cutoff) || lastAccess.Equal(cutoff) {
 		return nil
 	}
-	if entry.meta.Expires.Before(time.Now()) {
+	if entry.meta.Expires.Before(time.Now()) && !entry.meta.Pinned {
 		return c.deleteInternal(key)
 	}
 
-	if err := write(bytes.NewReader(entry.data), entry.meta.Expires, entry.meta.Object); err != nil {
+	if err := write(bytes.NewReader(entry.data), entry.metadataSnapshot()); err != nil {
 		return err
 	}
 	return c.deleteInternal(key)
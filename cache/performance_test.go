@@ -49,7 +49,10 @@ var cacheBenchmarkBackends = []cacheBenchmarkBackend{
 		name: "File",
 		new: func(b *testing.B, cfg *config.Config) cache.Cache[benchMetadata] {
 			b.Helper()
-			c := filecache.New[benchMetadata](cfg, b.TempDir(), benchmarkMaxCacheSize, time.Hour, benchmarkShardCount, context.Background())
+			c, err := filecache.New[benchMetadata](cfg, b.TempDir(), benchmarkMaxCacheSize, time.Hour, benchmarkShardCount, context.Background())
+			if err != nil {
+				b.Fatalf("failed to create cache: %v", err)
+			}
 			b.Cleanup(c.Destroy)
 			return c
 		},
@@ -58,7 +61,10 @@ var cacheBenchmarkBackends = []cacheBenchmarkBackend{
 		name: "Hybrid",
 		new: func(b *testing.B, cfg *config.Config) cache.Cache[benchMetadata] {
 			b.Helper()
-			c := hybrid.New[benchMetadata](cfg, b.TempDir(), 50, benchmarkMaxCacheSize, time.Hour, benchmarkShardCount, context.Background())
+			c, err := hybrid.New[benchMetadata](cfg, b.TempDir(), 50, benchmarkMaxCacheSize, time.Hour, benchmarkShardCount, context.Background())
+			if err != nil {
+				b.Fatalf("failed to create cache: %v", err)
+			}
 			b.Cleanup(c.Destroy)
 			return c
 		},
@@ -0,0 +1,182 @@
+package s3
+
+import (
+	"context"
+	"encoding/hex"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"reservoir/cache"
+	"reservoir/config"
+	"reservoir/utils/atomics"
+	"reservoir/utils/bytesize"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+const (
+	metadataDir      = "meta/" // Holds a JSON object with the metadata of every entry, named after its key.
+	dataDir          = "data/" // Holds the bodies, named after their key and content hash.
+	metadataSuffix   = ".json"
+	loadConcurrency  = 16
+	responseTimeout  = 30 * time.Second
+	spoolFilePattern = "reservoir-s3-*"
+)
+
+// Stores entries in a bucket of S3-compatible object storage. Every entry is a body object and a metadata
+// object, which is written last and marks the entry as complete. The metadata of all entries is kept in memory,
+// and entries written by other instances sharing the bucket are picked up when they are requested.
+type Cache[MetadataT any] struct {
+	client          *client
+	prefix          string
+	entriesMetadata map[cache.CacheKey]*cache.EntryMetadata[MetadataT]
+	tags            cache.TagIndex // Guarded by mu.
+	mu              sync.RWMutex
+	locks           []sync.RWMutex
+	byteSize        atomics.Int64
+	maxCacheSize    atomics.Int64
+	janitor         *cache.Janitor[MetadataT]
+	subs            config.ConfigSubscriber
+}
+
+func New[MetadataT any](cfg *config.Config, bucket string, prefix string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) *Cache[MetadataT] {
+	return newCache[MetadataT](cfg, bucket, prefix, maxCacheSize, cleanupInterval, shardCount, ctx, true)
+}
+
+// Creates a hybrid tier with its own capacity, which doesn't follow cache.max_cache_size.
+func NewTier[MetadataT any](cfg *config.Config, bucket string, prefix string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context) *Cache[MetadataT] {
+	return newCache[MetadataT](cfg, bucket, prefix, maxCacheSize, cleanupInterval, shardCount, ctx, false)
+}
+
+func newCache[MetadataT any](cfg *config.Config, bucket string, prefix string, maxCacheSize int64, cleanupInterval time.Duration, shardCount int, ctx context.Context, standalone bool) *Cache[MetadataT] {
+	c := &Cache[MetadataT]{
+		client:          newClient(&cfg.Cache.S3, bucket),
+		prefix:          prefix,
+		entriesMetadata: make(map[cache.CacheKey]*cache.EntryMetadata[MetadataT]),
+		tags:            cache.NewTagIndex(),
+		locks:           make([]sync.RWMutex, shardCount),
+		byteSize:        atomics.NewInt64(0),
+		maxCacheSize:    atomics.NewInt64(maxCacheSize),
+	}
+
+	if standalone {
+		c.subs.Add(cfg.Cache.MaxCacheSize.OnChange(func(newSize bytesize.ByteSize) {
+			c.maxCacheSize.Set(newSize.Bytes())
+		}))
+	}
+
+	c.loadEntries(ctx)
+
+	c.janitor = cache.NewJanitor(cfg, cleanupInterval, cache.JanitorFunctions[MetadataT]{
+		Iterate: func(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
+			c.mu.RLock()
+			snapshot := maps.Clone(c.entriesMetadata)
+			c.mu.RUnlock()
+
+			for key, metadata := range snapshot {
+				if !yield(key, metadata) {
+					break
+				}
+			}
+		},
+		Size: func() int64 {
+			return c.byteSize.Get()
+		},
+		Remove: func(key cache.CacheKey) error {
+			return c.ensureRemove(key)
+		},
+		Lock: func(key cache.CacheKey) *sync.RWMutex {
+			return cache.GetLock(c.locks, key)
+		},
+	}, standalone)
+	if c.byteSize.Get() >= c.maxCacheSize.Get() {
+		c.janitor.Evict(c.maxCacheSize.Get())
+	}
+	c.janitor.Start(ctx)
+	return c
+}
+
+func newClient(cfg *config.S3CacheConfig, bucket string) *client {
+	// The endpoint was checked when the config was verified.
+	endpoint, _ := url.Parse(cfg.Endpoint.Read())
+	if endpoint == nil {
+		endpoint = &url.URL{}
+	}
+
+	accessKey, secretKey := cfg.AccessKey.Read(), cfg.SecretKey.Read()
+	if accessKey == "" {
+		accessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if secretKey == "" {
+		secretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = responseTimeout
+	return &client{
+		http:      &http.Client{Transport: transport},
+		endpoint:  endpoint,
+		bucket:    bucket,
+		pathStyle: cfg.PathStyle.Read(),
+		signer:    signer{accessKey: accessKey, secretKey: secretKey, region: cfg.Region.Read()},
+	}
+}
+
+func (c *Cache[MetadataT]) Destroy() {
+	c.janitor.Stop()
+	c.subs.UnsubscribeAll()
+	c.client.http.CloseIdleConnections()
+}
+
+func (c *Cache[MetadataT]) metadataName(key cache.CacheKey) string {
+	return c.prefix + metadataDir + key.Hex + metadataSuffix
+}
+
+func (c *Cache[MetadataT]) dataName(key cache.CacheKey, hash string) string {
+	return c.prefix + dataDir + key.Hex + "/" + hash
+}
+
+// Loads the metadata of the entries in the bucket. Expired entries are removed.
+func (c *Cache[MetadataT]) loadEntries(ctx context.Context) {
+	keys := make([]cache.CacheKey, 0)
+	err := c.client.list(ctx, c.prefix+metadataDir, func(name string) {
+		keyHex, ok := strings.CutSuffix(strings.TrimPrefix(name, c.prefix+metadataDir), metadataSuffix)
+		if _, err := hex.DecodeString(keyHex); ok && err == nil && keyHex != "" {
+			keys = append(keys, cache.CacheKey{Hex: keyHex})
+		}
+	})
+	if err != nil {
+		slog.Error("Failed to list cache objects, entries are loaded when they are requested", "bucket", c.client.bucket, "error", err)
+		return
+	}
+
+	now := time.Now()
+	loaded, discarded := atomics.NewInt64(0), atomics.NewInt64(0)
+	group, groupCtx := errgroup.WithContext(ctx)
+	group.SetLimit(loadConcurrency)
+	for _, key := range keys {
+		group.Go(func() error {
+			meta, err := c.fetchMetadata(groupCtx, key)
+			if err != nil {
+				slog.Warn("Failed to load cache object metadata", "key", key.Hex, "error", err)
+				return nil
+			}
+			if meta.Expires.Before(now) && !meta.Pinned {
+				c.removeObjects(key, meta)
+				discarded.Add(1)
+				return nil
+			}
+			c.indexEntry(key, meta)
+			loaded.Add(1)
+			return nil
+		})
+	}
+	_ = group.Wait()
+
+	slog.Info("Loaded object storage cache", "bucket", c.client.bucket, "entries", loaded.Get(), "discarded", discarded.Get(), "size", bytesize.ByteSize(c.byteSize.Get()))
+}
@@ -0,0 +1,434 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reservoir/cache"
+	"reservoir/config"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type TestMeta struct {
+	ID string
+}
+
+const (
+	testBucket    = "reservoir-test"
+	testAccessKey = "test-access-key"
+	testSecretKey = "test-secret-key"
+	testPageSize  = 2 // Small enough for listings to need continuation.
+)
+
+// An in-memory S3 API that checks the signature of every request.
+type fakeS3 struct {
+	t       *testing.T
+	mu      sync.Mutex
+	objects map[string][]byte
+	ranges  []string
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *httptest.Server) {
+	t.Helper()
+
+	fake := &fakeS3{t: t, objects: make(map[string][]byte)}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	return fake, server
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if !f.validSignature(r, body) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = io.WriteString(w, "<Error><Code>SignatureDoesNotMatch</Code></Error>")
+		return
+	}
+
+	name, ok := strings.CutPrefix(r.URL.Path, "/"+testBucket+"/")
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch {
+	case r.Method == http.MethodGet && name == "" && r.URL.Query().Get("list-type") == "2":
+		f.list(w, r)
+	case r.Method == http.MethodPut:
+		f.objects[name] = body
+	case r.Method == http.MethodDelete:
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodGet:
+		f.get(w, r, name)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeS3) get(w http.ResponseWriter, r *http.Request, name string) {
+	data, exists := f.objects[name]
+	if !exists {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+		return
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if rangeHeader == "" {
+		_, _ = w.Write(data)
+		return
+	}
+	f.ranges = append(f.ranges, rangeHeader)
+
+	start, end, _ := strings.Cut(strings.TrimPrefix(rangeHeader, "bytes="), "-")
+	first, _ := strconv.Atoi(start)
+	last := len(data) - 1
+	if end != "" {
+		last, _ = strconv.Atoi(end)
+	}
+	last = min(last, len(data)-1)
+	w.WriteHeader(http.StatusPartialContent)
+	_, _ = w.Write(data[first : last+1])
+}
+
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	names := make([]string, 0)
+	for name := range f.objects {
+		if strings.HasPrefix(name, query.Get("prefix")) && name > query.Get("continuation-token") {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+
+	var result listResult
+	if len(names) > testPageSize {
+		names = names[:testPageSize]
+		result.IsTruncated = true
+		result.NextContinuationToken = names[len(names)-1]
+	}
+	for _, name := range names {
+		result.Contents = append(result.Contents, struct {
+			Key  string `xml:"Key"`
+			Size int64  `xml:"Size"`
+		}{Key: name, Size: int64(len(f.objects[name]))})
+	}
+	_ = xml.NewEncoder(w).Encode(result)
+}
+
+// Signs a copy of the request the way the client should have, and compares the signatures.
+func (f *fakeS3) validSignature(r *http.Request, body []byte) bool {
+	hash := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(hash[:]) {
+		return false
+	}
+	date, err := time.Parse(amzDateFormat, r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return false
+	}
+
+	signed, err := http.NewRequest(r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return false
+	}
+	for name, values := range r.Header {
+		if name != "Authorization" {
+			signed.Header[name] = values
+		}
+	}
+	s := signer{accessKey: testAccessKey, secretKey: testSecretKey, region: "us-east-1"}
+	s.sign(signed, r.Header.Get("X-Amz-Content-Sha256"), date)
+	return signed.Header.Get("Authorization") == r.Header.Get("Authorization")
+}
+
+func (f *fakeS3) objectNames() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	names := make([]string, 0, len(f.objects))
+	for name := range f.objects {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+func newTestConfig(endpoint string) *config.Config {
+	cfg := config.NewDefault()
+	cfg.Cache.S3.Endpoint.Overwrite(endpoint)
+	cfg.Cache.S3.AccessKey.Overwrite(testAccessKey)
+	cfg.Cache.S3.SecretKey.Overwrite(testSecretKey)
+	return cfg
+}
+
+func newTestCache(t *testing.T, cfg *config.Config) *Cache[TestMeta] {
+	t.Helper()
+
+	c := New[TestMeta](cfg, testBucket, "reservoir/", 1024*1024*1024, time.Minute, 16, t.Context())
+	t.Cleanup(c.Destroy)
+	return c
+}
+
+func readAll(t *testing.T, entry *cache.Entry[TestMeta]) []byte {
+	t.Helper()
+	defer entry.Data.Close()
+
+	data, err := io.ReadAll(entry.Data)
+	if err != nil {
+		t.Fatalf("failed to read entry: %v", err)
+	}
+	return data
+}
+
+func TestS3Cache_Basic(t *testing.T) {
+	fake, server := newFakeS3(t)
+	c := newTestCache(t, newTestConfig(server.URL))
+
+	key := cache.FromString("test-key")
+	data := []byte("hello object storage")
+	entry, err := c.Cache(key, bytes.NewReader(data), time.Now().Add(time.Hour), TestMeta{ID: "meta-s3"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	if got := readAll(t, entry); !bytes.Equal(got, data) {
+		t.Fatalf("expected the written entry to read %q, got %q", data, got)
+	}
+	if entry.Metadata.Tier != cache.TierObjectStorage {
+		t.Fatalf("expected tier %q, got %q", cache.TierObjectStorage, entry.Metadata.Tier)
+	}
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := readAll(t, retrieved); !bytes.Equal(got, data) {
+		t.Fatalf("expected %q, got %q", data, got)
+	}
+	if retrieved.Metadata.Object.ID != "meta-s3" {
+		t.Fatalf("expected metadata ID meta-s3, got %q", retrieved.Metadata.Object.ID)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != int64(len(data)) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+	if names := fake.objectNames(); len(names) != 2 {
+		t.Fatalf("expected a body and a metadata object, got %v", names)
+	}
+
+	if err := c.Delete(key); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+	if _, err := c.Get(key); err != cache.ErrCacheEntryNotFound {
+		t.Fatalf("expected ErrCacheEntryNotFound after delete, got %v", err)
+	}
+	if names := fake.objectNames(); len(names) != 0 {
+		t.Fatalf("expected delete to remove all objects, got %v", names)
+	}
+}
+
+func TestS3Cache_ReplacingEntryRemovesPreviousBody(t *testing.T) {
+	fake, server := newFakeS3(t)
+	c := newTestCache(t, newTestConfig(server.URL))
+
+	key := cache.FromString("replaced-key")
+	for _, body := range []string{"first body", "second body"} {
+		entry, err := c.Cache(key, strings.NewReader(body), time.Now().Add(time.Hour), TestMeta{})
+		if err != nil {
+			t.Fatalf("Cache failed: %v", err)
+		}
+		entry.Data.Close()
+	}
+
+	entry, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	if got := readAll(t, entry); string(got) != "second body" {
+		t.Fatalf("expected the second body, got %q", got)
+	}
+	if names := fake.objectNames(); len(names) != 2 {
+		t.Fatalf("expected the first body to be removed, got %v", names)
+	}
+	if stats := c.Stats(); stats.Entries != 1 || stats.Bytes != int64(len("second body")) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestS3Cache_ReadAtFetchesOnlyTheRange(t *testing.T) {
+	fake, server := newFakeS3(t)
+	c := newTestCache(t, newTestConfig(server.URL))
+
+	key := cache.FromString("ranged-key")
+	entry, err := c.Cache(key, strings.NewReader("0123456789abcdef"), time.Now().Add(time.Hour), TestMeta{})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer retrieved.Data.Close()
+
+	buf := make([]byte, 4)
+	if n, err := retrieved.Data.ReadAt(buf, 10); err != nil || string(buf[:n]) != "abcd" {
+		t.Fatalf("expected abcd, got %q (%v)", buf[:n], err)
+	}
+	if n, err := retrieved.Data.ReadAt(buf, 14); err != io.EOF || string(buf[:n]) != "ef" {
+		t.Fatalf("expected ef and EOF at the end of the body, got %q (%v)", buf[:n], err)
+	}
+	if _, err := retrieved.Data.Seek(12, io.SeekStart); err != nil {
+		t.Fatalf("Seek failed: %v", err)
+	}
+	if rest, err := io.ReadAll(retrieved.Data); err != nil || string(rest) != "cdef" {
+		t.Fatalf("expected cdef after seeking, got %q (%v)", rest, err)
+	}
+
+	fake.mu.Lock()
+	defer fake.mu.Unlock()
+	if want := []string{"bytes=10-13", "bytes=14-15", "bytes=12-"}; !slices.Equal(fake.ranges, want) {
+		t.Fatalf("expected ranges %v, got %v", want, fake.ranges)
+	}
+}
+
+func TestS3Cache_RestoresEntriesAfterRestart(t *testing.T) {
+	fake, server := newFakeS3(t)
+	cfg := newTestConfig(server.URL)
+
+	first := New[TestMeta](cfg, testBucket, "reservoir/", 1024*1024, time.Minute, 16, t.Context())
+	keys := []cache.CacheKey{cache.FromString("restored-a"), cache.FromString("restored-b"), cache.FromString("restored-c")}
+	for _, key := range keys {
+		entry, err := first.Cache(key, strings.NewReader("body of "+key.Hex), time.Now().Add(time.Hour), TestMeta{ID: key.Hex})
+		if err != nil {
+			t.Fatalf("Cache failed: %v", err)
+		}
+		entry.Data.Close()
+	}
+	expiredKey := cache.FromString("restored-expired")
+	entry, err := first.Cache(expiredKey, strings.NewReader("expired body"), time.Now().Add(-time.Minute), TestMeta{})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+	first.Destroy()
+
+	second := newTestCache(t, cfg)
+	if stats := second.Stats(); stats.Entries != len(keys) {
+		t.Fatalf("expected %d restored entries, got %+v", len(keys), stats)
+	}
+	for _, key := range keys {
+		entry, err := second.Get(key)
+		if err != nil {
+			t.Fatalf("Get of restored entry failed: %v", err)
+		}
+		if got := readAll(t, entry); string(got) != "body of "+key.Hex || entry.Metadata.Object.ID != key.Hex {
+			t.Fatalf("unexpected restored entry %q with metadata %+v", got, entry.Metadata.Object)
+		}
+	}
+	for _, name := range fake.objectNames() {
+		if strings.Contains(name, expiredKey.Hex) {
+			t.Fatalf("expected the objects of the expired entry to be removed, found %s", name)
+		}
+	}
+}
+
+func TestS3Cache_SharesEntriesBetweenInstances(t *testing.T) {
+	_, server := newFakeS3(t)
+	cfg := newTestConfig(server.URL)
+	writer := newTestCache(t, cfg)
+	reader := newTestCache(t, cfg)
+
+	key := cache.FromString("shared-key")
+	entry, err := writer.Cache(key, strings.NewReader("shared body"), time.Now().Add(time.Hour), TestMeta{ID: "shared"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+
+	shared, err := reader.Get(key)
+	if err != nil {
+		t.Fatalf("expected the entry written by the other instance, got %v", err)
+	}
+	if got := readAll(t, shared); string(got) != "shared body" || shared.Metadata.Object.ID != "shared" {
+		t.Fatalf("unexpected shared entry %q with metadata %+v", got, shared.Metadata.Object)
+	}
+}
+
+func TestS3Cache_RejectedSignatureFailsWrite(t *testing.T) {
+	fake, server := newFakeS3(t)
+	cfg := newTestConfig(server.URL)
+	cfg.Cache.S3.SecretKey.Overwrite("wrong-secret-key")
+	c := newTestCache(t, cfg)
+
+	_, err := c.Cache(cache.FromString("rejected-key"), strings.NewReader("body"), time.Now().Add(time.Hour), TestMeta{})
+	if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+		t.Fatalf("expected the signature to be rejected, got %v", err)
+	}
+	if names := fake.objectNames(); len(names) != 0 {
+		t.Fatalf("expected no objects, got %v", names)
+	}
+}
+
+// Runs against a real S3-compatible server, e.g. a local MinIO:
+//
+//	RESERVOIR_TEST_S3_ENDPOINT=http://localhost:9000 RESERVOIR_TEST_S3_BUCKET=reservoir \
+//	AWS_ACCESS_KEY_ID=minioadmin AWS_SECRET_ACCESS_KEY=minioadmin go test ./cache/s3/
+func TestS3Cache_ObjectStorageServer(t *testing.T) {
+	endpoint, bucket := os.Getenv("RESERVOIR_TEST_S3_ENDPOINT"), os.Getenv("RESERVOIR_TEST_S3_BUCKET")
+	if endpoint == "" || bucket == "" {
+		t.Skip("RESERVOIR_TEST_S3_ENDPOINT and RESERVOIR_TEST_S3_BUCKET are not set")
+	}
+
+	cfg := config.NewDefault()
+	cfg.Cache.S3.Endpoint.Overwrite(endpoint)
+	prefix := "reservoir-test-" + strconv.FormatInt(time.Now().UnixNano(), 36) + "/"
+	c := New[TestMeta](cfg, bucket, prefix, 1024*1024*1024, time.Minute, 16, t.Context())
+	defer c.Destroy()
+	defer c.Clear()
+
+	key := cache.FromString("server-key")
+	data := bytes.Repeat([]byte("reservoir "), 1000)
+	entry, err := c.Cache(key, bytes.NewReader(data), time.Now().Add(time.Hour), TestMeta{ID: "server"})
+	if err != nil {
+		t.Fatalf("Cache failed: %v", err)
+	}
+	entry.Data.Close()
+
+	retrieved, err := c.Get(key)
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+	defer retrieved.Data.Close()
+	buf := make([]byte, 9)
+	if n, err := retrieved.Data.ReadAt(buf, 5000); err != nil || string(buf[:n]) != "reservoir" {
+		t.Fatalf("expected a ranged read of the body, got %q (%v)", buf[:n], err)
+	}
+	if got, err := io.ReadAll(retrieved.Data); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("expected the full body, got %d bytes (%v)", len(got), err)
+	}
+
+	restarted := New[TestMeta](cfg, bucket, prefix, 1024*1024*1024, time.Minute, 16, t.Context())
+	defer restarted.Destroy()
+	if stats := restarted.Stats(); stats.Entries != 1 {
+		t.Fatalf("expected the entry to be restored from the bucket, got %+v", stats)
+	}
+}
@@ -0,0 +1,170 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const listPageSize = 1000
+
+// A minimal client for the object operations of the S3 API.
+type client struct {
+	http      *http.Client
+	endpoint  *url.URL
+	bucket    string
+	pathStyle bool
+	signer    signer
+}
+
+// The error response body of the S3 API.
+type apiError struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func (c *client) objectURL(name string, query url.Values) *url.URL {
+	u := *c.endpoint
+	if c.pathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + c.bucket + "/" + name
+	} else {
+		u.Host = c.bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + name
+	}
+	// Sent exactly as signed, url.URL would leave characters like '+' unescaped.
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// Sends a signed request and returns the response if it succeeded. body must be nil or have the given size.
+func (c *client) do(ctx context.Context, method string, name string, query url.Values, header http.Header, body io.Reader, size int64, payloadHash string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.objectURL(name, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	c.signer.sign(req, payloadHash, time.Now())
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s %s: %w", ErrRequest, method, name, err)
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrObjectNotFound
+	}
+	var apiErr apiError
+	_ = xml.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&apiErr)
+	return nil, fmt.Errorf("%w: %s %s: %s %s %s", ErrRequest, method, name, resp.Status, apiErr.Code, apiErr.Message)
+}
+
+// Uploads an object. body is not closed, the transport would otherwise close files handed to it.
+func (c *client) put(ctx context.Context, name string, body io.Reader, size int64, payloadHash string, contentType string) error {
+	resp, err := c.do(ctx, http.MethodPut, name, nil, http.Header{"Content-Type": {contentType}}, io.NopCloser(body), size, payloadHash)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.Body.Close()
+}
+
+// Reads the object from offset on, or length bytes of it if length is positive.
+func (c *client) get(ctx context.Context, name string, offset int64, length int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if length > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-"+strconv.FormatInt(offset+length-1, 10))
+	} else if offset > 0 {
+		header.Set("Range", "bytes="+strconv.FormatInt(offset, 10)+"-")
+	}
+	resp, err := c.do(ctx, http.MethodGet, name, nil, header, nil, 0, emptyPayloadHash)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusPartialContent || header.Get("Range") == "" {
+		return resp.Body, nil
+	}
+
+	// The server ignored the range and sent the whole object.
+	if _, err := io.CopyN(io.Discard, resp.Body, offset); err != nil {
+		resp.Body.Close()
+		return nil, fmt.Errorf("%w: GET %s: %w", ErrRequest, name, err)
+	}
+	if length <= 0 {
+		return resp.Body, nil
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{io.LimitReader(resp.Body, length), resp.Body}, nil
+}
+
+func (c *client) delete(ctx context.Context, name string) error {
+	resp, err := c.do(ctx, http.MethodDelete, name, nil, nil, nil, 0, emptyPayloadHash)
+	if errors.Is(err, ErrObjectNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+type listResult struct {
+	Contents []struct {
+		Key  string `xml:"Key"`
+		Size int64  `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// Calls fn with the name of every object starting with prefix, one page of the listing at a time.
+func (c *client) list(ctx context.Context, prefix string, fn func(name string)) error {
+	token := ""
+	for {
+		query := url.Values{
+			"list-type": {"2"},
+			"prefix":    {prefix},
+			"max-keys":  {strconv.Itoa(listPageSize)},
+		}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+
+		resp, err := c.do(ctx, http.MethodGet, "", query, nil, nil, 0, emptyPayloadHash)
+		if err != nil {
+			return err
+		}
+		var result listResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("%w: failed to decode object listing: %w", ErrRequest, err)
+		}
+
+		for _, object := range result.Contents {
+			fn(object.Key)
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return nil
+		}
+		token = result.NextContinuationToken
+	}
+}
@@ -0,0 +1,110 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// The body of a cached object. Reads stream the object from the current offset in a single request, which is
+// reopened after a seek. ReadAt fetches only the requested range.
+type objectData struct {
+	client *client
+	name   string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func newObjectData(client *client, name string, size int64) *objectData {
+	return &objectData{client: client, name: name, size: size}
+}
+
+func (d *objectData) Read(p []byte) (int, error) {
+	if d.offset >= d.size {
+		return 0, io.EOF
+	}
+	if d.body == nil {
+		body, err := d.client.get(context.Background(), d.name, d.offset, 0)
+		if err != nil {
+			return 0, err
+		}
+		d.body = body
+	}
+
+	n, err := d.body.Read(p)
+	d.offset += int64(n)
+	if errors.Is(err, io.EOF) && d.offset < d.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (d *objectData) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += d.offset
+	case io.SeekEnd:
+		offset += d.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative offset %d", offset)
+	}
+
+	if offset != d.offset {
+		d.closeBody()
+		d.offset = offset
+	}
+	return offset, nil
+}
+
+func (d *objectData) ReadAt(p []byte, offset int64) (int, error) {
+	if offset >= d.size {
+		return 0, io.EOF
+	}
+	length := min(int64(len(p)), d.size-offset)
+	if length == 0 {
+		return 0, nil
+	}
+
+	body, err := d.client.get(context.Background(), d.name, offset, length)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+
+	n, err := io.ReadFull(body, p[:length])
+	if err == nil && length < int64(len(p)) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (d *objectData) Close() error {
+	d.closeBody()
+	return nil
+}
+
+func (d *objectData) closeBody() {
+	if d.body != nil {
+		d.body.Close()
+		d.body = nil
+	}
+}
+
+// The body of an entry that was just written, read from the temporary file it was uploaded from.
+// The file is removed once the entry is closed.
+type spooledData struct {
+	*os.File
+}
+
+func (d spooledData) Close() error {
+	err := d.File.Close()
+	os.Remove(d.Name())
+	return err
+}
@@ -0,0 +1,12 @@
+package s3
+
+import (
+	"errors"
+)
+
+var (
+	ErrRequest        = errors.New("object storage request failed")
+	ErrObjectNotFound = errors.New("object not found")
+	ErrWrite          = errors.New("cache object write failed")
+	ErrEmpty          = errors.New("cache object empty")
+)
@@ -0,0 +1,232 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"reservoir/cache"
+	"reservoir/metrics"
+	"slices"
+	"time"
+)
+
+func metadataSnapshot[MetadataT any](meta *cache.EntryMetadata[MetadataT]) *cache.EntryMetadata[MetadataT] {
+	snapshot := *meta
+	snapshot.Tier = cache.TierObjectStorage
+	return &snapshot
+}
+
+// Returns the metadata of an entry, fetching it from the bucket if another instance wrote it. The caller holds
+// the entry's lock.
+func (c *Cache[MetadataT]) lookup(key cache.CacheKey) (*cache.EntryMetadata[MetadataT], bool) {
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if exists {
+		return meta, true
+	}
+
+	meta, err := c.fetchMetadata(context.Background(), key)
+	if err != nil {
+		if !errors.Is(err, ErrObjectNotFound) {
+			slog.Warn("Failed to fetch cache object metadata", "key", key.Hex, "error", err)
+		}
+		return nil, false
+	}
+	if meta.Expires.Before(time.Now()) && !meta.Pinned {
+		return nil, false
+	}
+	c.addEntry(key, meta)
+	return meta, true
+}
+
+func (c *Cache[MetadataT]) fetchMetadata(ctx context.Context, key cache.CacheKey) (*cache.EntryMetadata[MetadataT], error) {
+	body, err := c.client.get(ctx, c.metadataName(key), 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var meta cache.EntryMetadata[MetadataT]
+	if err := json.NewDecoder(body).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("%w: invalid metadata object: %w", ErrRequest, err)
+	}
+	if meta.Size <= 0 || meta.ContentHash == "" {
+		return nil, fmt.Errorf("%w: metadata object without a body", ErrRequest)
+	}
+	return &meta, nil
+}
+
+func (c *Cache[MetadataT]) writeMetadata(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) error {
+	encoded, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	return c.client.put(context.Background(), c.metadataName(key), bytes.NewReader(encoded), int64(len(encoded)), sha256Hex(encoded), "application/json")
+}
+
+func (c *Cache[MetadataT]) addEntry(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
+	c.indexEntry(key, meta)
+	c.janitor.Track(key, meta)
+}
+
+// Adds an entry to the in-memory index. The janitor picks up the entries indexed while loading when it starts.
+func (c *Cache[MetadataT]) indexEntry(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
+	c.mu.Lock()
+	c.entriesMetadata[key] = meta
+	c.tags.Add(key, cache.TagsOf(meta.Object))
+	c.mu.Unlock()
+
+	cache.IncrementCacheEntries()
+	cache.AddCacheSize(&c.byteSize, meta.Size)
+}
+
+func (c *Cache[MetadataT]) removeEntry(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
+	c.mu.Lock()
+	delete(c.entriesMetadata, key)
+	c.tags.Remove(key, cache.TagsOf(meta.Object))
+	c.mu.Unlock()
+
+	cache.DecrementCacheEntries()
+	cache.DecrementCacheSize(&c.byteSize, meta.Size)
+	c.janitor.Untrack(key)
+}
+
+func (c *Cache[MetadataT]) updateMetadata(key cache.CacheKey, modifier func(*cache.EntryMetadata[MetadataT]), recordMetrics bool) error {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, exists := c.lookup(key)
+	if !exists {
+		if recordMetrics {
+			metrics.Global.Cache.CacheErrors.Increment()
+		}
+		slog.Error("Failed to update metadata for cache entry", "key", key.Hex, "error", cache.ErrCacheEntryNotFound)
+		return fmt.Errorf("%w: cache entry for key '%s' does not exist", cache.ErrCacheEntryNotFound, key.Hex)
+	}
+
+	modifier(meta)
+	meta.LastAccess = time.Now()
+	if err := c.writeMetadata(key, meta); err != nil {
+		slog.Error("Failed to upload cache object metadata", "key", key.Hex, "error", err)
+	}
+	c.janitor.Track(key, meta)
+
+	if recordMetrics {
+		metrics.Global.Cache.CacheHits.Increment()
+	}
+
+	slog.Debug("Successfully updated metadata", "key", key.Hex)
+	return nil
+}
+
+func (c *Cache[MetadataT]) UpdateMetadata(key cache.CacheKey, modifier func(*cache.EntryMetadata[MetadataT])) error {
+	return c.updateMetadata(key, modifier, true)
+}
+
+func (c *Cache[MetadataT]) UpdateMetadataQuiet(key cache.CacheKey, modifier func(*cache.EntryMetadata[MetadataT])) error {
+	return c.updateMetadata(key, modifier, false)
+}
+
+// Carries the hit count and pin of an entry over from another tier.
+func (c *Cache[MetadataT]) InheritAccessState(key cache.CacheKey, previous *cache.EntryMetadata[MetadataT]) {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if !exists {
+		return
+	}
+
+	meta.Hits += previous.Hits
+	meta.Pinned = meta.Pinned || previous.Pinned
+	if err := c.writeMetadata(key, meta); err != nil {
+		slog.Error("Failed to upload cache object metadata", "key", key.Hex, "error", err)
+	}
+	c.janitor.Track(key, meta)
+}
+
+// Returns the metadata of an entry without counting it as an access. Only entries known to this instance are returned.
+func (c *Cache[MetadataT]) PeekMetadata(key cache.CacheKey) (*cache.EntryMetadata[MetadataT], bool) {
+	lock := cache.GetLock(c.locks, key)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	c.mu.RLock()
+	meta, exists := c.entriesMetadata[key]
+	c.mu.RUnlock()
+	if !exists {
+		return nil, false
+	}
+	return metadataSnapshot(meta), true
+}
+
+func (c *Cache[MetadataT]) getMetadata(key cache.CacheKey, recordMetrics bool) (*cache.EntryMetadata[MetadataT], bool, error) {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, exists := c.lookup(key)
+	if !exists {
+		if recordMetrics {
+			metrics.Global.Cache.CacheMisses.Increment()
+		}
+		return nil, false, cache.ErrCacheEntryNotFound
+	}
+	if recordMetrics {
+		metrics.Global.Cache.CacheHits.Increment()
+	}
+
+	meta.LastAccess = time.Now()
+	c.janitor.Track(key, meta)
+	return metadataSnapshot(meta), meta.Expires.Before(time.Now()), nil
+}
+
+func (c *Cache[MetadataT]) GetMetadata(key cache.CacheKey) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
+	return c.getMetadata(key, true)
+}
+
+func (c *Cache[MetadataT]) GetMetadataQuiet(key cache.CacheKey) (meta *cache.EntryMetadata[MetadataT], stale bool, err error) {
+	return c.getMetadata(key, false)
+}
+
+func (c *Cache[MetadataT]) Entries(yield func(key cache.CacheKey, metadata *cache.EntryMetadata[MetadataT]) bool) {
+	c.mu.RLock()
+	keys := slices.Collect(maps.Keys(c.entriesMetadata))
+	c.mu.RUnlock()
+
+	for _, key := range keys {
+		lock := cache.GetLock(c.locks, key)
+		lock.RLock()
+		c.mu.RLock()
+		metaPtr, exists := c.entriesMetadata[key]
+		c.mu.RUnlock()
+
+		var meta *cache.EntryMetadata[MetadataT]
+		if exists {
+			meta = metadataSnapshot(metaPtr)
+		}
+		lock.RUnlock()
+
+		if !exists {
+			continue
+		}
+		if !yield(key, meta) {
+			break
+		}
+	}
+}
+
+func (c *Cache[MetadataT]) KeysByTag(tag string) []cache.CacheKey {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.tags.Keys(tag)
+}
@@ -0,0 +1,218 @@
+package s3
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"reservoir/cache"
+	"reservoir/metrics"
+	"time"
+)
+
+func (c *Cache[MetadataT]) get(key cache.CacheKey, recordMetrics bool) (*cache.Entry[MetadataT], error) {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	meta, exists := c.lookup(key)
+	if !exists {
+		if recordMetrics {
+			metrics.Global.Cache.CacheMisses.Increment()
+		}
+		slog.Debug("Cache miss", "key", key.Hex)
+		return nil, cache.ErrCacheEntryNotFound
+	}
+
+	stale := meta.Expires.Before(time.Now())
+	meta.LastAccess = time.Now()
+	meta.Hits++
+	c.janitor.Track(key, meta)
+
+	if recordMetrics {
+		metrics.Global.Cache.CacheHits.Increment()
+	}
+	slog.Debug("Successful cache hit", "key", key.Hex)
+	return &cache.Entry[MetadataT]{
+		Data:     newObjectData(c.client, c.dataName(key, meta.ContentHash), meta.Size),
+		Metadata: metadataSnapshot(meta),
+		Stale:    stale,
+	}, nil
+}
+
+func (c *Cache[MetadataT]) Get(key cache.CacheKey) (*cache.Entry[MetadataT], error) {
+	return c.get(key, true)
+}
+
+func (c *Cache[MetadataT]) GetQuiet(key cache.CacheKey) (*cache.Entry[MetadataT], error) {
+	return c.get(key, false)
+}
+
+func (c *Cache[MetadataT]) Cache(key cache.CacheKey, data io.Reader, expires time.Time, metadata MetadataT) (*cache.Entry[MetadataT], error) {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	// The body is spooled to a temporary file first, the upload needs its size and hash before it starts.
+	spool, hash, size, err := spoolBody(data)
+	if err != nil {
+		metrics.Global.Cache.CacheErrors.Increment()
+		slog.Error("Failed to spool cache object", "key", key.Hex, "error", err)
+		return nil, err
+	}
+	spooled := spooledData{File: spool}
+
+	dataName := c.dataName(key, hash)
+	if err := c.client.put(context.Background(), dataName, spool, size, hash, "application/octet-stream"); err != nil {
+		spooled.Close()
+		metrics.Global.Cache.CacheErrors.Increment()
+		slog.Error("Failed to upload cache object", "key", key.Hex, "error", err)
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	now := time.Now()
+	meta := &cache.EntryMetadata[MetadataT]{
+		TimeWritten: now,
+		LastAccess:  now,
+		Expires:     expires,
+		Size:        size,
+		ContentHash: hash,
+		Object:      metadata,
+	}
+	previousMeta, replaced := c.lookup(key)
+	if replaced {
+		meta.Pinned = previousMeta.Pinned // A refreshed entry stays pinned.
+	}
+	if err := c.writeMetadata(key, meta); err != nil {
+		spooled.Close()
+		if previousMeta == nil || previousMeta.ContentHash != hash {
+			c.deleteObject(dataName)
+		}
+		metrics.Global.Cache.CacheErrors.Increment()
+		slog.Error("Failed to upload cache object metadata", "key", key.Hex, "error", err)
+		return nil, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	if replaced {
+		c.removeEntry(key, previousMeta)
+		if previousMeta.ContentHash != hash {
+			c.deleteObject(c.dataName(key, previousMeta.ContentHash))
+		}
+	}
+	c.addEntry(key, meta)
+
+	maxCacheSize := c.maxCacheSize.Get()
+	if c.byteSize.Get() >= maxCacheSize {
+		c.janitor.Evict(maxCacheSize)
+	}
+
+	slog.Debug("Successfully cached data", "key", key.Hex, "size", size, "hash", hash)
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		spooled.Close()
+		return nil, err
+	}
+	return &cache.Entry[MetadataT]{
+		Data:     spooled,
+		Metadata: metadataSnapshot(meta),
+	}, nil
+}
+
+// Writes the body to a temporary file and returns it with its SHA-256 and size.
+func spoolBody(data io.Reader) (*os.File, string, int64, error) {
+	spool, err := os.CreateTemp("", spoolFilePattern)
+	if err != nil {
+		return nil, "", 0, fmt.Errorf("%w: %w", ErrWrite, err)
+	}
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(spool, hasher), data)
+	if err == nil && size == 0 {
+		err = ErrEmpty
+	}
+	if err == nil {
+		_, err = spool.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		spooledData{File: spool}.Close()
+		return nil, "", 0, err
+	}
+	return spool, hex.EncodeToString(hasher.Sum(nil)), size, nil
+}
+
+func (c *Cache[MetadataT]) Delete(key cache.CacheKey) error {
+	lock := cache.GetLock(c.locks, key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	return c.ensureRemove(key)
+}
+
+// Removes the objects of an entry and forgets it, without acquiring its lock.
+func (c *Cache[MetadataT]) ensureRemove(key cache.CacheKey) error {
+	meta, exists := c.lookup(key)
+	if !exists {
+		return cache.ErrCacheEntryNotFound
+	}
+	if err := c.client.delete(context.Background(), c.metadataName(key)); err != nil {
+		slog.Error("Failed to remove cache object metadata", "key", key.Hex, "error", err)
+		return err
+	}
+	c.removeEntry(key, meta)
+	c.deleteObject(c.dataName(key, meta.ContentHash))
+	return nil
+}
+
+// Removes the objects of an entry that was never added.
+func (c *Cache[MetadataT]) removeObjects(key cache.CacheKey, meta *cache.EntryMetadata[MetadataT]) {
+	c.deleteObject(c.metadataName(key))
+	c.deleteObject(c.dataName(key, meta.ContentHash))
+}
+
+func (c *Cache[MetadataT]) deleteObject(name string) {
+	if err := c.client.delete(context.Background(), name); err != nil {
+		slog.Error("Failed to remove cache object", "name", name, "error", err)
+	}
+}
+
+func (c *Cache[MetadataT]) Stats() cache.Stats {
+	c.mu.RLock()
+	entries := len(c.entriesMetadata)
+	c.mu.RUnlock()
+
+	return cache.Stats{
+		Entries:  entries,
+		Bytes:    c.byteSize.Get(),
+		MaxBytes: c.maxCacheSize.Get(),
+	}
+}
+
+func (c *Cache[MetadataT]) EvictTo(maxCacheBytes int64) {
+	c.janitor.Evict(maxCacheBytes)
+}
+
+func (c *Cache[MetadataT]) Clear() error {
+	c.mu.RLock()
+	keys := make([]cache.CacheKey, 0, len(c.entriesMetadata))
+	for key := range c.entriesMetadata {
+		keys = append(keys, key)
+	}
+	c.mu.RUnlock()
+
+	var errs []error
+	for _, key := range keys {
+		lock := cache.GetLock(c.locks, key)
+		lock.Lock()
+		err := c.ensureRemove(key)
+		lock.Unlock()
+
+		if err != nil && !errors.Is(err, cache.ErrCacheEntryNotFound) {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
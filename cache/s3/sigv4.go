@@ -0,0 +1,117 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	sigV4Algorithm   = "AWS4-HMAC-SHA256"
+	sigV4Service     = "s3"
+	amzDateFormat    = "20060102T150405Z"
+	scopeDateFormat  = "20060102"
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" // SHA-256 of no bytes.
+)
+
+// Signs requests with AWS Signature Version 4, the scheme S3 and the S3-compatible storages authenticate with.
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+// Adds the date, payload hash and authorization headers. payloadHash is the hex SHA-256 of the request body.
+func (s signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format(amzDateFormat)
+	scope := now.Format(scopeDateFormat) + "/" + s.region + "/" + sigV4Service + "/aws4_request"
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers, signedHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	stringToSign := strings.Join([]string{sigV4Algorithm, amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretKey), now.Format(scopeDateFormat))
+	for _, part := range []string{s.region, sigV4Service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+" Credential="+s.accessKey+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// Returns the host and all x-amz-* and range headers as "name:value\n" lines, and their names.
+func canonicalHeaders(req *http.Request) (string, string) {
+	values := map[string]string{"host": req.URL.Host}
+	for name, headerValues := range req.Header {
+		name = strings.ToLower(name)
+		if strings.HasPrefix(name, "x-amz-") || name == "range" || name == "content-type" {
+			values[name] = strings.Join(headerValues, ",")
+		}
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + strings.Join(strings.Fields(values[name]), " ") + "\n")
+	}
+	return headers.String(), strings.Join(names, ";")
+}
+
+func canonicalQuery(query url.Values) string {
+	params := make([]string, 0, len(query))
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	slices.Sort(params)
+	return strings.Join(params, "&")
+}
+
+// Percent-encodes everything except the unreserved characters of RFC 3986, and slashes unless encodeSlash is set.
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for _, b := range []byte(s) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~':
+			encoded.WriteByte(b)
+		case b == '/' && !encodeSlash:
+			encoded.WriteByte(b)
+		default:
+			encoded.WriteString("%" + strings.ToUpper(hex.EncodeToString([]byte{b})))
+		}
+	}
+	return encoded.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
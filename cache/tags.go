@@ -0,0 +1,63 @@
+package cache
+
+// Implemented by metadata types that carry cache tags (surrogate keys).
+// The backends index these tags, so entries can be looked up by tag without scanning the whole cache.
+type Tagged interface {
+	CacheTags() []string
+}
+
+func TagsOf[MetadataT any](metadata MetadataT) []string {
+	if tagged, ok := any(metadata).(Tagged); ok {
+		return tagged.CacheTags()
+	}
+	return nil
+}
+
+// Maps tags to the keys of the entries carrying them.
+// It isn't synchronized, the owning backend guards it with the same lock as its entries.
+type TagIndex struct {
+	keys map[string]map[CacheKey]struct{}
+}
+
+func NewTagIndex() TagIndex {
+	return TagIndex{keys: make(map[string]map[CacheKey]struct{})}
+}
+
+func (t *TagIndex) Add(key CacheKey, tags []string) {
+	for _, tag := range tags {
+		keys, ok := t.keys[tag]
+		if !ok {
+			keys = make(map[CacheKey]struct{})
+			t.keys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (t *TagIndex) Remove(key CacheKey, tags []string) {
+	for _, tag := range tags {
+		keys, ok := t.keys[tag]
+		if !ok {
+			continue
+		}
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+}
+
+// Swaps the tags of a replaced entry for the ones of its replacement.
+func (t *TagIndex) Replace(key CacheKey, previousTags []string, tags []string) {
+	t.Remove(key, previousTags)
+	t.Add(key, tags)
+}
+
+// Returns the keys of the entries carrying the tag, in no particular order.
+func (t *TagIndex) Keys(tag string) []CacheKey {
+	keys := make([]CacheKey, 0, len(t.keys[tag]))
+	for key := range t.keys[tag] {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,32 @@
+package cachectl
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidExportFilter = errors.New("invalid export filter")
+
+// Selects the cache entries to export. Every field that is set has to match, without any field all entries are exported.
+type ExportFilter struct {
+	Host   string `json:"host,omitempty"`   // Host of the entry, supports "*.example.com" wildcards.
+	Prefix string `json:"prefix,omitempty"` // URL prefix, e.g. "deb.debian.org/debian/dists/".
+	Tag    string `json:"tag,omitempty"`    // Tag (surrogate key) of the entry.
+}
+
+type ExportResult struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"` // Size of the exported bodies.
+}
+
+type ImportOptions struct {
+	// Rewrites the expiry of every imported entry to now plus TTL. Without it, entries keep their expiry
+	// and entries that already expired are skipped, unless they're pinned.
+	TTL time.Duration
+}
+
+type ImportResult struct {
+	Imported int   `json:"imported"`
+	Skipped  int   `json:"skipped"` // Entries that expired and weren't imported.
+	Bytes    int64 `json:"bytes"`   // Size of the imported bodies.
+}
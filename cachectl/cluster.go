@@ -0,0 +1,11 @@
+package cachectl
+
+import "errors"
+
+var (
+	ErrClusterDisabled  = errors.New("cluster mode is disabled")
+	ErrPeerUnauthorized = errors.New("invalid cluster secret")
+	// Returned when a miss forwarded by another instance can't be cached here. The endpoint has to tell it apart from
+	// failures of this instance, so the other instance fetches the URL itself instead of marking this one down.
+	ErrPeerFetchNotCacheable = errors.New("response not cacheable")
+)
@@ -0,0 +1,47 @@
+package cachectl
+
+import (
+	"errors"
+	"net/http"
+	"reservoir/cache"
+	"time"
+)
+
+var ErrInvalidEntryQuery = errors.New("invalid entry query")
+
+// Describes a cache entry for browsing the cache.
+type EntryInfo struct {
+	Key         string      `json:"key"`
+	URL         string      `json:"url"`
+	Host        string      `json:"host"`
+	Size        int64       `json:"size"`
+	Tier        cache.Tier  `json:"tier"`
+	TimeWritten time.Time   `json:"time_written"`
+	LastAccess  time.Time   `json:"last_access"`
+	Expires     time.Time   `json:"expires"`
+	Stale       bool        `json:"stale"`
+	Hits        int64       `json:"hits"`
+	Pinned      bool        `json:"pinned"`
+	Tags        []string    `json:"tags"`
+	Header      http.Header `json:"header"`
+}
+
+// Selects, orders and paginates the entries of a listing.
+type EntryQuery struct {
+	Host       string     // Only entries of this host, supports "*.example.com" wildcards.
+	Search     string     // Only entries whose URL contains this, ignoring case.
+	Tag        string     // Only entries carrying this tag.
+	Tier       cache.Tier // Only entries in this tier.
+	Stale      *bool      // Only stale or only fresh entries.
+	Sort       string     // "url", "host", "size", "time_written", "last_access", "expires" or "hits", defaults to "last_access".
+	Descending bool
+	Offset     int
+	Limit      int // Defaults to 50, at most 1000.
+}
+
+type EntryPage struct {
+	Entries []EntryInfo `json:"entries"`
+	Total   int         `json:"total"` // Number of entries matching the query, ignoring the pagination.
+	Offset  int         `json:"offset"`
+	Limit   int         `json:"limit"`
+}
@@ -0,0 +1,16 @@
+package cachectl
+
+import "errors"
+
+var (
+	ErrInvalidTTLExtension   = errors.New("invalid TTL extension")
+	ErrEntryNotRevalidatable = errors.New("cache entry can't be revalidated")
+	ErrRevalidationFailed    = errors.New("error revalidating cache entry")
+)
+
+// Outcome of revalidating a cache entry against upstream.
+type RevalidationResult struct {
+	UpstreamStatus int       `json:"upstream_status"`
+	Modified       bool      `json:"modified"` // Set if upstream sent a new response, which replaced the entry.
+	Entry          EntryInfo `json:"entry"`
+}
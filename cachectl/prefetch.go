@@ -0,0 +1,17 @@
+// Package cachectl holds the types the proxy and the API share to control the cache, so the API doesn't depend
+// on the proxy itself.
+package cachectl
+
+import "errors"
+
+var ErrInvalidPrefetchURL = errors.New("invalid prefetch URL")
+
+// Outcome of prefetching a single URL.
+type PrefetchResult struct {
+	URL    string `json:"url"`
+	Status int    `json:"status,omitempty"` // Status the proxy would have served to a client.
+	Cache  string `json:"cache,omitempty"`  // hit, miss, revalidated or stale.
+	Stored bool   `json:"stored"`           // Set if the response is in the cache after the prefetch.
+	Bytes  int64  `json:"bytes"`
+	Error  string `json:"error,omitempty"`
+}
@@ -0,0 +1,16 @@
+package cachectl
+
+import "errors"
+
+var ErrInvalidPurgeFilter = errors.New("invalid purge filter")
+
+// Selects the cache entries to purge. Every field that is set has to match.
+// URLs are compared without their scheme, like cache keys are.
+type PurgeFilter struct {
+	URL    string `json:"url,omitempty"`    // Exact URL of the entry.
+	Host   string `json:"host,omitempty"`   // Host of the entry, supports "*.example.com" wildcards.
+	Prefix string `json:"prefix,omitempty"` // URL prefix, e.g. "deb.debian.org/debian/dists/".
+	Regex  string `json:"regex,omitempty"`  // Regular expression matched against the full URL.
+	Tag    string `json:"tag,omitempty"`    // Tag (surrogate key) of the entry.
+	Soft   bool   `json:"soft,omitempty"`   // Marks the entries as stale instead of removing them, so they're revalidated on the next request.
+}
@@ -0,0 +1,21 @@
+package cachectl
+
+import "time"
+
+type BreakerState string
+
+const (
+	BreakerStateClosed   BreakerState = "closed"
+	BreakerStateOpen     BreakerState = "open"
+	BreakerStateHalfOpen BreakerState = "half_open" // A single request probes whether the host is back.
+)
+
+// Describes the circuit breaker of an upstream host.
+type CircuitBreakerInfo struct {
+	Host                string       `json:"host"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastError           string       `json:"last_error,omitempty"`
+	OpenedAt            time.Time    `json:"opened_at,omitzero"`
+	RetryAt             time.Time    `json:"retry_at,omitzero"` // When the next request probes the host, if it isn't closed.
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/proxy"
+	"time"
+)
+
+// Subcommands that work on the cache directly instead of starting the proxy.
+// They open the cache like the server does, so they must not run while the server is running.
+var commands = map[string]func(cfg *config.Config, args []string) error{
+	"export": runExport,
+	"import": runImport,
+}
+
+// Runs the subcommand named by the first argument, if there is one, and reports whether it did.
+func runCommand(cfg *config.Config, args []string) (bool, error) {
+	if len(args) == 0 {
+		return false, nil
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		return false, nil
+	}
+	err := command(cfg, args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return true, nil
+	}
+	return true, err
+}
+
+func runExport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: reservoir export [flags] <file|->")
+		fs.PrintDefaults()
+	}
+	formatName := fs.String("format", string(bundle.FormatTarZstd), "Archive format, tar or tar.zst")
+	host := fs.String("host", "", "Only export entries of this host, supports *.example.com wildcards")
+	prefix := fs.String("prefix", "", "Only export entries whose URL starts with this prefix")
+	tag := fs.String("tag", "", "Only export entries carrying this tag")
+	cacheDir := fs.String("cache-dir", cfg.Cache.File.Dir.Read(), "Path to cache directory, unless cache.hybrid.tiers is set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one output file")
+	}
+
+	format, err := bundle.ParseFormat(*formatName)
+	if err != nil {
+		return err
+	}
+
+	return withCommandProxy(cfg, *cacheDir, func(p *proxy.Proxy) error {
+		out, closeOut, err := openCommandOutput(fs.Arg(0))
+		if err != nil {
+			return err
+		}
+
+		result, err := p.ExportBundle(out, format, cachectl.ExportFilter{Host: *host, Prefix: *prefix, Tag: *tag})
+		if closeErr := closeOut(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d entries (%d bytes)\n", result.Entries, result.Bytes)
+		return nil
+	})
+}
+
+func runImport(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: reservoir import [flags] <file|->")
+		fs.PrintDefaults()
+	}
+	ttl := fs.Duration("ttl", 0, "Rewrite the expiry of every imported entry to now plus this duration, e.g. 24h")
+	cacheDir := fs.String("cache-dir", cfg.Cache.File.Dir.Read(), "Path to cache directory, unless cache.hybrid.tiers is set")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("expected exactly one input file")
+	}
+	if *ttl < 0 {
+		return errors.New("ttl can't be negative")
+	}
+
+	return withCommandProxy(cfg, *cacheDir, func(p *proxy.Proxy) error {
+		in := io.Reader(os.Stdin)
+		if name := fs.Arg(0); name != "-" {
+			file, err := os.Open(name)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			in = file
+		}
+
+		result, err := p.ImportBundle(in, cachectl.ImportOptions{TTL: *ttl})
+		fmt.Fprintf(os.Stderr, "Imported %d entries (%d bytes), skipped %d expired entries\n", result.Imported, result.Bytes, result.Skipped)
+		return err
+	})
+}
+
+// Opens the cache on disk through a proxy that never listens, so entries are read and written like the server does.
+func withCommandProxy(cfg *config.Config, cacheDir string, fn func(p *proxy.Proxy) error) error {
+	switch cfg.Cache.Type.Read() {
+	case config.CacheTypeMemory:
+		return errors.New("the memory cache isn't persisted, export and import only work with the file, hybrid or s3 cache")
+	case config.CacheTypeHybrid:
+		// All tiers below memory are opened, cache.file.dir is only one of them without cache.hybrid.tiers.
+		if len(cfg.Cache.Hybrid.Tiers.Read().Values()) > 0 && cacheDir != cfg.Cache.File.Dir.Read() {
+			return errors.New("-cache-dir can't be used with cache.hybrid.tiers, the configured tiers are opened instead")
+		}
+		// Without memory, imported entries are written to the tiers instead of being lost on exit. The snapshot
+		// is left alone, so entries only kept in it aren't exported and the server still restores them.
+		cfg.Cache.Memory.MemoryBudgetPercent.Overwrite(0)
+		cfg.Cache.Memory.Snapshot.Enabled.Overwrite(false)
+	}
+	cfg.Cache.File.Dir.Overwrite(cacheDir)
+	if err := cfg.Verify(); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	p, err := proxy.NewProxy(cfg, nil, ctx)
+	if err != nil {
+		return err
+	}
+	defer p.Destroy()
+
+	start := time.Now()
+	err = fn(p)
+	if err == nil {
+		fmt.Fprintf(os.Stderr, "Done in %s\n", time.Since(start).Round(time.Millisecond))
+	}
+	return err
+}
+
+// Opens the file to write to, or stdout for "-".
+func openCommandOutput(name string) (io.Writer, func() error, error) {
+	if name == "-" {
+		return os.Stdout, func() error { return nil }, nil
+	}
+	file, err := os.Create(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, file.Close, nil
+}
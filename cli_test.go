@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reservoir/cache"
+	"reservoir/cache/bundle"
+	"reservoir/config"
+	"reservoir/utils/bytesize"
+	"reservoir/utils/stringlist"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTieredCommandConfig(fastDir, slowDir string) *config.Config {
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeHybrid)
+	cfg.Cache.Hybrid.Tiers.Overwrite(stringlist.New(fastDir+"=1K", slowDir+"=1M"))
+	cfg.Cache.MaxCacheSize.Overwrite(bytesize.ParseUnchecked("2M"))
+	cfg.Cache.LockShards.Overwrite(32)
+	return cfg
+}
+
+func writeTestBundle(t *testing.T, entries int, size int) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "entries.tar")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("failed to create bundle: %v", err)
+	}
+	defer file.Close()
+
+	bw, err := bundle.NewWriter[map[string]any](file, bundle.FormatTar)
+	if err != nil {
+		t.Fatalf("failed to create bundle writer: %v", err)
+	}
+	for i := range entries {
+		url := "http://deb.debian.org/pool/" + string(rune('a'+i)) + ".deb"
+		body := strings.Repeat(string(rune('a'+i)), size)
+		sum := sha256.Sum256([]byte(body))
+		meta := &cache.EntryMetadata[map[string]any]{
+			TimeWritten: time.Now(),
+			Expires:     time.Now().Add(time.Hour),
+			Size:        int64(size),
+			Object:      map[string]any{"URL": url},
+		}
+		if err := bw.Add(cache.FromString(url), meta, hex.EncodeToString(sum[:]), strings.NewReader(body)); err != nil {
+			t.Fatalf("failed to add bundle entry: %v", err)
+		}
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("failed to close bundle: %v", err)
+	}
+	return path
+}
+
+func countBundleEntries(t *testing.T, path string) int {
+	t.Helper()
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open bundle: %v", err)
+	}
+	defer file.Close()
+
+	entries := 0
+	err = bundle.Read(file, func(bundle.Entry[map[string]any]) error {
+		entries++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read bundle: %v", err)
+	}
+	return entries
+}
+
+func dirHasFiles(t *testing.T, dir string) bool {
+	t.Helper()
+
+	found := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			found = true
+		}
+		return nil
+	})
+	return found
+}
+
+func TestCommandsUseAllHybridTiers(t *testing.T) {
+	fastDir, slowDir := t.TempDir(), t.TempDir()
+
+	// The fast tier only fits one entry, the others move down to the slow tier.
+	if _, err := runCommand(newTieredCommandConfig(fastDir, slowDir), []string{"import", writeTestBundle(t, 3, 600)}); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+	if !dirHasFiles(t, fastDir) || !dirHasFiles(t, slowDir) {
+		t.Fatal("expected the imported entries to be spread over both tiers")
+	}
+
+	out := filepath.Join(t.TempDir(), "export.tar")
+	if _, err := runCommand(newTieredCommandConfig(fastDir, slowDir), []string{"export", "-format", "tar", out}); err != nil {
+		t.Fatalf("export failed: %v", err)
+	}
+	if got := countBundleEntries(t, out); got != 3 {
+		t.Fatalf("expected the entries of both tiers to be exported, got %d", got)
+	}
+
+	if _, err := runCommand(newTieredCommandConfig(fastDir, slowDir), []string{"export", "-cache-dir", t.TempDir(), out}); err == nil {
+		t.Fatal("expected -cache-dir to be refused with hybrid tiers")
+	}
+}
+
+func TestCommandsVerifyTheConfig(t *testing.T) {
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeFile)
+	if _, err := runCommand(cfg, []string{"export", "-cache-dir", "", filepath.Join(t.TempDir(), "export.tar")}); err == nil {
+		t.Fatal("expected an empty cache directory to be refused")
+	}
+}
+
+func TestCommandsLeaveTheMemorySnapshotAlone(t *testing.T) {
+	fastDir, slowDir := t.TempDir(), t.TempDir()
+	snapshot := filepath.Join(t.TempDir(), "memory.snapshot")
+	if err := os.WriteFile(snapshot, []byte("server snapshot"), 0o644); err != nil {
+		t.Fatalf("failed to write snapshot: %v", err)
+	}
+
+	cfg := newTieredCommandConfig(fastDir, slowDir)
+	cfg.Cache.Memory.Snapshot.Enabled.Overwrite(true)
+	cfg.Cache.Memory.Snapshot.Path.Overwrite(snapshot)
+	if _, err := runCommand(cfg, []string{"import", writeTestBundle(t, 1, 600)}); err != nil {
+		t.Fatalf("import failed: %v", err)
+	}
+
+	if data, err := os.ReadFile(snapshot); err != nil || string(data) != "server snapshot" {
+		t.Fatalf("expected the snapshot of the server to be kept, got %q: %v", data, err)
+	}
+}
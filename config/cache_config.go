@@ -2,8 +2,10 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"reservoir/utils/bytesize"
 	"reservoir/utils/duration"
+	"reservoir/utils/stringlist"
 	"time"
 )
 
@@ -13,33 +15,86 @@ var (
 	CacheTypeFile   CacheType = "file"
 	CacheTypeHybrid CacheType = "hybrid"
 	CacheTypeMemory CacheType = "memory"
+	CacheTypeS3     CacheType = "s3"
+)
+
+type EvictionPolicy string
+
+var (
+	EvictionPolicyLRU  EvictionPolicy = "lru"
+	EvictionPolicyLFU  EvictionPolicy = "lfu"
+	EvictionPolicyGDSF EvictionPolicy = "gdsf"
+	EvictionPolicyARC  EvictionPolicy = "arc"
+)
+
+type FsyncPolicy string
+
+var (
+	FsyncPolicyNone    FsyncPolicy = "none"
+	FsyncPolicyData    FsyncPolicy = "data"
+	FsyncPolicyDataDir FsyncPolicy = "data+dir"
 )
 
 type FileCacheConfig struct {
-	Dir ConfigProp[string] `json:"dir"` // The directory used by the file backend and hybrid file tier.
+	Dir   ConfigProp[string]      `json:"dir"`   // The directory used by the file backend and hybrid file tier.
+	Fsync ConfigProp[FsyncPolicy] `json:"fsync"` // What is flushed to disk before a written body is used. Supported values are "none", "data", and "data+dir".
 }
 
 type MemoryCacheConfig struct {
-	MemoryBudgetPercent ConfigProp[int] `json:"memory_budget_percent"` // The percentage of total memory used by the memory backend and hybrid memory tier.
+	MemoryBudgetPercent ConfigProp[int]      `json:"memory_budget_percent"` // The percentage of total memory used by the memory backend and hybrid memory tier.
+	Snapshot            MemorySnapshotConfig `json:"snapshot"`
+}
+
+type MemorySnapshotConfig struct {
+	Enabled ConfigProp[bool]              `json:"enabled"`  // Whether memory entries are written to a snapshot on shutdown and restored from it on startup.
+	Path    ConfigProp[string]            `json:"path"`     // The file the snapshot is written to.
+	MaxSize ConfigProp[bytesize.ByteSize] `json:"max_size"` // The most entry data written to the snapshot, the most recently accessed entries are written first.
+	Timeout ConfigProp[duration.Duration] `json:"timeout"`  // How long writing or restoring the snapshot may take. Entries that didn't make it are skipped.
 }
 
 type HybridCacheConfig struct {
-	DemoteAfter ConfigProp[duration.Duration] `json:"demote_after"` // How long a hybrid memory-tier entry can sit without access before it is demoted to the file tier.
+	DemoteAfter ConfigProp[duration.Duration]     `json:"demote_after"` // How long a hybrid memory-tier entry can sit without access before it is demoted to the file tier.
+	Tiers       ConfigProp[stringlist.StringList] `json:"tiers"`        // The file tiers below the memory tier, fastest first, e.g. "/mnt/nvme/reservoir=200G,1h". See HybridTier. Empty means one tier in cache.file.dir.
+}
+
+type S3CacheConfig struct {
+	Endpoint  ConfigProp[string] `json:"endpoint"`   // The URL of the S3-compatible object storage, e.g. "http://localhost:9000".
+	Region    ConfigProp[string] `json:"region"`     // The region requests are signed for.
+	Bucket    ConfigProp[string] `json:"bucket"`     // The bucket used by the s3 backend.
+	Prefix    ConfigProp[string] `json:"prefix"`     // Prepended to the names of the objects written by the s3 backend.
+	AccessKey ConfigProp[string] `json:"access_key"` // Falls back to the AWS_ACCESS_KEY_ID environment variable.
+	SecretKey ConfigProp[string] `json:"secret_key"` // Falls back to the AWS_SECRET_ACCESS_KEY environment variable.
+	PathStyle ConfigProp[bool]   `json:"path_style"` // Whether the bucket is part of the path instead of the host name, as most self-hosted storages expect.
+}
+
+type EvictionConfig struct {
+	Policy              ConfigProp[EvictionPolicy] `json:"policy"`                 // The order entries are evicted in. Supported values are "lru", "lfu", "gdsf", and "arc".
+	LowWaterMarkPercent ConfigProp[int]            `json:"low_water_mark_percent"` // Eviction removes entries until the cache is at this percentage of its maximum size.
 }
 
 type CacheConfig struct {
 	MaxCacheSize    ConfigProp[bytesize.ByteSize] `json:"max_cache_size"`   // The maximum size of the cache across all tiers.
-	Type            ConfigProp[CacheType]         `json:"type"`             // The type of cache to use. Supported values are "memory", "file", and "hybrid".
+	Type            ConfigProp[CacheType]         `json:"type"`             // The type of cache to use. Supported values are "memory", "file", "hybrid", and "s3".
 	CleanupInterval ConfigProp[duration.Duration] `json:"cleanup_interval"` // The interval at which expired cache entries are removed.
 	LockShards      ConfigProp[int]               `json:"lock_shards"`      // The number of shards to use for per-key locking.
 	File            FileCacheConfig               `json:"file"`
 	Memory          MemoryCacheConfig             `json:"memory"`
 	Hybrid          HybridCacheConfig             `json:"hybrid"`
+	S3              S3CacheConfig                 `json:"s3"`
+	Eviction        EvictionConfig                `json:"eviction"`
 }
 
 func (c *CacheConfig) setRestartNeededProps() {
 	c.Type.SetRequiresRestart()
 	c.File.Dir.SetRequiresRestart()
+	c.Hybrid.Tiers.SetRequiresRestart()
+	c.S3.Endpoint.SetRequiresRestart()
+	c.S3.Region.SetRequiresRestart()
+	c.S3.Bucket.SetRequiresRestart()
+	c.S3.Prefix.SetRequiresRestart()
+	c.S3.AccessKey.SetRequiresRestart()
+	c.S3.SecretKey.SetRequiresRestart()
+	c.S3.PathStyle.SetRequiresRestart()
 	c.LockShards.SetRequiresRestart()
 }
 
@@ -53,15 +108,81 @@ func (c *CacheConfig) verify() error {
 	if c.Memory.MemoryBudgetPercent.Read() < 0 || c.Memory.MemoryBudgetPercent.Read() > 100 {
 		return fmt.Errorf("cache.memory.memory_budget_percent must be between 0 and 100")
 	}
+	if c.Memory.Snapshot.Enabled.Read() && c.Memory.Snapshot.Path.Read() == "" {
+		return fmt.Errorf("cache.memory.snapshot.path cannot be empty when snapshots are enabled")
+	}
+	if c.Memory.Snapshot.MaxSize.Read().Bytes() <= 0 {
+		return fmt.Errorf("cache.memory.snapshot.max_size must be greater than 0")
+	}
+	if c.Memory.Snapshot.Timeout.Read().Cast() <= 0 {
+		return fmt.Errorf("cache.memory.snapshot.timeout must be greater than 0")
+	}
 	if c.Hybrid.DemoteAfter.Read().Cast() <= 0 {
 		return fmt.Errorf("cache.hybrid.demote_after must be greater than 0")
 	}
+	usesS3 := c.Type.Read() == CacheTypeS3
+	tiers := c.Hybrid.Tiers.Read().Values()
+	dirs := make(map[string]struct{}, len(tiers))
+	for i, raw := range tiers {
+		tier, err := ParseHybridTier(raw)
+		if err != nil {
+			return fmt.Errorf("cache.hybrid.tiers: %w", err)
+		}
+		if bucket, _, ok := tier.ObjectStorage(); ok {
+			if bucket == "" {
+				return fmt.Errorf("cache.hybrid.tiers: the object storage tier '%s' is missing a bucket", raw)
+			}
+			if i != len(tiers)-1 {
+				return fmt.Errorf("cache.hybrid.tiers: the object storage tier '%s' must be the last tier", raw)
+			}
+			usesS3 = usesS3 || c.Type.Read() == CacheTypeHybrid
+		}
+		if _, ok := dirs[tier.Dir]; ok {
+			return fmt.Errorf("cache.hybrid.tiers: directory '%s' is used by more than one tier", tier.Dir)
+		}
+		dirs[tier.Dir] = struct{}{}
+		if i == len(tiers)-1 && tier.DemoteAfter > 0 {
+			return fmt.Errorf("cache.hybrid.tiers: the last tier '%s' has no tier to demote entries to", raw)
+		}
+	}
+	if usesS3 {
+		if err := c.S3.verify(c.Type.Read() == CacheTypeS3); err != nil {
+			return err
+		}
+	}
 	if c.File.Dir.Read() == "" {
 		return fmt.Errorf("cache.file.dir cannot be empty")
 	}
+	switch c.File.Fsync.Read() {
+	case FsyncPolicyNone, FsyncPolicyData, FsyncPolicyDataDir:
+	default:
+		return fmt.Errorf("cache.file.fsync must be one of 'none', 'data', or 'data+dir'")
+	}
+	if percent := c.Eviction.LowWaterMarkPercent.Read(); percent <= 0 || percent >= 100 {
+		return fmt.Errorf("cache.eviction.low_water_mark_percent must be between 1 and 99")
+	}
+	switch c.Eviction.Policy.Read() {
+	case EvictionPolicyLRU, EvictionPolicyLFU, EvictionPolicyGDSF, EvictionPolicyARC:
+	default:
+		return fmt.Errorf("cache.eviction.policy must be one of 'lru', 'lfu', 'gdsf', or 'arc'")
+	}
 	cType := c.Type.Read()
-	if cType != CacheTypeFile && cType != CacheTypeMemory && cType != CacheTypeHybrid {
-		return fmt.Errorf("cache.type must be one of 'file', 'memory', or 'hybrid'")
+	if cType != CacheTypeFile && cType != CacheTypeMemory && cType != CacheTypeHybrid && cType != CacheTypeS3 {
+		return fmt.Errorf("cache.type must be one of 'file', 'memory', 'hybrid', or 's3'")
+	}
+	return nil
+}
+
+func (c *S3CacheConfig) verify(needsBucket bool) error {
+	endpoint, err := url.Parse(c.Endpoint.Read())
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return fmt.Errorf("cache.s3.endpoint must be an http or https URL")
+	}
+	if c.Region.Read() == "" {
+		return fmt.Errorf("cache.s3.region cannot be empty")
+	}
+	if needsBucket && c.Bucket.Read() == "" {
+		return fmt.Errorf("cache.s3.bucket cannot be empty")
 	}
 	return nil
 }
@@ -73,13 +194,34 @@ func defaultCacheConfig() CacheConfig {
 		CleanupInterval: NewConfigProp(duration.Duration(5 * time.Minute)),
 		LockShards:      NewConfigProp(1024),
 		File: FileCacheConfig{
-			Dir: NewConfigProp("var/cache/"),
+			Dir:   NewConfigProp("var/cache/"),
+			Fsync: NewConfigProp(FsyncPolicyData),
 		},
 		Memory: MemoryCacheConfig{
 			MemoryBudgetPercent: NewConfigProp(25),
+			Snapshot: MemorySnapshotConfig{
+				Enabled: NewConfigProp(false),
+				Path:    NewConfigProp("var/memory.snapshot"),
+				MaxSize: NewConfigProp(bytesize.ParseUnchecked("1G")),
+				Timeout: NewConfigProp(duration.Duration(30 * time.Second)),
+			},
 		},
 		Hybrid: HybridCacheConfig{
 			DemoteAfter: NewConfigProp(duration.Duration(5 * time.Minute)),
+			Tiers:       NewConfigProp(stringlist.New()),
+		},
+		S3: S3CacheConfig{
+			Endpoint:  NewConfigProp(""),
+			Region:    NewConfigProp("us-east-1"),
+			Bucket:    NewConfigProp(""),
+			Prefix:    NewConfigProp("reservoir/"),
+			AccessKey: NewConfigProp(""),
+			SecretKey: NewConfigProp(""),
+			PathStyle: NewConfigProp(true),
+		},
+		Eviction: EvictionConfig{
+			Policy:              NewConfigProp(EvictionPolicyLRU),
+			LowWaterMarkPercent: NewConfigProp(80),
 		},
 	}
 }
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Limits the concurrent upstream requests to each host matching the host pattern.
+type ConcurrencyRule struct {
+	Host  string // Supports "*.example.com" wildcards.
+	Limit int    // 0 means no limit.
+}
+
+// Parses a rule written as "<host>=<requests>", e.g. "*.debian.org=4".
+// Limits apply per host, so the rule can't have a path prefix.
+func ParseConcurrencyRule(rule string) (ConcurrencyRule, error) {
+	host, pathPrefix, rawLimit, err := splitURLRule("concurrency", rule)
+	if err != nil {
+		return ConcurrencyRule{}, err
+	}
+	if pathPrefix != "/" {
+		return ConcurrencyRule{}, fmt.Errorf("concurrency rule '%s' can't have a path prefix", rule)
+	}
+
+	limit, err := strconv.Atoi(strings.TrimSpace(rawLimit))
+	if err != nil || limit < 0 {
+		return ConcurrencyRule{}, fmt.Errorf("concurrency rule '%s' must limit to 0 or more requests", rule)
+	}
+	return ConcurrencyRule{Host: host, Limit: limit}, nil
+}
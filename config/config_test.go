@@ -6,6 +6,7 @@ import (
 	"os"
 	"reflect"
 	"reservoir/utils/bytesize"
+	"reservoir/utils/stringlist"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -331,6 +332,34 @@ func TestConfig_Verify(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "invalid eviction policy",
+			modify: func(c *Config) {
+				c.Cache.Eviction.Policy.Overwrite("random")
+			},
+			wantErr: true,
+		},
+		{
+			name: "low water mark at the maximum size",
+			modify: func(c *Config) {
+				c.Cache.Eviction.LowWaterMarkPercent.Overwrite(100)
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid fsync policy",
+			modify: func(c *Config) {
+				c.Cache.File.Fsync.Overwrite("always")
+			},
+			wantErr: true,
+		},
+		{
+			name: "fsync of data and directories",
+			modify: func(c *Config) {
+				c.Cache.File.Fsync.Overwrite(FsyncPolicyDataDir)
+			},
+			wantErr: false,
+		},
 		{
 			name: "empty cache dir",
 			modify: func(c *Config) {
@@ -338,6 +367,276 @@ func TestConfig_Verify(t *testing.T) {
 			},
 			wantErr: true,
 		},
+		{
+			name: "valid tag rule",
+			modify: func(c *Config) {
+				c.Proxy.TagRules.Overwrite(stringlist.New("*.ubuntu.com/dists/noble=noble, ubuntu"))
+			},
+			wantErr: false,
+		},
+		{
+			name: "tag rule without tags",
+			modify: func(c *Config) {
+				c.Proxy.TagRules.Overwrite(stringlist.New("*.ubuntu.com/dists/noble="))
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid size rule",
+			modify: func(c *Config) {
+				c.Proxy.Admission.SizeRules.Overwrite(stringlist.New("cdimage.ubuntu.com=-1G", "*.debian.org/debian/dists=1K-"))
+			},
+			wantErr: false,
+		},
+		{
+			name: "size rule without limits",
+			modify: func(c *Config) {
+				c.Proxy.Admission.SizeRules.Overwrite(stringlist.New("cdimage.ubuntu.com=-"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "size rule with minimum above maximum",
+			modify: func(c *Config) {
+				c.Proxy.Admission.SizeRules.Overwrite(stringlist.New("cdimage.ubuntu.com=2G-1G"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission request count above the sketch maximum",
+			modify: func(c *Config) {
+				c.Proxy.Admission.MinRequests.Overwrite(16)
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid hybrid tiers",
+			modify: func(c *Config) {
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("/mnt/nvme/reservoir=200G,1h", "/mnt/hdd/reservoir=4T"))
+			},
+			wantErr: false,
+		},
+		{
+			name: "hybrid tier without size",
+			modify: func(c *Config) {
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("/mnt/nvme/reservoir"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "last hybrid tier with demotion time",
+			modify: func(c *Config) {
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("/mnt/nvme/reservoir=200G,1h", "/mnt/hdd/reservoir=4T,1h"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "hybrid tiers sharing a directory",
+			modify: func(c *Config) {
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("/mnt/reservoir=200G,1h", "/mnt/reservoir=4T"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid object storage hybrid tier",
+			modify: func(c *Config) {
+				c.Cache.S3.Endpoint.Overwrite("http://localhost:9000")
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("/mnt/nvme/reservoir=200G,1h", "s3://reservoir/cold=10T"))
+			},
+			wantErr: false,
+		},
+		{
+			name: "object storage hybrid tier before a file tier",
+			modify: func(c *Config) {
+				c.Cache.S3.Endpoint.Overwrite("http://localhost:9000")
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("s3://reservoir=10T,1h", "/mnt/hdd/reservoir=4T"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "object storage hybrid tier without endpoint",
+			modify: func(c *Config) {
+				c.Cache.Hybrid.Tiers.Overwrite(stringlist.New("/mnt/nvme/reservoir=200G,1h", "s3://reservoir=10T"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "s3 cache without bucket",
+			modify: func(c *Config) {
+				c.Cache.Type.Overwrite(CacheTypeS3)
+				c.Cache.S3.Endpoint.Overwrite("http://localhost:9000")
+			},
+			wantErr: true,
+		},
+		{
+			name: "enabled memory snapshot without path",
+			modify: func(c *Config) {
+				c.Cache.Memory.Snapshot.Enabled.Overwrite(true)
+				c.Cache.Memory.Snapshot.Path.Overwrite("")
+			},
+			wantErr: true,
+		},
+		{
+			name: "memory snapshot without time budget",
+			modify: func(c *Config) {
+				c.Cache.Memory.Snapshot.Timeout.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "cluster with peers",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.Enabled.Overwrite(true)
+				c.Proxy.Cluster.Peers.Overwrite(stringlist.New("http://10.0.1.12:8080", "https://cache-2.internal"))
+				c.Proxy.Cluster.Secret.Overwrite("0123456789abcdef")
+			},
+			wantErr: false,
+		},
+		{
+			name: "cluster with invalid peer",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.Peers.Overwrite(stringlist.New("10.0.1.12:8080"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "enabled cluster with short secret",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.Enabled.Overwrite(true)
+				c.Proxy.Cluster.Secret.Overwrite("secret")
+			},
+			wantErr: true,
+		},
+		{
+			name: "shard mode with self",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.Enabled.Overwrite(true)
+				c.Proxy.Cluster.Mode.Overwrite(ClusterModeShard)
+				c.Proxy.Cluster.Self.Overwrite("http://10.0.1.11:8080")
+				c.Proxy.Cluster.Peers.Overwrite(stringlist.New("http://10.0.1.12:8080"))
+				c.Proxy.Cluster.Secret.Overwrite("0123456789abcdef")
+			},
+			wantErr: false,
+		},
+		{
+			name: "shard mode without self",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.Enabled.Overwrite(true)
+				c.Proxy.Cluster.Mode.Overwrite(ClusterModeShard)
+				c.Proxy.Cluster.Secret.Overwrite("0123456789abcdef")
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid cluster mode",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.Mode.Overwrite("mirror")
+			},
+			wantErr: true,
+		},
+		{
+			name: "cluster without owner timeout",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.OwnerTimeout.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "cluster without max failures",
+			modify: func(c *Config) {
+				c.Proxy.Cluster.MaxFailures.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid bandwidth limits",
+			modify: func(c *Config) {
+				c.Proxy.Bandwidth.UpstreamRate.Overwrite(bytesize.ByteSize(100 * bytesize.UnitM))
+				c.Proxy.Bandwidth.UpstreamHostRules.Overwrite(stringlist.New("*.debian.org=5M", "cdimage.ubuntu.com=0"))
+				c.Proxy.Bandwidth.DailyQuota.Overwrite(bytesize.ByteSize(10 * bytesize.UnitG))
+				c.Proxy.Bandwidth.QuotaAction.Overwrite(QuotaActionCacheOnly)
+			},
+			wantErr: false,
+		},
+		{
+			name: "rate rule with path prefix",
+			modify: func(c *Config) {
+				c.Proxy.Bandwidth.UpstreamHostRules.Overwrite(stringlist.New("*.debian.org/debian=5M"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "rate rule without rate",
+			modify: func(c *Config) {
+				c.Proxy.Bandwidth.UpstreamHostRules.Overwrite(stringlist.New("*.debian.org="))
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid quota action",
+			modify: func(c *Config) {
+				c.Proxy.Bandwidth.QuotaAction.Overwrite("block")
+			},
+			wantErr: true,
+		},
+		{
+			name: "throttle quota action without rate",
+			modify: func(c *Config) {
+				c.Proxy.Bandwidth.QuotaThrottleRate.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid upstream queue",
+			modify: func(c *Config) {
+				c.Proxy.UpstreamQueue.MaxPerHost.Overwrite(8)
+				c.Proxy.UpstreamQueue.HostRules.Overwrite(stringlist.New("*.debian.org=4", "cdimage.ubuntu.com=0"))
+				c.Proxy.UpstreamQueue.TimeoutAction.Overwrite(QueueTimeoutActionReject)
+			},
+			wantErr: false,
+		},
+		{
+			name: "concurrency rule with negative limit",
+			modify: func(c *Config) {
+				c.Proxy.UpstreamQueue.HostRules.Overwrite(stringlist.New("*.debian.org=-1"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "upstream queue without max wait",
+			modify: func(c *Config) {
+				c.Proxy.UpstreamQueue.MaxWait.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid queue timeout action",
+			modify: func(c *Config) {
+				c.Proxy.UpstreamQueue.TimeoutAction.Overwrite("wait")
+			},
+			wantErr: true,
+		},
+		{
+			name: "circuit breaker without max failures",
+			modify: func(c *Config) {
+				c.Proxy.CircuitBreaker.MaxFailures.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "circuit breaker without retry delay",
+			modify: func(c *Config) {
+				c.Proxy.CircuitBreaker.RetryAfter.Overwrite(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid client identity",
+			modify: func(c *Config) {
+				c.Proxy.Bandwidth.ClientIdentity.Overwrite("mac")
+			},
+			wantErr: true,
+		},
 		{
 			name: "invalid cache type",
 			modify: func(c *Config) {
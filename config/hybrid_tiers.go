@@ -0,0 +1,66 @@
+package config
+
+import (
+	"fmt"
+	"reservoir/utils/bytesize"
+	"strings"
+	"time"
+)
+
+// Prefix of the tier directories that refer to a bucket of the object storage configured in cache.s3.
+const objectStorageTierScheme = "s3://"
+
+// A tier of the hybrid cache below the memory tier, stored in a directory or in object storage.
+type HybridTier struct {
+	Dir         string // A directory, or "s3://<bucket>[/<prefix>]".
+	MaxSize     int64
+	DemoteAfter time.Duration // 0 means entries only move to the next tier when this one is full.
+}
+
+// Parses a tier written as "<dir>=<max size>[,<demote after>]", e.g. "/mnt/nvme/reservoir=200G,1h" or "s3://reservoir/cold=4T".
+func ParseHybridTier(tier string) (HybridTier, error) {
+	separator := strings.LastIndex(tier, "=")
+	if separator < 0 {
+		return HybridTier{}, fmt.Errorf("hybrid tier '%s' is missing '='", tier)
+	}
+
+	dir := strings.TrimSpace(tier[:separator])
+	if dir == "" || dir == objectStorageTierScheme {
+		return HybridTier{}, fmt.Errorf("hybrid tier '%s' is missing a directory", tier)
+	}
+	rawSize, rawDemoteAfter, hasDemoteAfter := strings.Cut(tier[separator+1:], ",")
+
+	size, err := bytesize.Parse(strings.TrimSpace(rawSize))
+	if err != nil {
+		return HybridTier{}, fmt.Errorf("hybrid tier '%s' has an invalid size: %w", tier, err)
+	}
+	if size.Bytes() <= 0 {
+		return HybridTier{}, fmt.Errorf("hybrid tier '%s' must have a size greater than 0", tier)
+	}
+
+	hybridTier := HybridTier{Dir: dir, MaxSize: size.Bytes()}
+	if hasDemoteAfter {
+		demoteAfter, err := time.ParseDuration(strings.TrimSpace(rawDemoteAfter))
+		if err != nil {
+			return HybridTier{}, fmt.Errorf("hybrid tier '%s' has an invalid demotion time: %w", tier, err)
+		}
+		if demoteAfter <= 0 {
+			return HybridTier{}, fmt.Errorf("hybrid tier '%s' must have a demotion time greater than 0", tier)
+		}
+		hybridTier.DemoteAfter = demoteAfter
+	}
+	return hybridTier, nil
+}
+
+// Returns the bucket and object name prefix of a tier stored in object storage.
+func (t HybridTier) ObjectStorage() (bucket string, prefix string, ok bool) {
+	location, ok := strings.CutPrefix(t.Dir, objectStorageTierScheme)
+	if !ok {
+		return "", "", false
+	}
+	bucket, prefix, _ = strings.Cut(location, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return bucket, prefix, true
+}
@@ -2,24 +2,126 @@ package config
 
 import (
 	"fmt"
+	"net/netip"
+	"net/url"
+	"reservoir/utils/bytesize"
 	"reservoir/utils/duration"
+	"reservoir/utils/stringlist"
+	"reservoir/utils/tinylfu"
 	"time"
 )
 
+const minClusterSecretLength = 16
+
 type CachePolicyConfig struct {
 	IgnoreCacheControl ConfigProp[bool]              `json:"ignore_cache_control"`  // If true, the proxy will ignore Cache-Control headers from the upstream response.
 	DefaultMaxAge      ConfigProp[duration.Duration] `json:"default_max_age"`       // The default cache max age to use if the upstream response does not specify a Cache-Control or Expires header.
 	ForceDefaultMaxAge ConfigProp[bool]              `json:"force_default_max_age"` // If true, always use the default cache max age.
 }
 
+type FollowRedirectsConfig struct {
+	Hosts       ConfigProp[stringlist.StringList] `json:"hosts"`        // Hosts whose redirects are followed by the proxy instead of being returned to the client. Supports "*.example.com" wildcards.
+	MaxHops     ConfigProp[int]                   `json:"max_hops"`     // The maximum number of redirects followed for a single request.
+	TargetHosts ConfigProp[stringlist.StringList] `json:"target_hosts"` // Hosts followed redirects may lead to. Supports "*.example.com" wildcards. If empty, any host but internal addresses is allowed.
+}
+
+type CompressionConfig struct {
+	Enabled      ConfigProp[bool]                  `json:"enabled"`       // If true, a single representation is stored per URL and transcoded to the encoding the client accepts.
+	CompressText ConfigProp[bool]                  `json:"compress_text"` // If true, compressible responses that upstream sent uncompressed are stored gzip compressed.
+	TextTypes    ConfigProp[stringlist.StringList] `json:"text_types"`    // Content types considered compressible. Supports "text/*" wildcards.
+}
+
+type AdmissionConfig struct {
+	MinRequests   ConfigProp[int]                   `json:"min_requests"`    // How often a URL has to be requested before its response is cached, at most 15. 1 caches on the first request.
+	MinObjectSize ConfigProp[bytesize.ByteSize]     `json:"min_object_size"` // Responses smaller than this aren't cached. 0 disables the limit.
+	MaxObjectSize ConfigProp[bytesize.ByteSize]     `json:"max_object_size"` // Responses larger than this aren't cached. 0 disables the limit.
+	SizeRules     ConfigProp[stringlist.StringList] `json:"size_rules"`      // Size limits replacing the global ones for matching URLs, e.g. "cdimage.ubuntu.com=-1G". See SizeRule.
+}
+
+type ClusterMode string
+
+var (
+	ClusterModeReplicate ClusterMode = "replicate"
+	ClusterModeShard     ClusterMode = "shard"
+)
+
+type ClusterConfig struct {
+	Enabled      ConfigProp[bool]                  `json:"enabled"`       // If true, cache misses are looked up on the peers before they are fetched from upstream.
+	Mode         ConfigProp[ClusterMode]           `json:"mode"`          // "replicate" asks every peer and caches what they have, "shard" forwards misses to the owner of the key on a hash ring.
+	Self         ConfigProp[string]                `json:"self"`          // Webserver URL of this instance as the other instances list it, places it on the hash ring.
+	Peers        ConfigProp[stringlist.StringList] `json:"peers"`         // Webserver URLs of the other instances, e.g. "http://10.0.1.12:8080", asked in this order.
+	Secret       ConfigProp[string]                `json:"secret"`        // Shared by all instances of the cluster, authenticates the lookups between them.
+	Timeout      ConfigProp[duration.Duration]     `json:"timeout"`       // How long to wait for a peer to start answering. Peers are asked at the same time.
+	OwnerTimeout ConfigProp[duration.Duration]     `json:"owner_timeout"` // How long to wait in shard mode for the owner of a key to start answering a forwarded miss, which may include its upstream request.
+	MaxFailures  ConfigProp[int]                   `json:"max_failures"`  // Failed lookups in a row after which a peer is considered down.
+	RetryAfter   ConfigProp[duration.Duration]     `json:"retry_after"`   // How long a peer that is down is skipped before it is asked again.
+}
+
+type QuotaAction string
+
+var (
+	QuotaActionThrottle  QuotaAction = "throttle"
+	QuotaActionReject    QuotaAction = "reject"
+	QuotaActionCacheOnly QuotaAction = "cache_only"
+)
+
+type ClientIdentity string
+
+var (
+	ClientIdentityIP   ClientIdentity = "ip"
+	ClientIdentityUser ClientIdentity = "user"
+)
+
+type BandwidthConfig struct {
+	UpstreamRate      ConfigProp[bytesize.ByteSize]     `json:"upstream_rate"`       // Bytes per second fetched from upstream in total. 0 disables the limit.
+	UpstreamHostRate  ConfigProp[bytesize.ByteSize]     `json:"upstream_host_rate"`  // Bytes per second fetched from each upstream host. 0 disables the limit.
+	UpstreamHostRules ConfigProp[stringlist.StringList] `json:"upstream_host_rules"` // Rates replacing upstream_host_rate for matching hosts, e.g. "*.debian.org=5M". See RateRule.
+	ClientRate        ConfigProp[bytesize.ByteSize]     `json:"client_rate"`         // Bytes per second sent to each client. 0 disables the limit.
+	ClientIdentity    ConfigProp[ClientIdentity]        `json:"client_identity"`     // "ip" tells clients apart by their IP, "user" by the user name of their Proxy-Authorization header.
+	DailyQuota        ConfigProp[bytesize.ByteSize]     `json:"daily_quota"`         // Bytes each client may receive per UTC day. 0 disables the quota.
+	MonthlyQuota      ConfigProp[bytesize.ByteSize]     `json:"monthly_quota"`       // Bytes each client may receive per UTC month. 0 disables the quota.
+	QuotaAction       ConfigProp[QuotaAction]           `json:"quota_action"`        // What happens to clients over their quota: "throttle", "reject" with 429, or "cache_only".
+	QuotaThrottleRate ConfigProp[bytesize.ByteSize]     `json:"quota_throttle_rate"` // Bytes per second sent to clients over their quota with the "throttle" action.
+}
+
+type QueueTimeoutAction string
+
+var (
+	QueueTimeoutActionServeStale QueueTimeoutAction = "serve_stale"
+	QueueTimeoutActionReject     QueueTimeoutAction = "reject"
+)
+
+type UpstreamQueueConfig struct {
+	MaxPerHost    ConfigProp[int]                   `json:"max_per_host"`   // Concurrent requests to each upstream host, more wait in a queue. 0 disables the limit.
+	HostRules     ConfigProp[stringlist.StringList] `json:"host_rules"`     // Limits replacing max_per_host for matching hosts, e.g. "*.debian.org=4". See ConcurrencyRule.
+	MaxWait       ConfigProp[duration.Duration]     `json:"max_wait"`       // How long a request waits in the queue before giving up.
+	TimeoutAction ConfigProp[QueueTimeoutAction]    `json:"timeout_action"` // "serve_stale" serves a stale entry if there is one, "reject" always answers 503.
+}
+
+type CircuitBreakerConfig struct {
+	Enabled     ConfigProp[bool]              `json:"enabled"`      // If true, upstream hosts that keep failing are not contacted for a while.
+	MaxFailures ConfigProp[int]               `json:"max_failures"` // Failed requests in a row after which the breaker of a host opens.
+	RetryAfter  ConfigProp[duration.Duration] `json:"retry_after"`  // How long a breaker stays open before a single request probes the host again.
+}
+
 type ProxyConfig struct {
-	Listen               ConfigProp[string] `json:"listen"`                 // The address and port that the proxy will listen on.
-	CaCert               ConfigProp[string] `json:"ca_cert"`                // Path to CA certificate file.
-	CaKey                ConfigProp[string] `json:"ca_key"`                 // Path to CA private key file.
-	UpstreamDefaultHttps ConfigProp[bool]   `json:"upstream_default_https"` // If true, the proxy will always send HTTPS instead of HTTP to the upstream server.
-	RetryOnRange416      ConfigProp[bool]   `json:"retry_on_range_416"`     // If true, the proxy will retry a request without the Range header if the upstream responds with a 416 Range Not Satisfiable.
-	RetryOnInvalidRange  ConfigProp[bool]   `json:"retry_on_invalid_range"` // If true, the proxy will retry a request without the Range header if the client sends an invalid Range header. (not recommended)
-	CachePolicy          CachePolicyConfig  `json:"cache_policy"`
+	Listen               ConfigProp[string]                `json:"listen"`                 // The address and port that the proxy will listen on.
+	CaCert               ConfigProp[string]                `json:"ca_cert"`                // Path to CA certificate file.
+	CaKey                ConfigProp[string]                `json:"ca_key"`                 // Path to CA private key file.
+	UpstreamDefaultHttps ConfigProp[bool]                  `json:"upstream_default_https"` // If true, the proxy will always send HTTPS instead of HTTP to the upstream server.
+	RetryOnRange416      ConfigProp[bool]                  `json:"retry_on_range_416"`     // If true, the proxy will retry a request without the Range header if the upstream responds with a 416 Range Not Satisfiable.
+	RetryOnInvalidRange  ConfigProp[bool]                  `json:"retry_on_invalid_range"` // If true, the proxy will retry a request without the Range header if the client sends an invalid Range header. (not recommended)
+	VerifyIntegrity      ConfigProp[bool]                  `json:"verify_integrity"`       // If true, responses are only cached if their length and Content-MD5, Digest or Repr-Digest headers match the received body.
+	PurgeAllowedClients  ConfigProp[stringlist.StringList] `json:"purge_allowed_clients"`  // Client IPs or CIDR ranges allowed to send PURGE requests to the proxy. Empty disables PURGE.
+	TagRules             ConfigProp[stringlist.StringList] `json:"tag_rules"`              // Rules tagging cached entries by URL, e.g. "*.ubuntu.com/dists/noble=noble". See TagRule.
+	CachePolicy          CachePolicyConfig                 `json:"cache_policy"`
+	FollowRedirects      FollowRedirectsConfig             `json:"follow_redirects"`
+	Compression          CompressionConfig                 `json:"compression"`
+	Admission            AdmissionConfig                   `json:"admission"`
+	Cluster              ClusterConfig                     `json:"cluster"`
+	Bandwidth            BandwidthConfig                   `json:"bandwidth"`
+	UpstreamQueue        UpstreamQueueConfig               `json:"upstream_queue"`
+	CircuitBreaker       CircuitBreakerConfig              `json:"circuit_breaker"`
 }
 
 func (c *ProxyConfig) setRestartNeededProps() {
@@ -38,9 +140,145 @@ func (c *ProxyConfig) verify() error {
 	if c.CaKey.Read() == "" {
 		return fmt.Errorf("proxy.ca_key cannot be empty")
 	}
+	for _, client := range c.PurgeAllowedClients.Read().Values() {
+		if _, err := netip.ParsePrefix(client); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(client); err != nil {
+			return fmt.Errorf("proxy.purge_allowed_clients contains invalid IP or CIDR range '%s'", client)
+		}
+	}
+	for _, rule := range c.TagRules.Read().Values() {
+		if _, err := ParseTagRule(rule); err != nil {
+			return fmt.Errorf("proxy.tag_rules: %w", err)
+		}
+	}
+	if minRequests := c.Admission.MinRequests.Read(); minRequests < 1 || minRequests > tinylfu.MaxCount {
+		return fmt.Errorf("proxy.admission.min_requests must be between 1 and %d", tinylfu.MaxCount)
+	}
+	if c.Admission.MinObjectSize.Read() < 0 || c.Admission.MaxObjectSize.Read() < 0 {
+		return fmt.Errorf("proxy.admission object sizes can't be negative")
+	}
+	if maxSize := c.Admission.MaxObjectSize.Read(); maxSize > 0 && c.Admission.MinObjectSize.Read() > maxSize {
+		return fmt.Errorf("proxy.admission.min_object_size can't be above proxy.admission.max_object_size")
+	}
+	for _, rule := range c.Admission.SizeRules.Read().Values() {
+		if _, err := ParseSizeRule(rule); err != nil {
+			return fmt.Errorf("proxy.admission.size_rules: %w", err)
+		}
+	}
+	if c.FollowRedirects.MaxHops.Read() <= 0 {
+		return fmt.Errorf("proxy.follow_redirects.max_hops must be greater than 0")
+	}
+	if err := c.Cluster.verify(); err != nil {
+		return err
+	}
+	if err := c.Bandwidth.verify(); err != nil {
+		return err
+	}
+	if err := c.UpstreamQueue.verify(); err != nil {
+		return err
+	}
+	return c.CircuitBreaker.verify()
+}
+
+func (c *ClusterConfig) verify() error {
+	for _, peer := range c.Peers.Read().Values() {
+		if !isPeerURL(peer) {
+			return fmt.Errorf("proxy.cluster.peers contains invalid URL '%s', expected e.g. 'http://10.0.1.12:8080'", peer)
+		}
+	}
+	switch c.Mode.Read() {
+	case ClusterModeReplicate:
+	case ClusterModeShard:
+		if c.Enabled.Read() && !isPeerURL(c.Self.Read()) {
+			return fmt.Errorf("proxy.cluster.self must be the URL the peers reach this instance at in shard mode, e.g. 'http://10.0.1.11:8080'")
+		}
+	default:
+		return fmt.Errorf("proxy.cluster.mode must be one of 'replicate' or 'shard'")
+	}
+	if c.Enabled.Read() && len(c.Secret.Read()) < minClusterSecretLength {
+		return fmt.Errorf("proxy.cluster.secret must be at least %d characters long", minClusterSecretLength)
+	}
+	if c.Timeout.Read().Cast() <= 0 {
+		return fmt.Errorf("proxy.cluster.timeout must be greater than 0")
+	}
+	if c.OwnerTimeout.Read().Cast() <= 0 {
+		return fmt.Errorf("proxy.cluster.owner_timeout must be greater than 0")
+	}
+	if c.MaxFailures.Read() < 1 {
+		return fmt.Errorf("proxy.cluster.max_failures must be at least 1")
+	}
+	if c.RetryAfter.Read().Cast() <= 0 {
+		return fmt.Errorf("proxy.cluster.retry_after must be greater than 0")
+	}
 	return nil
 }
 
+func (c *BandwidthConfig) verify() error {
+	if c.UpstreamRate.Read() < 0 || c.UpstreamHostRate.Read() < 0 || c.ClientRate.Read() < 0 {
+		return fmt.Errorf("proxy.bandwidth rates can't be negative")
+	}
+	if c.DailyQuota.Read() < 0 || c.MonthlyQuota.Read() < 0 {
+		return fmt.Errorf("proxy.bandwidth quotas can't be negative")
+	}
+	for _, rule := range c.UpstreamHostRules.Read().Values() {
+		if _, err := ParseRateRule(rule); err != nil {
+			return fmt.Errorf("proxy.bandwidth.upstream_host_rules: %w", err)
+		}
+	}
+	switch c.ClientIdentity.Read() {
+	case ClientIdentityIP, ClientIdentityUser:
+	default:
+		return fmt.Errorf("proxy.bandwidth.client_identity must be one of 'ip' or 'user'")
+	}
+	switch c.QuotaAction.Read() {
+	case QuotaActionThrottle:
+		if c.QuotaThrottleRate.Read() <= 0 {
+			return fmt.Errorf("proxy.bandwidth.quota_throttle_rate must be greater than 0 with the 'throttle' quota action")
+		}
+	case QuotaActionReject, QuotaActionCacheOnly:
+	default:
+		return fmt.Errorf("proxy.bandwidth.quota_action must be one of 'throttle', 'reject' or 'cache_only'")
+	}
+	return nil
+}
+
+func (c *UpstreamQueueConfig) verify() error {
+	if c.MaxPerHost.Read() < 0 {
+		return fmt.Errorf("proxy.upstream_queue.max_per_host can't be negative")
+	}
+	for _, rule := range c.HostRules.Read().Values() {
+		if _, err := ParseConcurrencyRule(rule); err != nil {
+			return fmt.Errorf("proxy.upstream_queue.host_rules: %w", err)
+		}
+	}
+	if c.MaxWait.Read().Cast() <= 0 {
+		return fmt.Errorf("proxy.upstream_queue.max_wait must be greater than 0")
+	}
+	switch c.TimeoutAction.Read() {
+	case QueueTimeoutActionServeStale, QueueTimeoutActionReject:
+	default:
+		return fmt.Errorf("proxy.upstream_queue.timeout_action must be one of 'serve_stale' or 'reject'")
+	}
+	return nil
+}
+
+func (c *CircuitBreakerConfig) verify() error {
+	if c.MaxFailures.Read() < 1 {
+		return fmt.Errorf("proxy.circuit_breaker.max_failures must be at least 1")
+	}
+	if c.RetryAfter.Read().Cast() <= 0 {
+		return fmt.Errorf("proxy.circuit_breaker.retry_after must be greater than 0")
+	}
+	return nil
+}
+
+func isPeerURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
 func defaultProxyConfig() ProxyConfig {
 	return ProxyConfig{
 		Listen:               NewConfigProp(":9999"),
@@ -49,10 +287,68 @@ func defaultProxyConfig() ProxyConfig {
 		UpstreamDefaultHttps: NewConfigProp(true),
 		RetryOnRange416:      NewConfigProp(true),
 		RetryOnInvalidRange:  NewConfigProp(false),
+		VerifyIntegrity:      NewConfigProp(true),
+		PurgeAllowedClients:  NewConfigProp(stringlist.New("127.0.0.0/8", "::1")),
+		TagRules:             NewConfigProp(stringlist.New()),
 		CachePolicy: CachePolicyConfig{
 			IgnoreCacheControl: NewConfigProp(true),
 			DefaultMaxAge:      NewConfigProp(duration.Duration(15 * time.Minute)),
 			ForceDefaultMaxAge: NewConfigProp(true),
 		},
+		FollowRedirects: FollowRedirectsConfig{
+			Hosts:       NewConfigProp(stringlist.New()),
+			MaxHops:     NewConfigProp(5),
+			TargetHosts: NewConfigProp(stringlist.New()),
+		},
+		Compression: CompressionConfig{
+			Enabled:      NewConfigProp(false),
+			CompressText: NewConfigProp(false),
+			TextTypes: NewConfigProp(stringlist.New(
+				"text/*",
+				"application/json",
+				"application/xml",
+				"application/javascript",
+				"application/x-yaml",
+			)),
+		},
+		Admission: AdmissionConfig{
+			MinRequests:   NewConfigProp(1),
+			MinObjectSize: NewConfigProp(bytesize.ByteSize(0)),
+			MaxObjectSize: NewConfigProp(bytesize.ByteSize(0)),
+			SizeRules:     NewConfigProp(stringlist.New()),
+		},
+		Cluster: ClusterConfig{
+			Enabled:      NewConfigProp(false),
+			Mode:         NewConfigProp(ClusterModeReplicate),
+			Self:         NewConfigProp(""),
+			Peers:        NewConfigProp(stringlist.New()),
+			Secret:       NewConfigProp(""),
+			Timeout:      NewConfigProp(duration.Duration(2 * time.Second)),
+			OwnerTimeout: NewConfigProp(duration.Duration(45 * time.Second)),
+			MaxFailures:  NewConfigProp(3),
+			RetryAfter:   NewConfigProp(duration.Duration(30 * time.Second)),
+		},
+		Bandwidth: BandwidthConfig{
+			UpstreamRate:      NewConfigProp(bytesize.ByteSize(0)),
+			UpstreamHostRate:  NewConfigProp(bytesize.ByteSize(0)),
+			UpstreamHostRules: NewConfigProp(stringlist.New()),
+			ClientRate:        NewConfigProp(bytesize.ByteSize(0)),
+			ClientIdentity:    NewConfigProp(ClientIdentityIP),
+			DailyQuota:        NewConfigProp(bytesize.ByteSize(0)),
+			MonthlyQuota:      NewConfigProp(bytesize.ByteSize(0)),
+			QuotaAction:       NewConfigProp(QuotaActionThrottle),
+			QuotaThrottleRate: NewConfigProp(bytesize.ByteSize(64 * bytesize.UnitK)),
+		},
+		UpstreamQueue: UpstreamQueueConfig{
+			MaxPerHost:    NewConfigProp(0),
+			HostRules:     NewConfigProp(stringlist.New()),
+			MaxWait:       NewConfigProp(duration.Duration(30 * time.Second)),
+			TimeoutAction: NewConfigProp(QueueTimeoutActionServeStale),
+		},
+		CircuitBreaker: CircuitBreakerConfig{
+			Enabled:     NewConfigProp(false),
+			MaxFailures: NewConfigProp(5),
+			RetryAfter:  NewConfigProp(duration.Duration(30 * time.Second)),
+		},
 	}
 }
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"reservoir/utils/bytesize"
+	"strings"
+)
+
+// Limits the upstream throughput of each host matching the host pattern.
+type RateRule struct {
+	Host string // Supports "*.example.com" wildcards.
+	Rate int64  // Bytes per second, 0 means no limit.
+}
+
+// Parses a rule written as "<host>=<bytes per second>", e.g. "*.debian.org=5M".
+// Rates apply per host, so the rule can't have a path prefix.
+func ParseRateRule(rule string) (RateRule, error) {
+	host, pathPrefix, rawRate, err := splitURLRule("rate", rule)
+	if err != nil {
+		return RateRule{}, err
+	}
+	if pathPrefix != "/" {
+		return RateRule{}, fmt.Errorf("rate rule '%s' can't have a path prefix", rule)
+	}
+
+	rate, err := bytesize.Parse(strings.TrimSpace(rawRate))
+	if err != nil {
+		return RateRule{}, fmt.Errorf("rate rule '%s' has an invalid rate: %w", rule, err)
+	}
+	return RateRule{Host: host, Rate: rate.Bytes()}, nil
+}
@@ -0,0 +1,49 @@
+package config
+
+import (
+	"fmt"
+	"reservoir/utils/bytesize"
+	"strings"
+)
+
+// Limits the size of the responses cached for URLs matching the host and path prefix.
+type SizeRule struct {
+	Host       string // Supports "*.example.com" wildcards.
+	PathPrefix string
+	MinSize    int64 // 0 means no lower limit.
+	MaxSize    int64 // 0 means no upper limit.
+}
+
+// Parses a rule written as "<host>[/<path prefix>]=[<min size>]-[<max size>]", e.g. "cdimage.ubuntu.com=-1G".
+func ParseSizeRule(rule string) (SizeRule, error) {
+	host, pathPrefix, limits, err := splitURLRule("size", rule)
+	if err != nil {
+		return SizeRule{}, err
+	}
+
+	rawMin, rawMax, ok := strings.Cut(strings.TrimSpace(limits), "-")
+	if !ok {
+		return SizeRule{}, fmt.Errorf("size rule '%s' must have the form <min>-<max>", rule)
+	}
+	sizeRule := SizeRule{Host: host, PathPrefix: pathPrefix}
+	for _, limit := range []struct {
+		raw    string
+		target *int64
+	}{{rawMin, &sizeRule.MinSize}, {rawMax, &sizeRule.MaxSize}} {
+		if limit.raw = strings.TrimSpace(limit.raw); limit.raw == "" {
+			continue
+		}
+		size, err := bytesize.Parse(limit.raw)
+		if err != nil {
+			return SizeRule{}, fmt.Errorf("size rule '%s' has an invalid size: %w", rule, err)
+		}
+		*limit.target = size.Bytes()
+	}
+	if sizeRule.MinSize == 0 && sizeRule.MaxSize == 0 {
+		return SizeRule{}, fmt.Errorf("size rule '%s' has no limits", rule)
+	}
+	if sizeRule.MaxSize > 0 && sizeRule.MinSize > sizeRule.MaxSize {
+		return SizeRule{}, fmt.Errorf("size rule '%s' has a minimum above its maximum", rule)
+	}
+	return sizeRule, nil
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Tags the cached entries whose URL matches the host and path prefix.
+type TagRule struct {
+	Host       string // Supports "*.example.com" wildcards.
+	PathPrefix string
+	Tags       []string
+}
+
+// Parses a rule written as "<host>[/<path prefix>]=<tag>[,<tag>...]", e.g. "*.ubuntu.com/dists/noble=noble".
+func ParseTagRule(rule string) (TagRule, error) {
+	host, pathPrefix, rawTags, err := splitURLRule("tag", rule)
+	if err != nil {
+		return TagRule{}, err
+	}
+
+	tags := make([]string, 0)
+	for tag := range strings.SplitSeq(rawTags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	if len(tags) == 0 {
+		return TagRule{}, fmt.Errorf("tag rule '%s' has no tags", rule)
+	}
+
+	return TagRule{Host: host, PathPrefix: pathPrefix, Tags: tags}, nil
+}
+
+// Splits a rule written as "<host>[/<path prefix>]=<value>" into its parts. The path prefix keeps its leading slash.
+func splitURLRule(kind string, rule string) (host string, pathPrefix string, value string, err error) {
+	pattern, value, ok := strings.Cut(rule, "=")
+	if !ok {
+		return "", "", "", fmt.Errorf("%s rule '%s' is missing '='", kind, rule)
+	}
+
+	host, pathPrefix, _ = strings.Cut(strings.TrimSpace(pattern), "/")
+	if host == "" {
+		return "", "", "", fmt.Errorf("%s rule '%s' is missing a host", kind, rule)
+	}
+	return host, "/" + pathPrefix, value, nil
+}
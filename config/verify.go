@@ -5,6 +5,11 @@ import (
 	"reflect"
 )
 
+// Checks the configuration again after properties were overwritten in code, which skips the checks of loading and updating it.
+func (c *Config) Verify() error {
+	return c.verify()
+}
+
 func (c *Config) verify() error {
 	if err := checkIsSetRecursive(reflect.ValueOf(c)); err != nil {
 		return err
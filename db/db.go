@@ -6,6 +6,7 @@ import (
 	"embed"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"time"
 
@@ -112,8 +113,14 @@ func (tx *Tx) ExecResult(query string, args ...any) (sql.Result, error) {
 }
 
 func (db *Database) Migrate() error {
+	return db.MigrateFS(migrationFs)
+}
+
+// Applies the .sql files in the migrations directory of migrations that weren't applied yet, in file name order.
+// Databases other than the main one, e.g. the file cache's metadata index, embed their own migrations.
+func (db *Database) MigrateFS(migrations fs.FS) error {
 	slog.Debug("Migrating database...")
-	files, err := migrationFs.ReadDir("migrations")
+	files, err := fs.ReadDir(migrations, "migrations")
 	if err != nil {
 		slog.Error("Failed to read database migrations", "error", err)
 		return fmt.Errorf("%w: %v", ErrMigrationFailed, err)
@@ -135,7 +142,7 @@ func (db *Database) Migrate() error {
 			continue
 		}
 
-		if err := applyMigration(tx, file.Name()); err != nil {
+		if err := applyMigration(tx, migrations, file.Name()); err != nil {
 			_ = tx.Rollback()
 			return err
 		}
@@ -158,7 +165,7 @@ func ensureSchemaMigrations(tx *sql.Tx) error {
 	return nil
 }
 
-func applyMigration(tx *sql.Tx, filename string) error {
+func applyMigration(tx *sql.Tx, migrations fs.FS, filename string) error {
 	applied, err := migrationApplied(tx, filename)
 	if err != nil {
 		return err
@@ -168,7 +175,7 @@ func applyMigration(tx *sql.Tx, filename string) error {
 		return nil
 	}
 
-	migration, err := migrationFs.ReadFile("migrations/" + filename)
+	migration, err := fs.ReadFile(migrations, "migrations/"+filename)
 	if err != nil {
 		slog.Error("Failed to read migration", "file", filename, "error", err)
 		return fmt.Errorf("%w: %v", ErrMigrationFailed, err)
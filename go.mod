@@ -4,7 +4,9 @@ go 1.26.2
 
 require (
 	github.com/DeRuina/timberjack v1.4.2
+	github.com/andybalholm/brotli v1.2.0
 	github.com/jmoiron/sqlx v1.4.0
+	github.com/klauspost/compress v1.18.6
 	github.com/shirou/gopsutil/v4 v4.26.4
 	golang.org/x/crypto v0.50.0
 	golang.org/x/net v0.53.0
@@ -15,7 +17,6 @@ require (
 require (
 	dario.cat/mergo v1.0.2 // indirect
 	github.com/air-verse/air v1.65.1 // indirect
-	github.com/andybalholm/brotli v1.2.0 // indirect
 	github.com/bep/godartsass/v2 v2.5.0 // indirect
 	github.com/bep/golibsass v1.2.0 // indirect
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -27,7 +28,6 @@ require (
 	github.com/gohugoio/hugo v0.149.1 // indirect
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/joho/godotenv v1.5.1 // indirect
-	github.com/klauspost/compress v1.18.6 // indirect
 	github.com/lufia/plan9stats v0.0.0-20260330125221-c963978e514e // indirect
 	github.com/mattn/go-colorable v0.1.14 // indirect
 	github.com/mattn/go-isatty v0.0.22 // indirect
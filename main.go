@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log/slog"
+	"os"
 	"os/signal"
 	"reservoir/config"
 	"reservoir/logging"
@@ -16,6 +18,14 @@ func main() {
 		panic(err)
 	}
 
+	if ran, err := runCommand(cfg, os.Args[1:]); ran {
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	config.OverrideFromFlags(cfg)
 	logging.Init(cfg)
 
@@ -8,6 +8,7 @@ type CacheStorageMetrics struct {
 	Bytes          int64  `json:"bytes"`
 	MaxBytes       int64  `json:"max_bytes"`
 	MemoryCapBytes *int64 `json:"memory_cap_bytes,omitempty"`
+	DedupBytes     int64  `json:"dedup_bytes"`
 }
 
 type cacheMetrics struct {
@@ -18,13 +19,20 @@ type cacheMetrics struct {
 	CacheRequestMisses        atomics.Int64                      `json:"cache_request_misses"`
 	CacheRequestRevalidations atomics.Int64                      `json:"cache_request_revalidations"`
 	CacheRequestStales        atomics.Int64                      `json:"cache_request_stales"`
+	CacheRequestPeerHits      atomics.Int64                      `json:"cache_request_peer_hits"`
 	CacheEntries              atomics.Int64                      `json:"cache_entries"`
 	BytesCached               atomics.Int64                      `json:"bytes_cached"`
 	CleanupRuns               atomics.Int64                      `json:"cleanup_runs"`
 	BytesCleaned              atomics.Int64                      `json:"bytes_cleaned"`
 	CacheEvictions            atomics.Int64                      `json:"cache_evictions"`
-	CacheHitLatency           atomics.Int64                      `json:"cache_hit_latency"`  // In nanoseconds
-	CacheMissLatency          atomics.Int64                      `json:"cache_miss_latency"` // In nanoseconds
+	IntegrityFailures         atomics.Int64                      `json:"integrity_failures"`        // Upstream responses discarded because their length or digest didn't match
+	PurgedEntries             atomics.Int64                      `json:"purged_entries"`            // Entries removed by targeted purges
+	SoftPurgedEntries         atomics.Int64                      `json:"soft_purged_entries"`       // Entries marked as stale by soft purges
+	AdmissionsAccepted        atomics.Int64                      `json:"admissions_accepted"`       // Cacheable responses let into the cache by the admission policy
+	AdmissionsRejectedCount   atomics.Int64                      `json:"admissions_rejected_count"` // Cacheable responses not cached because their URL wasn't requested often enough
+	AdmissionsRejectedSize    atomics.Int64                      `json:"admissions_rejected_size"`  // Cacheable responses not cached because of the object size limits
+	CacheHitLatency           atomics.Int64                      `json:"cache_hit_latency"`         // In nanoseconds
+	CacheMissLatency          atomics.Int64                      `json:"cache_miss_latency"`        // In nanoseconds
 	Storage                   atomics.Value[CacheStorageMetrics] `json:"storage"`
 }
 
@@ -37,11 +45,18 @@ func NewCacheMetrics() cacheMetrics {
 		CacheRequestMisses:        atomics.NewInt64(0),
 		CacheRequestRevalidations: atomics.NewInt64(0),
 		CacheRequestStales:        atomics.NewInt64(0),
+		CacheRequestPeerHits:      atomics.NewInt64(0),
 		CacheEntries:              atomics.NewInt64(0),
 		BytesCached:               atomics.NewInt64(0),
 		CleanupRuns:               atomics.NewInt64(0),
 		BytesCleaned:              atomics.NewInt64(0),
 		CacheEvictions:            atomics.NewInt64(0),
+		IntegrityFailures:         atomics.NewInt64(0),
+		PurgedEntries:             atomics.NewInt64(0),
+		SoftPurgedEntries:         atomics.NewInt64(0),
+		AdmissionsAccepted:        atomics.NewInt64(0),
+		AdmissionsRejectedCount:   atomics.NewInt64(0),
+		AdmissionsRejectedSize:    atomics.NewInt64(0),
 		CacheHitLatency:           atomics.NewInt64(0),
 		CacheMissLatency:          atomics.NewInt64(0),
 		Storage:                   atomics.NewValue(CacheStorageMetrics{}),
@@ -12,6 +12,9 @@ type requestMetrics struct {
 	ClientRequestLatency        atomics.Int64 `json:"client_request_latency"`   // ns, full proxy request duration
 	ClientResponseLatency       atomics.Int64 `json:"client_response_latency"`  // ns, response write to client
 	UpstreamRequestLatency      atomics.Int64 `json:"upstream_request_latency"` // ns, upstream fetch duration
+	UpstreamRedirectsFollowed   atomics.Int64 `json:"upstream_redirects_followed"`
+	TranscodedResponses         atomics.Int64 `json:"transcoded_responses"`
+	PrefetchRequests            atomics.Int64 `json:"prefetch_requests"`
 	CoalescedRequests           atomics.Int64 `json:"coalesced_requests"`
 	NonCoalescedRequests        atomics.Int64 `json:"non_coalesced_requests"`
 	CoalescedCacheHits          atomics.Int64 `json:"coalesced_cache_hits"`
@@ -20,6 +23,28 @@ type requestMetrics struct {
 	StatusOKResponses           atomics.Int64 `json:"status_ok_responses"`
 	StatusClientErrorResponses  atomics.Int64 `json:"status_client_error_responses"`
 	StatusServerErrorResponses  atomics.Int64 `json:"status_server_error_responses"`
+	PeerRequests                atomics.Int64 `json:"peer_requests"`
+	PeerHits                    atomics.Int64 `json:"peer_hits"`
+	PeerErrors                  atomics.Int64 `json:"peer_errors"`
+	PeerRequestLatency          atomics.Int64 `json:"peer_request_latency"` // ns, until a peer starts answering
+	BytesFetchedFromPeers       atomics.Int64 `json:"bytes_fetched_from_peers"`
+	PeerRequestsServed          atomics.Int64 `json:"peer_requests_served"`
+	PeersDown                   atomics.Int64 `json:"peers_down"`
+	ForwardedMisses             atomics.Int64 `json:"forwarded_misses"`        // Misses sent to the owner of the key in shard mode.
+	ForwardedMissesServed       atomics.Int64 `json:"forwarded_misses_served"` // Misses of other instances fetched as the owner of the key.
+	ClusterRingMembers          atomics.Int64 `json:"cluster_ring_members"`
+	UpstreamThrottleWait        atomics.Int64 `json:"upstream_throttle_wait"` // ns, upstream reads held back by the bandwidth limits
+	ClientThrottleWait          atomics.Int64 `json:"client_throttle_wait"`   // ns, client writes held back by the bandwidth limits
+	QuotaExceededRequests       atomics.Int64 `json:"quota_exceeded_requests"`
+	QuotaRejectedRequests       atomics.Int64 `json:"quota_rejected_requests"`
+	QuotaCacheOnlyMisses        atomics.Int64 `json:"quota_cache_only_misses"`
+	UpstreamQueuedRequests      atomics.Int64 `json:"upstream_queued_requests"` // Upstream requests that had to wait for a free slot of their host.
+	UpstreamQueueTime           atomics.Int64 `json:"upstream_queue_time"`      // ns, waited for a free slot
+	UpstreamQueueTimeouts       atomics.Int64 `json:"upstream_queue_timeouts"`
+	UpstreamQueueLength         atomics.Int64 `json:"upstream_queue_length"` // Upstream requests currently waiting.
+	CircuitBreakersOpen         atomics.Int64 `json:"circuit_breakers_open"` // Upstream hosts currently not contacted, including those being probed.
+	CircuitBreakerTrips         atomics.Int64 `json:"circuit_breaker_trips"`
+	CircuitBreakerRejections    atomics.Int64 `json:"circuit_breaker_rejections"` // Upstream requests not sent because the breaker of their host was open.
 }
 
 func NewRequestMetrics() requestMetrics {
@@ -33,6 +58,9 @@ func NewRequestMetrics() requestMetrics {
 		ClientRequestLatency:        atomics.NewInt64(0),
 		ClientResponseLatency:       atomics.NewInt64(0),
 		UpstreamRequestLatency:      atomics.NewInt64(0),
+		UpstreamRedirectsFollowed:   atomics.NewInt64(0),
+		TranscodedResponses:         atomics.NewInt64(0),
+		PrefetchRequests:            atomics.NewInt64(0),
 		CoalescedRequests:           atomics.NewInt64(0),
 		NonCoalescedRequests:        atomics.NewInt64(0),
 		CoalescedCacheHits:          atomics.NewInt64(0),
@@ -41,5 +69,27 @@ func NewRequestMetrics() requestMetrics {
 		StatusOKResponses:           atomics.NewInt64(0),
 		StatusClientErrorResponses:  atomics.NewInt64(0),
 		StatusServerErrorResponses:  atomics.NewInt64(0),
+		PeerRequests:                atomics.NewInt64(0),
+		PeerHits:                    atomics.NewInt64(0),
+		PeerErrors:                  atomics.NewInt64(0),
+		PeerRequestLatency:          atomics.NewInt64(0),
+		BytesFetchedFromPeers:       atomics.NewInt64(0),
+		PeerRequestsServed:          atomics.NewInt64(0),
+		PeersDown:                   atomics.NewInt64(0),
+		ForwardedMisses:             atomics.NewInt64(0),
+		ForwardedMissesServed:       atomics.NewInt64(0),
+		ClusterRingMembers:          atomics.NewInt64(0),
+		UpstreamThrottleWait:        atomics.NewInt64(0),
+		ClientThrottleWait:          atomics.NewInt64(0),
+		QuotaExceededRequests:       atomics.NewInt64(0),
+		QuotaRejectedRequests:       atomics.NewInt64(0),
+		QuotaCacheOnlyMisses:        atomics.NewInt64(0),
+		UpstreamQueuedRequests:      atomics.NewInt64(0),
+		UpstreamQueueTime:           atomics.NewInt64(0),
+		UpstreamQueueTimeouts:       atomics.NewInt64(0),
+		UpstreamQueueLength:         atomics.NewInt64(0),
+		CircuitBreakersOpen:         atomics.NewInt64(0),
+		CircuitBreakerTrips:         atomics.NewInt64(0),
+		CircuitBreakerRejections:    atomics.NewInt64(0),
 	}
 }
@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"hash/maphash"
+	"log/slog"
+	"net/url"
+	"reservoir/cache"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/hostmatch"
+	"reservoir/utils/tinylfu"
+	"strings"
+)
+
+// Counters per row of the request frequency sketch, 256 KiB in total.
+const admissionSketchWidth = 1 << 16
+
+type admissionBypassKey struct{}
+
+// Returned while handling a cacheable response the admission policy rejected. The response body is still unread,
+// so it can be passed through to the client instead of being fetched again.
+var errNotAdmitted = errors.New("response not admitted into the cache")
+
+// Decides which cacheable responses are stored. A URL has to be requested often enough, counted in a
+// TinyLFU sketch, and its response has to fit the object size limits. Everything else is only passed
+// through to the client, so one-off downloads don't evict the working set.
+type admissionPolicy struct {
+	cfg    *config.Config
+	sketch *tinylfu.Sketch
+	seed   maphash.Seed
+}
+
+func newAdmissionPolicy(cfg *config.Config) *admissionPolicy {
+	return &admissionPolicy{
+		cfg:    cfg,
+		sketch: tinylfu.New(admissionSketchWidth),
+		seed:   maphash.MakeSeed(),
+	}
+}
+
+// Marks requests whose responses are cached without being requested often enough first, e.g. prefetches.
+// The object size limits still apply.
+func withoutRequestCount(ctx context.Context) context.Context {
+	return context.WithValue(ctx, admissionBypassKey{}, true)
+}
+
+// Counts a request for the URL of the base key.
+func (a *admissionPolicy) recordRequest(baseKey cache.CacheKey) {
+	if a.cfg.Proxy.Admission.MinRequests.Read() <= 1 {
+		return
+	}
+	a.sketch.Increment(maphash.String(a.seed, baseKey.Hex))
+}
+
+// Reports whether a cacheable response should be stored. size is the Content-Length of the response, -1 if unknown.
+// Responses of unknown size are only checked against the request count. checkCount is unset for responses
+// that replace an entry, which was already admitted.
+func (a *admissionPolicy) admit(ctx context.Context, u *url.URL, baseKey cache.CacheKey, size int64, checkCount bool) bool {
+	minSize, maxSize := a.sizeLimits(u)
+	if size >= 0 && (size < minSize || (maxSize > 0 && size > maxSize)) {
+		slog.Debug("Not caching response outside of the object size limits", "url", u, "size", size, "min_size", minSize, "max_size", maxSize)
+		metrics.Global.Cache.AdmissionsRejectedSize.Increment()
+		return false
+	}
+
+	minRequests := a.cfg.Proxy.Admission.MinRequests.Read()
+	if checkCount && minRequests > 1 && ctx.Value(admissionBypassKey{}) == nil {
+		if requests := a.sketch.Estimate(maphash.String(a.seed, baseKey.Hex)); requests < minRequests {
+			slog.Debug("Not caching response of a URL that wasn't requested often enough", "url", u, "requests", requests, "min_requests", minRequests)
+			metrics.Global.Cache.AdmissionsRejectedCount.Increment()
+			return false
+		}
+	}
+
+	metrics.Global.Cache.AdmissionsAccepted.Increment()
+	return true
+}
+
+// Returns the size limits of the first size rule matching the URL, or the global ones.
+func (a *admissionPolicy) sizeLimits(u *url.URL) (int64, int64) {
+	for _, raw := range a.cfg.Proxy.Admission.SizeRules.Read().Values() {
+		rule, err := config.ParseSizeRule(raw)
+		if err != nil {
+			slog.Warn("Ignoring invalid size rule", "rule", raw, "error", err)
+			continue
+		}
+		if hostmatch.Match(rule.Host, u.Host) && strings.HasPrefix(u.Path, rule.PathPrefix) {
+			return rule.MinSize, rule.MaxSize
+		}
+	}
+	return a.cfg.Proxy.Admission.MinObjectSize.Read().Bytes(), a.cfg.Proxy.Admission.MaxObjectSize.Read().Bytes()
+}
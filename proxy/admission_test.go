@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"context"
+	"net/url"
+	"reservoir/cache"
+	"reservoir/config"
+	"reservoir/utils/bytesize"
+	"reservoir/utils/stringlist"
+	"testing"
+)
+
+func TestAdmissionSizeLimits(t *testing.T) {
+	cfg := config.NewDefault()
+	cfg.Proxy.Admission.MaxObjectSize.Overwrite(bytesize.ParseUnchecked("1M"))
+	cfg.Proxy.Admission.SizeRules.Overwrite(stringlist.New("cdimage.ubuntu.com/releases=-4G", "*.debian.org=1K-"))
+	a := newAdmissionPolicy(cfg)
+
+	tests := []struct {
+		url  string
+		size int64
+		want bool
+	}{
+		{url: "http://example.com/small", size: 100, want: true},
+		{url: "http://example.com/large", size: 2 << 20, want: false},
+		{url: "http://example.com/unknown-size", size: -1, want: true},
+		{url: "http://cdimage.ubuntu.com/releases/noble.iso", size: 3 << 30, want: true},
+		{url: "http://cdimage.ubuntu.com/daily/noble.iso", size: 3 << 30, want: false},
+		{url: "http://deb.debian.org/debian/pool/a.deb", size: 3 << 30, want: true},
+		{url: "http://deb.debian.org/debian/tiny", size: 100, want: false},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := a.admit(context.Background(), u, cache.FromString(tt.url), tt.size, true); got != tt.want {
+			t.Fatalf("admit(%q, %d) = %t, want %t", tt.url, tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestAdmissionRequiresRequestCount(t *testing.T) {
+	cfg := config.NewDefault()
+	cfg.Proxy.Admission.MinRequests.Overwrite(3)
+	a := newAdmissionPolicy(cfg)
+
+	u, _ := url.Parse("http://example.com/file")
+	key := cache.FromString(u.String())
+	for i := 1; i <= 3; i++ {
+		a.recordRequest(key)
+		if got, want := a.admit(context.Background(), u, key, 10, true), i >= 3; got != want {
+			t.Fatalf("admit after %d requests = %t, want %t", i, got, want)
+		}
+	}
+
+	other := cache.FromString("http://example.com/other")
+	if a.admit(context.Background(), u, other, 10, true) {
+		t.Fatal("expected a URL that was never requested to be rejected")
+	}
+	if !a.admit(context.Background(), u, other, 10, false) {
+		t.Fatal("expected a replaced entry to skip the request count")
+	}
+	if !a.admit(withoutRequestCount(context.Background()), u, other, 10, true) {
+		t.Fatal("expected a prefetch to skip the request count")
+	}
+}
@@ -0,0 +1,322 @@
+package proxy
+
+import (
+	"encoding/base64"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reservoir/cache"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/proxy/responder"
+	"reservoir/utils/hostmatch"
+	"reservoir/utils/syncmap"
+	"reservoir/utils/tokenbucket"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	clientPruneInterval = 10 * time.Minute
+	clientIdleTimeout   = time.Hour
+)
+
+var (
+	ErrQuotaExceeded = errors.New("traffic quota exceeded")
+	errCacheOnlyMiss = errors.New("response isn't cached and the client may only be served from cache")
+)
+
+// Limits the throughput fetched from upstream and sent to clients, and counts the traffic of each client against
+// its daily and monthly quota. Quotas are kept in memory and start over when the proxy restarts.
+type bandwidthShaper struct {
+	cfg       *config.Config
+	upstream  *tokenbucket.Bucket
+	hosts     *syncmap.SyncMap[string, *tokenbucket.Bucket]
+	mu        sync.Mutex
+	clients   map[string]*clientUsage
+	lastPrune time.Time
+	now       func() time.Time
+}
+
+// The traffic of a single client in the current UTC day and month.
+type clientUsage struct {
+	mu         sync.Mutex
+	bucket     *tokenbucket.Bucket
+	day        string
+	month      string
+	dayBytes   int64
+	monthBytes int64
+	lastSeen   time.Time
+}
+
+func newBandwidthShaper(cfg *config.Config) *bandwidthShaper {
+	return &bandwidthShaper{
+		cfg:       cfg,
+		upstream:  tokenbucket.New(cfg.Proxy.Bandwidth.UpstreamRate.Read().Bytes()),
+		hosts:     syncmap.New[string, *tokenbucket.Bucket](),
+		clients:   make(map[string]*clientUsage),
+		lastPrune: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Limits an upstream response body to the global and per host rates.
+func (s *bandwidthShaper) shapeUpstream(resp *http.Response) {
+	s.upstream.SetRate(s.cfg.Proxy.Bandwidth.UpstreamRate.Read().Bytes())
+	host := s.hostBucket(resp.Request.URL.Hostname())
+	if s.upstream.Rate() == 0 && host == nil {
+		return
+	}
+
+	onWait := func(wait time.Duration) {
+		metrics.Global.Requests.UpstreamThrottleWait.Add(wait.Nanoseconds())
+	}
+	resp.Body = shapedBody{
+		Reader: tokenbucket.NewReader(resp.Request.Context(), resp.Body, onWait, s.upstream, host),
+		Closer: resp.Body,
+	}
+}
+
+// Returns the bucket shared by all upstream requests to the host, nil if its rate isn't limited.
+func (s *bandwidthShaper) hostBucket(host string) *tokenbucket.Bucket {
+	rate := s.hostRate(host)
+	if rate == 0 {
+		return nil
+	}
+	bucket := s.hosts.GetOrSet(host, tokenbucket.New(rate))
+	bucket.SetRate(rate)
+	return bucket
+}
+
+// Returns the rate of the first rate rule matching the host, or the global one.
+func (s *bandwidthShaper) hostRate(host string) int64 {
+	for _, raw := range s.cfg.Proxy.Bandwidth.UpstreamHostRules.Read().Values() {
+		rule, err := config.ParseRateRule(raw)
+		if err != nil {
+			slog.Warn("Ignoring invalid rate rule", "rule", raw, "error", err)
+			continue
+		}
+		if hostmatch.Match(rule.Host, host) {
+			return rule.Rate
+		}
+	}
+	return s.cfg.Proxy.Bandwidth.UpstreamHostRate.Read().Bytes()
+}
+
+// Reports whether responses to clients are limited or counted at all.
+func (s *bandwidthShaper) limitsClients() bool {
+	cfg := &s.cfg.Proxy.Bandwidth
+	return cfg.ClientRate.Read() > 0 || cfg.DailyQuota.Read() > 0 || cfg.MonthlyQuota.Read() > 0
+}
+
+// Tells clients apart by the user name of their Proxy-Authorization header if configured, otherwise by their IP.
+// The user name isn't verified, the proxy doesn't authenticate clients itself.
+func (s *bandwidthShaper) clientID(req *http.Request) string {
+	if s.cfg.Proxy.Bandwidth.ClientIdentity.Read() == config.ClientIdentityUser {
+		if user, ok := proxyAuthUser(req.Header); ok {
+			return "user:" + user
+		}
+	}
+	return "ip:" + clientIP(req.RemoteAddr)
+}
+
+func proxyAuthUser(header http.Header) (string, bool) {
+	scheme, credentials, ok := strings.Cut(header.Get("Proxy-Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(credentials))
+	if err != nil {
+		return "", false
+	}
+	user, _, ok := strings.Cut(string(decoded), ":")
+	return user, ok && user != ""
+}
+
+// Returns the usage of the client sending the request, creating it on its first request.
+func (s *bandwidthShaper) client(req *http.Request) *clientUsage {
+	id := s.clientID(req)
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastPrune) >= clientPruneInterval {
+		s.pruneClients(now)
+	}
+	usage, ok := s.clients[id]
+	if !ok {
+		usage = &clientUsage{bucket: tokenbucket.New(0)}
+		s.clients[id] = usage
+	}
+	usage.mu.Lock()
+	usage.lastSeen = now
+	usage.mu.Unlock()
+	return usage
+}
+
+// Forgets idle clients that have no traffic counted against a quota. Must be called with s.mu held.
+func (s *bandwidthShaper) pruneClients(now time.Time) {
+	daily := s.cfg.Proxy.Bandwidth.DailyQuota.Read().Bytes()
+	monthly := s.cfg.Proxy.Bandwidth.MonthlyQuota.Read().Bytes()
+
+	for id, usage := range s.clients {
+		usage.mu.Lock()
+		usage.rollover(now)
+		idle := now.Sub(usage.lastSeen) >= clientIdleTimeout
+		counted := (daily > 0 && usage.dayBytes > 0) || (monthly > 0 && usage.monthBytes > 0)
+		usage.mu.Unlock()
+
+		if idle && !counted {
+			delete(s.clients, id)
+		}
+	}
+	s.lastPrune = now
+}
+
+// Starts counting from zero once a new UTC day or month began. Must be called with u.mu held.
+func (u *clientUsage) rollover(now time.Time) {
+	now = now.UTC()
+	if day := now.Format(time.DateOnly); day != u.day {
+		u.day = day
+		u.dayBytes = 0
+	}
+	if month := now.Format("2006-01"); month != u.month {
+		u.month = month
+		u.monthBytes = 0
+	}
+}
+
+// Reports whether the client used up its daily or monthly quota, and when it is reset.
+func (s *bandwidthShaper) quotaExceeded(u *clientUsage) (bool, time.Time) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return s.quotaExceededLocked(u, s.now())
+}
+
+func (s *bandwidthShaper) quotaExceededLocked(u *clientUsage, now time.Time) (bool, time.Time) {
+	u.rollover(now)
+
+	var exceeded bool
+	var resetAt time.Time
+	utc := now.UTC()
+	if monthly := s.cfg.Proxy.Bandwidth.MonthlyQuota.Read().Bytes(); monthly > 0 && u.monthBytes >= monthly {
+		exceeded = true
+		resetAt = time.Date(utc.Year(), utc.Month()+1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	if daily := s.cfg.Proxy.Bandwidth.DailyQuota.Read().Bytes(); daily > 0 && u.dayBytes >= daily && !exceeded {
+		exceeded = true
+		resetAt = time.Date(utc.Year(), utc.Month(), utc.Day()+1, 0, 0, 0, 0, time.UTC)
+	}
+	return exceeded, resetAt
+}
+
+// Sets the rate of the client's bucket, clients over their quota are throttled with the "throttle" action.
+// Must be called with u.mu held.
+func (s *bandwidthShaper) updateClientRate(u *clientUsage, exceeded bool) {
+	cfg := &s.cfg.Proxy.Bandwidth
+	rate := cfg.ClientRate.Read().Bytes()
+	if exceeded && cfg.QuotaAction.Read() == config.QuotaActionThrottle {
+		if throttled := cfg.QuotaThrottleRate.Read().Bytes(); rate == 0 || throttled < rate {
+			rate = throttled
+		}
+	}
+	u.bucket.SetRate(rate)
+}
+
+// Counts bytes sent to the client against its quota.
+func (s *bandwidthShaper) record(u *clientUsage, n int) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	now := s.now()
+	u.rollover(now)
+	u.dayBytes += int64(n)
+	u.monthBytes += int64(n)
+
+	exceeded, _ := s.quotaExceededLocked(u, now)
+	s.updateClientRate(u, exceeded)
+}
+
+// Wraps the responder so response bodies are limited to the client's rate and counted against its quota.
+func (s *bandwidthShaper) shapeClient(r responder.Responder, req *http.Request, u *clientUsage, exceeded bool) responder.Responder {
+	u.mu.Lock()
+	s.updateClientRate(u, exceeded)
+	u.mu.Unlock()
+
+	return shapedResponder{Responder: r, shape: func(body io.Reader) io.Reader {
+		onWait := func(wait time.Duration) {
+			metrics.Global.Requests.ClientThrottleWait.Add(wait.Nanoseconds())
+		}
+		counted := quotaReader{Reader: body, record: func(n int) { s.record(u, n) }}
+		return tokenbucket.NewReader(req.Context(), counted, onWait, u.bucket)
+	}}
+}
+
+// Answers a request from the cache alone, stale entries included, for clients over their quota with the
+// "cache_only" action. It bypasses the request coalescing, a miss must not fail the requests sharing the fetch.
+func (f *fetcher) fetchCacheOnly(req *http.Request, baseKey cache.CacheKey) (fetchResult, error) {
+	if (req.Method != http.MethodGet && req.Method != http.MethodHead) || !f.policy.RequestAllowsSharedCache(req) {
+		return fetchResult{}, errCacheOnlyMiss
+	}
+	lookupKey := f.lookupCacheKey(f.withCanonicalAcceptEncoding(req), baseKey)
+
+	if req.Method == http.MethodHead {
+		meta, stale, err := f.cache.GetMetadata(lookupKey)
+		if err != nil {
+			return fetchResult{}, errCacheOnlyMiss
+		}
+		return headFetchResult(meta, stale, fetchInfo{Status: cacheOnlyHitStatus(stale)}), nil
+	}
+
+	cached, err := f.cache.Get(lookupKey)
+	if err != nil {
+		return fetchResult{}, errCacheOnlyMiss
+	}
+	return fetchResult{
+		Type: fetchTypeCached,
+		Cached: cachedFetchResult{
+			fetchInfo: fetchInfo{Status: cacheOnlyHitStatus(cached.Stale)},
+			Entry:     cached,
+		},
+	}, nil
+}
+
+func cacheOnlyHitStatus(stale bool) hitStatus {
+	if stale {
+		return hitStatusStale
+	}
+	return hitStatusHit
+}
+
+type shapedBody struct {
+	io.Reader
+	io.Closer
+}
+
+type quotaReader struct {
+	io.Reader
+	record func(n int)
+}
+
+func (r quotaReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		r.record(n)
+	}
+	return n, err
+}
+
+type shapedResponder struct {
+	responder.Responder
+	shape func(body io.Reader) io.Reader
+}
+
+func (r shapedResponder) Write(status int, body io.Reader) (int64, time.Duration, error) {
+	if body == http.NoBody {
+		return r.Responder.Write(status, body) // Responders recognize bodiless responses by it.
+	}
+	return r.Responder.Write(status, r.shape(body))
+}
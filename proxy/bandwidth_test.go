@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/bytesize"
+	"reservoir/utils/stringlist"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newBandwidthTestProxy(t *testing.T, modify func(cfg *config.BandwidthConfig)) *Proxy {
+	t.Helper()
+
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeMemory)
+	cfg.Proxy.UpstreamDefaultHttps.Overwrite(false)
+	modify(&cfg.Proxy.Bandwidth)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewProxyWithUpstreamClient(cfg, nil, nil, ctx)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		p.Destroy()
+	})
+	return p
+}
+
+func newBandwidthTestOrigin(t *testing.T, body string) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var requests atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(origin.Close)
+	return origin, &requests
+}
+
+func serveProxyRequest(p *Proxy, rawURL string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	p.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, rawURL, nil))
+	return rec
+}
+
+func TestBandwidthQuotaRejects(t *testing.T) {
+	useFreshMetrics(t)
+
+	origin, _ := newBandwidthTestOrigin(t, strings.Repeat("x", 100))
+	p := newBandwidthTestProxy(t, func(cfg *config.BandwidthConfig) {
+		cfg.DailyQuota.Overwrite(bytesize.ByteSize(100))
+		cfg.QuotaAction.Overwrite(config.QuotaActionReject)
+	})
+
+	if rec := serveProxyRequest(p, origin.URL+"/a"); rec.Code != http.StatusOK {
+		t.Fatalf("expected the first request within the quota to succeed, got %d", rec.Code)
+	}
+	rec := serveProxyRequest(p, origin.URL+"/a")
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a request over the quota to be rejected with 429, got %d", rec.Code)
+	}
+	if retryAfter := rec.Header().Get("Retry-After"); retryAfter == "" || retryAfter == "0" {
+		t.Fatalf("expected a Retry-After until the quota resets, got %q", retryAfter)
+	}
+	if got := metrics.Global.Requests.QuotaRejectedRequests.Get(); got != 1 {
+		t.Fatalf("expected 1 rejected request, got %d", got)
+	}
+}
+
+func TestBandwidthQuotaServesCacheOnly(t *testing.T) {
+	useFreshMetrics(t)
+
+	origin, requests := newBandwidthTestOrigin(t, strings.Repeat("x", 100))
+	p := newBandwidthTestProxy(t, func(cfg *config.BandwidthConfig) {
+		cfg.DailyQuota.Overwrite(bytesize.ByteSize(50))
+		cfg.QuotaAction.Overwrite(config.QuotaActionCacheOnly)
+	})
+
+	serveProxyRequest(p, origin.URL+"/cached")
+
+	rec := serveProxyRequest(p, origin.URL+"/cached")
+	if rec.Code != http.StatusOK || rec.Body.Len() != 100 {
+		t.Fatalf("expected a cached response for a client over its quota, got %d with %d bytes", rec.Code, rec.Body.Len())
+	}
+	if rec := serveProxyRequest(p, origin.URL+"/uncached"); rec.Code != http.StatusGatewayTimeout {
+		t.Fatalf("expected a miss of a client over its quota to fail with 504, got %d", rec.Code)
+	}
+	if got := requests.Load(); got != 1 {
+		t.Fatalf("expected only the request within the quota to reach the origin, got %d", got)
+	}
+	if got := metrics.Global.Requests.QuotaCacheOnlyMisses.Get(); got != 1 {
+		t.Fatalf("expected 1 cache only miss, got %d", got)
+	}
+}
+
+func TestBandwidthQuotaThrottles(t *testing.T) {
+	p := newBandwidthTestProxy(t, func(cfg *config.BandwidthConfig) {
+		cfg.ClientRate.Overwrite(bytesize.ParseUnchecked("1M"))
+		cfg.MonthlyQuota.Overwrite(bytesize.ParseUnchecked("1K"))
+		cfg.QuotaThrottleRate.Overwrite(bytesize.ParseUnchecked("8K"))
+	})
+	shaper := p.fetch.bandwidth
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/file", nil)
+	usage := shaper.client(req)
+	shaper.shapeClient(newDelayedResponder(0), req, usage, false)
+	if got := usage.bucket.Rate(); got != 1<<20 {
+		t.Fatalf("expected the client rate within the quota, got %d", got)
+	}
+
+	shaper.record(usage, 1024)
+	if exceeded, resetAt := shaper.quotaExceeded(usage); !exceeded || resetAt.Day() != 1 {
+		t.Fatalf("expected the monthly quota to be exceeded until the next month, got %t until %v", exceeded, resetAt)
+	}
+	if got := usage.bucket.Rate(); got != 8<<10 {
+		t.Fatalf("expected the client to be throttled to the quota throttle rate, got %d", got)
+	}
+
+	// The counters start over in the next month.
+	shaper.now = func() time.Time { return time.Now().AddDate(0, 1, 0) }
+	if exceeded, _ := shaper.quotaExceeded(usage); exceeded {
+		t.Fatal("expected the quota to be reset in the next month")
+	}
+}
+
+func TestBandwidthClientIdentity(t *testing.T) {
+	p := newBandwidthTestProxy(t, func(cfg *config.BandwidthConfig) {
+		cfg.ClientIdentity.Overwrite(config.ClientIdentityUser)
+	})
+	shaper := p.fetch.bandwidth
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/file", nil)
+	req.RemoteAddr = "10.0.0.7:51234"
+	if got := shaper.clientID(req); got != "ip:10.0.0.7" {
+		t.Fatalf("expected clients without credentials to be told apart by IP, got %q", got)
+	}
+
+	req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("alice:secret")))
+	if got := shaper.clientID(req); got != "user:alice" {
+		t.Fatalf("expected the user name of the Proxy-Authorization header, got %q", got)
+	}
+}
+
+func TestBandwidthUpstreamHostRates(t *testing.T) {
+	p := newBandwidthTestProxy(t, func(cfg *config.BandwidthConfig) {
+		cfg.UpstreamHostRate.Overwrite(bytesize.ParseUnchecked("1M"))
+		cfg.UpstreamHostRules.Overwrite(stringlist.New("*.debian.org=5M", "cdimage.ubuntu.com=0"))
+	})
+	shaper := p.fetch.bandwidth
+
+	tests := map[string]int64{
+		"deb.debian.org":     5 << 20,
+		"cdimage.ubuntu.com": 0,
+		"example.com":        1 << 20,
+	}
+	for host, want := range tests {
+		if got := shaper.hostRate(host); got != want {
+			t.Fatalf("hostRate(%q) = %d, want %d", host, got, want)
+		}
+	}
+	if shaper.hostBucket("cdimage.ubuntu.com") != nil {
+		t.Fatal("expected no bucket for a host without a limit")
+	}
+	if shaper.hostBucket("deb.debian.org") != shaper.hostBucket("deb.debian.org") {
+		t.Fatal("expected requests to the same host to share a bucket")
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reservoir/cache"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"time"
+)
+
+// Writes the cache entries matching the filter to w as a bundle in the given format.
+// Entries removed while the export runs are left out.
+func (p *Proxy) ExportBundle(w io.Writer, format bundle.Format, filter cachectl.ExportFilter) (cachectl.ExportResult, error) {
+	matcher, err := compileEntryMatcher(cachectl.PurgeFilter{Host: filter.Host, Prefix: filter.Prefix})
+	if err != nil {
+		return cachectl.ExportResult{}, fmt.Errorf("%w: %v", cachectl.ErrInvalidExportFilter, err)
+	}
+
+	bw, err := bundle.NewWriter[cachedRequestInfo](w, format)
+	if err != nil {
+		return cachectl.ExportResult{}, err
+	}
+
+	var result cachectl.ExportResult
+	for _, key := range p.matchEntryKeys(filter.Tag, matcher) {
+		size, err := p.exportEntry(bw, key)
+		if errors.Is(err, cache.ErrCacheEntryNotFound) {
+			continue // Evicted or purged since it was matched.
+		}
+		if err != nil {
+			return result, err
+		}
+		result.Entries++
+		result.Bytes += size
+	}
+
+	if err := bw.Close(); err != nil {
+		return result, err
+	}
+	slog.Info("Exported cache entries", "host", filter.Host, "prefix", filter.Prefix, "tag", filter.Tag, "format", format, "entries", result.Entries, "bytes", result.Bytes)
+	return result, nil
+}
+
+func (p *Proxy) exportEntry(bw *bundle.Writer[cachedRequestInfo], key cache.CacheKey) (int64, error) {
+	entry, err := p.cache.Get(key)
+	if err != nil {
+		return 0, err
+	}
+	return addEntryToBundle(bw, key, entry)
+}
+
+// Adds an opened cache entry to the bundle and closes it.
+func addEntryToBundle(bw *bundle.Writer[cachedRequestInfo], key cache.CacheKey, entry *cache.Entry[cachedRequestInfo]) (int64, error) {
+	defer entry.Data.Close()
+
+	checksum := entry.Metadata.ContentHash
+	if checksum == "" {
+		hash := sha256.New()
+		if _, err := io.Copy(hash, entry.Data); err != nil {
+			return 0, fmt.Errorf("error hashing cache entry %s: %w", key, err)
+		}
+		if _, err := entry.Data.Seek(0, io.SeekStart); err != nil {
+			return 0, fmt.Errorf("error rewinding cache entry %s: %w", key, err)
+		}
+		checksum = hex.EncodeToString(hash.Sum(nil))
+	}
+
+	if err := bw.Add(key, entry.Metadata, checksum, entry.Data); err != nil {
+		return 0, fmt.Errorf("error exporting cache entry %s: %w", key, err)
+	}
+	return entry.Metadata.Size, nil
+}
+
+// Stores the entries of a bundle read from r in the cache, replacing entries with the same key.
+// Every body is checked against its checksum, a corrupted entry stops the import with bundle.ErrChecksumMismatch.
+// Entries imported before the error are kept.
+func (p *Proxy) ImportBundle(r io.Reader, opts cachectl.ImportOptions) (cachectl.ImportResult, error) {
+	if opts.TTL < 0 {
+		return cachectl.ImportResult{}, errors.New("import TTL can't be negative")
+	}
+
+	var result cachectl.ImportResult
+	now := time.Now()
+	err := bundle.Read(r, func(entry bundle.Entry[cachedRequestInfo]) error {
+		expires := entry.Metadata.Expires
+		if opts.TTL > 0 {
+			expires = now.Add(opts.TTL)
+		} else if expires.Before(now) && !entry.Metadata.Pinned {
+			result.Skipped++
+			return nil
+		}
+
+		cached, err := p.cache.Cache(entry.Key, entry.Body, expires, entry.Metadata.Object)
+		if err != nil {
+			// The backend may only see a read error, drain the body to surface a failed checksum.
+			if _, readErr := io.Copy(io.Discard, entry.Body); readErr != nil {
+				return readErr
+			}
+			return fmt.Errorf("error importing cache entry %s: %w", entry.Key, err)
+		}
+		cached.Data.Close()
+
+		if entry.Metadata.Pinned || entry.Metadata.Hits > 0 {
+			err := p.cache.UpdateMetadata(entry.Key, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
+				meta.Pinned = entry.Metadata.Pinned
+				meta.Hits = entry.Metadata.Hits
+			})
+			if err != nil && !errors.Is(err, cache.ErrCacheEntryNotFound) {
+				return fmt.Errorf("error restoring metadata of cache entry %s: %w", entry.Key, err)
+			}
+		}
+		p.fetch.indexVariants(&entry.Metadata.Object)
+
+		result.Imported++
+		result.Bytes += entry.Metadata.Size
+		return nil
+	})
+
+	slog.Info("Imported cache entries", "imported", result.Imported, "skipped", result.Skipped, "bytes", result.Bytes, "ttl", opts.TTL, "error", err)
+	return result, err
+}
@@ -19,8 +19,30 @@ const (
 	hitStatusRevalidated
 	hitStatusHit
 	hitStatusStale
+	hitStatusPeer // Missed locally, but a peer of the cluster had it.
 )
 
+func (s hitStatus) String() string {
+	switch s {
+	case hitStatusMiss:
+		return "miss"
+	case hitStatusRevalidated:
+		return "revalidated"
+	case hitStatusHit:
+		return "hit"
+	case hitStatusStale:
+		return "stale"
+	case hitStatusPeer:
+		return "peer"
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+// Reports whether the response comes from a cache, this or a peer's, rather than directly from upstream.
+func (s hitStatus) servedFromCache() bool {
+	return s == hitStatusHit || s == hitStatusRevalidated || s == hitStatusStale || s == hitStatusPeer
+}
+
 type fwdReason int
 
 const (
@@ -51,6 +73,8 @@ func makeCacheStatusHeader(cached typeutils.Optional[*cache.Entry[cachedRequestI
 		params = append(params, "miss")
 	case hitStatusStale:
 		params = append(params, "hit; detail=\"stale\"")
+	case hitStatusPeer:
+		params = append(params, "hit; detail=\"peer\"")
 	}
 
 	if cacheStatus.fwdReason.IsSome() {
@@ -74,7 +98,7 @@ func makeCacheStatusHeader(cached typeutils.Optional[*cache.Entry[cachedRequestI
 		params = append(params, "stored")
 	}
 
-	if cached.IsSome() && (cacheStatus.hitStatus == hitStatusHit || cacheStatus.hitStatus == hitStatusRevalidated || cacheStatus.hitStatus == hitStatusStale) {
+	if cached.IsSome() && cacheStatus.hitStatus.servedFromCache() {
 		ttl := max(0, int(time.Until(cached.ForceUnwrap().Metadata.Expires).Seconds()))
 		params = append(params, fmt.Sprintf("ttl=%d", ttl))
 	}
@@ -129,6 +153,8 @@ func addCacheHeaders(r responder.Responder, req *http.Request, cached typeutils.
 		xCache = "REVALIDATED"
 	case hitStatusStale:
 		xCache = "STALE"
+	case hitStatusPeer:
+		xCache = "PEER"
 	}
 	r.AddHeader("X-Cache", xCache)
 
@@ -137,7 +163,7 @@ func addCacheHeaders(r responder.Responder, req *http.Request, cached typeutils.
 	if cached.IsSome() {
 		cached := cached.ForceUnwrap()
 
-		wasCached := cacheStatus.hitStatus == hitStatusHit || cacheStatus.hitStatus == hitStatusRevalidated || cacheStatus.hitStatus == hitStatusStale
+		wasCached := cacheStatus.hitStatus.servedFromCache()
 		justCached := cacheStatus.hitStatus == hitStatusHit && cacheStatus.stored
 		if wasCached || justCached {
 			currentAge := getCurrentAge(cached.Metadata.Object.Header, cached.Metadata.TimeWritten)
@@ -170,7 +196,7 @@ func fetchResultToCacheStatus(fetched fetchResult) cacheStatus {
 		hitStatus: fetchInfo.Status,
 		fwdReason: fwdReason,
 		fwdStatus: fwdStatus,
-		stored:    fetched.Type == fetchTypeCached && fetched.Cached.fetchInfo.Status == hitStatusMiss,
+		stored:    fetched.Type == fetchTypeCached && (fetchInfo.Status == hitStatusMiss || fetchInfo.Status == hitStatusPeer) && !fetched.Cached.Transient,
 	}
 
 	return cacheStatus
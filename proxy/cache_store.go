@@ -7,6 +7,7 @@ import (
 	filecache "reservoir/cache/file"
 	"reservoir/cache/hybrid"
 	memorycache "reservoir/cache/memory"
+	s3cache "reservoir/cache/s3"
 	"reservoir/config"
 )
 
@@ -18,14 +19,17 @@ func newCacheStore(cfg *config.Config, ctx context.Context) (cache.Cache[cachedR
 	switch cfg.Cache.Type.Read() {
 	case config.CacheTypeFile:
 		cacheDir := cfg.Cache.File.Dir.Read()
-		return filecache.New[cachedRequestInfo](cfg, cacheDir, maxCacheSize, cleanupInterval, shardCount, ctx), nil
+		return filecache.New[cachedRequestInfo](cfg, cacheDir, maxCacheSize, cleanupInterval, shardCount, ctx)
 	case config.CacheTypeHybrid:
 		cacheDir := cfg.Cache.File.Dir.Read()
 		memoryBudget := cfg.Cache.Memory.MemoryBudgetPercent.Read()
-		return hybrid.New[cachedRequestInfo](cfg, cacheDir, memoryBudget, maxCacheSize, cleanupInterval, shardCount, ctx), nil
+		return hybrid.New[cachedRequestInfo](cfg, cacheDir, memoryBudget, maxCacheSize, cleanupInterval, shardCount, ctx)
 	case config.CacheTypeMemory:
 		memoryBudget := cfg.Cache.Memory.MemoryBudgetPercent.Read()
 		return memorycache.New[cachedRequestInfo](cfg, memoryBudget, maxCacheSize, cleanupInterval, shardCount, ctx), nil
+	case config.CacheTypeS3:
+		bucket, prefix := cfg.Cache.S3.Bucket.Read(), cfg.Cache.S3.Prefix.Read()
+		return s3cache.New[cachedRequestInfo](cfg, bucket, prefix, maxCacheSize, cleanupInterval, shardCount, ctx), nil
 	default:
 		return nil, fmt.Errorf("unsupported cache type: %v", cfg.Cache.Type.Read())
 	}
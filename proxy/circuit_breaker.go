@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/syncmap"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrCircuitOpen = errors.New("upstream host keeps failing, not contacting it for a while")
+
+// Stops sending requests to upstream hosts that keep failing. After enough failures in a row the breaker of a host
+// opens and requests to it fail right away, so stale entries are served without waiting for the host to time out.
+// Once the retry delay passed, a single request probes the host. The breaker closes if it succeeds and opens
+// again otherwise.
+type circuitBreakers struct {
+	cfg   *config.Config
+	hosts *syncmap.SyncMap[string, *circuitBreaker]
+}
+
+// Only hosts that failed have a breaker.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	failures  int
+	lastError string
+	openedAt  time.Time
+	retryAt   time.Time
+	probing   bool
+}
+
+func newCircuitBreakers(cfg *config.Config) *circuitBreakers {
+	return &circuitBreakers{
+		cfg:   cfg,
+		hosts: syncmap.New[string, *circuitBreaker](),
+	}
+}
+
+// Reports whether a request may be sent to the host, returns ErrCircuitOpen if not. The returned done func
+// records the response or error the request got and must be called once it's answered.
+func (c *circuitBreakers) allow(host string) (done func(resp *http.Response, err error), err error) {
+	if !c.cfg.Proxy.CircuitBreaker.Enabled.Read() {
+		return func(*http.Response, error) {}, nil
+	}
+	breaker, ok := c.hosts.Get(host)
+	if !ok {
+		return func(resp *http.Response, err error) { c.record(host, false, resp, err) }, nil
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+
+	probe := false
+	switch c.stateOf(breaker, time.Now()) {
+	case cachectl.BreakerStateOpen:
+		metrics.Global.Requests.CircuitBreakerRejections.Increment()
+		return nil, ErrCircuitOpen
+	case cachectl.BreakerStateHalfOpen:
+		if breaker.probing {
+			metrics.Global.Requests.CircuitBreakerRejections.Increment()
+			return nil, ErrCircuitOpen
+		}
+		breaker.probing = true
+		probe = true
+		slog.Info("Probing upstream host with an open circuit breaker", "host", host)
+	}
+	return func(resp *http.Response, err error) { c.record(host, probe, resp, err) }, nil
+}
+
+func (c *circuitBreakers) record(host string, probe bool, resp *http.Response, err error) {
+	cause, relevant := hostFailure(resp, err)
+	breaker, ok := c.hosts.Get(host)
+	if !ok {
+		if cause == "" {
+			return
+		}
+		breaker = c.hosts.GetOrSet(host, &circuitBreaker{})
+	}
+
+	breaker.mu.Lock()
+	defer breaker.mu.Unlock()
+	if probe {
+		breaker.probing = false
+	}
+
+	maxFailures := c.cfg.Proxy.CircuitBreaker.MaxFailures.Read()
+	switch {
+	case !relevant:
+		// Says nothing about the host, e.g. the client went away.
+
+	case cause == "":
+		if breaker.failures >= maxFailures {
+			metrics.Global.Requests.CircuitBreakersOpen.Decrement()
+			slog.Info("Upstream host is up again, closing its circuit breaker", "host", host)
+		}
+		breaker.failures = 0
+		breaker.openedAt = time.Time{}
+		breaker.retryAt = time.Time{}
+
+	default:
+		breaker.failures++
+		breaker.lastError = cause
+		if breaker.failures < maxFailures {
+			return
+		}
+		if breaker.failures == maxFailures {
+			metrics.Global.Requests.CircuitBreakersOpen.Increment()
+			metrics.Global.Requests.CircuitBreakerTrips.Increment()
+			breaker.openedAt = time.Now()
+		}
+		retryAfter := c.cfg.Proxy.CircuitBreaker.RetryAfter.Read().Cast()
+		breaker.retryAt = time.Now().Add(retryAfter)
+		slog.Warn("Upstream host keeps failing, opening its circuit breaker", "host", host, "cause", cause, "failures", breaker.failures, "retry_after", retryAfter)
+	}
+}
+
+// Must be called with breaker.mu held.
+func (c *circuitBreakers) stateOf(breaker *circuitBreaker, now time.Time) cachectl.BreakerState {
+	switch {
+	case breaker.failures < c.cfg.Proxy.CircuitBreaker.MaxFailures.Read():
+		return cachectl.BreakerStateClosed
+	case now.Before(breaker.retryAt):
+		return cachectl.BreakerStateOpen
+	default:
+		return cachectl.BreakerStateHalfOpen
+	}
+}
+
+// Returns why an upstream request failed because of its host, empty if it succeeded. Gateway errors count as
+// failures, they usually mean the origin behind a CDN or load balancer is down. relevant is unset if the outcome
+// says nothing about the host.
+func hostFailure(resp *http.Response, err error) (cause string, relevant bool) {
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrUpstreamQueueTimeout):
+		return "", false
+	case err != nil:
+		return err.Error(), true
+	case resp.StatusCode == http.StatusBadGateway, resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		return "upstream responded with " + resp.Status, true
+	default:
+		return "", true
+	}
+}
+
+// Lists the circuit breakers of the upstream hosts that failed since the proxy started, ordered by host.
+func (p *Proxy) CircuitBreakers() []cachectl.CircuitBreakerInfo {
+	now := time.Now()
+	infos := make([]cachectl.CircuitBreakerInfo, 0)
+	for host := range p.fetch.breakers.hosts.Keys() {
+		breaker, ok := p.fetch.breakers.hosts.Get(host)
+		if !ok {
+			continue
+		}
+
+		breaker.mu.Lock()
+		info := cachectl.CircuitBreakerInfo{
+			Host:                host,
+			State:               p.fetch.breakers.stateOf(breaker, now),
+			ConsecutiveFailures: breaker.failures,
+			LastError:           breaker.lastError,
+		}
+		if info.State != cachectl.BreakerStateClosed {
+			info.OpenedAt = breaker.openedAt
+			info.RetryAt = breaker.retryAt
+		}
+		breaker.mu.Unlock()
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b cachectl.CircuitBreakerInfo) int { return cmp.Compare(a.Host, b.Host) })
+	return infos
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/duration"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newBreakerTestProxy(t *testing.T) *Proxy {
+	t.Helper()
+
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeMemory)
+	cfg.Proxy.UpstreamDefaultHttps.Overwrite(false)
+	cfg.Proxy.CircuitBreaker.Enabled.Overwrite(true)
+	cfg.Proxy.CircuitBreaker.MaxFailures.Overwrite(2)
+	cfg.Proxy.CircuitBreaker.RetryAfter.Overwrite(duration.Duration(50 * time.Millisecond))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewProxyWithUpstreamClient(cfg, nil, nil, ctx)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		p.Destroy()
+	})
+	return p
+}
+
+func TestCircuitBreakerOpensAndProbes(t *testing.T) {
+	useFreshMetrics(t)
+
+	p := newBreakerTestProxy(t)
+	breakers := p.fetch.breakers
+	failure := errors.New("connection refused")
+	send := func(err error) error {
+		done, allowErr := breakers.allow("deb.debian.org")
+		if allowErr != nil {
+			return allowErr
+		}
+		if err != nil {
+			done(nil, err)
+		} else {
+			done(&http.Response{StatusCode: http.StatusOK, Status: "200 OK"}, nil)
+		}
+		return nil
+	}
+
+	for range 2 {
+		if err := send(failure); err != nil {
+			t.Fatalf("expected requests to be sent while the breaker is closed, got %v", err)
+		}
+	}
+	if err := send(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected %v after 2 failures, got %v", ErrCircuitOpen, err)
+	}
+	if got := metrics.Global.Requests.CircuitBreakersOpen.Get(); got != 1 {
+		t.Fatalf("expected 1 open breaker, got %d", got)
+	}
+	if breakers := p.CircuitBreakers(); len(breakers) != 1 || breakers[0].State != cachectl.BreakerStateOpen || breakers[0].LastError != failure.Error() {
+		t.Fatalf("expected the open breaker of the host, got %+v", breakers)
+	}
+
+	// After the retry delay a single probe goes through, a failed probe opens the breaker again.
+	time.Sleep(60 * time.Millisecond)
+	probe, err := breakers.allow("deb.debian.org")
+	if err != nil {
+		t.Fatalf("expected a probe once the retry delay passed, got %v", err)
+	}
+	if err := send(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected other requests to fail fast while probing, got %v", err)
+	}
+	probe(nil, failure)
+	if err := send(nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected a failed probe to open the breaker again, got %v", err)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if err := send(nil); err != nil {
+		t.Fatalf("expected a probe once the retry delay passed, got %v", err)
+	}
+	if breakers := p.CircuitBreakers(); breakers[0].State != cachectl.BreakerStateClosed || breakers[0].ConsecutiveFailures != 0 {
+		t.Fatalf("expected a successful probe to close the breaker, got %+v", breakers)
+	}
+	if got := metrics.Global.Requests.CircuitBreakersOpen.Get(); got != 0 {
+		t.Fatalf("expected no open breakers, got %d", got)
+	}
+	if got := metrics.Global.Requests.CircuitBreakerTrips.Get(); got != 1 {
+		t.Fatalf("expected 1 trip, got %d", got)
+	}
+}
+
+func TestCircuitBreakerServesStaleOrFailsFast(t *testing.T) {
+	useFreshMetrics(t)
+
+	var down atomic.Bool
+	var requests atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			http.Error(w, "origin down", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	t.Cleanup(origin.Close)
+
+	p := newBreakerTestProxy(t)
+	p.cfg.Proxy.CachePolicy.DefaultMaxAge.Overwrite(duration.Duration(time.Millisecond))
+
+	serveProxyRequest(p, origin.URL+"/stale")
+	down.Store(true)
+	for range 2 {
+		serveProxyRequest(p, origin.URL+"/miss")
+	}
+	sent := requests.Load()
+
+	if rec := serveProxyRequest(p, origin.URL+"/stale"); rec.Code != http.StatusOK || rec.Body.String() != "content" {
+		t.Fatalf("expected the stale entry while the breaker is open, got %d: %q", rec.Code, rec.Body.String())
+	}
+	if rec := serveProxyRequest(p, origin.URL+"/miss"); rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("expected a miss to fail fast with 503 while the breaker is open, got %d", rec.Code)
+	}
+	if got := requests.Load(); got != sent {
+		t.Fatalf("expected no requests to the origin while the breaker is open, got %d more", got-sent)
+	}
+}
+
+func TestCircuitBreakerIgnoresCanceledRequests(t *testing.T) {
+	useFreshMetrics(t)
+
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+		w.Write([]byte("content"))
+	}))
+	t.Cleanup(origin.Close)
+
+	p := newBreakerTestProxy(t)
+	for range 2 {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		req := httptest.NewRequestWithContext(ctx, http.MethodGet, origin.URL+"/file", nil)
+		if _, _, err := p.fetch.sendRequestToUpstream(req); err == nil {
+			t.Fatal("expected the canceled request to fail")
+		}
+		cancel()
+	}
+
+	if breakers := p.CircuitBreakers(); len(breakers) != 0 {
+		t.Fatalf("expected canceled requests not to count as failures of the host, got %+v", breakers)
+	}
+}
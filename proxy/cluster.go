@@ -0,0 +1,384 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"reservoir/cache"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/syncmap"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrPeerResponse = errors.New("invalid peer response")
+
+const (
+	// Path of the webserver endpoint peers fetch entries from, followed by the entry's cache key.
+	peerEntriesPath = "/api/cluster/entries/"
+	// Path of the webserver endpoint the owner of a key fetches misses of other instances at, in shard mode.
+	peerFetchPath = "/api/cluster/fetch"
+	// Set on every lookup sent to a peer. A request carrying it is never passed on to other peers.
+	peerHopHeader = "X-Reservoir-Peer"
+
+	peerDialTimeout = 5 * time.Second
+)
+
+type peerHealth struct {
+	mu        sync.Mutex
+	failures  int
+	downUntil time.Time
+}
+
+// The other instances of a cluster. Peers that fail too often in a row are skipped for a while.
+type peerSet struct {
+	cfg    *config.Config
+	client *http.Client
+	health *syncmap.SyncMap[string, *peerHealth]
+
+	ringMu      sync.Mutex
+	ring        *hashRing
+	ringMembers string // The members the ring was built for, to notice when the configuration changes.
+}
+
+func newPeerSet(cfg *config.Config) *peerSet {
+	return &peerSet{
+		cfg: cfg,
+		client: &http.Client{
+			Transport: &http.Transport{
+				DialContext:         (&net.Dialer{Timeout: peerDialTimeout, KeepAlive: upstreamKeepAlive}).DialContext,
+				DisableCompression:  true,
+				MaxIdleConnsPerHost: 10,
+				IdleConnTimeout:     upstreamIdleConnTimeout,
+			},
+			CheckRedirect: noFollowRedirects,
+		},
+		health: syncmap.New[string, *peerHealth](),
+	}
+}
+
+func (s *peerSet) enabled() bool {
+	return s.cfg.Proxy.Cluster.Enabled.Read() && len(s.cfg.Proxy.Cluster.Peers.Read().Values()) > 0
+}
+
+func (s *peerSet) sharded() bool {
+	return s.enabled() && s.cfg.Proxy.Cluster.Mode.Read() == config.ClusterModeShard
+}
+
+// Returns the hash ring over this instance and its peers, rebuilding it if they changed since it was last built.
+func (s *peerSet) hashRing() *hashRing {
+	members := []string{normalizePeerURL(s.cfg.Proxy.Cluster.Self.Read())}
+	for _, peer := range s.cfg.Proxy.Cluster.Peers.Read().Values() {
+		members = append(members, normalizePeerURL(peer))
+	}
+	// Every instance lists the others in its own order, sorting gives them all the same ring.
+	slices.Sort(members)
+	members = slices.Compact(members)
+	key := strings.Join(members, " ")
+
+	s.ringMu.Lock()
+	defer s.ringMu.Unlock()
+	if s.ring == nil || s.ringMembers != key {
+		if s.ring != nil {
+			slog.Info("Cluster membership changed, rebalancing the hash ring", "members", members)
+		}
+		s.ring = newHashRing(members)
+		s.ringMembers = key
+		metrics.Global.Requests.ClusterRingMembers.Set(int64(len(members)))
+	}
+	return s.ring
+}
+
+func normalizePeerURL(peer string) string {
+	return strings.TrimSuffix(peer, "/")
+}
+
+func (s *peerSet) healthOf(peer string) *peerHealth {
+	return s.health.GetOrSet(normalizePeerURL(peer), &peerHealth{})
+}
+
+func (s *peerSet) isDown(peer string) bool {
+	health := s.healthOf(peer)
+	health.mu.Lock()
+	defer health.mu.Unlock()
+	return time.Now().Before(health.downUntil)
+}
+
+func (s *peerSet) recordSuccess(peer string) {
+	health := s.healthOf(peer)
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	if health.failures >= s.cfg.Proxy.Cluster.MaxFailures.Read() {
+		metrics.Global.Requests.PeersDown.Decrement()
+		slog.Info("Cache peer is up again", "peer", peer)
+	}
+	health.failures = 0
+	health.downUntil = time.Time{}
+}
+
+func (s *peerSet) recordFailure(peer string, err error) {
+	metrics.Global.Requests.PeerErrors.Increment()
+
+	health := s.healthOf(peer)
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	health.failures++
+	maxFailures := s.cfg.Proxy.Cluster.MaxFailures.Read()
+	if health.failures < maxFailures {
+		slog.Warn("Cache peer lookup failed", "peer", peer, "error", err, "failures", health.failures)
+		return
+	}
+	if health.failures == maxFailures {
+		metrics.Global.Requests.PeersDown.Increment()
+	}
+	retryAfter := s.cfg.Proxy.Cluster.RetryAfter.Read().Cast()
+	health.downUntil = time.Now().Add(retryAfter)
+	slog.Warn("Cache peer is down, skipping it", "peer", peer, "error", err, "failures", health.failures, "retry_after", retryAfter)
+}
+
+func (s *peerSet) closeIdleConnections() {
+	s.client.CloseIdleConnections()
+}
+
+// Sends a request for an entry matching the request's variant headers to a peer endpoint.
+// Returns the response body, a tar bundle with the entry, or nil if the peer doesn't have it.
+// ErrNotCacheable is returned if the peer fetched a response that can't be cached or failed to fetch it from upstream,
+// neither means the peer itself is unhealthy.
+func (s *peerSet) request(req *http.Request, peerURL string, timeout time.Duration) (io.ReadCloser, error) {
+	ctx, cancel := context.WithCancel(req.Context())
+	// The timeout only covers the peer starting to answer, the body may take as long as it needs.
+	stopTimer := time.AfterFunc(timeout, cancel).Stop
+
+	peerReq, err := http.NewRequestWithContext(ctx, http.MethodGet, peerURL, nil)
+	if err != nil {
+		stopTimer()
+		cancel()
+		return nil, err
+	}
+	peerReq.Header.Set("Authorization", "Bearer "+s.cfg.Proxy.Cluster.Secret.Read())
+	peerReq.Header.Set(peerHopHeader, "1")
+	for _, name := range supportedVaryHeaders {
+		for _, value := range req.Header.Values(name) {
+			peerReq.Header.Add(name, value)
+		}
+	}
+
+	start := time.Now()
+	metrics.Global.Requests.PeerRequests.Increment()
+	resp, err := s.client.Do(peerReq)
+	stopTimer()
+	metrics.Global.Requests.PeerRequestLatency.Add(time.Since(start).Nanoseconds())
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return cancelOnClose{ReadCloser: resp.Body, cancel: cancel}, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		cancel()
+		return nil, nil
+	case http.StatusConflict, http.StatusBadGateway:
+		resp.Body.Close()
+		cancel()
+		return nil, ErrNotCacheable
+	default:
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("%w: status %d", ErrPeerResponse, resp.StatusCode)
+	}
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c cancelOnClose) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	if n > 0 {
+		metrics.Global.Requests.BytesFetchedFromPeers.Add(int64(n))
+	}
+	return n, err
+}
+
+func (c cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// Looks the request up on all peers at once and stores the first fresh entry one of them answers with in the local cache.
+// Returns false if no peer has it, the caller then fetches it from upstream.
+func (f *fetcher) fetchFromPeers(req *http.Request, baseKey cache.CacheKey) (fetchResult, bool) {
+	if !f.peers.enabled() || req.Header.Get(peerHopHeader) != "" {
+		return fetchResult{}, false
+	}
+
+	// Asking the peers one after another would make a miss wait for the timeout of every peer that hangs.
+	answers := make(chan peerAnswer)
+	pending := 0
+	for _, peer := range f.cfg.Proxy.Cluster.Peers.Read().Values() {
+		if f.peers.isDown(peer) {
+			continue
+		}
+		pending++
+		go func() {
+			peerURL := normalizePeerURL(peer) + peerEntriesPath + url.PathEscape(baseKey.Hex)
+			body, err := f.peers.request(req, peerURL, f.cfg.Proxy.Cluster.Timeout.Read().Cast())
+			answers <- peerAnswer{peer: peer, body: body, err: err}
+		}()
+	}
+
+	for ; pending > 0; pending-- {
+		answer := <-answers
+		cached, err := f.storePeerAnswer(req, baseKey, answer)
+		if err != nil {
+			f.peers.recordFailure(answer.peer, err)
+			continue
+		}
+		f.peers.recordSuccess(answer.peer)
+		if cached == nil {
+			continue
+		}
+		// The other peers may still be answering, their entries aren't needed anymore.
+		go f.peers.discardAnswers(answers, pending-1)
+
+		metrics.Global.Requests.PeerHits.Increment()
+		slog.Debug("Cache hit on peer", "url", req.URL, "peer", answer.peer, "key", cached.Metadata.Object.URL)
+		return fetchResult{
+			Type: fetchTypeCached,
+			Cached: cachedFetchResult{
+				fetchInfo: fetchInfo{Status: hitStatusPeer},
+				Entry:     cached,
+			},
+		}, true
+	}
+	return fetchResult{}, false
+}
+
+// What a peer answered to a lookup. body is nil if the peer doesn't have the entry.
+type peerAnswer struct {
+	peer string
+	body io.ReadCloser
+	err  error
+}
+
+// Waits for the remaining n answers to a lookup that was already answered by another peer, and closes them.
+func (s *peerSet) discardAnswers(answers <-chan peerAnswer, n int) {
+	for range n {
+		answer := <-answers
+		if answer.err != nil {
+			s.recordFailure(answer.peer, answer.err)
+			continue
+		}
+		s.recordSuccess(answer.peer)
+		if answer.body != nil {
+			answer.body.Close()
+		}
+	}
+}
+
+// Stores the entry a peer answered with in the local cache, and closes the answer.
+func (f *fetcher) storePeerAnswer(req *http.Request, baseKey cache.CacheKey, answer peerAnswer) (*cache.Entry[cachedRequestInfo], error) {
+	if answer.err != nil || answer.body == nil {
+		return nil, answer.err
+	}
+	defer answer.body.Close()
+
+	return readPeerEntry(answer.body, req, baseKey, func(entry bundle.Entry[cachedRequestInfo]) (*cache.Entry[cachedRequestInfo], error) {
+		if entry.Metadata.Expires.Before(time.Now()) {
+			return nil, fmt.Errorf("%w: entry %s is stale", ErrPeerResponse, entry.Key)
+		}
+
+		stored, err := f.cache.Cache(entry.Key, entry.Body, entry.Metadata.Expires, entry.Metadata.Object)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrCacheResponseFailed, err)
+		}
+		f.setVariantIndex(baseKey, entry.Metadata.Object.Vary)
+		return stored, nil
+	})
+}
+
+// Reads the single entry bundle a peer answered with and passes the entry to open, which returns it with readable data.
+func readPeerEntry(body io.Reader, req *http.Request, baseKey cache.CacheKey, open func(bundle.Entry[cachedRequestInfo]) (*cache.Entry[cachedRequestInfo], error)) (*cache.Entry[cachedRequestInfo], error) {
+	var opened *cache.Entry[cachedRequestInfo]
+	err := bundle.Read(body, func(entry bundle.Entry[cachedRequestInfo]) error {
+		if opened != nil {
+			return fmt.Errorf("%w: more than one entry", ErrPeerResponse)
+		}
+		// The peer picked the variant, it has to be the one this request looks up.
+		if entry.Key != makeVariantCacheKey(req, baseKey, entry.Metadata.Object.Vary) {
+			return fmt.Errorf("%w: unexpected entry %s", ErrPeerResponse, entry.Key)
+		}
+
+		var err error
+		opened, err = open(entry)
+		return err
+	})
+	if err == nil && opened == nil {
+		err = fmt.Errorf("%w: empty bundle", ErrPeerResponse)
+	}
+	if err != nil {
+		if opened != nil && opened.Data != nil {
+			opened.Data.Close()
+		}
+		return nil, err
+	}
+	return opened, nil
+}
+
+// Writes the fresh cache entry of the URL with the given base key as a single entry bundle to w.
+// The variant is picked from the header like for a proxied request. Only the local cache is consulted,
+// so a lookup can't be passed on from peer to peer.
+func (p *Proxy) ExportPeerEntry(w io.Writer, baseKeyHex string, secret string, header http.Header) error {
+	cfg := &p.cfg.Proxy.Cluster
+	if !cfg.Enabled.Read() {
+		return cachectl.ErrClusterDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.Secret.Read())) != 1 {
+		return cachectl.ErrPeerUnauthorized
+	}
+
+	baseKey, err := cache.ParseKey(baseKeyHex)
+	if err != nil {
+		return fmt.Errorf("%w: %v", cache.ErrCacheEntryNotFound, err)
+	}
+	key := p.fetch.lookupCacheKey(&http.Request{Header: header}, baseKey)
+
+	// The entry is opened before the bundle is started, a miss must not write anything.
+	entry, err := p.cache.Get(key)
+	if err != nil {
+		return err
+	}
+	if entry.Stale {
+		entry.Data.Close()
+		return cache.ErrCacheEntryNotFound
+	}
+
+	bw, err := bundle.NewWriter[cachedRequestInfo](w, bundle.FormatTar)
+	if err != nil {
+		entry.Data.Close()
+		return err
+	}
+	if _, err := addEntryToBundle(bw, key, entry); err != nil {
+		return err
+	}
+	metrics.Global.Requests.PeerRequestsServed.Increment()
+	return bw.Close()
+}
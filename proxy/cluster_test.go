@@ -0,0 +1,264 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reservoir/cache"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/duration"
+	"reservoir/utils/stringlist"
+	"reservoir/utils/typeutils"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testClusterSecret = "0123456789abcdef"
+
+func newClusterTestProxy(t *testing.T, peers ...string) *Proxy {
+	t.Helper()
+
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeMemory)
+	cfg.Proxy.UpstreamDefaultHttps.Overwrite(false)
+	cfg.Proxy.Cluster.Enabled.Overwrite(true)
+	cfg.Proxy.Cluster.Secret.Overwrite(testClusterSecret)
+	cfg.Proxy.Cluster.Peers.Overwrite(stringlist.New(peers...))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewProxyWithUpstreamClient(cfg, nil, nil, ctx)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		p.Destroy()
+	})
+	return p
+}
+
+// Serves the peer endpoint of p like the webserver does.
+func newPeerServer(t *testing.T, p *Proxy) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		servePeer(w, r, p)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func servePeer(w http.ResponseWriter, r *http.Request, p *Proxy) {
+	secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	var err error
+	if r.URL.Path == peerFetchPath {
+		err = p.FetchForPeer(r.Context(), w, r.URL.Query().Get("url"), secret, r.Header)
+	} else {
+		err = p.ExportPeerEntry(w, strings.TrimPrefix(r.URL.Path, peerEntriesPath), secret, r.Header)
+	}
+	switch {
+	case err == nil:
+	case errors.Is(err, cachectl.ErrPeerUnauthorized):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, cache.ErrCacheEntryNotFound), errors.Is(err, cachectl.ErrClusterDisabled):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	case errors.Is(err, cachectl.ErrPeerFetchNotCacheable):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case r.URL.Path == peerFetchPath:
+		http.Error(w, err.Error(), http.StatusBadGateway)
+	default:
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func newClusterRequest(t *testing.T, f *fetcher, rawURL string) (*http.Request, cache.CacheKey) {
+	t.Helper()
+
+	req, err := newProxyRequest(context.Background(), rawURL)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req = f.withCanonicalAcceptEncoding(req)
+	return req, cache.MakeFromRequest(req)
+}
+
+// Caches a response with the body "package" for rawURL on peer.
+func storeOnPeer(t *testing.T, peer *Proxy, rawURL string) (*http.Request, cache.CacheKey, cache.CacheKey) {
+	t.Helper()
+
+	req, baseKey := newClusterRequest(t, &peer.fetch, rawURL)
+	vary := []string{"accept-encoding"}
+	key := makeVariantCacheKey(req, baseKey, vary)
+	info := cachedRequestInfo{URL: rawURL, Header: http.Header{"Content-Type": {"application/octet-stream"}}, Vary: vary}
+	stored, err := peer.cache.Cache(key, strings.NewReader("package"), time.Now().Add(time.Hour), info)
+	if err != nil {
+		t.Fatalf("failed to cache entry on peer: %v", err)
+	}
+	stored.Data.Close()
+	peer.fetch.setVariantIndex(baseKey, vary)
+	return req, baseKey, key
+}
+
+func TestFetchFromPeersStoresPeerEntry(t *testing.T) {
+	useFreshMetrics(t)
+
+	peer := newClusterTestProxy(t)
+	server := newPeerServer(t, peer)
+	local := newClusterTestProxy(t, server.URL)
+
+	req, baseKey, key := storeOnPeer(t, peer, "http://deb.debian.org/debian/pool/a.deb")
+
+	fetched, ok := local.fetch.fetchFromPeers(req, baseKey)
+	if !ok {
+		t.Fatal("expected the peer to have the entry")
+	}
+	defer fetched.Cached.Entry.Data.Close()
+	if fetched.Cached.Status != hitStatusPeer {
+		t.Fatalf("expected status %v, got %v", hitStatusPeer, fetched.Cached.Status)
+	}
+	body, err := io.ReadAll(fetched.Cached.Entry.Data)
+	if err != nil || string(body) != "package" {
+		t.Fatalf("unexpected body %q: %v", body, err)
+	}
+
+	if got := local.fetch.lookupCacheKey(req, baseKey); got != key {
+		t.Fatalf("expected the variant to be indexed locally, lookup key is %s", got)
+	}
+	localEntry, err := local.cache.Get(key)
+	if err != nil {
+		t.Fatalf("expected the entry to be stored locally: %v", err)
+	}
+	localEntry.Data.Close()
+
+	status := makeCacheStatusHeader(typeutils.Some(fetched.Cached.Entry), fetchResultToCacheStatus(fetched))
+	if !strings.HasPrefix(status, `reservoir; hit; detail="peer"; stored; ttl=`) {
+		t.Fatalf("unexpected Cache-Status %q", status)
+	}
+	if metrics.Global.Requests.PeerHits.Get() != 1 || metrics.Global.Requests.PeerRequestsServed.Get() != 1 {
+		t.Fatalf("expected one peer hit and one served peer request, got %d and %d", metrics.Global.Requests.PeerHits.Get(), metrics.Global.Requests.PeerRequestsServed.Get())
+	}
+}
+
+func TestFetchFromPeersMisses(t *testing.T) {
+	useFreshMetrics(t)
+
+	peer := newClusterTestProxy(t)
+	server := newPeerServer(t, peer)
+	local := newClusterTestProxy(t, server.URL)
+
+	req, baseKey := newClusterRequest(t, &local.fetch, "http://deb.debian.org/debian/pool/missing.deb")
+	if _, ok := local.fetch.fetchFromPeers(req, baseKey); ok {
+		t.Fatal("expected a miss for an entry no peer has")
+	}
+	if metrics.Global.Requests.PeerRequests.Get() != 1 || metrics.Global.Requests.PeerErrors.Get() != 0 {
+		t.Fatalf("expected one peer request without errors, got %d requests and %d errors", metrics.Global.Requests.PeerRequests.Get(), metrics.Global.Requests.PeerErrors.Get())
+	}
+
+	req.Header.Set(peerHopHeader, "1")
+	if _, ok := local.fetch.fetchFromPeers(req, baseKey); ok {
+		t.Fatal("expected a request from a peer not to be passed on")
+	}
+	if metrics.Global.Requests.PeerRequests.Get() != 1 {
+		t.Fatal("expected a request from a peer not to be sent to other peers")
+	}
+}
+
+func TestFetchFromPeersSkipsFailingPeer(t *testing.T) {
+	useFreshMetrics(t)
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(server.Close)
+
+	local := newClusterTestProxy(t, server.URL)
+	local.cfg.Proxy.Cluster.MaxFailures.Overwrite(2)
+
+	req, baseKey := newClusterRequest(t, &local.fetch, "http://deb.debian.org/debian/pool/a.deb")
+	for range 3 {
+		if _, ok := local.fetch.fetchFromPeers(req, baseKey); ok {
+			t.Fatal("expected a failing peer not to return an entry")
+		}
+	}
+	if got := requests.Load(); got != 2 {
+		t.Fatalf("expected the peer to be skipped after 2 failures, it got %d requests", got)
+	}
+	if metrics.Global.Requests.PeersDown.Get() != 1 {
+		t.Fatalf("expected one peer to be down, got %d", metrics.Global.Requests.PeersDown.Get())
+	}
+}
+
+func TestFetchFromPeersDoesNotWaitForHangingPeer(t *testing.T) {
+	useFreshMetrics(t)
+
+	releaseCh := make(chan struct{})
+	release := sync.OnceFunc(func() { close(releaseCh) })
+	hanging := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-releaseCh:
+		case <-r.Context().Done():
+		}
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(hanging.Close)
+	t.Cleanup(release)
+
+	peer := newClusterTestProxy(t)
+	server := newPeerServer(t, peer)
+	local := newClusterTestProxy(t, hanging.URL, server.URL)
+	local.cfg.Proxy.Cluster.Timeout.Overwrite(duration.Duration(10 * time.Second))
+
+	req, baseKey, _ := storeOnPeer(t, peer, "http://deb.debian.org/debian/pool/a.deb")
+
+	start := time.Now()
+	fetched, ok := local.fetch.fetchFromPeers(req, baseKey)
+	if !ok {
+		t.Fatal("expected the second peer to have the entry")
+	}
+	fetched.Cached.Entry.Data.Close()
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the hit not to wait for the hanging peer, it took %v", elapsed)
+	}
+
+	// The hanging peer's lookup is still recorded once it answers.
+	release()
+	health := local.fetch.peers.healthOf(hanging.URL)
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		health.mu.Lock()
+		failures := health.failures
+		health.mu.Unlock()
+		if failures == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the failure of the hanging peer to be recorded")
+		}
+	}
+}
+
+func TestExportPeerEntryRequiresSecret(t *testing.T) {
+	p := newClusterTestProxy(t)
+	key := cache.FromString("http://example.com/file")
+
+	if err := p.ExportPeerEntry(io.Discard, key.Hex, "wrong-secret-value", http.Header{}); !errors.Is(err, cachectl.ErrPeerUnauthorized) {
+		t.Fatalf("expected %v, got %v", cachectl.ErrPeerUnauthorized, err)
+	}
+	if err := p.ExportPeerEntry(io.Discard, key.Hex, testClusterSecret, http.Header{}); !errors.Is(err, cache.ErrCacheEntryNotFound) {
+		t.Fatalf("expected %v, got %v", cache.ErrCacheEntryNotFound, err)
+	}
+
+	p.cfg.Proxy.Cluster.Enabled.Overwrite(false)
+	if err := p.ExportPeerEntry(io.Discard, key.Hex, testClusterSecret, http.Header{}); !errors.Is(err, cachectl.ErrClusterDisabled) {
+		t.Fatalf("expected %v, got %v", cachectl.ErrClusterDisabled, err)
+	}
+}
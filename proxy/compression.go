@@ -0,0 +1,148 @@
+package proxy
+
+import (
+	"io"
+	"log/slog"
+	"net/http"
+	"reservoir/metrics"
+	"reservoir/proxy/contentcoding"
+	"reservoir/proxy/responder"
+	"strings"
+)
+
+// The Accept-Encoding sent upstream for shareable requests when compression negotiation is enabled.
+// gzip is preferred as it is the coding clients most often accept, which keeps transcoding on cache hits rare.
+const canonicalAcceptEncoding = "gzip, zstd;q=0.9, br;q=0.8"
+
+func (f *fetcher) compressionEnabled() bool {
+	return f.cfg.Proxy.Compression.Enabled.Read()
+}
+
+// Returns a copy of the request asking upstream for the canonical set of encodings.
+// This makes every client share the same cached representation regardless of its own Accept-Encoding.
+func (f *fetcher) withCanonicalAcceptEncoding(req *http.Request) *http.Request {
+	if !f.compressionEnabled() {
+		return req
+	}
+
+	canonical := req.Clone(req.Context())
+	canonical.Header.Set("Accept-Encoding", canonicalAcceptEncoding)
+	return canonical
+}
+
+// Reports whether an uncompressed upstream response should be compressed before it is stored.
+func (f *fetcher) shouldCompressForStorage(resp *http.Response) bool {
+	cfg := f.cfg.Proxy.Compression
+	if !cfg.Enabled.Read() || !cfg.CompressText.Read() {
+		return false
+	}
+	if contentcoding.Normalize(resp.Header.Get("Content-Encoding")) != contentcoding.Identity {
+		return false
+	}
+	return contentcoding.MatchesType(resp.Header.Get("Content-Type"), cfg.TextTypes.Read().Values())
+}
+
+// Compresses an upstream response body with gzip while it is being stored.
+// Returns the compressed body together with the headers describing it.
+func compressForStorage(body io.Reader, header http.Header) (io.ReadCloser, http.Header, error) {
+	compressed, err := contentcoding.Transcode(body, contentcoding.Identity, contentcoding.Gzip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header = header.Clone()
+	header.Set("Content-Encoding", contentcoding.Gzip)
+	header.Del("Content-Length")
+	addVary(header, "Accept-Encoding")
+	return compressed, header, nil
+}
+
+// Picks the content coding the response will be sent to the client in.
+// Returns false if the response is sent as is, either because negotiation is disabled or the stored coding is unknown.
+func (p *Proxy) negotiatedEncoding(req *http.Request, header http.Header) (stored string, target string, ok bool) {
+	if !p.cfg.Proxy.Compression.Enabled.Read() {
+		return "", "", false
+	}
+
+	stored = contentcoding.Normalize(header.Get("Content-Encoding"))
+	if !contentcoding.IsSupported(stored) {
+		return "", "", false
+	}
+
+	target = contentcoding.ParseAccept(req.Header.Values("Accept-Encoding")).Negotiate(stored)
+	return stored, target, true
+}
+
+// Reports whether the response has to be transcoded before it can be sent to the client.
+func (p *Proxy) requiresTranscoding(req *http.Request, header http.Header) bool {
+	stored, target, ok := p.negotiatedEncoding(req, header)
+	return ok && stored != target
+}
+
+// Rewrites the response headers set on the responder to use a content coding the client accepts,
+// and returns the body to send, transcoded if necessary. compressedByProxy marks bodies Reservoir compressed itself.
+// IMPORTANT: The returned body must be closed once the response has been written.
+func (p *Proxy) negotiateEncoding(r responder.Responder, req *http.Request, body io.Reader, compressedByProxy bool) (io.ReadCloser, error) {
+	header := r.GetHeaders()
+	stored, target, ok := p.negotiatedEncoding(req, header)
+	if !ok {
+		return io.NopCloser(body), nil
+	}
+
+	// The body sent depends on the client's Accept-Encoding from here on.
+	addVary(header, "Accept-Encoding")
+
+	upstreamCoding := stored
+	if compressedByProxy {
+		upstreamCoding = contentcoding.Identity
+	}
+	if target != upstreamCoding {
+		// The representation differs from what upstream sent, so it can't share its strong validator.
+		weakenETag(header)
+	}
+
+	if target == stored {
+		return io.NopCloser(body), nil
+	}
+
+	slog.Debug("Transcoding response for client", "url", req.URL, "from", stored, "to", target)
+
+	if target == contentcoding.Identity {
+		header.Del("Content-Encoding")
+	} else {
+		header.Set("Content-Encoding", target)
+	}
+	header.Del("Content-Length")
+	header.Del("Accept-Ranges")
+
+	if body == nil || req.Method == http.MethodHead {
+		return io.NopCloser(http.NoBody), nil
+	}
+
+	transcoded, err := contentcoding.Transcode(body, stored, target)
+	if err != nil {
+		return nil, err
+	}
+	metrics.Global.Requests.TranscodedResponses.Increment()
+	return transcoded, nil
+}
+
+func addVary(header http.Header, name string) {
+	for _, value := range header.Values("Vary") {
+		for part := range strings.SplitSeq(value, ",") {
+			part = strings.TrimSpace(part)
+			if part == "*" || strings.EqualFold(part, name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
+
+func weakenETag(header http.Header) {
+	etag := header.Get("ETag")
+	if etag == "" || strings.HasPrefix(etag, "W/") {
+		return
+	}
+	header.Set("ETag", "W/"+etag)
+}
@@ -76,6 +76,11 @@ func (p *Proxy) handleCONNECT(r responder.Responder, proxyReq *http.Request) err
 		}
 
 		req.Close = true
+		req.RemoteAddr = proxyReq.RemoteAddr // Requests read from the tunnel don't know the client address.
+		if auth := proxyReq.Header.Get("Proxy-Authorization"); auth != "" && req.Header.Get("Proxy-Authorization") == "" {
+			// Identifies the user for the bandwidth limits. It's a hop-by-hop header, so it isn't sent upstream.
+			req.Header.Set("Proxy-Authorization", auth)
+		}
 		if err := p.handleHTTP(responder, req); err != nil {
 			slog.Error("Error processing HTTP request in CONNECT tunnel", "host", proxyReq.Host, "error", err)
 		}
@@ -0,0 +1,252 @@
+package contentcoding
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	Identity = "identity"
+	Gzip     = "gzip"
+	Brotli   = "br"
+	Zstd     = "zstd"
+)
+
+var ErrUnsupportedCoding = errors.New("unsupported content coding")
+
+// Codings in the order they are preferred when the client accepts several of them equally.
+// gzip comes first as it is the cheapest to produce and nearly every client understands it.
+var preferredCodings = []string{Gzip, Zstd, Brotli, Identity}
+
+// Normalizes a Content-Encoding value. An empty value means the identity coding.
+func Normalize(coding string) string {
+	coding = strings.ToLower(strings.TrimSpace(coding))
+	switch coding {
+	case "", Identity:
+		return Identity
+	case "x-gzip":
+		return Gzip
+	}
+	return coding
+}
+
+// Reports whether the coding can be decoded and encoded.
+func IsSupported(coding string) bool {
+	switch Normalize(coding) {
+	case Identity, Gzip, Brotli, Zstd:
+		return true
+	}
+	return false
+}
+
+type acceptEntry struct {
+	coding string
+	q      float64
+}
+
+// A parsed Accept-Encoding header.
+type Accept struct {
+	entries []acceptEntry
+}
+
+// Parses the values of an Accept-Encoding header.
+func ParseAccept(values []string) Accept {
+	accept := Accept{}
+	for _, value := range values {
+		for part := range strings.SplitSeq(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			coding = strings.ToLower(strings.TrimSpace(coding))
+			if coding == "" {
+				continue
+			}
+			if coding != "*" {
+				coding = Normalize(coding)
+			}
+
+			q := 1.0
+			for param := range strings.SplitSeq(params, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+				if !ok || strings.ToLower(strings.TrimSpace(name)) != "q" {
+					continue
+				}
+				if parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+					q = parsed
+				}
+			}
+			accept.entries = append(accept.entries, acceptEntry{coding: coding, q: q})
+		}
+	}
+	return accept
+}
+
+// Returns the quality the client assigned to the coding, or 0 if it is not acceptable.
+func (a Accept) Quality(coding string) float64 {
+	coding = Normalize(coding)
+
+	wildcard := -1.0
+	for _, entry := range a.entries {
+		if entry.coding == coding {
+			return entry.q
+		}
+		if entry.coding == "*" {
+			wildcard = entry.q
+		}
+	}
+	if wildcard >= 0 {
+		return wildcard
+	}
+
+	// Identity is always acceptable unless explicitly excluded.
+	// This also means only identity is assumed to be understood without an Accept-Encoding header.
+	if coding == Identity {
+		return 1
+	}
+	return 0
+}
+
+// Picks the coding to serve a representation stored with the given coding in.
+// The stored coding is kept if the client accepts it, otherwise the best supported coding the client accepts is chosen.
+// Falls back to identity if the client accepts none of the supported codings.
+func (a Accept) Negotiate(stored string) string {
+	stored = Normalize(stored)
+	if a.Quality(stored) > 0 {
+		return stored
+	}
+
+	best, bestQ := Identity, 0.0
+	for _, coding := range preferredCodings {
+		if q := a.Quality(coding); q > bestQ {
+			best, bestQ = coding, q
+		}
+	}
+	return best
+}
+
+// Wraps a reader so it yields the decoded content of the given coding.
+func NewReader(coding string, r io.Reader) (io.ReadCloser, error) {
+	switch Normalize(coding) {
+	case Identity:
+		return io.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Brotli:
+		return io.NopCloser(brotli.NewReader(r)), nil
+	case Zstd:
+		decoder, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedCoding, coding)
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// Wraps a writer so everything written to it is encoded with the given coding.
+// The returned writer must be closed to flush the encoded stream.
+func NewWriter(coding string, w io.Writer) (io.WriteCloser, error) {
+	switch Normalize(coding) {
+	case Identity:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Brotli:
+		return brotli.NewWriterLevel(w, brotli.DefaultCompression), nil
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnsupportedCoding, coding)
+}
+
+type transcoder struct {
+	pipe *io.PipeReader
+	done chan struct{}
+}
+
+func (t *transcoder) Read(p []byte) (int, error) {
+	return t.pipe.Read(p)
+}
+
+// Closes the transcoded stream and waits until the source is no longer being read.
+func (t *transcoder) Close() error {
+	err := t.pipe.Close()
+	<-t.done
+	return err
+}
+
+// Returns a reader that converts the content read from r from one coding to another.
+// The source reader is not closed, but it is guaranteed to no longer be in use once the returned reader is closed.
+func Transcode(r io.Reader, from string, to string) (io.ReadCloser, error) {
+	from, to = Normalize(from), Normalize(to)
+	if !IsSupported(from) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCoding, from)
+	}
+	if !IsSupported(to) {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedCoding, to)
+	}
+
+	pr, pw := io.Pipe()
+	t := &transcoder{pipe: pr, done: make(chan struct{})}
+
+	go func() {
+		defer close(t.done)
+		pw.CloseWithError(transcodeTo(pw, r, from, to))
+	}()
+
+	return t, nil
+}
+
+func transcodeTo(w io.Writer, r io.Reader, from string, to string) error {
+	decoded, err := NewReader(from, r)
+	if err != nil {
+		return err
+	}
+	defer decoded.Close()
+
+	encoder, err := NewWriter(to, w)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(encoder, decoded); err != nil {
+		encoder.Close()
+		return err
+	}
+	return encoder.Close()
+}
+
+// Reports whether the media type of a Content-Type header matches any of the patterns.
+// Patterns are either full media types like "application/json" or wildcards like "text/*".
+func MatchesType(contentType string, patterns []string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if prefix, ok := strings.CutSuffix(pattern, "/*"); ok {
+			if strings.HasPrefix(mediaType, prefix+"/") {
+				return true
+			}
+			continue
+		}
+		if mediaType == pattern {
+			return true
+		}
+	}
+	return false
+}
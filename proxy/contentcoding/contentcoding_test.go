@@ -0,0 +1,89 @@
+package contentcoding
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		accept string
+		stored string
+		want   string
+	}{
+		{accept: "gzip, br", stored: "gzip", want: Gzip},
+		{accept: "br", stored: "gzip", want: Brotli},
+		{accept: "gzip;q=0.5, zstd", stored: "br", want: Zstd},
+		{accept: "", stored: "gzip", want: Identity},
+		{accept: "deflate", stored: "zstd", want: Identity},
+		{accept: "*", stored: "br", want: Brotli},
+		{accept: "gzip;q=0, *", stored: "gzip", want: Zstd},
+		{accept: "identity;q=0, gzip", stored: "", want: Gzip},
+		{accept: "x-gzip", stored: "gzip", want: Gzip},
+	}
+
+	for _, tt := range tests {
+		var values []string
+		if tt.accept != "" {
+			values = []string{tt.accept}
+		}
+		if got := ParseAccept(values).Negotiate(tt.stored); got != tt.want {
+			t.Errorf("Negotiate(%q) with Accept-Encoding %q = %q, want %q", tt.stored, tt.accept, got, tt.want)
+		}
+	}
+}
+
+func TestTranscodeRoundTrip(t *testing.T) {
+	content := []byte(strings.Repeat("reservoir caches packages\n", 128))
+
+	for _, coding := range []string{Gzip, Brotli, Zstd} {
+		encoded, err := Transcode(bytes.NewReader(content), Identity, coding)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", coding, err)
+		}
+		encodedBytes, err := io.ReadAll(encoded)
+		encoded.Close()
+		if err != nil {
+			t.Fatalf("failed to read %s stream: %v", coding, err)
+		}
+
+		decoded, err := Transcode(bytes.NewReader(encodedBytes), coding, Identity)
+		if err != nil {
+			t.Fatalf("failed to decode %s: %v", coding, err)
+		}
+		decodedBytes, err := io.ReadAll(decoded)
+		decoded.Close()
+		if err != nil {
+			t.Fatalf("failed to read decoded %s stream: %v", coding, err)
+		}
+
+		if !bytes.Equal(decodedBytes, content) {
+			t.Fatalf("%s round trip changed the content", coding)
+		}
+	}
+}
+
+func TestTranscodeRejectsUnsupportedCodings(t *testing.T) {
+	if _, err := Transcode(strings.NewReader(""), "compress", Gzip); err == nil {
+		t.Fatal("expected error for unsupported source coding")
+	}
+}
+
+func TestMatchesType(t *testing.T) {
+	patterns := []string{"text/*", "application/json"}
+
+	if !MatchesType("text/plain; charset=utf-8", patterns) {
+		t.Error("expected text/plain to match text/*")
+	}
+	if !MatchesType("Application/JSON", patterns) {
+		t.Error("expected media type match to be case-insensitive")
+	}
+	if MatchesType("application/octet-stream", patterns) {
+		t.Error("expected application/octet-stream not to match")
+	}
+	if MatchesType("", patterns) {
+		t.Error("expected missing Content-Type not to match")
+	}
+}
@@ -0,0 +1,146 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/url"
+	"reservoir/cache"
+	"reservoir/cachectl"
+	"reservoir/utils/hostmatch"
+	"slices"
+	"strings"
+	"time"
+)
+
+const (
+	defaultEntryPageLimit = 50
+	maxEntryPageLimit     = 1000
+)
+
+var entrySortFields = map[string]func(a, b *cachectl.EntryInfo) int{
+	"url":          func(a, b *cachectl.EntryInfo) int { return strings.Compare(a.URL, b.URL) },
+	"host":         func(a, b *cachectl.EntryInfo) int { return strings.Compare(a.Host, b.Host) },
+	"size":         func(a, b *cachectl.EntryInfo) int { return cmp.Compare(a.Size, b.Size) },
+	"time_written": func(a, b *cachectl.EntryInfo) int { return a.TimeWritten.Compare(b.TimeWritten) },
+	"last_access":  func(a, b *cachectl.EntryInfo) int { return a.LastAccess.Compare(b.LastAccess) },
+	"expires":      func(a, b *cachectl.EntryInfo) int { return a.Expires.Compare(b.Expires) },
+	"hits":         func(a, b *cachectl.EntryInfo) int { return cmp.Compare(a.Hits, b.Hits) },
+}
+
+func newEntryInfo(key cache.CacheKey, meta *cache.EntryMetadata[cachedRequestInfo], now time.Time) cachectl.EntryInfo {
+	info := cachectl.EntryInfo{
+		Key:         key.Hex,
+		URL:         meta.Object.URL,
+		Size:        meta.Size,
+		Tier:        meta.Tier,
+		TimeWritten: meta.TimeWritten,
+		LastAccess:  meta.LastAccess,
+		Expires:     meta.Expires,
+		Stale:       meta.Expires.Before(now),
+		Hits:        meta.Hits,
+		Pinned:      meta.Pinned,
+		Tags:        meta.Object.Tags,
+		Header:      meta.Object.Header,
+	}
+	if u, err := url.Parse(meta.Object.URL); err == nil {
+		info.Host = u.Host
+	}
+	return info
+}
+
+// Reports whether the entry passes the filters of the query.
+func entryMatches(q *cachectl.EntryQuery, info *cachectl.EntryInfo) bool {
+	if q.Host != "" && !hostmatch.Match(q.Host, info.Host) {
+		return false
+	}
+	if q.Search != "" && !strings.Contains(strings.ToLower(info.URL), strings.ToLower(q.Search)) {
+		return false
+	}
+	if q.Tag != "" && !slices.Contains(info.Tags, q.Tag) {
+		return false
+	}
+	if q.Tier != "" && info.Tier != q.Tier {
+		return false
+	}
+	if q.Stale != nil && info.Stale != *q.Stale {
+		return false
+	}
+	return true
+}
+
+// Returns one page of the cache entries matching the query.
+func (p *Proxy) ListEntries(query cachectl.EntryQuery) (cachectl.EntryPage, error) {
+	if query.Sort == "" {
+		query.Sort = "last_access"
+	}
+	compare, ok := entrySortFields[query.Sort]
+	if !ok {
+		return cachectl.EntryPage{}, fmt.Errorf("%w: unknown sort field '%s'", cachectl.ErrInvalidEntryQuery, query.Sort)
+	}
+	if query.Offset < 0 || query.Limit < 0 {
+		return cachectl.EntryPage{}, fmt.Errorf("%w: offset and limit can't be negative", cachectl.ErrInvalidEntryQuery)
+	}
+	if query.Limit == 0 {
+		query.Limit = defaultEntryPageLimit
+	}
+	query.Limit = min(query.Limit, maxEntryPageLimit)
+
+	now := time.Now()
+	matched := make([]cachectl.EntryInfo, 0)
+	for key, meta := range p.cache.Entries {
+		info := newEntryInfo(key, meta, now)
+		if entryMatches(&query, &info) {
+			matched = append(matched, info)
+		}
+	}
+
+	slices.SortFunc(matched, func(a, b cachectl.EntryInfo) int {
+		result := compare(&a, &b)
+		if result == 0 {
+			result = strings.Compare(a.Key, b.Key) // Keep pages stable between requests.
+		}
+		if query.Descending {
+			return -result
+		}
+		return result
+	})
+
+	start := min(query.Offset, len(matched))
+	end := min(start+query.Limit, len(matched))
+	return cachectl.EntryPage{
+		Entries: matched[start:end],
+		Total:   len(matched),
+		Offset:  query.Offset,
+		Limit:   query.Limit,
+	}, nil
+}
+
+// Returns the details of a single cache entry.
+func (p *Proxy) GetEntry(key string) (cachectl.EntryInfo, error) {
+	cacheKey, err := cache.ParseKey(key)
+	if err != nil {
+		return cachectl.EntryInfo{}, fmt.Errorf("%w: %v", cache.ErrCacheEntryNotFound, err)
+	}
+
+	meta, _, err := p.cache.GetMetadata(cacheKey)
+	if err != nil {
+		return cachectl.EntryInfo{}, err
+	}
+	return newEntryInfo(cacheKey, meta, time.Now()), nil
+}
+
+// Opens the stored body of a cache entry. The body is returned as stored, so it may still be compressed.
+// Reading a body this way counts as a cache hit.
+func (p *Proxy) OpenEntryBody(key string) (cachectl.EntryInfo, io.ReadCloser, error) {
+	cacheKey, err := cache.ParseKey(key)
+	if err != nil {
+		return cachectl.EntryInfo{}, nil, fmt.Errorf("%w: %v", cache.ErrCacheEntryNotFound, err)
+	}
+
+	entry, err := p.cache.Get(cacheKey)
+	if err != nil {
+		return cachectl.EntryInfo{}, nil, err
+	}
+	return newEntryInfo(cacheKey, entry.Metadata, time.Now()), entry.Data, nil
+}
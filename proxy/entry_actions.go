@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reservoir/cache"
+	"reservoir/cachectl"
+	"time"
+)
+
+// Pins or unpins a cache entry. Pinned entries are never evicted and are kept after they expire,
+// in which case they're revalidated like any other stale entry.
+func (p *Proxy) PinEntry(key string, pinned bool) (cachectl.EntryInfo, error) {
+	cacheKey, err := cache.ParseKey(key)
+	if err != nil {
+		return cachectl.EntryInfo{}, fmt.Errorf("%w: %v", cache.ErrCacheEntryNotFound, err)
+	}
+
+	err = p.cache.UpdateMetadata(cacheKey, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
+		meta.Pinned = pinned
+	})
+	if err != nil {
+		return cachectl.EntryInfo{}, err
+	}
+
+	slog.Info("Changed pin of cache entry", "key", key, "pinned", pinned)
+	return p.GetEntry(key)
+}
+
+// Pushes the expiry of a cache entry back by the given duration. Stale entries are extended from now.
+func (p *Proxy) ExtendEntryTTL(key string, extension time.Duration) (cachectl.EntryInfo, error) {
+	if extension <= 0 {
+		return cachectl.EntryInfo{}, fmt.Errorf("%w: the extension has to be positive", cachectl.ErrInvalidTTLExtension)
+	}
+
+	cacheKey, err := cache.ParseKey(key)
+	if err != nil {
+		return cachectl.EntryInfo{}, fmt.Errorf("%w: %v", cache.ErrCacheEntryNotFound, err)
+	}
+
+	err = p.cache.UpdateMetadata(cacheKey, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
+		from := meta.Expires
+		if now := time.Now(); from.Before(now) {
+			from = now
+		}
+		meta.Expires = from.Add(extension)
+	})
+	if err != nil {
+		return cachectl.EntryInfo{}, err
+	}
+
+	slog.Info("Extended TTL of cache entry", "key", key, "extension", extension)
+	return p.GetEntry(key)
+}
+
+// Sends a conditional request for a cache entry upstream right away, even if the entry is still fresh.
+func (p *Proxy) RevalidateEntry(ctx context.Context, key string) (cachectl.RevalidationResult, error) {
+	cacheKey, err := cache.ParseKey(key)
+	if err != nil {
+		return cachectl.RevalidationResult{}, fmt.Errorf("%w: %v", cache.ErrCacheEntryNotFound, err)
+	}
+
+	meta, _, err := p.cache.GetMetadata(cacheKey)
+	if err != nil {
+		return cachectl.RevalidationResult{}, err
+	}
+	if meta.Object.URL == "" {
+		return cachectl.RevalidationResult{}, fmt.Errorf("%w: the entry was stored without its URL", cachectl.ErrEntryNotRevalidatable)
+	}
+
+	req, err := newProxyRequest(ctx, meta.Object.URL)
+	if err != nil {
+		return cachectl.RevalidationResult{}, fmt.Errorf("%w: %v", cachectl.ErrEntryNotRevalidatable, err)
+	}
+
+	sharedReq := p.fetch.withCanonicalAcceptEncoding(req)
+	baseKey := cache.MakeFromRequest(sharedReq)
+	if makeVariantCacheKey(sharedReq, baseKey, meta.Object.Vary) != cacheKey {
+		// The entry is a variant selected by request headers we don't know anymore.
+		return cachectl.RevalidationResult{}, fmt.Errorf("%w: the entry varies on %v", cachectl.ErrEntryNotRevalidatable, meta.Object.Vary)
+	}
+
+	fetched, err := p.fetch.revalidateEntry(sharedReq, baseKey, cacheKey, meta)
+	if err != nil {
+		return cachectl.RevalidationResult{}, fmt.Errorf("%w: %v", cachectl.ErrRevalidationFailed, err)
+	}
+
+	if fetched.Type == fetchTypeDirect {
+		fetched.Direct.Response.Body.Close()
+		return cachectl.RevalidationResult{UpstreamStatus: fetched.Direct.UpstreamStatus},
+			fmt.Errorf("%w: upstream returned a non-cacheable response with status %d", cachectl.ErrRevalidationFailed, fetched.Direct.UpstreamStatus)
+	}
+
+	entry := fetched.Cached.Entry
+	if entry.Data != nil {
+		entry.Data.Close()
+	}
+
+	result := cachectl.RevalidationResult{
+		UpstreamStatus: fetched.Cached.UpstreamStatus,
+		Modified:       fetched.Cached.UpstreamStatus != http.StatusNotModified,
+		Entry:          newEntryInfo(cacheKey, entry.Metadata, time.Now()),
+	}
+	slog.Info("Revalidated cache entry", "key", key, "url", meta.Object.URL, "upstream_status", result.UpstreamStatus, "modified", result.Modified)
+	return result, nil
+}
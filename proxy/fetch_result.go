@@ -4,6 +4,7 @@ import (
 	"io"
 	"net/http"
 	"reservoir/cache"
+	"sync/atomic"
 	"time"
 )
 
@@ -23,7 +24,14 @@ type fetchInfo struct {
 // Represents a fetch that was not served from cache, but returned directly from the origin server.
 type directFetchResult struct {
 	fetchInfo
-	Response *http.Response
+	Response    *http.Response
+	NotAdmitted bool         // Set if the response could be cached, but the admission policy rejected it.
+	claimed     *atomic.Bool // Set on results shared between coalesced requests, only one of them serves the response.
+}
+
+// Reports whether the caller may serve the response. Of the requests sharing a result, only the first gets it.
+func (d *directFetchResult) claim() bool {
+	return d.claimed == nil || d.claimed.CompareAndSwap(false, true)
 }
 
 // Represents a fetch that was served from cache. Possibly revalidated from origin.
@@ -31,6 +39,7 @@ type cachedFetchResult struct {
 	fetchInfo
 	Entry     *cache.Entry[cachedRequestInfo]
 	Coalesced bool
+	Transient bool // Set if the entry was fetched through its owner in shard mode and isn't in this instance's cache.
 }
 
 type fetchResult struct {
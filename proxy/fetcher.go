@@ -9,6 +9,7 @@ import (
 	"reservoir/metrics"
 	"reservoir/proxy/headers"
 	"reservoir/utils/syncmap"
+	"sync/atomic"
 
 	"golang.org/x/sync/singleflight"
 )
@@ -19,35 +20,47 @@ type fetcher struct {
 	cache        cache.Cache[cachedRequestInfo]
 	cfg          *config.Config
 	policy       cachePolicy
+	admission    *admissionPolicy
 	client       *http.Client
 	group        singleflight.Group
 	variantIndex *syncmap.SyncMap[cache.CacheKey, []string]
+	peers        *peerSet
+	bandwidth    *bandwidthShaper
+	queue        *upstreamQueue
+	breakers     *circuitBreakers
 }
 
 func newFetcher(cacheStore cache.Cache[cachedRequestInfo], cfg *config.Config, upstreamClient *http.Client) fetcher {
 	if upstreamClient == nil {
 		upstreamClient = newUpstreamClient()
 	}
+	client := *upstreamClient
+	client.CheckRedirect = noFollowRedirects
 
 	return fetcher{
 		cache:        cacheStore,
 		cfg:          cfg,
 		policy:       newCachePolicy(cfg),
-		client:       upstreamClient,
+		admission:    newAdmissionPolicy(cfg),
+		client:       &client,
 		group:        singleflight.Group{},
 		variantIndex: syncmap.New[cache.CacheKey, []string](),
+		peers:        newPeerSet(cfg),
+		bandwidth:    newBandwidthShaper(cfg),
+		queue:        newUpstreamQueue(cfg),
+		breakers:     newCircuitBreakers(cfg),
 	}
 }
 
 func (f *fetcher) closeIdleConnections() {
 	f.client.CloseIdleConnections()
+	f.peers.closeIdleConnections()
 }
 
 // Will deduplicate cachable requests and otherwise return the bypassed upstream response.
 // IMPORTANT: Remember to close data streams!
 func (f *fetcher) dedupFetch(req *http.Request, baseKey cache.CacheKey, clientHd *headers.HeaderDirectives) (fetched fetchResult, err error) {
 	slog.Debug("Attempting to dedup fetch...")
-	lookupKey := f.lookupCacheKey(req, baseKey)
 
 	if !f.policy.RequestAllowsSharedCache(req) {
 		slog.Debug("Request contains credentials, bypassing shared cache", "url", req.URL)
@@ -55,6 +68,18 @@ func (f *fetcher) dedupFetch(req *http.Request, baseKey cache.CacheKey, clientHd
 		return f.fetchDirectlyFromUpstream(req)
 	}
 
+	// Shared requests ask upstream for the canonical encodings, the client's own Accept-Encoding is honored when responding.
+	sharedReq := f.withCanonicalAcceptEncoding(req)
+	lookupKey := f.lookupCacheKey(sharedReq, baseKey)
+	if req.Method == http.MethodGet {
+		f.admission.recordRequest(baseKey)
+	}
+
+	if req.Method == http.MethodHead && !clientHd.Range.IsPresent() {
+		metrics.Global.Requests.NonCoalescedRequests.Increment()
+		return f.fetchHead(sharedReq, lookupKey)
+	}
+
 	shouldCoalesce := !clientHd.Range.IsPresent() && req.Method == http.MethodGet
 	if !shouldCoalesce {
 		// These requests also aren't cacheable, so they just go straight to upstream..
@@ -66,8 +91,12 @@ func (f *fetcher) dedupFetch(req *http.Request, baseKey cache.CacheKey, clientHd
 
 	originalClientHd := *clientHd // Copy the original client headers so the shared requests don't get a modified version
 
-	fetchedObj, err, shared := f.group.Do(f.singleflightKey(req, baseKey), func() (any, error) {
-		return f.getFromCacheOrFetch(req, baseKey, lookupKey, clientHd)
+	fetchedObj, err, shared := f.group.Do(f.singleflightKey(sharedReq, baseKey), func() (any, error) {
+		fetched, err := f.getFromCacheOrFetch(sharedReq, baseKey, lookupKey, clientHd)
+		if err == nil && fetched.Type == fetchTypeDirect {
+			fetched.Direct.claimed = new(atomic.Bool)
+		}
+		return fetched, err
 	})
 	if err != nil {
 		if errors.Is(err, ErrNotCacheable) {
@@ -103,19 +132,29 @@ func (f *fetcher) dedupFetch(req *http.Request, baseKey cache.CacheKey, clientHd
 				fetched.Cached.Entry.Data.Close()
 			}
 
-			freshLookupKey := f.lookupCacheKey(req, baseKey)
-			cached, err := f.cache.Get(freshLookupKey)
-			if err != nil {
-				slog.Error("Error getting newly cached response. Bypassing cache and fetching upstream...", "url", req.URL, "key", freshLookupKey, "error", err)
-				return f.fetchDirectlyFromUpstream(req)
+			if fetched.Cached.Transient {
+				// The entry isn't cached here, the owner serves each follower its own copy from its cache.
+				fromOwner, err := f.fetchFromOwner(sharedReq, baseKey)
+				if err != nil {
+					slog.Warn("Error fetching shared response from its owner. Bypassing cache and fetching upstream...", "url", req.URL, "error", err)
+					return f.fetchDirectlyFromUpstream(req)
+				}
+				fetched.Cached.Entry = fromOwner.Cached.Entry
+			} else {
+				freshLookupKey := f.lookupCacheKey(sharedReq, baseKey)
+				cached, err := f.cache.Get(freshLookupKey)
+				if err != nil {
+					slog.Error("Error getting newly cached response. Bypassing cache and fetching upstream...", "url", req.URL, "key", freshLookupKey, "error", err)
+					return f.fetchDirectlyFromUpstream(req)
+				}
+				fetched.Cached.Entry = cached
 			}
-			fetched.Cached.Entry = cached
 		}
 
 		// Track coalesced cache hits/misses
 		if shared {
 			switch fetched.Cached.Status {
-			case hitStatusHit:
+			case hitStatusHit, hitStatusPeer:
 				metrics.Global.Requests.CoalescedCacheHits.Increment()
 			case hitStatusRevalidated:
 				metrics.Global.Requests.CoalescedCacheRevalidations.Increment()
@@ -128,7 +167,7 @@ func (f *fetcher) dedupFetch(req *http.Request, baseKey cache.CacheKey, clientHd
 		return fetched, nil
 
 	case fetchTypeDirect:
-		if shared {
+		if shared && !fetched.Direct.claim() {
 			slog.Debug("Fetched shared direct response, fetching own upstream...", "url", req.URL, "status", fetched.Direct.Status, "upstream_status", fetched.Direct.UpstreamStatus, "coalesced", shared)
 			return f.fetchDirectlyFromUpstream(req) // Followers should fetch their own upstream response
 		}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"cmp"
+	"encoding/binary"
+	"reservoir/cache"
+	"slices"
+	"strconv"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Points each member gets on the ring. More points spread the keys more evenly.
+const ringVirtualNodes = 128
+
+type ringPoint struct {
+	hash   uint64
+	member int
+}
+
+// A consistent-hash ring over the members of a cluster. Adding or removing a member only moves the keys
+// between it and its neighbours, every other key keeps its owner.
+type hashRing struct {
+	members []string
+	points  []ringPoint
+}
+
+func newHashRing(members []string) *hashRing {
+	ring := &hashRing{members: members, points: make([]ringPoint, 0, len(members)*ringVirtualNodes)}
+	for i, member := range members {
+		for point := range ringVirtualNodes {
+			sum := blake2b.Sum256([]byte(member + "#" + strconv.Itoa(point)))
+			ring.points = append(ring.points, ringPoint{hash: binary.BigEndian.Uint64(sum[:8]), member: i})
+		}
+	}
+	slices.SortFunc(ring.points, func(a, b ringPoint) int {
+		return cmp.Or(cmp.Compare(a.hash, b.hash), cmp.Compare(a.member, b.member))
+	})
+	return ring
+}
+
+// Returns the members in the order they own the key: the owner first, followed by the members
+// taking over if the ones before them are down.
+func (r *hashRing) owners(key cache.CacheKey) []string {
+	if len(r.points) == 0 {
+		return nil
+	}
+
+	// Cache keys are already hashes, their first 8 bytes place them on the ring.
+	position, err := strconv.ParseUint(key.Hex[:16], 16, 64)
+	if err != nil {
+		sum := blake2b.Sum256([]byte(key.Hex))
+		position = binary.BigEndian.Uint64(sum[:8])
+	}
+	start, _ := slices.BinarySearchFunc(r.points, position, func(p ringPoint, target uint64) int {
+		return cmp.Compare(p.hash, target)
+	})
+
+	owners := make([]string, 0, len(r.members))
+	seen := make([]bool, len(r.members))
+	for i := range r.points {
+		point := r.points[(start+i)%len(r.points)]
+		if seen[point.member] {
+			continue
+		}
+		seen[point.member] = true
+		owners = append(owners, r.members[point.member])
+		if len(owners) == len(r.members) {
+			break
+		}
+	}
+	return owners
+}
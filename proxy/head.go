@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reservoir/cache"
+	"reservoir/metrics"
+	"time"
+)
+
+// Answers a HEAD request from the metadata of the matching GET entry without opening its body.
+// Stale entries are revalidated with an upstream HEAD, which refreshes the entry if its validators still match.
+func (f *fetcher) fetchHead(req *http.Request, lookupKey cache.CacheKey) (fetchResult, error) {
+	slog.Debug("Trying to answer HEAD request from cached metadata...", "url", req.URL, "key", lookupKey)
+
+	meta, stale, err := f.cache.GetMetadata(lookupKey)
+	if err != nil {
+		if !errors.Is(err, cache.ErrCacheEntryNotFound) {
+			metrics.Global.Cache.CacheErrors.Increment()
+		}
+		slog.Debug("No cached entry for HEAD request, forwarding upstream", "url", req.URL, "key", lookupKey)
+		return f.fetchDirectlyFromUpstream(req)
+	}
+
+	if !stale {
+		slog.Debug("Cache hit, answering HEAD request from cached metadata", "url", req.URL, "key", lookupKey)
+		return headFetchResult(meta, false, fetchInfo{Status: hitStatusHit}), nil
+	}
+
+	slog.Debug("Cached entry is stale, revalidating with upstream HEAD", "url", req.URL, "key", lookupKey)
+
+	up := req.Clone(req.Context())
+	setRevalidationHeaders(up, meta)
+
+	resp, upstreamLatency, err := f.sendRequestToUpstream(up)
+	if err != nil && !f.mayServeStaleAfter(err) {
+		return fetchResult{}, err
+	}
+	if err != nil {
+		slog.Warn("Serving stale cached metadata because upstream HEAD failed", "url", req.URL, "key", lookupKey, "error", err)
+		return headFetchResult(meta, true, fetchInfo{Status: hitStatusStale}), nil
+	}
+
+	info := fetchInfo{UpstreamStatus: resp.StatusCode, UpstreamLatency: upstreamLatency}
+	switch {
+	case resp.StatusCode == http.StatusNotModified || (resp.StatusCode == http.StatusOK && validatorsMatch(resp.Header, meta)):
+		resp.Body.Close()
+		if err := f.refreshFreshness(req, lookupKey); err != nil {
+			return fetchResult{}, err
+		}
+		refreshed, _, err := f.cache.GetMetadata(lookupKey)
+		if err != nil {
+			return fetchResult{}, err
+		}
+		info.Status = hitStatusRevalidated
+		return headFetchResult(refreshed, false, info), nil
+
+	case resp.StatusCode >= 500:
+		resp.Body.Close()
+		slog.Warn("Serving stale cached metadata because upstream HEAD returned an error status", "url", req.URL, "key", lookupKey, "upstream_status", resp.StatusCode)
+		info.Status = hitStatusStale
+		return headFetchResult(meta, true, info), nil
+
+	default:
+		// The representation changed upstream. The GET entry is left stale so the next GET replaces it.
+		info.Status = hitStatusMiss
+		resp.Body = trackFetchedBytes(resp.Body)
+		return fetchResult{Type: fetchTypeDirect, Direct: directFetchResult{fetchInfo: info, Response: resp}}, nil
+	}
+}
+
+func headFetchResult(meta *cache.EntryMetadata[cachedRequestInfo], stale bool, info fetchInfo) fetchResult {
+	return fetchResult{
+		Type: fetchTypeCached,
+		Cached: cachedFetchResult{
+			fetchInfo: info,
+			Entry: &cache.Entry[cachedRequestInfo]{
+				Metadata: meta,
+				Stale:    stale,
+			},
+		},
+	}
+}
+
+// Reports whether an upstream 200 response describes the same representation as the cached entry.
+func validatorsMatch(header http.Header, meta *cache.EntryMetadata[cachedRequestInfo]) bool {
+	if etag := header.Get("ETag"); etag != "" && meta.Object.ETag != "" {
+		return etag == meta.Object.ETag
+	}
+
+	lastModified, err := http.ParseTime(header.Get("Last-Modified"))
+	if err != nil || meta.Object.LastModified.IsZero() {
+		return false
+	}
+	return lastModified.Truncate(time.Second).Equal(meta.Object.LastModified.Truncate(time.Second))
+}
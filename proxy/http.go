@@ -3,13 +3,17 @@ package proxy
 import (
 	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"math"
 	"net/http"
 	"reservoir/cache"
+	"reservoir/config"
 	"reservoir/metrics"
 	"reservoir/proxy/headers"
 	"reservoir/proxy/responder"
 	"reservoir/utils/typeutils"
+	"strconv"
 	"time"
 )
 
@@ -37,10 +41,51 @@ func (p *Proxy) processRequest(r responder.Responder, req *http.Request, key cac
 		metrics.Global.Requests.ClientRequestLatency.Add(time.Since(startTime).Nanoseconds())
 	}()
 
-	fetched, err := p.fetch.dedupFetch(req, key, clientHd)
+	cacheOnly := false
+	if shaper := p.fetch.bandwidth; shaper.limitsClients() {
+		usage := shaper.client(req)
+		exceeded, resetAt := shaper.quotaExceeded(usage)
+		if exceeded {
+			metrics.Global.Requests.QuotaExceededRequests.Increment()
+
+			switch p.cfg.Proxy.Bandwidth.QuotaAction.Read() {
+			case config.QuotaActionReject:
+				slog.Warn("Rejected request of client over its traffic quota", "remote_addr", req.RemoteAddr, "url", req.URL, "reset_at", resetAt)
+				metrics.Global.Requests.QuotaRejectedRequests.Increment()
+				r.SetHeader("Retry-After", strconv.FormatInt(int64(math.Ceil(time.Until(resetAt).Seconds())), 10))
+				r.WriteError("Traffic quota exceeded", http.StatusTooManyRequests)
+				return ErrQuotaExceeded
+			case config.QuotaActionCacheOnly:
+				cacheOnly = true
+			}
+		}
+		r = shaper.shapeClient(r, req, usage, exceeded)
+	}
+
+	var fetched fetchResult
+	var err error
+	if cacheOnly {
+		fetched, err = p.fetch.fetchCacheOnly(req, key)
+	} else {
+		fetched, err = p.fetch.dedupFetch(req, key, clientHd)
+	}
 	latency := time.Since(startTime)
 
-	if err != nil {
+	switch {
+	case errors.Is(err, errCacheOnlyMiss):
+		slog.Debug("Not fetching uncached response for client over its traffic quota", "remote_addr", req.RemoteAddr, "url", req.URL)
+		metrics.Global.Requests.QuotaCacheOnlyMisses.Increment()
+		r.WriteError("Traffic quota exceeded, only cached responses are served", http.StatusGatewayTimeout)
+		return err
+	case errors.Is(err, ErrCircuitOpen):
+		slog.Warn("Upstream host keeps failing, not fetching the request", "url", req.URL, "key", key)
+		r.WriteError("Upstream unavailable, try again later", http.StatusServiceUnavailable)
+		return err
+	case errors.Is(err, ErrUpstreamQueueTimeout):
+		slog.Warn("Upstream is busy, no free slot for the request", "url", req.URL, "key", key)
+		r.WriteError("Upstream busy, try again later", http.StatusServiceUnavailable)
+		return err
+	case err != nil:
 		slog.Error("Error fetching resource", "url", req.URL, "key", key, "error", err)
 		r.WriteError("Error fetching resource", http.StatusBadGateway)
 		return err
@@ -57,6 +102,9 @@ func (p *Proxy) processRequest(r responder.Responder, req *http.Request, key cac
 	case hitStatusStale:
 		metrics.Global.Cache.CacheRequestStales.Increment()
 		metrics.Global.Cache.CacheHitLatency.Add(latency.Nanoseconds())
+	case hitStatusPeer:
+		metrics.Global.Cache.CacheRequestPeerHits.Increment()
+		metrics.Global.Cache.CacheHitLatency.Add(latency.Nanoseconds())
 	default:
 		metrics.Global.Cache.CacheRequestHits.Increment()
 		metrics.Global.Cache.CacheHitLatency.Add(latency.Nanoseconds())
@@ -72,17 +120,32 @@ func (p *Proxy) processRequest(r responder.Responder, req *http.Request, key cac
 			addCacheHeaders(r, req, typeutils.None[*cache.Entry[cachedRequestInfo]](), fetchResultToCacheStatus(fetched))
 		}
 
-		return finalizeAndRespond(r, fetched.Direct.Response.Body, fetched.Direct.UpstreamStatus, req)
+		var body io.Reader = fetched.Direct.Response.Body
+		if fetched.Direct.UpstreamStatus == http.StatusOK {
+			negotiated, err := p.negotiateEncoding(r, req, body, false)
+			if err != nil {
+				slog.Error("Error negotiating response encoding", "url", req.URL, "error", err)
+				r.WriteError("error encoding response", http.StatusInternalServerError)
+				return err
+			}
+			defer negotiated.Close()
+			body = negotiated
+		}
+
+		return finalizeAndRespond(r, body, fetched.Direct.UpstreamStatus, req)
 
 	case fetchTypeCached:
-		if fetched.Cached.Entry == nil || fetched.Cached.Entry.Data == nil {
+		if fetched.Cached.Entry == nil || (fetched.Cached.Entry.Data == nil && req.Method != http.MethodHead) {
 			slog.Error("fetchTypeCached: entry or data is nil", "url", req.URL)
 			r.WriteError("internal error: cache entry data is nil", http.StatusInternalServerError)
 			return fmt.Errorf("cache entry data is nil")
 		}
-		defer fetched.Cached.Entry.Data.Close()
+		if fetched.Cached.Entry.Data != nil {
+			defer fetched.Cached.Entry.Data.Close()
+		}
 
-		if clientHd.Range.IsPresent() {
+		// Ranges refer to the stored bytes, so they can't be served from a representation that has to be transcoded first.
+		if clientHd.Range.IsPresent() && !p.requiresTranscoding(req, fetched.Cached.Entry.Metadata.Object.Header) {
 			if err := p.handleRangeRequest(r, req, fetched.Cached.Entry, key, clientHd); err != nil {
 				slog.Error("Error handling Range request", "url", req.URL, "key", key, "error", err)
 				if errors.Is(err, ErrIfRangeMismatch) {
@@ -100,12 +163,21 @@ func (p *Proxy) processRequest(r responder.Responder, req *http.Request, key cac
 
 		r.SetHeaders(fetched.Cached.Entry.Metadata.Object.Header)
 		r.SetHeader("Accept-Ranges", "bytes")
+		r.SetHeader("Content-Length", strconv.FormatInt(fetched.Cached.Entry.Metadata.Size, 10))
 		r.SetHeader("ETag", fetched.Cached.Entry.Metadata.Object.ETag)
 		r.SetHeader("Last-Modified", fetched.Cached.Entry.Metadata.Object.LastModified.Format(http.TimeFormat))
 		addCacheHeaders(r, req, typeutils.Some(fetched.Cached.Entry), fetchResultToCacheStatus(fetched))
 
+		body, err := p.negotiateEncoding(r, req, fetched.Cached.Entry.Data, fetched.Cached.Entry.Metadata.Object.Compressed)
+		if err != nil {
+			slog.Error("Error negotiating response encoding", "url", req.URL, "key", key, "error", err)
+			r.WriteError("error encoding response", http.StatusInternalServerError)
+			return err
+		}
+		defer body.Close()
+
 		slog.Debug("Serving cached response", "url", req.URL, "key", key)
-		return finalizeAndRespond(r, fetched.Cached.Entry.Data, http.StatusOK, req)
+		return finalizeAndRespond(r, body, http.StatusOK, req)
 
 	default:
 		// This should not be possible
@@ -117,6 +189,10 @@ func (p *Proxy) handleHTTP(r responder.Responder, proxyReq *http.Request) error
 	slog.Debug("Handling HTTP request", "host", proxyReq.Host, "remote_addr", proxyReq.RemoteAddr)
 	metrics.Global.Requests.HTTPProxyRequests.Increment()
 
+	if proxyReq.Method == methodPurge {
+		return p.handlePurge(r, proxyReq)
+	}
+
 	clientHd := headers.ParseHeaderDirective(proxyReq.Header)
 	clientHd.StripRegularConditionals(proxyReq.Header)
 
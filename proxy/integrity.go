@@ -0,0 +1,179 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log/slog"
+	"net/http"
+	"reservoir/metrics"
+	"strings"
+)
+
+var ErrIntegrityCheckFailed = errors.New("response integrity check failed")
+
+var digestHashes = map[string]func() hash.Hash{
+	"md5":     md5.New,
+	"sha":     sha1.New,
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+type expectedDigest struct {
+	header    string
+	algorithm string
+	sum       []byte
+}
+
+// Verifies an upstream response body while it is being read.
+// Instead of io.EOF, the final read returns ErrIntegrityCheckFailed if the body doesn't match the
+// length or digests announced in the response headers, so the cache discards the entry.
+type integrityReader struct {
+	reader         io.Reader
+	url            string
+	expectedLength int64
+	read           int64
+	digests        []expectedDigest
+	hashes         map[string]hash.Hash
+	err            error
+}
+
+func newIntegrityReader(body io.Reader, resp *http.Response) *integrityReader {
+	r := &integrityReader{
+		reader:         body,
+		expectedLength: resp.ContentLength,
+		hashes:         make(map[string]hash.Hash),
+	}
+	if resp.Request != nil {
+		r.url = resp.Request.URL.String()
+	}
+
+	r.digests = append(r.digests, parseContentMD5(resp.Header)...)
+	r.digests = append(r.digests, parseDigestHeader(resp.Header)...)
+	r.digests = append(r.digests, parseStructuredDigestHeader(resp.Header, "Repr-Digest")...)
+	r.digests = append(r.digests, parseStructuredDigestHeader(resp.Header, "Content-Digest")...)
+
+	for _, digest := range r.digests {
+		if _, ok := r.hashes[digest.algorithm]; !ok {
+			r.hashes[digest.algorithm] = digestHashes[digest.algorithm]()
+		}
+	}
+	return r
+}
+
+func (r *integrityReader) Read(p []byte) (int, error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+
+	n, err := r.reader.Read(p)
+	r.read += int64(n)
+	for _, h := range r.hashes {
+		h.Write(p[:n])
+	}
+
+	if errors.Is(err, io.EOF) {
+		if verifyErr := r.verify(); verifyErr != nil {
+			// Keep returning the error, readers like io.ReadFull drop it if the final read filled their buffer.
+			r.err = verifyErr
+			metrics.Global.Cache.IntegrityFailures.Increment()
+			slog.Warn("Discarding upstream response that failed integrity validation", "url", r.url, "error", verifyErr)
+			return n, verifyErr
+		}
+	}
+	return n, err
+}
+
+func (r *integrityReader) verify() error {
+	if r.expectedLength >= 0 && r.read != r.expectedLength {
+		return fmt.Errorf("%w: received %d bytes, Content-Length is %d", ErrIntegrityCheckFailed, r.read, r.expectedLength)
+	}
+
+	for _, digest := range r.digests {
+		if !bytes.Equal(r.hashes[digest.algorithm].Sum(nil), digest.sum) {
+			return fmt.Errorf("%w: %s %s mismatch", ErrIntegrityCheckFailed, digest.header, digest.algorithm)
+		}
+	}
+	return nil
+}
+
+func parseContentMD5(header http.Header) []expectedDigest {
+	value := strings.TrimSpace(header.Get("Content-MD5"))
+	if value == "" {
+		return nil
+	}
+
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		slog.Debug("Ignoring malformed Content-MD5 header", "value", value)
+		return nil
+	}
+	return []expectedDigest{{header: "Content-MD5", algorithm: "md5", sum: sum}}
+}
+
+// Parses the legacy Digest header (RFC 3230), e.g. "sha-256=X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=".
+func parseDigestHeader(header http.Header) []expectedDigest {
+	digests := make([]expectedDigest, 0)
+	for _, value := range header.Values("Digest") {
+		for part := range strings.SplitSeq(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				continue
+			}
+			algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+			if _, supported := digestHashes[algorithm]; !supported {
+				continue
+			}
+
+			sum, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+			if err != nil {
+				slog.Debug("Ignoring malformed Digest header value", "value", part)
+				continue
+			}
+			digests = append(digests, expectedDigest{header: "Digest", algorithm: algorithm, sum: sum})
+		}
+	}
+	return digests
+}
+
+// Parses the structured digest fields of RFC 9530, e.g. "sha-256=:X48E9qOokqqrvdts8nOJRJN3OWDUoyWxBf7kbu9DBPE=:".
+func parseStructuredDigestHeader(header http.Header, name string) []expectedDigest {
+	digests := make([]expectedDigest, 0)
+	for _, value := range header.Values(name) {
+		for part := range strings.SplitSeq(value, ",") {
+			algorithm, encoded, ok := strings.Cut(strings.TrimSpace(part), "=")
+			if !ok {
+				continue
+			}
+			algorithm = strings.ToLower(strings.TrimSpace(algorithm))
+			if algorithm == "md5" || algorithm == "sha" {
+				// Only the secure algorithms are registered for the structured fields.
+				continue
+			}
+			if _, supported := digestHashes[algorithm]; !supported {
+				continue
+			}
+
+			encoded = strings.TrimSpace(encoded)
+			if len(encoded) < 2 || encoded[0] != ':' || encoded[len(encoded)-1] != ':' {
+				slog.Debug("Ignoring malformed digest field value", "header", name, "value", part)
+				continue
+			}
+
+			sum, err := base64.StdEncoding.DecodeString(encoded[1 : len(encoded)-1])
+			if err != nil {
+				slog.Debug("Ignoring malformed digest field value", "header", name, "value", part)
+				continue
+			}
+			digests = append(digests, expectedDigest{header: name, algorithm: algorithm, sum: sum})
+		}
+	}
+	return digests
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reservoir/cache"
+	"reservoir/cachectl"
+	"reservoir/metrics"
+	"reservoir/proxy/headers"
+	"sync"
+)
+
+// Fetches the given URLs through the cache so later client requests are served from it.
+// At most concurrency URLs are fetched at the same time, report is called once per URL from the worker goroutines.
+// Blocks until every URL was handled or ctx is done, URLs not started by then are reported as canceled.
+func (p *Proxy) Prefetch(ctx context.Context, urls []string, concurrency int, report func(cachectl.PrefetchResult)) {
+	concurrency = max(concurrency, 1)
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for _, rawURL := range urls {
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			report(cachectl.PrefetchResult{URL: rawURL, Error: ctx.Err().Error()})
+			continue
+		}
+
+		wg.Go(func() {
+			defer func() { <-slots }()
+			report(p.prefetchOne(ctx, rawURL))
+		})
+	}
+	wg.Wait()
+}
+
+func (p *Proxy) prefetchOne(ctx context.Context, rawURL string) cachectl.PrefetchResult {
+	result := cachectl.PrefetchResult{URL: rawURL}
+
+	// Prefetched URLs are wanted in the cache, they don't have to be requested often enough first.
+	req, err := newProxyRequest(withoutRequestCount(ctx), rawURL)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	metrics.Global.Requests.PrefetchRequests.Increment()
+
+	// Prefetches go through the same path as client requests, so they're keyed and coalesced the same way.
+	key := cache.MakeFromRequest(req)
+	clientHd := headers.ParseHeaderDirective(req.Header)
+
+	fetched, err := p.fetch.dedupFetch(req, key, clientHd)
+	if err != nil {
+		slog.Warn("Failed to prefetch URL", "url", rawURL, "error", err)
+		result.Error = err.Error()
+		return result
+	}
+
+	result.Cache = fetched.getFetchInfo().Status.String()
+	switch fetched.Type {
+	case fetchTypeCached:
+		result.Status = http.StatusOK
+		result.Stored = !fetched.Cached.Transient
+		if fetched.Cached.Entry != nil {
+			result.Bytes = fetched.Cached.Entry.Metadata.Size
+			if fetched.Cached.Entry.Data != nil {
+				fetched.Cached.Entry.Data.Close()
+			}
+		}
+	case fetchTypeDirect:
+		// Responses that weren't cached don't need to be downloaded.
+		result.Status = fetched.Direct.UpstreamStatus
+		fetched.Direct.Response.Body.Close()
+	}
+
+	slog.Debug("Prefetched URL", "url", rawURL, "status", result.Status, "cache", result.Cache, "stored", result.Stored)
+	return result
+}
+
+// Builds a request that looks like one received from a client of the proxy for the given absolute URL.
+func newProxyRequest(ctx context.Context, rawURL string) (*http.Request, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", cachectl.ErrInvalidPrefetchURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%w: expected an absolute http or https URL", cachectl.ErrInvalidPrefetchURL)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", cachectl.ErrInvalidPrefetchURL, err)
+	}
+	req.Host = u.Host
+	return req, nil
+}
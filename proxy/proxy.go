@@ -25,10 +25,13 @@ var (
 )
 
 type cachedRequestInfo struct {
+	URL          string // The upstream URL the response was fetched from.
 	ETag         string
 	LastModified time.Time
 	Header       http.Header
 	Vary         []string
+	Compressed   bool     // Set if the proxy compressed the body itself before storing it.
+	Tags         []string // Surrogate keys the entry can be purged by.
 }
 
 type Proxy struct {
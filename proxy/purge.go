@@ -0,0 +1,266 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"path"
+	"regexp"
+	"reservoir/cache"
+	"reservoir/cachectl"
+	"reservoir/metrics"
+	"reservoir/proxy/responder"
+	"reservoir/utils/hostmatch"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const methodPurge = "PURGE"
+
+var ErrPurgeFailed = errors.New("error purging cache entries")
+
+type purgeMatcher struct {
+	url    string
+	host   string
+	prefix string
+	regex  *regexp.Regexp
+}
+
+func newPurgeMatcher(filter cachectl.PurgeFilter) (*purgeMatcher, error) {
+	if filter.URL == "" && filter.Host == "" && filter.Prefix == "" && filter.Regex == "" && filter.Tag == "" {
+		return nil, fmt.Errorf("%w: at least one of url, host, prefix, regex or tag is required", cachectl.ErrInvalidPurgeFilter)
+	}
+
+	m, err := compileEntryMatcher(filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", cachectl.ErrInvalidPurgeFilter, err)
+	}
+	return m, nil
+}
+
+// Compiles the URL criteria of a filter. Without any criteria, every entry matches.
+func compileEntryMatcher(filter cachectl.PurgeFilter) (*purgeMatcher, error) {
+	m := &purgeMatcher{host: strings.TrimSpace(filter.Host)}
+	if filter.URL != "" {
+		u, err := parsePurgeURL(filter.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid url: %v", err)
+		}
+		m.url = canonicalPurgeURL(u)
+	}
+	if filter.Prefix != "" {
+		u, err := parsePurgeURL(filter.Prefix)
+		if err != nil {
+			return nil, fmt.Errorf("invalid prefix: %v", err)
+		}
+		m.prefix = rawPurgeURL(u)
+	}
+	if filter.Regex != "" {
+		regex, err := regexp.Compile(filter.Regex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex: %v", err)
+		}
+		m.regex = regex
+	}
+	return m, nil
+}
+
+// Reports whether the matcher checks the URL of entries, otherwise every entry matches.
+func (m *purgeMatcher) hasURLCriteria() bool {
+	return m.url != "" || m.host != "" || m.prefix != "" || m.regex != nil
+}
+
+func (m *purgeMatcher) matches(rawURL string) bool {
+	if !m.hasURLCriteria() {
+		return true
+	}
+	if rawURL == "" {
+		return false
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return false
+	}
+
+	if m.url != "" && canonicalPurgeURL(u) != m.url {
+		return false
+	}
+	if m.host != "" && !hostmatch.Match(m.host, u.Host) {
+		return false
+	}
+	if m.prefix != "" && !strings.HasPrefix(rawPurgeURL(u), m.prefix) {
+		return false
+	}
+	if m.regex != nil && !m.regex.MatchString(rawURL) {
+		return false
+	}
+	return true
+}
+
+// Parses a URL given with or without its scheme.
+func parsePurgeURL(rawURL string) (*url.URL, error) {
+	rawURL = strings.TrimSpace(rawURL)
+	if !strings.Contains(rawURL, "://") {
+		rawURL = "http://" + rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Host == "" {
+		return nil, errors.New("missing host")
+	}
+	return u, nil
+}
+
+// Normalizes a URL the same way cache keys are built, so differently written URLs of the same entry match.
+func canonicalPurgeURL(u *url.URL) string {
+	canonical := strings.ToLower(u.Host) + path.Clean("/"+u.Path)
+	if u.RawQuery != "" {
+		canonical += "?" + u.RawQuery
+	}
+	return canonical
+}
+
+// Like canonicalPurgeURL, but keeps the path as is, so prefixes ending in a slash stay meaningful.
+func rawPurgeURL(u *url.URL) string {
+	raw := strings.ToLower(u.Host) + u.EscapedPath()
+	if u.RawQuery != "" {
+		raw += "?" + u.RawQuery
+	}
+	return raw
+}
+
+// Removes, or with a soft purge marks as stale, all cache entries matching the filter and returns how many were purged.
+// Entries stored without their URL, e.g. by older versions, never match URL criteria.
+func (p *Proxy) Purge(filter cachectl.PurgeFilter) (int, error) {
+	matcher, err := newPurgeMatcher(filter)
+	if err != nil {
+		return 0, err
+	}
+
+	matched := p.matchEntryKeys(filter.Tag, matcher)
+
+	now := time.Now()
+	purge := p.cache.Delete
+	if filter.Soft {
+		purge = func(key cache.CacheKey) error {
+			return p.cache.UpdateMetadata(key, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
+				if meta.Expires.After(now) {
+					meta.Expires = now
+				}
+			})
+		}
+	}
+
+	purged := 0
+	var errs []error
+	for _, key := range matched {
+		if err := purge(key); err != nil {
+			if errors.Is(err, cache.ErrCacheEntryNotFound) {
+				continue // Already evicted or purged concurrently.
+			}
+			errs = append(errs, err)
+			continue
+		}
+		purged++
+	}
+
+	if filter.Soft {
+		metrics.Global.Cache.SoftPurgedEntries.Add(int64(purged))
+	} else {
+		metrics.Global.Cache.PurgedEntries.Add(int64(purged))
+	}
+	slog.Info("Purged cache entries", "url", filter.URL, "host", filter.Host, "prefix", filter.Prefix, "regex", filter.Regex, "tag", filter.Tag, "soft", filter.Soft, "purged", purged)
+	if len(errs) > 0 {
+		return purged, fmt.Errorf("%w: %v", ErrPurgeFailed, errors.Join(errs...))
+	}
+	return purged, nil
+}
+
+// Collects the keys of the entries carrying the tag, if given, and matching the URL criteria.
+// Tagged entries are looked up in the tag index, so purging by tag alone doesn't scan the cache.
+func (p *Proxy) matchEntryKeys(tag string, matcher *purgeMatcher) []cache.CacheKey {
+	if tag != "" && !matcher.hasURLCriteria() {
+		return p.cache.KeysByTag(tag)
+	}
+
+	var tagged map[cache.CacheKey]struct{}
+	if tag != "" {
+		tagged = make(map[cache.CacheKey]struct{})
+		for _, key := range p.cache.KeysByTag(tag) {
+			tagged[key] = struct{}{}
+		}
+	}
+
+	matched := make([]cache.CacheKey, 0)
+	for key, meta := range p.cache.Entries {
+		if tagged != nil {
+			if _, ok := tagged[key]; !ok {
+				continue
+			}
+		}
+		if matcher.matches(meta.Object.URL) {
+			matched = append(matched, key)
+		}
+	}
+	return matched
+}
+
+// Handles a PURGE request sent to the proxy listener, which removes the cached entries of the requested URL.
+func (p *Proxy) handlePurge(r responder.Responder, req *http.Request) error {
+	if !purgeClientAllowed(req.RemoteAddr, p.cfg.Proxy.PurgeAllowedClients.Read().Values()) {
+		slog.Warn("Rejected PURGE request from client that isn't allowed to purge", "remote_addr", req.RemoteAddr, "url", req.URL)
+		return r.WriteError("PURGE not allowed", http.StatusForbidden)
+	}
+
+	target := req.Host + req.URL.EscapedPath()
+	if req.URL.RawQuery != "" {
+		target += "?" + req.URL.RawQuery
+	}
+
+	purged, err := p.Purge(cachectl.PurgeFilter{URL: target})
+	if err != nil {
+		slog.Error("Error handling PURGE request", "url", target, "error", err)
+		if errors.Is(err, cachectl.ErrInvalidPurgeFilter) {
+			return r.WriteError(err.Error(), http.StatusBadRequest)
+		}
+		return r.WriteError("error purging cache entries", http.StatusInternalServerError)
+	}
+
+	status := http.StatusOK
+	if purged == 0 {
+		status = http.StatusNotFound
+	}
+	body := fmt.Sprintf("{\"purged\":%d}\n", purged)
+	r.SetHeader("Content-Type", "application/json")
+	r.SetHeader("Content-Length", strconv.Itoa(len(body)))
+	_, _, err = r.Write(status, strings.NewReader(body))
+	return err
+}
+
+// Reports whether the client address is within one of the allowed IPs or CIDR ranges.
+func purgeClientAllowed(remoteAddr string, allowed []string) bool {
+	addrPort, err := netip.ParseAddrPort(remoteAddr)
+	if err != nil {
+		return false
+	}
+	addr := addrPort.Addr().Unmap()
+
+	for _, client := range allowed {
+		if prefix, err := netip.ParsePrefix(client); err == nil {
+			if prefix.Contains(addr) {
+				return true
+			}
+			continue
+		}
+		if allowedAddr, err := netip.ParseAddr(client); err == nil && allowedAddr.Unmap() == addr {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/utils/stringlist"
+	"slices"
+	"testing"
+)
+
+func TestPurgeMatcher(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter cachectl.PurgeFilter
+		url    string
+		want   bool
+	}{
+		{name: "exact url", filter: cachectl.PurgeFilter{URL: "http://deb.debian.org/debian/dists/stable/InRelease"}, url: "https://deb.debian.org/debian/dists/stable/InRelease", want: true},
+		{name: "url without scheme", filter: cachectl.PurgeFilter{URL: "DEB.debian.org/debian//dists/stable/InRelease"}, url: "https://deb.debian.org/debian/dists/stable/InRelease", want: true},
+		{name: "url with different query", filter: cachectl.PurgeFilter{URL: "deb.debian.org/file?a=1"}, url: "https://deb.debian.org/file?a=2", want: false},
+		{name: "host", filter: cachectl.PurgeFilter{Host: "deb.debian.org"}, url: "https://deb.debian.org/debian/pool/a.deb", want: true},
+		{name: "host wildcard", filter: cachectl.PurgeFilter{Host: "*.ubuntu.com"}, url: "http://archive.ubuntu.com/ubuntu/dists/noble/Release", want: true},
+		{name: "other host", filter: cachectl.PurgeFilter{Host: "deb.debian.org"}, url: "https://security.debian.org/a.deb", want: false},
+		{name: "prefix", filter: cachectl.PurgeFilter{Prefix: "deb.debian.org/debian/dists/"}, url: "https://deb.debian.org/debian/dists/stable/InRelease", want: true},
+		{name: "prefix outside", filter: cachectl.PurgeFilter{Prefix: "https://deb.debian.org/debian/dists/"}, url: "https://deb.debian.org/debian/pool/a.deb", want: false},
+		{name: "regex", filter: cachectl.PurgeFilter{Regex: `/InRelease$`}, url: "https://deb.debian.org/debian/dists/stable/InRelease", want: true},
+		{name: "all fields must match", filter: cachectl.PurgeFilter{Host: "deb.debian.org", Regex: `\.deb$`}, url: "https://deb.debian.org/debian/dists/stable/InRelease", want: false},
+		{name: "entry without url", filter: cachectl.PurgeFilter{Host: "*"}, url: "", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := newPurgeMatcher(tt.filter)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got := matcher.matches(tt.url); got != tt.want {
+				t.Fatalf("matches(%q) = %t, want %t", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurgeMatcherRejectsInvalidFilters(t *testing.T) {
+	filters := []cachectl.PurgeFilter{
+		{},
+		{Regex: "("},
+		{URL: "http:///path-without-host"},
+	}
+	for _, filter := range filters {
+		if _, err := newPurgeMatcher(filter); err == nil {
+			t.Fatalf("expected filter %+v to be rejected", filter)
+		}
+	}
+}
+
+func TestPurgeClientAllowed(t *testing.T) {
+	allowed := []string{"127.0.0.0/8", "::1", "192.168.1.10"}
+
+	tests := []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{remoteAddr: "127.0.0.1:5000", want: true},
+		{remoteAddr: "[::1]:5000", want: true},
+		{remoteAddr: "[::ffff:127.0.0.1]:5000", want: true},
+		{remoteAddr: "192.168.1.10:5000", want: true},
+		{remoteAddr: "192.168.1.11:5000", want: false},
+		{remoteAddr: "", want: false},
+	}
+	for _, tt := range tests {
+		if got := purgeClientAllowed(tt.remoteAddr, allowed); got != tt.want {
+			t.Fatalf("purgeClientAllowed(%q) = %t, want %t", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+func TestEntryTags(t *testing.T) {
+	cfg := config.NewDefault()
+	cfg.Proxy.TagRules.Overwrite(stringlist.New("*.ubuntu.com/ubuntu/dists/noble=noble", "security.ubuntu.com=security,noble"))
+	f := &fetcher{cfg: cfg}
+
+	header := http.Header{}
+	header.Add("Surrogate-Key", "release  index")
+	header.Add("Cache-Tag", "ubuntu, release")
+
+	tests := []struct {
+		url  string
+		want []string
+	}{
+		{url: "http://archive.ubuntu.com/ubuntu/dists/noble/InRelease", want: []string{"index", "noble", "release", "ubuntu"}},
+		{url: "http://archive.ubuntu.com/ubuntu/dists/jammy/InRelease", want: []string{"index", "release", "ubuntu"}},
+		{url: "http://security.ubuntu.com/ubuntu/pool/a.deb", want: []string{"index", "noble", "release", "security", "ubuntu"}},
+	}
+	for _, tt := range tests {
+		u, _ := url.Parse(tt.url)
+		if got := f.entryTags(u, header); !slices.Equal(got, tt.want) {
+			t.Fatalf("entryTags(%q) = %v, want %v", tt.url, got, tt.want)
+		}
+	}
+
+	if got := f.entryTags(&url.URL{Host: "deb.debian.org", Path: "/"}, http.Header{}); got != nil {
+		t.Fatalf("expected untagged response to have no tags, got %v", got)
+	}
+}
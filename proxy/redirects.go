@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"reservoir/metrics"
+	"reservoir/utils/hostmatch"
+	"strings"
+)
+
+// Request headers that aren't sent along when a redirect leads to another host.
+var crossHostRedirectHeaders = []string{
+	"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range",
+	"Authorization", "Cookie",
+}
+
+func isRedirectStatus(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// The upstream client never follows redirects on its own, unlike the default client used before hosts could opt in.
+// Redirects are either returned to the client or followed by the fetcher for hosts that opted in,
+// so the final object is cached under the original key.
+func noFollowRedirects(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+func (f *fetcher) shouldFollowRedirects(req *http.Request) bool {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if !f.policy.RequestAllowsSharedCache(req) {
+		return false
+	}
+	return hostmatch.MatchAny(f.cfg.Proxy.FollowRedirects.Hosts.Read().Values(), req.Host)
+}
+
+// Follows the redirect chain starting at resp until a non-redirect response or the hop limit is reached.
+// If the hop limit is reached, the last redirect response is returned as is.
+func (f *fetcher) followRedirects(req *http.Request, resp *http.Response) (*http.Response, error) {
+	maxHops := f.cfg.Proxy.FollowRedirects.MaxHops.Read()
+
+	for hop := 0; isRedirectStatus(resp.StatusCode); hop++ {
+		if hop >= maxHops {
+			slog.Warn("Redirect hop limit reached, returning redirect to client", "url", req.URL, "max_hops", maxHops)
+			return resp, nil
+		}
+
+		location, err := resp.Location()
+		if err != nil {
+			slog.Debug("Redirect response has no usable Location, returning it as is", "url", req.URL, "status", resp.StatusCode, "error", err)
+			return resp, nil
+		}
+		if reason := f.redirectRefused(req.Context(), resp.Request.URL, location); reason != "" {
+			slog.Warn("Not following upstream redirect, returning it to client", "url", req.URL, "location", location, "reason", reason)
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		next := req.Clone(req.Context())
+		next.URL = location
+		next.Host = location.Host
+		next.RequestURI = ""
+		removeHopByHopHeaders(next.Header)
+		if !strings.EqualFold(location.Host, req.Host) {
+			// Validators belong to the entry of the original URL, credentials to the original host.
+			for _, name := range crossHostRedirectHeaders {
+				next.Header.Del(name)
+			}
+		}
+
+		slog.Debug("Following upstream redirect", "url", req.URL, "location", location, "hop", hop+1)
+		resp, err = f.sendToUpstreamHost(next, func() (*http.Response, error) {
+			resp, err := f.client.Do(next)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrSendRequestFailed, err)
+			}
+			return resp, nil
+		})
+		if err != nil {
+			slog.Error("Error following upstream redirect", "url", req.URL, "location", location, "error", err)
+			return nil, err
+		}
+		removeHopByHopHeaders(resp.Header)
+		metrics.Global.Requests.UpstreamRedirectsFollowed.Increment()
+	}
+
+	return resp, nil
+}
+
+// Returns why the redirect from one URL to the next must not be followed, empty if it may be.
+// The followed response is cached under the original URL, so it must not come over a weaker scheme,
+// from a host the config doesn't allow, or from the proxy's own network unless the config lists the host.
+func (f *fetcher) redirectRefused(ctx context.Context, from *url.URL, to *url.URL) string {
+	switch {
+	case to.Scheme != "http" && to.Scheme != "https":
+		return "unsupported scheme"
+	case from.Scheme == "https" && to.Scheme == "http":
+		return "downgrade from https to http"
+	}
+	targets := f.cfg.Proxy.FollowRedirects.TargetHosts.Read().Values()
+	if hostmatch.MatchAny(targets, to.Host) || strings.EqualFold(from.Host, to.Host) {
+		return ""
+	}
+	if len(targets) > 0 {
+		return "target host not allowed"
+	}
+	if resolvesToInternalAddr(ctx, to.Hostname()) {
+		return "target host is an internal address"
+	}
+	return ""
+}
+
+// Reports whether the host is, or resolves to, a loopback, private, link-local or unspecified address.
+// A host that can't be resolved isn't internal, the request to it fails anyway.
+func resolvesToInternalAddr(ctx context.Context, host string) bool {
+	addrs := make([]netip.Addr, 0, 1)
+	if addr, err := netip.ParseAddr(host); err == nil {
+		addrs = append(addrs, addr)
+	} else if resolved, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host); err == nil {
+		addrs = resolved
+	}
+	for _, addr := range addrs {
+		addr = addr.Unmap()
+		if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+			addr.IsInterfaceLocalMulticast() || addr.IsUnspecified() {
+			return true
+		}
+	}
+	return false
+}
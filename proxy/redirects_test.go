@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reservoir/config"
+	"reservoir/utils/stringlist"
+	"strings"
+	"testing"
+)
+
+func TestRedirectRefused(t *testing.T) {
+	cfg := config.NewDefault()
+	f := &fetcher{cfg: cfg}
+
+	tests := []struct {
+		from, to    string
+		targetHosts []string
+		refused     bool
+	}{
+		{from: "https://github.com/a", to: "https://objects.githubusercontent.com/a", refused: false},
+		{from: "http://mirror.test/a", to: "https://cdn.mirror.test/a", refused: false},
+		{from: "https://github.com/a", to: "http://objects.githubusercontent.com/a", refused: true},
+		{from: "https://github.com/a", to: "ftp://objects.githubusercontent.com/a", refused: true},
+		{from: "https://github.com/a", to: "https://objects.githubusercontent.com/a", targetHosts: []string{"*.githubusercontent.com"}, refused: false},
+		{from: "https://github.com/a", to: "https://169.254.169.254/latest", targetHosts: []string{"*.githubusercontent.com"}, refused: true},
+		{from: "https://github.com/a", to: "http://169.254.169.254/latest", refused: true},
+		{from: "http://mirror.test/a", to: "http://127.0.0.1:8080/admin", refused: true},
+		{from: "http://mirror.test/a", to: "http://10.0.0.5/a", refused: true},
+		{from: "http://mirror.test/a", to: "http://[::1]/a", refused: true},
+		{from: "http://mirror.test/a", to: "http://localhost/a", refused: true},
+		{from: "http://mirror.test/a", to: "http://10.0.0.5/a", targetHosts: []string{"10.0.0.5"}, refused: false},
+		{from: "http://10.0.0.5/a", to: "http://10.0.0.5/b", refused: false},
+	}
+	for _, tt := range tests {
+		cfg.Proxy.FollowRedirects.TargetHosts.Overwrite(stringlist.New(tt.targetHosts...))
+		from, _ := url.Parse(tt.from)
+		to, _ := url.Parse(tt.to)
+		if reason := f.redirectRefused(t.Context(), from, to); (reason != "") != tt.refused {
+			t.Errorf("redirectRefused(%q, %q) with target hosts %v = %q, want refused %t", tt.from, tt.to, tt.targetHosts, reason, tt.refused)
+		}
+	}
+}
+
+func TestRedirectToAnotherHostDropsValidators(t *testing.T) {
+	var mirrorHeader http.Header
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mirrorHeader = r.Header.Clone()
+		w.Write([]byte("package"))
+	}))
+	t.Cleanup(mirror.Close)
+	mirrorURL := strings.Replace(mirror.URL, "127.0.0.1", "localhost", 1)
+
+	var originHeader http.Header
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originHeader = r.Header.Clone()
+		http.Redirect(w, r, mirrorURL+r.URL.Path, http.StatusFound)
+	}))
+	t.Cleanup(origin.Close)
+
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeMemory)
+	cfg.Proxy.UpstreamDefaultHttps.Overwrite(false)
+	cfg.Proxy.FollowRedirects.Hosts.Overwrite(stringlist.New("127.0.0.1"))
+	cfg.Proxy.FollowRedirects.TargetHosts.Overwrite(stringlist.New("localhost"))
+	p, err := NewProxyWithUpstreamClient(cfg, nil, nil, t.Context())
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	t.Cleanup(p.Destroy)
+
+	req := httptest.NewRequest(http.MethodGet, origin.URL+"/pool/a.deb", nil)
+	req.Header.Set("If-None-Match", `"v1"`)
+	req.Header.Set("If-Modified-Since", "Mon, 19 Oct 2026 00:00:00 GMT")
+	resp, _, err := p.fetch.sendRequestToUpstream(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the redirect to be followed, got %d", resp.StatusCode)
+	}
+	if originHeader.Get("If-None-Match") == "" {
+		t.Fatal("expected the validators to be sent to the original host")
+	}
+	if mirrorHeader.Get("If-None-Match") != "" || mirrorHeader.Get("If-Modified-Since") != "" {
+		t.Fatalf("expected no validators to be sent to the redirect target, got %v", mirrorHeader)
+	}
+}
@@ -104,6 +104,10 @@ func (c *RawHTTPResponder) Write(status int, body io.Reader) (written int64, wri
 	resp.Body = io.NopCloser(countingreader.New(body, &read))
 	resp.StatusCode = status
 	c.parseAndSetContentLength()
+	if body == http.NoBody {
+		// Bodiless responses to HEAD keep the declared Content-Length of the representation.
+		resp.Request = &http.Request{Method: http.MethodHead}
+	}
 
 	writeDuration, err = c.writeResponse()
 	return int64(read), writeDuration, err
@@ -63,3 +63,23 @@ func TestRawHTTPResponderDoesNotLeakHeadersBetweenResponses(t *testing.T) {
 		t.Fatalf("second response leaked previous header: got %q", got)
 	}
 }
+
+func TestRawHTTPResponderKeepsContentLengthForBodilessResponses(t *testing.T) {
+	var buf bytes.Buffer
+	responder := NewRawHTTPResponder(&buf)
+
+	responder.SetHeader("Content-Length", "42")
+	if _, _, err := responder.Write(http.StatusOK, http.NoBody); err != nil {
+		t.Fatalf("failed to write bodiless response: %v", err)
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(&buf), &http.Request{Method: http.MethodHead})
+	if err != nil {
+		t.Fatalf("failed to read raw response: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.ContentLength != 42 {
+		t.Fatalf("expected Content-Length 42, got %d", resp.ContentLength)
+	}
+}
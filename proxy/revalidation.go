@@ -14,21 +14,57 @@ import (
 func (f *fetcher) handleUpstream304(req *http.Request, key cache.CacheKey) (cached *cache.Entry[cachedRequestInfo], err error) {
 	slog.Debug("Handling 304 response from upstream", "url", req.URL, "key", key)
 
+	if err := f.refreshFreshness(req, key); err != nil {
+		return nil, err
+	}
+	return f.cache.Get(key)
+}
+
+// Marks a cached entry as fresh again after upstream confirmed it is still valid.
+func (f *fetcher) refreshFreshness(req *http.Request, key cache.CacheKey) error {
 	slog.Debug("Revalidating cache metadata...", "url", req.URL, "key", key)
-	err = f.cache.UpdateMetadata(key, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
+	err := f.cache.UpdateMetadata(key, func(meta *cache.EntryMetadata[cachedRequestInfo]) {
 		// Update the metadata to reflect that the cached response is still valid.
 		maxAge := f.cfg.Proxy.CachePolicy.DefaultMaxAge.Read().Cast()
 		meta.Expires = time.Now().Add(maxAge)
 	})
 	if err != nil {
-		return nil, fmt.Errorf("%w: %v", ErrUpdateCacheMetadata, err)
+		return fmt.Errorf("%w: %v", ErrUpdateCacheMetadata, err)
 	}
 
 	slog.Debug("Successfully revalidated cache metadata", "url", req.URL, "key", key)
-	return f.cache.Get(key)
+	return nil
+}
+
+// Sets the conditional headers for revalidating the given cached entry against upstream.
+func setRevalidationHeaders(up *http.Request, meta *cache.EntryMetadata[cachedRequestInfo]) {
+	if meta.Object.ETag != "" {
+		up.Header.Set("If-None-Match", meta.Object.ETag)
+	}
+	if !meta.Object.LastModified.IsZero() {
+		up.Header.Set("If-Modified-Since", meta.Object.LastModified.Format(http.TimeFormat))
+	}
+}
+
+// Asks upstream whether a cached entry is still valid, regardless of its freshness.
+// A 304 refreshes the entry, a new cacheable response replaces it.
+func (f *fetcher) revalidateEntry(req *http.Request, baseKey cache.CacheKey, key cache.CacheKey, meta *cache.EntryMetadata[cachedRequestInfo]) (fetchResult, error) {
+	up := req.Clone(req.Context())
+	setRevalidationHeaders(up, meta)
+
+	return f.fetchUpstream(up, baseKey, key, headers.ParseHeaderDirective(up.Header))
 }
 
 func (f *fetcher) handleCacheMiss(req *http.Request, baseKey cache.CacheKey, lookupKey cache.CacheKey, clientHd *headers.HeaderDirectives) (fetchResult, error) {
+	if f.peers.sharded() {
+		fetched, err := f.fetchFromOwner(req, baseKey)
+		if !errors.Is(err, errOwnedLocally) {
+			return fetched, err
+		}
+	} else if fetched, ok := f.fetchFromPeers(req, baseKey); ok {
+		return fetched, nil
+	}
+
 	slog.Debug("Cache miss, fetching upstream...", "url", req.URL, "key", lookupKey)
 	upFetch, err := f.fetchUpstream(req, baseKey, lookupKey, clientHd)
 	if err != nil {
@@ -77,7 +113,8 @@ func (f *fetcher) getFromCacheOrFetch(req *http.Request, baseKey cache.CacheKey,
 			if err != nil {
 				return fetchResult{}, err
 			}
-			if res.Type == fetchTypeDirect {
+			// Responses the admission policy rejected are passed through rather than fetched again.
+			if res.Type == fetchTypeDirect && !res.Direct.NotAdmitted {
 				res.Direct.Response.Body.Close()
 				return fetchResult{}, ErrNotCacheable
 			}
@@ -108,15 +145,13 @@ func (f *fetcher) getFromCacheOrFetch(req *http.Request, baseKey cache.CacheKey,
 	up := req.Clone(req.Context())
 
 	// Cache is stale: set conditional headers if available
-	if cached.Metadata.Object.ETag != "" {
-		up.Header.Set("If-None-Match", cached.Metadata.Object.ETag)
-	}
-	if !cached.Metadata.Object.LastModified.IsZero() {
-		up.Header.Set("If-Modified-Since", cached.Metadata.Object.LastModified.Format(http.TimeFormat))
-	}
+	setRevalidationHeaders(up, cached.Metadata)
 
 	fetch, err := f.fetchUpstream(up, baseKey, lookupKey, clientHd)
 	if err != nil {
+		if !f.mayServeStaleAfter(err) {
+			return fetchResult{}, err
+		}
 		return f.serveStaleCachedResponse(req, lookupKey, 0, err)
 	}
 	if fetch.Type == fetchTypeDirect {
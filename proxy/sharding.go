@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"reservoir/cache"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/proxy/headers"
+	"time"
+)
+
+// Returned by fetchFromOwner if the miss is handled by this instance.
+var errOwnedLocally = errors.New("cache key is owned by this instance")
+
+// Holds an entry owned by another instance while it is served. It isn't cached here, the file is removed on close.
+type transientEntryData struct {
+	*os.File
+}
+
+func (d transientEntryData) Close() error {
+	err := d.File.Close()
+	_ = os.Remove(d.Name())
+	return err
+}
+
+// In shard mode, forwards a miss to the instance owning the key on the hash ring. The owner fetches it through its own
+// cache, so concurrent misses on all instances share one upstream request, and only the owner stores it.
+// Returns errOwnedLocally if this instance owns the key or every instance before it on the ring is down, and
+// ErrNotCacheable if the owner fetched a response that can't be cached.
+func (f *fetcher) fetchFromOwner(req *http.Request, baseKey cache.CacheKey) (fetchResult, error) {
+	if req.Header.Get(peerHopHeader) != "" {
+		// The sender thinks this instance owns the key. Their rings may disagree while the configuration
+		// changes, answering here keeps the request from bouncing between them.
+		return fetchResult{}, errOwnedLocally
+	}
+
+	self := normalizePeerURL(f.cfg.Proxy.Cluster.Self.Read())
+	for _, owner := range f.peers.hashRing().owners(baseKey) {
+		if owner == self {
+			return fetchResult{}, errOwnedLocally
+		}
+		if f.peers.isDown(owner) {
+			continue
+		}
+
+		entry, err := f.fetchForwarded(req, owner, baseKey)
+		if errors.Is(err, ErrNotCacheable) {
+			f.peers.recordSuccess(owner)
+			return fetchResult{}, err
+		}
+		if err != nil {
+			f.peers.recordFailure(owner, err)
+			continue
+		}
+		f.peers.recordSuccess(owner)
+
+		metrics.Global.Requests.PeerHits.Increment()
+		slog.Debug("Fetched miss through its owner", "url", req.URL, "owner", owner)
+		return fetchResult{
+			Type: fetchTypeCached,
+			Cached: cachedFetchResult{
+				fetchInfo: fetchInfo{Status: hitStatusPeer},
+				Entry:     entry,
+				Transient: true,
+			},
+		}, nil
+	}
+	return fetchResult{}, errOwnedLocally
+}
+
+func (f *fetcher) fetchForwarded(req *http.Request, owner string, baseKey cache.CacheKey) (*cache.Entry[cachedRequestInfo], error) {
+	metrics.Global.Requests.ForwardedMisses.Increment()
+
+	// Requests read from a CONNECT tunnel only carry the path, the owner needs the absolute URL. The scheme is picked
+	// like it is for sending the request upstream.
+	target, err := addrToUrl(req.Host, f.cfg.Proxy.UpstreamDefaultHttps.Read())
+	if err != nil {
+		return nil, err
+	}
+
+	// The owner may have to fetch the response from upstream first, so it gets longer to answer than a lookup.
+	peerURL := owner + peerFetchPath + "?url=" + url.QueryEscape(target.String()+req.URL.RequestURI())
+	body, err := f.peers.request(req, peerURL, f.cfg.Proxy.Cluster.OwnerTimeout.Read().Cast())
+	if err != nil {
+		return nil, err
+	}
+	if body == nil {
+		return nil, fmt.Errorf("%w: cluster mode is disabled on the owner", ErrPeerResponse)
+	}
+	defer body.Close()
+
+	return readPeerEntry(body, req, baseKey, spoolPeerEntry)
+}
+
+func spoolPeerEntry(entry bundle.Entry[cachedRequestInfo]) (*cache.Entry[cachedRequestInfo], error) {
+	spool, err := os.CreateTemp("", "reservoir-peer-*")
+	if err != nil {
+		return nil, err
+	}
+	data := transientEntryData{File: spool}
+
+	if _, err := io.Copy(spool, entry.Body); err != nil {
+		data.Close()
+		return nil, err
+	}
+	if _, err := spool.Seek(0, io.SeekStart); err != nil {
+		data.Close()
+		return nil, err
+	}
+	return &cache.Entry[cachedRequestInfo]{
+		Data:     data,
+		Metadata: entry.Metadata,
+		Stale:    entry.Metadata.Expires.Before(time.Now()),
+	}, nil
+}
+
+// Fetches a URL through the cache for another instance of a shard mode cluster, as the owner of its key,
+// and writes the entry as a single entry tar bundle to w. The variant is picked from the header.
+// Returns cachectl.ErrPeerFetchNotCacheable without writing anything if the response can't be cached.
+func (p *Proxy) FetchForPeer(ctx context.Context, w io.Writer, rawURL string, secret string, header http.Header) error {
+	cfg := &p.cfg.Proxy.Cluster
+	if !cfg.Enabled.Read() || cfg.Mode.Read() != config.ClusterModeShard {
+		return cachectl.ErrClusterDisabled
+	}
+	if subtle.ConstantTimeCompare([]byte(secret), []byte(cfg.Secret.Read())) != 1 {
+		return cachectl.ErrPeerUnauthorized
+	}
+
+	req, err := newProxyRequest(ctx, rawURL)
+	if err != nil {
+		return err
+	}
+	for _, name := range supportedVaryHeaders {
+		for _, value := range header.Values(name) {
+			req.Header.Add(name, value)
+		}
+	}
+	// Marks the request as forwarded, so it's answered here even if this instance's ring disagrees.
+	req.Header.Set(peerHopHeader, "1")
+
+	metrics.Global.Requests.ForwardedMissesServed.Increment()
+	baseKey := cache.MakeFromRequest(req)
+	fetched, err := p.fetch.dedupFetch(req, baseKey, headers.ParseHeaderDirective(req.Header))
+	if err != nil {
+		return err
+	}
+	if fetched.Type == fetchTypeDirect {
+		fetched.Direct.Response.Body.Close()
+		return cachectl.ErrPeerFetchNotCacheable
+	}
+
+	entry := fetched.Cached.Entry
+	if entry.Data == nil {
+		return cachectl.ErrPeerFetchNotCacheable
+	}
+	key := makeVariantCacheKey(p.fetch.withCanonicalAcceptEncoding(req), baseKey, entry.Metadata.Object.Vary)
+
+	bw, err := bundle.NewWriter[cachedRequestInfo](w, bundle.FormatTar)
+	if err != nil {
+		entry.Data.Close()
+		return err
+	}
+	if _, err := addEntryToBundle(bw, key, entry); err != nil {
+		return err
+	}
+	return bw.Close()
+}
@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reservoir/cache"
+	"reservoir/config"
+	"reservoir/utils/duration"
+	"reservoir/utils/stringlist"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHashRingOwners(t *testing.T) {
+	members := []string{"http://10.0.1.11:8080", "http://10.0.1.12:8080", "http://10.0.1.13:8080"}
+	ring := newHashRing(members)
+
+	counts := make(map[string]int)
+	for i := range 3000 {
+		owners := ring.owners(cache.FromString(strconv.Itoa(i)))
+		if len(owners) != len(members) {
+			t.Fatalf("expected every member in the owner order, got %v", owners)
+		}
+		counts[owners[0]]++
+	}
+	for _, member := range members {
+		if counts[member] < 700 || counts[member] > 1300 {
+			t.Fatalf("expected the keys to be spread evenly, got %v", counts)
+		}
+	}
+}
+
+func TestHashRingRebalancesOnlyMovedKeys(t *testing.T) {
+	before := newHashRing([]string{"http://a:8080", "http://b:8080", "http://c:8080"})
+	after := newHashRing([]string{"http://a:8080", "http://b:8080", "http://c:8080", "http://d:8080"})
+
+	moved := 0
+	for i := range 3000 {
+		key := cache.FromString(strconv.Itoa(i))
+		oldOwner, newOwner := before.owners(key)[0], after.owners(key)[0]
+		if oldOwner != newOwner {
+			if newOwner != "http://d:8080" {
+				t.Fatalf("key %d moved from %s to %s instead of the new member", i, oldOwner, newOwner)
+			}
+			moved++
+		}
+	}
+	if moved < 450 || moved > 1050 {
+		t.Fatalf("expected about a quarter of the keys to move to the new member, %d did", moved)
+	}
+}
+
+// Starts two shard mode instances and returns the one owning the key of rawURL first.
+func newShardTestInstances(t *testing.T, rawURL string) (owner, nonOwner *Proxy) {
+	t.Helper()
+
+	// The instances are only known once their webservers listen, the handlers look them up when called.
+	var instances [2]*Proxy
+	var servers [2]*httptest.Server
+	for i := range servers {
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			servePeer(w, r, instances[i])
+		}))
+		t.Cleanup(servers[i].Close)
+	}
+	for i := range instances {
+		instances[i] = newClusterTestProxy(t, servers[1-i].URL)
+		instances[i].cfg.Proxy.Cluster.Mode.Overwrite(config.ClusterModeShard)
+		instances[i].cfg.Proxy.Cluster.Self.Overwrite(servers[i].URL)
+	}
+
+	_, baseKey := newClusterRequest(t, &instances[0].fetch, rawURL)
+	owner, nonOwner = instances[0], instances[1]
+	if instances[0].fetch.peers.hashRing().owners(baseKey)[0] != servers[0].URL {
+		owner, nonOwner = instances[1], instances[0]
+	}
+	if got, want := nonOwner.fetch.peers.hashRing().owners(baseKey), owner.fetch.peers.hashRing().owners(baseKey); got[0] != want[0] {
+		t.Fatalf("expected both instances to agree on the owner, got %v and %v", got, want)
+	}
+	return owner, nonOwner
+}
+
+func newShardTestOrigin(t *testing.T) (*httptest.Server, *atomic.Int64) {
+	t.Helper()
+
+	var requests atomic.Int64
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		fmt.Fprint(w, "package")
+	}))
+	t.Cleanup(origin.Close)
+	return origin, &requests
+}
+
+func TestShardModeFetchesThroughOwner(t *testing.T) {
+	useFreshMetrics(t)
+
+	origin, originRequests := newShardTestOrigin(t)
+	rawURL := origin.URL + "/debian/pool/a.deb"
+	owner, nonOwner := newShardTestInstances(t, rawURL)
+
+	forwarded := nonOwner.prefetchOne(t.Context(), rawURL)
+	if forwarded.Error != "" || forwarded.Cache != "peer" || forwarded.Stored {
+		t.Fatalf("expected the non-owner to serve the owner's entry without storing it, got %+v", forwarded)
+	}
+	if entries := nonOwner.cache.Stats().Entries; entries != 0 {
+		t.Fatalf("expected the non-owner's cache to stay empty, it has %d entries", entries)
+	}
+
+	local := owner.prefetchOne(t.Context(), rawURL)
+	if local.Error != "" || local.Cache != "hit" {
+		t.Fatalf("expected the owner to have cached the forwarded miss, got %+v", local)
+	}
+	if got := originRequests.Load(); got != 1 {
+		t.Fatalf("expected a single upstream request, got %d", got)
+	}
+}
+
+func TestShardModeForwardsTunnelRequests(t *testing.T) {
+	useFreshMetrics(t)
+
+	origin, originRequests := newShardTestOrigin(t)
+	owner, nonOwner := newShardTestInstances(t, origin.URL+"/debian/pool/a.deb")
+
+	// Requests read from a CONNECT tunnel only carry the path.
+	host := strings.TrimPrefix(origin.URL, "http://")
+	req, err := http.ReadRequest(bufio.NewReader(strings.NewReader("GET /debian/pool/a.deb HTTP/1.1\r\nHost: " + host + "\r\n\r\n")))
+	if err != nil {
+		t.Fatalf("failed to read request: %v", err)
+	}
+	req = nonOwner.fetch.withCanonicalAcceptEncoding(req)
+
+	fetched, err := nonOwner.fetch.fetchFromOwner(req, cache.MakeFromRequest(req))
+	if err != nil {
+		t.Fatalf("expected the owner to answer the tunnel request, got %v", err)
+	}
+	fetched.Cached.Entry.Data.Close()
+	if fetched.Cached.Status != hitStatusPeer {
+		t.Fatalf("expected the entry of the owner, got %+v", fetched.Cached.fetchInfo)
+	}
+	if owner.cache.Stats().Entries != 1 || originRequests.Load() != 1 {
+		t.Fatalf("expected the owner to fetch and store the entry once, it has %d entries after %d upstream requests", owner.cache.Stats().Entries, originRequests.Load())
+	}
+	if nonOwner.fetch.peers.isDown(owner.cfg.Proxy.Cluster.Self.Read()) {
+		t.Fatal("expected the owner not to be marked as down")
+	}
+}
+
+func TestShardModeFallsBackWhenOwnerIsDown(t *testing.T) {
+	useFreshMetrics(t)
+
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+
+	p := newClusterTestProxy(t, down.URL)
+	p.cfg.Proxy.Cluster.Mode.Overwrite(config.ClusterModeShard)
+	p.cfg.Proxy.Cluster.Self.Overwrite("http://127.0.0.1:1")
+	p.cfg.Proxy.Cluster.MaxFailures.Overwrite(1)
+
+	// Find a key the unreachable peer owns.
+	var req *http.Request
+	var baseKey cache.CacheKey
+	for i := 0; ; i++ {
+		req, baseKey = newClusterRequest(t, &p.fetch, "http://deb.debian.org/"+strconv.Itoa(i))
+		if p.fetch.peers.hashRing().owners(baseKey)[0] == down.URL {
+			break
+		}
+	}
+
+	if _, err := p.fetch.fetchFromOwner(req, baseKey); err != errOwnedLocally {
+		t.Fatalf("expected the miss to be handled locally while the owner is down, got %v", err)
+	}
+	if !p.fetch.peers.isDown(down.URL) {
+		t.Fatal("expected the unreachable owner to be marked as down")
+	}
+
+	p.cfg.Proxy.Cluster.Peers.Overwrite(stringlist.New(down.URL, "http://127.0.0.1:2"))
+	if members := len(p.fetch.peers.hashRing().members); members != 3 {
+		t.Fatalf("expected the ring to be rebuilt with 3 members, got %d", members)
+	}
+}
+
+func TestShardModeFallsBackWhenOwnerHangs(t *testing.T) {
+	useFreshMetrics(t)
+
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(hung.Close)
+	t.Cleanup(func() { close(release) })
+
+	p := newClusterTestProxy(t, hung.URL)
+	p.cfg.Proxy.Cluster.Mode.Overwrite(config.ClusterModeShard)
+	p.cfg.Proxy.Cluster.Self.Overwrite("http://127.0.0.1:1")
+	p.cfg.Proxy.Cluster.OwnerTimeout.Overwrite(duration.Duration(50 * time.Millisecond))
+	p.cfg.Proxy.Cluster.MaxFailures.Overwrite(1)
+
+	var req *http.Request
+	var baseKey cache.CacheKey
+	for i := 0; ; i++ {
+		req, baseKey = newClusterRequest(t, &p.fetch, "http://deb.debian.org/"+strconv.Itoa(i))
+		if p.fetch.peers.hashRing().owners(baseKey)[0] == hung.URL {
+			break
+		}
+	}
+
+	start := time.Now()
+	if _, err := p.fetch.fetchFromOwner(req, baseKey); err != errOwnedLocally {
+		t.Fatalf("expected the miss to be handled locally once the owner timed out, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the owner timeout to end the wait, it took %s", elapsed)
+	}
+	if !p.fetch.peers.isDown(hung.URL) {
+		t.Fatal("expected the owner that timed out to be marked as down")
+	}
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"reservoir/config"
+	"reservoir/utils/hostmatch"
+	"slices"
+	"strings"
+)
+
+const (
+	surrogateKeyHeader = "Surrogate-Key" // Space separated, as sent by Fastly style origins.
+	cacheTagHeader     = "Cache-Tag"     // Comma separated, as sent by Cloudflare style origins.
+)
+
+func (i cachedRequestInfo) CacheTags() []string {
+	return i.Tags
+}
+
+// Collects the tags of a response from its upstream headers and the configured tag rules.
+func (f *fetcher) entryTags(u *url.URL, header http.Header) []string {
+	tags := make([]string, 0)
+	for _, value := range header.Values(surrogateKeyHeader) {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	for _, value := range header.Values(cacheTagHeader) {
+		for tag := range strings.SplitSeq(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	for _, raw := range f.cfg.Proxy.TagRules.Read().Values() {
+		rule, err := config.ParseTagRule(raw)
+		if err != nil {
+			slog.Warn("Ignoring invalid tag rule", "rule", raw, "error", err)
+			continue
+		}
+		if hostmatch.Match(rule.Host, u.Host) && strings.HasPrefix(u.Path, rule.PathPrefix) {
+			tags = append(tags, rule.Tags...)
+		}
+	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+	slices.Sort(tags)
+	return slices.Compact(tags)
+}
@@ -1,7 +1,10 @@
 package proxy
 
 import (
+	"cmp"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"reservoir/cache"
@@ -22,6 +25,12 @@ func (f *fetcher) handleUpstream200(req *http.Request, resp *http.Response, base
 
 	storeKey := makeVariantCacheKey(req, baseKey, decision.Vary)
 
+	// Responses replacing an entry, e.g. after a revalidation, were admitted before.
+	_, _, lookupErr := f.cache.GetMetadata(storeKey)
+	if !f.admission.admit(req.Context(), req.URL, baseKey, resp.ContentLength, lookupErr != nil) {
+		return nil, errNotAdmitted
+	}
+
 	slog.Debug("Caching response...", "status", resp.Status, "url", req.URL, "key", storeKey, "lookup_key", lookupKey)
 
 	lastModified := time.Now()
@@ -31,15 +40,38 @@ func (f *fetcher) handleUpstream200(req *http.Request, resp *http.Response, base
 
 	etag := resp.Header.Get("ETag")
 
+	var body io.Reader = resp.Body
+	if f.cfg.Proxy.VerifyIntegrity.Read() {
+		body = newIntegrityReader(resp.Body, resp)
+	}
+
 	var bytesRead int
-	reader := countingreader.New(resp.Body, &bytesRead)
+	reader := countingreader.New(body, &bytesRead)
 	cacheReader := cache.WithSizeHint(reader, resp.ContentLength)
 
+	header := resp.Header
+	compressed := f.shouldCompressForStorage(resp)
+	if compressed {
+		slog.Debug("Compressing uncompressed response before storing it", "url", req.URL, "key", storeKey, "content_type", resp.Header.Get("Content-Type"))
+
+		compressedBody, compressedHeader, err := compressForStorage(reader, resp.Header)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrCacheResponseFailed, err)
+		}
+		defer compressedBody.Close()
+
+		header = compressedHeader
+		cacheReader = compressedBody
+	}
+
 	cached, err = f.cache.Cache(storeKey, cacheReader, decision.Expires, cachedRequestInfo{
+		URL:          req.URL.String(),
 		ETag:         etag,
 		LastModified: lastModified,
-		Header:       resp.Header,
+		Header:       header,
 		Vary:         decision.Vary,
+		Compressed:   compressed,
+		Tags:         f.entryTags(req.URL, resp.Header),
 	})
 	if err != nil {
 		return nil, fmt.Errorf("%w: %v", ErrCacheResponseFailed, err)
@@ -95,10 +127,14 @@ func (f *fetcher) handleUpstreamResponse(req *http.Request, resp *http.Response,
 
 func (f *fetcher) sendRequestToUpstream(req *http.Request) (*http.Response, time.Duration, error) {
 	slog.Debug("Sending request to upstream", "url", req.URL)
-	metrics.Global.Requests.UpstreamRequests.Increment()
 
 	startTime := time.Now()
-	resp, err := sendRequestToTarget(f.client, req, f.cfg.Proxy.UpstreamDefaultHttps.Read())
+	resp, err := f.sendToUpstreamHost(req, func() (*http.Response, error) {
+		return sendRequestToTarget(f.client, req, f.cfg.Proxy.UpstreamDefaultHttps.Read())
+	})
+	if err == nil && isRedirectStatus(resp.StatusCode) && f.shouldFollowRedirects(req) {
+		resp, err = f.followRedirects(req, resp)
+	}
 	latency := time.Since(startTime)
 
 	metrics.Global.Requests.UpstreamRequestLatency.Add(latency.Nanoseconds())
@@ -110,6 +146,36 @@ func (f *fetcher) sendRequestToUpstream(req *http.Request) (*http.Response, time
 	return resp, latency, nil
 }
 
+// Sends a single request to its upstream host through the host's circuit breaker, upstream queue and bandwidth
+// limits. send does the actual round trip. Every followed redirect goes through here for its own host.
+func (f *fetcher) sendToUpstreamHost(req *http.Request, send func() (*http.Response, error)) (*http.Response, error) {
+	host := upstreamHostname(req)
+	done, err := f.breakers.allow(host)
+	if err != nil {
+		slog.Debug("Not sending request to upstream host with an open circuit breaker", "url", req.URL, "host", host)
+		return nil, err
+	}
+	release, err := f.queue.acquire(req)
+	if err != nil {
+		done(nil, err)
+		return nil, err
+	}
+	metrics.Global.Requests.UpstreamRequests.Increment()
+
+	resp, err := send()
+	if err != nil {
+		// Sending wraps the error, the breaker needs to see whether the client went away.
+		done(nil, cmp.Or(req.Context().Err(), err))
+		release()
+		return nil, err
+	}
+	done(resp, nil)
+	resp.Body = releasingBody{ReadCloser: resp.Body, release: release}
+
+	f.bandwidth.shapeUpstream(resp)
+	return resp, nil
+}
+
 func (f *fetcher) fetchUpstream(req *http.Request, baseKey cache.CacheKey, lookupKey cache.CacheKey, clientHd *headers.HeaderDirectives) (fetchResult, error) {
 	slog.Debug("Fetching from upstream...")
 
@@ -120,7 +186,8 @@ func (f *fetcher) fetchUpstream(req *http.Request, baseKey cache.CacheKey, looku
 	}
 
 	cached, err := f.handleUpstreamResponse(req, resp, baseKey, lookupKey, clientHd, false)
-	if err != nil {
+	notAdmitted := errors.Is(err, errNotAdmitted)
+	if err != nil && !notAdmitted {
 		resp.Body.Close()
 		slog.Error("Error handling upstream response after cache miss", "url", req.URL, "error", err)
 		return fetchResult{}, err
@@ -132,7 +199,7 @@ func (f *fetcher) fetchUpstream(req *http.Request, baseKey cache.CacheKey, looku
 		resp.Body = trackFetchedBytes(resp.Body)
 
 		fetchInfo := fetchInfo{UpstreamStatus: resp.StatusCode, Status: hitStatusMiss, UpstreamLatency: upstreamLatency}
-		directRes := directFetchResult{Response: resp, fetchInfo: fetchInfo}
+		directRes := directFetchResult{Response: resp, fetchInfo: fetchInfo, NotAdmitted: notAdmitted}
 		return fetchResult{Type: fetchTypeDirect, Direct: directRes}, nil
 	}
 
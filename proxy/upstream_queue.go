@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/hostmatch"
+	"reservoir/utils/syncmap"
+	"slices"
+	"sync"
+	"time"
+)
+
+var ErrUpstreamQueueTimeout = errors.New("timed out waiting for a free upstream slot")
+
+// Limits the concurrent requests to each upstream host. Requests over the limit wait in a queue per host, which hands
+// free slots to the waiting clients in turn, so a client missing many files at once doesn't hold up the others.
+type upstreamQueue struct {
+	cfg   *config.Config
+	hosts *syncmap.SyncMap[string, *hostQueue]
+}
+
+type hostQueue struct {
+	mu      sync.Mutex
+	active  int
+	waiting map[string][]*queuedRequest // By client.
+	clients []string                    // Clients with waiting requests, in the order they get the next slots.
+}
+
+type queuedRequest struct {
+	ready   chan struct{}
+	granted bool
+}
+
+func newUpstreamQueue(cfg *config.Config) *upstreamQueue {
+	return &upstreamQueue{
+		cfg:   cfg,
+		hosts: syncmap.New[string, *hostQueue](),
+	}
+}
+
+// Waits for a free slot of the request's host. The returned release func frees it again and must be called
+// once the response is done. Returns ErrUpstreamQueueTimeout if no slot got free within the maximum wait.
+func (q *upstreamQueue) acquire(req *http.Request) (release func(), err error) {
+	host := upstreamHostname(req)
+	limit := q.hostLimit(host)
+	if limit == 0 {
+		return func() {}, nil
+	}
+
+	hq := q.hosts.GetOrSet(host, &hostQueue{waiting: make(map[string][]*queuedRequest)})
+	release = sync.OnceFunc(func() { q.release(host, hq) })
+
+	hq.mu.Lock()
+	if hq.active < limit && len(hq.clients) == 0 {
+		hq.active++
+		hq.mu.Unlock()
+		return release, nil
+	}
+	client := clientIP(req.RemoteAddr)
+	waiter := &queuedRequest{ready: make(chan struct{})}
+	if len(hq.waiting[client]) == 0 {
+		hq.clients = append(hq.clients, client)
+	}
+	hq.waiting[client] = append(hq.waiting[client], waiter)
+	hq.mu.Unlock()
+
+	slog.Debug("Waiting for a free upstream slot", "url", req.URL, "host", host, "limit", limit, "client", client)
+	metrics.Global.Requests.UpstreamQueuedRequests.Increment()
+	metrics.Global.Requests.UpstreamQueueLength.Increment()
+
+	startTime := time.Now()
+	timer := time.NewTimer(q.cfg.Proxy.UpstreamQueue.MaxWait.Read().Cast())
+	defer timer.Stop()
+	select {
+	case <-waiter.ready:
+	case <-timer.C:
+		err = ErrUpstreamQueueTimeout
+	case <-req.Context().Done():
+		err = req.Context().Err()
+	}
+	metrics.Global.Requests.UpstreamQueueLength.Decrement()
+	metrics.Global.Requests.UpstreamQueueTime.Add(time.Since(startTime).Nanoseconds())
+
+	if err != nil {
+		hq.mu.Lock()
+		granted := waiter.granted
+		if !granted {
+			hq.remove(client, waiter)
+		}
+		hq.mu.Unlock()
+
+		if !granted {
+			if errors.Is(err, ErrUpstreamQueueTimeout) {
+				slog.Warn("Gave up waiting for a free upstream slot", "url", req.URL, "host", host, "limit", limit)
+				metrics.Global.Requests.UpstreamQueueTimeouts.Increment()
+			}
+			return nil, err
+		}
+		// The slot was handed over while giving up, it's used rather than passed on.
+	}
+	return release, nil
+}
+
+func (q *upstreamQueue) release(host string, hq *hostQueue) {
+	limit := q.hostLimit(host)
+
+	hq.mu.Lock()
+	defer hq.mu.Unlock()
+	hq.active--
+	hq.dispatch(limit)
+}
+
+// Hands free slots to the waiting requests, one client after the other. Must be called with hq.mu held.
+func (hq *hostQueue) dispatch(limit int) {
+	for len(hq.clients) > 0 && (limit == 0 || hq.active < limit) {
+		client := hq.clients[0]
+		queue := hq.waiting[client]
+		next := queue[0]
+
+		hq.clients = hq.clients[1:]
+		if len(queue) > 1 {
+			hq.waiting[client] = queue[1:]
+			hq.clients = append(hq.clients, client) // Waits for its next turn behind the other clients.
+		} else {
+			delete(hq.waiting, client)
+		}
+
+		hq.active++
+		next.granted = true
+		close(next.ready)
+	}
+}
+
+// Removes a request that gave up waiting. Must be called with hq.mu held.
+func (hq *hostQueue) remove(client string, waiter *queuedRequest) {
+	queue := slices.DeleteFunc(hq.waiting[client], func(r *queuedRequest) bool { return r == waiter })
+	if len(queue) > 0 {
+		hq.waiting[client] = queue
+		return
+	}
+	delete(hq.waiting, client)
+	hq.clients = slices.DeleteFunc(hq.clients, func(c string) bool { return c == client })
+}
+
+// Reports whether a stale entry may be served instead of failing after fetching from upstream failed with err.
+func (f *fetcher) mayServeStaleAfter(err error) bool {
+	if errors.Is(err, ErrUpstreamQueueTimeout) {
+		return f.cfg.Proxy.UpstreamQueue.TimeoutAction.Read() == config.QueueTimeoutActionServeStale
+	}
+	return true
+}
+
+// Returns the limit of the first concurrency rule matching the host, or the global one.
+func (q *upstreamQueue) hostLimit(host string) int {
+	for _, raw := range q.cfg.Proxy.UpstreamQueue.HostRules.Read().Values() {
+		rule, err := config.ParseConcurrencyRule(raw)
+		if err != nil {
+			slog.Warn("Ignoring invalid concurrency rule", "rule", raw, "error", err)
+			continue
+		}
+		if hostmatch.Match(rule.Host, host) {
+			return rule.Limit
+		}
+	}
+	return q.cfg.Proxy.UpstreamQueue.MaxPerHost.Read()
+}
+
+// Returns the host a request is sent to, without the port.
+func upstreamHostname(req *http.Request) string {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		return hostname
+	}
+	return host
+}
+
+// Returns the IP of a client address, or the address as is if it has no port.
+func clientIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// Frees the upstream slot of a response once its body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
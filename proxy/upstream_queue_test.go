@@ -0,0 +1,147 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reservoir/config"
+	"reservoir/metrics"
+	"reservoir/utils/duration"
+	"reservoir/utils/stringlist"
+	"testing"
+	"time"
+)
+
+func newQueueTestProxy(t *testing.T, modify func(cfg *config.Config)) *Proxy {
+	t.Helper()
+
+	cfg := config.NewDefault()
+	cfg.Cache.Type.Overwrite(config.CacheTypeMemory)
+	cfg.Proxy.UpstreamDefaultHttps.Overwrite(false)
+	cfg.Proxy.UpstreamQueue.MaxPerHost.Overwrite(1)
+	cfg.Proxy.UpstreamQueue.MaxWait.Overwrite(duration.Duration(50 * time.Millisecond))
+	modify(cfg)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p, err := NewProxyWithUpstreamClient(cfg, nil, nil, ctx)
+	if err != nil {
+		t.Fatalf("failed to create proxy: %v", err)
+	}
+	t.Cleanup(func() {
+		cancel()
+		p.Destroy()
+	})
+	return p
+}
+
+func TestUpstreamQueueTakesTurnsBetweenClients(t *testing.T) {
+	hq := &hostQueue{waiting: make(map[string][]*queuedRequest), active: 1}
+	enqueue := func(client string) *queuedRequest {
+		waiter := &queuedRequest{ready: make(chan struct{})}
+		if len(hq.waiting[client]) == 0 {
+			hq.clients = append(hq.clients, client)
+		}
+		hq.waiting[client] = append(hq.waiting[client], waiter)
+		return waiter
+	}
+	a1, a2, a3 := enqueue("10.0.0.1"), enqueue("10.0.0.1"), enqueue("10.0.0.1")
+	b1 := enqueue("10.0.0.2")
+
+	for i, want := range []*queuedRequest{a1, b1, a2, a3} {
+		hq.active--
+		hq.dispatch(1)
+		select {
+		case <-want.ready:
+		default:
+			t.Fatalf("expected waiter %d to get the free slot", i)
+		}
+	}
+	if len(hq.clients) != 0 || len(hq.waiting) != 0 || hq.active != 1 {
+		t.Fatalf("expected an empty queue with one active request, got %d clients and %d active", len(hq.clients), hq.active)
+	}
+}
+
+func TestUpstreamQueueTimesOut(t *testing.T) {
+	useFreshMetrics(t)
+
+	p := newQueueTestProxy(t, func(cfg *config.Config) {
+		cfg.Proxy.UpstreamQueue.HostRules.Overwrite(stringlist.New("*.debian.org=2"))
+	})
+	queue := p.fetch.queue
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.test/file", nil)
+	release, err := queue.acquire(req)
+	if err != nil {
+		t.Fatalf("expected a free slot, got %v", err)
+	}
+	if _, err := queue.acquire(req); !errors.Is(err, ErrUpstreamQueueTimeout) {
+		t.Fatalf("expected %v while the only slot is taken, got %v", ErrUpstreamQueueTimeout, err)
+	}
+	if got := metrics.Global.Requests.UpstreamQueueTimeouts.Get(); got != 1 {
+		t.Fatalf("expected 1 queue timeout, got %d", got)
+	}
+
+	// Hosts with their own limit don't share the slot.
+	debian := httptest.NewRequest(http.MethodGet, "http://deb.debian.org/debian/file", nil)
+	for range 2 {
+		if _, err := queue.acquire(debian); err != nil {
+			t.Fatalf("expected the host rule to allow 2 requests, got %v", err)
+		}
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := queue.acquire(req)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	release()
+	release() // Releasing twice must not free a second slot.
+	if err := <-done; err != nil {
+		t.Fatalf("expected the waiting request to get the released slot, got %v", err)
+	}
+	if got := metrics.Global.Requests.UpstreamQueuedRequests.Get(); got != 2 {
+		t.Fatalf("expected 2 queued requests, got %d", got)
+	}
+}
+
+func TestUpstreamQueueTimeoutActions(t *testing.T) {
+	for _, tt := range []struct {
+		action config.QueueTimeoutAction
+		want   int
+	}{
+		{action: config.QueueTimeoutActionServeStale, want: http.StatusOK},
+		{action: config.QueueTimeoutActionReject, want: http.StatusServiceUnavailable},
+	} {
+		t.Run(string(tt.action), func(t *testing.T) {
+			unblock := make(chan struct{})
+			origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/slow" {
+					<-unblock
+				}
+				w.Write([]byte("content"))
+			}))
+			t.Cleanup(origin.Close)
+			t.Cleanup(func() { close(unblock) })
+
+			p := newQueueTestProxy(t, func(cfg *config.Config) {
+				cfg.Proxy.CachePolicy.DefaultMaxAge.Overwrite(duration.Duration(time.Millisecond))
+				cfg.Proxy.UpstreamQueue.TimeoutAction.Overwrite(tt.action)
+			})
+
+			if rec := serveProxyRequest(p, origin.URL+"/stale"); rec.Code != http.StatusOK {
+				t.Fatalf("expected the first request to be fetched, got %d", rec.Code)
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			// Takes the only slot of the origin until the test ends.
+			go serveProxyRequest(p, origin.URL+"/slow")
+			time.Sleep(20 * time.Millisecond)
+
+			if rec := serveProxyRequest(p, origin.URL+"/stale"); rec.Code != tt.want {
+				t.Fatalf("expected %d once the queue timed out, got %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
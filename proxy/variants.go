@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"context"
 	"net/http"
 	"reservoir/cache"
 	"strings"
@@ -54,3 +55,16 @@ func (f *fetcher) setVariantIndex(baseKey cache.CacheKey, vary []string) {
 	copy(copied, vary)
 	f.variantIndex.Set(baseKey, copied)
 }
+
+// Registers the Vary headers of an entry stored without a request, e.g. by an import,
+// so requests for its URL look up the right variant.
+func (f *fetcher) indexVariants(info *cachedRequestInfo) {
+	if len(info.Vary) == 0 {
+		return
+	}
+	req, err := newProxyRequest(context.Background(), info.URL)
+	if err != nil {
+		return
+	}
+	f.setVariantIndex(cache.MakeFromRequest(f.withCanonicalAcceptEncoding(req)), info.Vary)
+}
@@ -0,0 +1,115 @@
+package tests
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"reservoir/cachectl"
+	"reservoir/utils/bytesize"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAdmissionWaitsForRepeatedRequests(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Admission.MinRequests.Overwrite(2)
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	env.Start()
+
+	url := env.Upstream.URL + "/pool/a.deb"
+	resp, err := env.Client.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if body := readResponseBody(t, resp); body != "body of /pool/a.deb" {
+		t.Fatalf("expected the rejected response to be passed through, got %q", body)
+	}
+	if page, _ := env.Proxy.ListEntries(cachectl.EntryQuery{}); page.Total != 0 {
+		t.Fatalf("expected nothing to be cached after the first request, got %d entries", page.Total)
+	}
+
+	if got := fetchCacheStatus(t, env, url); got != "MISS" {
+		t.Fatalf("expected the second request to be fetched from upstream, got X-Cache %q", got)
+	}
+	if got := fetchCacheStatus(t, env, url); got != "HIT" {
+		t.Fatalf("expected the response to be cached after the second request, got X-Cache %q", got)
+	}
+	if got := upstreamRequests.Load(); got != 2 {
+		t.Fatalf("expected one upstream request per miss, got %d", got)
+	}
+
+	var results []cachectl.PrefetchResult
+	env.Proxy.Prefetch(context.Background(), []string{env.Upstream.URL + "/pool/b.deb"}, 1, func(result cachectl.PrefetchResult) {
+		results = append(results, result)
+	})
+	if len(results) != 1 || !results[0].Stored {
+		t.Fatalf("expected a prefetch to be cached right away, got %+v", results)
+	}
+}
+
+func TestAdmissionRejectsLargeObjects(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Admission.MaxObjectSize.Overwrite(bytesize.ByteSize(8))
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("response body"))
+	})
+	env.Start()
+
+	url := env.Upstream.URL + "/large"
+	for range 2 {
+		if got := fetchCacheStatus(t, env, url); got != "MISS" {
+			t.Fatalf("expected a response above the size limit to never be cached, got X-Cache %q", got)
+		}
+	}
+	if got := upstreamRequests.Load(); got != 2 {
+		t.Fatalf("expected one upstream request per client request, got %d", got)
+	}
+}
+
+func TestAdmissionPassesRejectedResponseToCoalescedRequest(t *testing.T) {
+	const clients = 5
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Admission.MinRequests.Overwrite(clients + 1) // None of the requests is admitted.
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		time.Sleep(100 * time.Millisecond) // Lets the concurrent requests pile up.
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("response body"))
+	})
+	env.Start()
+
+	var wg sync.WaitGroup
+	for range clients {
+		wg.Go(func() {
+			resp, err := env.Client.Get(env.Upstream.URL + "/coalesced")
+			if err != nil {
+				t.Errorf("request failed: %v", err)
+				return
+			}
+			defer resp.Body.Close()
+			if body, err := io.ReadAll(resp.Body); err != nil || string(body) != "response body" {
+				t.Errorf("expected the upstream body, got %q: %v", body, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	// One of the coalesced requests serves the shared response, the others fetch their own.
+	if got := upstreamRequests.Load(); got != clients {
+		t.Fatalf("expected one upstream request per client request, got %d", got)
+	}
+}
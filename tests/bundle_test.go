@@ -0,0 +1,94 @@
+package tests
+
+import (
+	"bytes"
+	"errors"
+	"net/http"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExportAndImportBundle(t *testing.T) {
+	source := SetupTestEnv(t)
+	var upstreamRequests atomic.Int64
+	source.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	source.Start()
+
+	indexURL := source.Upstream.URL + "/dists/stable/InRelease"
+	packageURL := source.Upstream.URL + "/pool/a.deb"
+	fetchCacheStatus(t, source, indexURL)
+	fetchCacheStatus(t, source, packageURL)
+
+	var archive bytes.Buffer
+	exported, err := source.Proxy.ExportBundle(&archive, bundle.FormatTarZstd, cachectl.ExportFilter{Prefix: source.Upstream.URL + "/pool/"})
+	if err != nil {
+		t.Fatalf("ExportBundle failed: %v", err)
+	}
+	if exported.Entries != 1 {
+		t.Fatalf("expected 1 exported entry, got %d", exported.Entries)
+	}
+
+	// Import from the memory cache of the source into a file cache.
+	target := startRestartableProxy(t, newFileCacheProxyConfig(t))
+	imported, err := target.proxy.ImportBundle(&archive, cachectl.ImportOptions{TTL: 24 * time.Hour})
+	if err != nil {
+		t.Fatalf("ImportBundle failed: %v", err)
+	}
+	if imported.Imported != 1 || imported.Skipped != 0 {
+		t.Fatalf("unexpected import result: %+v", imported)
+	}
+
+	page, err := target.proxy.ListEntries(cachectl.EntryQuery{})
+	if err != nil || len(page.Entries) != 1 {
+		t.Fatalf("expected a single imported entry, got %+v (err %v)", page, err)
+	}
+	if until := time.Until(page.Entries[0].Expires); until < 23*time.Hour {
+		t.Fatalf("expected the TTL to be rewritten, entry expires in %s", until)
+	}
+
+	resp, err := target.client.Get(packageURL)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	if body := readRestartTestBody(t, resp); body != "body of /pool/a.deb" {
+		t.Fatalf("unexpected body %q", body)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Fatalf("expected imported entry to be served from the cache, got X-Cache %q", got)
+	}
+	if got := upstreamRequests.Load(); got != 2 {
+		t.Fatalf("expected only the 2 requests of the source to reach upstream, got %d", got)
+	}
+}
+
+func TestImportBundleRejectsCorruptedBody(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Start()
+
+	fetchCacheStatus(t, env, env.Upstream.URL+"/file")
+
+	var archive bytes.Buffer
+	if _, err := env.Proxy.ExportBundle(&archive, bundle.FormatTar, cachectl.ExportFilter{}); err != nil {
+		t.Fatalf("ExportBundle failed: %v", err)
+	}
+	if err := env.Proxy.ClearCache(); err != nil {
+		t.Fatalf("ClearCache failed: %v", err)
+	}
+
+	corrupted := bytes.Replace(archive.Bytes(), []byte("response body"), []byte("response BODY"), 1)
+	if bytes.Equal(corrupted, archive.Bytes()) {
+		t.Fatal("expected the body to be in the uncompressed archive")
+	}
+
+	_, err := env.Proxy.ImportBundle(bytes.NewReader(corrupted), cachectl.ImportOptions{})
+	if !errors.Is(err, bundle.ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+}
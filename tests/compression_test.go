@@ -0,0 +1,172 @@
+package tests
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"reservoir/proxy/contentcoding"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func gzipBytes(t *testing.T, content []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(content); err != nil {
+		t.Fatalf("failed to gzip content: %v", err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatalf("failed to finish gzip stream: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// Sends a request with the given Accept-Encoding and returns the response with its body decoded.
+func getWithAcceptEncoding(t *testing.T, env *TestEnv, targetURL string, acceptEncoding string) (*http.Response, []byte) {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, targetURL, nil)
+	if err != nil {
+		t.Fatalf("failed to create request: %v", err)
+	}
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+
+	resp, err := env.Client.Do(req)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	decoded, err := contentcoding.NewReader(resp.Header.Get("Content-Encoding"), resp.Body)
+	if err != nil {
+		t.Fatalf("failed to decode %q response: %v", resp.Header.Get("Content-Encoding"), err)
+	}
+	defer decoded.Close()
+
+	body, err := io.ReadAll(decoded)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	return resp, body
+}
+
+func TestCompressionStoresOneRepresentationForAllEncodings(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Compression.Enabled.Overwrite(true)
+
+	content := []byte(strings.Repeat("Package: reservoir\n", 64))
+	compressed := gzipBytes(t, content)
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", "\"packages\"")
+		w.Header().Set("Vary", "Accept-Encoding")
+		if strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Header().Set("Content-Encoding", "gzip")
+			w.Write(compressed)
+			return
+		}
+		w.Write(content)
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/dists/Packages"
+	for _, acceptEncoding := range []string{"identity", "gzip", "br", "zstd", "deflate"} {
+		resp, body := getWithAcceptEncoding(t, env, targetURL, acceptEncoding)
+		if !bytes.Equal(body, content) {
+			t.Fatalf("unexpected decoded body for Accept-Encoding %q", acceptEncoding)
+		}
+
+		wantEncoding := acceptEncoding
+		if acceptEncoding == "identity" || acceptEncoding == "deflate" {
+			wantEncoding = ""
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != wantEncoding {
+			t.Fatalf("expected Content-Encoding %q for Accept-Encoding %q, got %q", wantEncoding, acceptEncoding, got)
+		}
+		if got := resp.Header.Get("Vary"); !strings.EqualFold(got, "Accept-Encoding") {
+			t.Fatalf("expected Vary: Accept-Encoding, got %q", got)
+		}
+
+		wantETag := "W/\"packages\""
+		if acceptEncoding == "gzip" {
+			wantETag = "\"packages\""
+		}
+		if got := resp.Header.Get("ETag"); got != wantETag {
+			t.Fatalf("expected ETag %q for Accept-Encoding %q, got %q", wantETag, acceptEncoding, got)
+		}
+	}
+
+	if got := upstreamRequests.Load(); got != 1 {
+		t.Fatalf("expected a single upstream request for all encodings, got %d", got)
+	}
+}
+
+func TestCompressTextCompressesUncompressedResponsesBeforeStoring(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Compression.Enabled.Overwrite(true)
+	env.Cfg.Proxy.Compression.CompressText.Overwrite(true)
+
+	content := []byte(strings.Repeat("{\"name\": \"reservoir\"}\n", 64))
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("ETag", "\"index\"")
+		w.Write(content)
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/index.json"
+
+	resp, body := getWithAcceptEncoding(t, env, targetURL, "gzip")
+	if !bytes.Equal(body, content) {
+		t.Fatal("unexpected decoded body for gzip response")
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("expected stored response to be gzip encoded, got %q", got)
+	}
+	if got := resp.Header.Get("ETag"); got != "W/\"index\"" {
+		t.Fatalf("expected weak ETag for compressed response, got %q", got)
+	}
+
+	resp, body = getWithAcceptEncoding(t, env, targetURL, "identity")
+	if !bytes.Equal(body, content) {
+		t.Fatal("unexpected body for identity response")
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected identity response, got Content-Encoding %q", got)
+	}
+	if got := resp.Header.Get("ETag"); got != "\"index\"" {
+		t.Fatalf("expected upstream ETag for identity response, got %q", got)
+	}
+}
+
+func TestCompressTextLeavesOtherContentTypesUncompressed(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Compression.Enabled.Overwrite(true)
+	env.Cfg.Proxy.Compression.CompressText.Overwrite(true)
+
+	content := bytes.Repeat([]byte{0x7f, 'E', 'L', 'F'}, 64)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(content)
+	})
+	env.Start()
+
+	resp, body := getWithAcceptEncoding(t, env, env.Upstream.URL+"/package.deb", "gzip")
+	if !bytes.Equal(body, content) {
+		t.Fatal("unexpected body for binary response")
+	}
+	if got := resp.Header.Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected binary response to stay uncompressed, got Content-Encoding %q", got)
+	}
+}
@@ -0,0 +1,66 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"reservoir/cachectl"
+	"testing"
+)
+
+func TestListEntriesReportsURLAndHits(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	env.Start()
+
+	hotURL := env.Upstream.URL + "/pool/hot.deb"
+	coldURL := env.Upstream.URL + "/pool/cold.deb"
+	for range 3 {
+		fetchCacheStatus(t, env, hotURL)
+	}
+	fetchCacheStatus(t, env, coldURL)
+
+	page, err := env.Proxy.ListEntries(cachectl.EntryQuery{Sort: "hits", Descending: true})
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	if page.Total != 2 || len(page.Entries) != 2 {
+		t.Fatalf("expected 2 entries, got %+v", page)
+	}
+
+	hot := page.Entries[0]
+	if hot.URL != hotURL {
+		t.Fatalf("expected most hit entry to be %s, got %s", hotURL, hot.URL)
+	}
+	if hot.Hits != 2 {
+		t.Fatalf("expected 2 hits for the hot entry, got %d", hot.Hits)
+	}
+	if hot.Header.Get("Content-Type") != "text/plain" {
+		t.Fatalf("expected stored headers, got %v", hot.Header)
+	}
+
+	page, err = env.Proxy.ListEntries(cachectl.EntryQuery{Search: "COLD"})
+	if err != nil {
+		t.Fatalf("ListEntries failed: %v", err)
+	}
+	if page.Total != 1 || page.Entries[0].URL != coldURL {
+		t.Fatalf("expected search to find only the cold entry, got %+v", page)
+	}
+
+	_, body, err := env.Proxy.OpenEntryBody(hot.Key)
+	if err != nil {
+		t.Fatalf("OpenEntryBody failed: %v", err)
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read entry body: %v", err)
+	}
+	if string(content) != "body of /pool/hot.deb" {
+		t.Fatalf("unexpected entry body %q", content)
+	}
+}
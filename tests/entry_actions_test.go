@@ -0,0 +1,93 @@
+package tests
+
+import (
+	"io"
+	"net/http"
+	"reservoir/cachectl"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestPinExtendAndRevalidateEntry(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	var version atomic.Int64
+	var conditionalRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		etag := `"v` + strconv.FormatInt(version.Load(), 10) + `"`
+		if r.Header.Get("If-None-Match") != "" {
+			conditionalRequests.Add(1)
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("release " + etag))
+	})
+	env.Start()
+
+	url := env.Upstream.URL + "/dists/stable/InRelease"
+	fetchCacheStatus(t, env, url)
+
+	page, err := env.Proxy.ListEntries(cachectl.EntryQuery{})
+	if err != nil || len(page.Entries) != 1 {
+		t.Fatalf("expected a single entry, got %+v (err %v)", page, err)
+	}
+	key := page.Entries[0].Key
+
+	pinned, err := env.Proxy.PinEntry(key, true)
+	if err != nil {
+		t.Fatalf("PinEntry failed: %v", err)
+	}
+	if !pinned.Pinned {
+		t.Fatal("expected entry to be pinned")
+	}
+
+	extended, err := env.Proxy.ExtendEntryTTL(key, 24*time.Hour)
+	if err != nil {
+		t.Fatalf("ExtendEntryTTL failed: %v", err)
+	}
+	if want := pinned.Expires.Add(24 * time.Hour); !extended.Expires.Equal(want) {
+		t.Fatalf("expected expiry %s, got %s", want, extended.Expires)
+	}
+
+	result, err := env.Proxy.RevalidateEntry(t.Context(), key)
+	if err != nil {
+		t.Fatalf("RevalidateEntry failed: %v", err)
+	}
+	if result.UpstreamStatus != http.StatusNotModified || result.Modified {
+		t.Fatalf("expected unchanged entry to be confirmed with a 304, got %+v", result)
+	}
+	if conditionalRequests.Load() != 1 {
+		t.Fatalf("expected one conditional upstream request, got %d", conditionalRequests.Load())
+	}
+
+	version.Store(1)
+	result, err = env.Proxy.RevalidateEntry(t.Context(), key)
+	if err != nil {
+		t.Fatalf("RevalidateEntry failed: %v", err)
+	}
+	if result.UpstreamStatus != http.StatusOK || !result.Modified {
+		t.Fatalf("expected changed entry to be replaced, got %+v", result)
+	}
+	if !result.Entry.Pinned {
+		t.Fatal("expected replaced entry to stay pinned")
+	}
+
+	_, body, err := env.Proxy.OpenEntryBody(key)
+	if err != nil {
+		t.Fatalf("OpenEntryBody failed: %v", err)
+	}
+	defer body.Close()
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("failed to read entry body: %v", err)
+	}
+	if string(content) != `release "v1"` {
+		t.Fatalf("expected revalidation to store the new body, got %q", content)
+	}
+}
@@ -272,6 +272,61 @@ func TestStaleCacheServedWhenUpstreamIsUnavailable(t *testing.T) {
 	}
 }
 
+func TestHeadRequestRefreshesStaleEntry(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	var getRequests atomic.Int64
+	var headRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=1")
+		w.Header().Set("ETag", "\"head-refresh\"")
+		if r.Method == http.MethodHead {
+			headRequests.Add(1)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		getRequests.Add(1)
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("refreshable body"))
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/head-refresh"
+	resp, err := env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+	readResponseBody(t, resp)
+
+	time.Sleep(1100 * time.Millisecond)
+
+	resp, err = env.Client.Head(targetURL)
+	if err != nil {
+		t.Fatalf("HEAD request failed: %v", err)
+	}
+	resp.Body.Close()
+	if got := resp.Header.Get("X-Cache"); got != "REVALIDATED" {
+		t.Fatalf("expected X-Cache REVALIDATED, got %q", got)
+	}
+	if got := headRequests.Load(); got != 1 {
+		t.Fatalf("expected 1 upstream HEAD, got %d", got)
+	}
+
+	resp, err = env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("GET after refresh failed: %v", err)
+	}
+	if body := readResponseBody(t, resp); body != "refreshable body" {
+		t.Fatalf("unexpected body after refresh: %q", body)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Fatalf("expected refreshed entry to be a fresh hit, got %q", got)
+	}
+	if got := getRequests.Load(); got != 1 {
+		t.Fatalf("expected HEAD refresh to avoid a second GET, got %d upstream GETs", got)
+	}
+}
+
 func readResponseBody(t *testing.T, resp *http.Response) string {
 	t.Helper()
 
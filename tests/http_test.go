@@ -5,7 +5,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/http/httptest"
+	"reservoir/cachectl"
 	"reservoir/metrics"
+	"reservoir/utils/stringlist"
+	"strings"
 	"sync/atomic"
 	"testing"
 )
@@ -270,6 +274,7 @@ func TestUpstream416RetriesWithoutRangeWhenEnabled(t *testing.T) {
 
 func TestVaryAcceptEncodingUsesSeparateCacheVariants(t *testing.T) {
 	env := SetupTestEnv(t)
+	env.Cfg.Proxy.Compression.Enabled.Overwrite(false)
 
 	var upstreamRequests atomic.Int64
 	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -404,6 +409,268 @@ func TestNoStoreCachesWhenAggressivePackageCacheEnabled(t *testing.T) {
 	}
 }
 
+func TestHeadRequestServedFromCachedGetEntry(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", "\"head-etag\"")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("package contents"))
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/head-test.deb"
+	resp, err := env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("warmup failed: %v", err)
+	}
+	resp.Body.Close()
+
+	resp, err = env.Client.Head(targetURL)
+	if err != nil {
+		t.Fatalf("HEAD request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200 OK, got %d", resp.StatusCode)
+	}
+	if resp.ContentLength != int64(len("package contents")) {
+		t.Fatalf("expected Content-Length %d, got %d", len("package contents"), resp.ContentLength)
+	}
+	if got := resp.Header.Get("ETag"); got != "\"head-etag\"" {
+		t.Fatalf("expected cached ETag, got %q", got)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Fatalf("expected X-Cache HIT, got %q", got)
+	}
+	if got := upstreamRequests.Load(); got != 1 {
+		t.Fatalf("expected HEAD to be answered from cache, got %d upstream requests", got)
+	}
+}
+
+func TestHeadRequestWithoutCachedEntryGoesUpstream(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		if r.Method != http.MethodHead {
+			t.Errorf("expected upstream HEAD, got %s", r.Method)
+		}
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.WriteHeader(http.StatusOK)
+	})
+	env.Start()
+
+	for i := 0; i < 2; i++ {
+		resp, err := env.Client.Head(env.Upstream.URL + "/head-miss")
+		if err != nil {
+			t.Fatalf("HEAD request failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200 OK, got %d", resp.StatusCode)
+		}
+	}
+	if got := upstreamRequests.Load(); got != 2 {
+		t.Fatalf("expected HEAD responses to not be cached, got %d upstream requests", got)
+	}
+}
+
+func noClientRedirects(env *TestEnv) {
+	env.Client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+}
+
+func TestRedirectsFollowedAndCachedUnderOriginalURLForOptedInHosts(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.FollowRedirects.Hosts.Overwrite(stringlist.New("127.0.0.1"))
+	noClientRedirects(env)
+
+	var redirectRequests atomic.Int64
+	var finalRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release.tar.gz":
+			redirectRequests.Add(1)
+			http.Redirect(w, r, "/signed/release.tar.gz?sig=abc", http.StatusFound)
+		case "/signed/release.tar.gz":
+			finalRequests.Add(1)
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.WriteHeader(http.StatusOK)
+			w.Write([]byte("release archive"))
+		default:
+			http.NotFound(w, r)
+		}
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/release.tar.gz"
+	for i := 0; i < 2; i++ {
+		resp, err := env.Client.Get(targetURL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response body: %v", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected proxy to follow redirect and return 200, got %d", resp.StatusCode)
+		}
+		if string(body) != "release archive" {
+			t.Fatalf("unexpected response body: %q", body)
+		}
+	}
+
+	if got := redirectRequests.Load(); got != 1 {
+		t.Fatalf("expected original URL to be fetched once, got %d", got)
+	}
+	if got := finalRequests.Load(); got != 1 {
+		t.Fatalf("expected redirect target to be fetched once, got %d", got)
+	}
+}
+
+func TestRedirectsReturnedToClientForOtherHosts(t *testing.T) {
+	env := SetupTestEnv(t)
+	noClientRedirects(env)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	})
+	env.Start()
+
+	resp, err := env.Client.Get(env.Upstream.URL + "/moved")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected redirect to be returned to client, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != "/elsewhere" {
+		t.Fatalf("expected Location /elsewhere, got %q", got)
+	}
+}
+
+// Redirect targets go through their own host's circuit breaker, "localhost" is a different host than "127.0.0.1".
+func TestRedirectTargetsUseTheirOwnCircuitBreaker(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.FollowRedirects.Hosts.Overwrite(stringlist.New("127.0.0.1"))
+	env.Cfg.Proxy.FollowRedirects.TargetHosts.Overwrite(stringlist.New("localhost"))
+	env.Cfg.Proxy.CircuitBreaker.Enabled.Overwrite(true)
+	env.Cfg.Proxy.CircuitBreaker.MaxFailures.Overwrite(1)
+	noClientRedirects(env)
+
+	mirror := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "mirror down", http.StatusServiceUnavailable)
+	}))
+	t.Cleanup(mirror.Close)
+	mirrorURL := strings.Replace(mirror.URL, "127.0.0.1", "localhost", 1)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, mirrorURL+r.URL.Path, http.StatusFound)
+	})
+	env.Start()
+
+	resp, err := env.Client.Get(env.Upstream.URL + "/pool/a.deb")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	breakers := env.Proxy.CircuitBreakers()
+	if len(breakers) != 1 || breakers[0].Host != "localhost" || breakers[0].State != cachectl.BreakerStateOpen {
+		t.Fatalf("expected only the breaker of the redirect target to open, got %+v", breakers)
+	}
+}
+
+func TestRedirectsToHostsOutsideTargetHostsReturnedToClient(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.FollowRedirects.Hosts.Overwrite(stringlist.New("127.0.0.1"))
+	env.Cfg.Proxy.FollowRedirects.TargetHosts.Overwrite(stringlist.New("*.example.com"))
+	noClientRedirects(env)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://internal.test/secret", http.StatusFound)
+	})
+	env.Start()
+
+	resp, err := env.Client.Get(env.Upstream.URL + "/moved")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect to a host outside the target hosts to be returned to client, got %d", resp.StatusCode)
+	}
+}
+
+func TestRedirectsToInternalAddressesReturnedToClient(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.FollowRedirects.Hosts.Overwrite(stringlist.New("127.0.0.1"))
+	noClientRedirects(env)
+
+	var internalRequests atomic.Int64
+	internal := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		internalRequests.Add(1)
+		w.Write([]byte("internal"))
+	}))
+	t.Cleanup(internal.Close)
+	internalURL := strings.Replace(internal.URL, "127.0.0.1", "localhost", 1)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, internalURL+"/admin", http.StatusFound)
+	})
+	env.Start()
+
+	resp, err := env.Client.Get(env.Upstream.URL + "/moved")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect to an internal address to be returned to client, got %d", resp.StatusCode)
+	}
+	if got := internalRequests.Load(); got != 0 {
+		t.Fatalf("expected no request to the internal address, got %d", got)
+	}
+}
+
+func TestRedirectHopLimitReturnsLastRedirect(t *testing.T) {
+	env := SetupTestEnv(t)
+	env.Cfg.Proxy.FollowRedirects.Hosts.Overwrite(stringlist.New("127.0.0.1"))
+	env.Cfg.Proxy.FollowRedirects.MaxHops.Overwrite(2)
+	noClientRedirects(env)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"/next", http.StatusFound)
+	})
+	env.Start()
+
+	resp, err := env.Client.Get(env.Upstream.URL + "/loop")
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("expected last redirect to be returned after hop limit, got %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Location"); got != "/loop/next/next/next" {
+		t.Fatalf("expected redirect returned by the second hop, got Location %q", got)
+	}
+}
+
 func BenchmarkProxyLatencyCold(b *testing.B) {
 	env := SetupTestEnv(b)
 	env.Start()
@@ -0,0 +1,145 @@
+package tests
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"reservoir/metrics"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestResponseWithMismatchedContentMD5IsNotCached(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	content := "Package: reservoir\nVersion: 1.0\n"
+	goodMD5 := md5.Sum([]byte(content))
+	badMD5 := md5.Sum([]byte("something else"))
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		if count == 1 {
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(badMD5[:]))
+		} else {
+			w.Header().Set("Content-MD5", base64.StdEncoding.EncodeToString(goodMD5[:]))
+		}
+		w.Write([]byte(content))
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/dists/Packages"
+	failuresBefore := metrics.Global.Cache.IntegrityFailures.Get()
+
+	resp, err := env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+	readResponseBody(t, resp)
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Fatalf("expected corrupted response to fail with 502, got %d", resp.StatusCode)
+	}
+	if got := metrics.Global.Cache.IntegrityFailures.Get() - failuresBefore; got != 1 {
+		t.Fatalf("expected 1 integrity failure, got %d", got)
+	}
+
+	resp, err = env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("second request failed: %v", err)
+	}
+	if body := readResponseBody(t, resp); body != content {
+		t.Fatalf("unexpected body after upstream recovered: %q", body)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "MISS" {
+		t.Fatalf("expected corrupted response not to be cached, got X-Cache %q", got)
+	}
+
+	resp, err = env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("third request failed: %v", err)
+	}
+	if body := readResponseBody(t, resp); body != content {
+		t.Fatalf("unexpected cached body: %q", body)
+	}
+	if got := resp.Header.Get("X-Cache"); got != "HIT" {
+		t.Fatalf("expected valid response to be cached, got X-Cache %q", got)
+	}
+}
+
+func TestResponseWithMatchingReprDigestIsCached(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	content := "verified body"
+	sum := sha256.Sum256([]byte(content))
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Repr-Digest", "sha-256=:"+base64.StdEncoding.EncodeToString(sum[:])+":")
+		w.Write([]byte(content))
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/verified"
+	for range 2 {
+		resp, err := env.Client.Get(targetURL)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		if body := readResponseBody(t, resp); body != content {
+			t.Fatalf("unexpected body: %q", body)
+		}
+	}
+
+	if got := upstreamRequests.Load(); got != 1 {
+		t.Fatalf("expected verified response to be cached, got %d upstream requests", got)
+	}
+}
+
+func TestCorruptedRevalidationKeepsServingStaleEntry(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	content := "original body"
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=1")
+		if count == 1 {
+			w.Write([]byte(content))
+			return
+		}
+
+		sum := sha256.Sum256([]byte("the body that was meant to be sent"))
+		w.Header().Set("Digest", "sha-256="+base64.StdEncoding.EncodeToString(sum[:]))
+		w.Write([]byte("corrupted mirror body"))
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/stale-on-corruption"
+
+	resp, err := env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("first request failed: %v", err)
+	}
+	if body := readResponseBody(t, resp); body != content {
+		t.Fatalf("unexpected first body: %q", body)
+	}
+
+	time.Sleep(1100 * time.Millisecond)
+
+	resp, err = env.Client.Get(targetURL)
+	if err != nil {
+		t.Fatalf("second request failed: %v", err)
+	}
+	if body := readResponseBody(t, resp); body != content {
+		t.Fatalf("expected stale cached body instead of corrupted response, got %q", body)
+	}
+	if cacheStatus := resp.Header.Get("Cache-Status"); !strings.Contains(cacheStatus, "stale") {
+		t.Fatalf("expected stale Cache-Status, got %q", cacheStatus)
+	}
+}
@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"net/http"
+	"reservoir/cachectl"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestPrefetchedURLsAreServedFromCache(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	env.Start()
+
+	urls := []string{
+		env.Upstream.URL + "/pool/a.deb",
+		env.Upstream.URL + "/pool/b.deb",
+		env.Upstream.URL + "/pool/c.deb",
+		"ftp://mirror.example/pool/d.deb",
+	}
+
+	var mu sync.Mutex
+	results := make(map[string]cachectl.PrefetchResult)
+	env.Proxy.Prefetch(context.Background(), urls, 2, func(result cachectl.PrefetchResult) {
+		mu.Lock()
+		defer mu.Unlock()
+		results[result.URL] = result
+	})
+
+	if len(results) != len(urls) {
+		t.Fatalf("expected a result for every URL, got %d", len(results))
+	}
+	for _, url := range urls[:3] {
+		result := results[url]
+		if result.Error != "" || !result.Stored || result.Cache != "miss" {
+			t.Fatalf("unexpected prefetch result for %s: %+v", url, result)
+		}
+	}
+	if results[urls[3]].Error == "" {
+		t.Fatal("expected invalid URL to be reported as failed")
+	}
+	if got := upstreamRequests.Load(); got != 3 {
+		t.Fatalf("expected 3 upstream requests while prefetching, got %d", got)
+	}
+
+	for _, url := range urls[:3] {
+		resp, err := env.Client.Get(url)
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		readResponseBody(t, resp)
+		if got := resp.Header.Get("X-Cache"); got != "HIT" {
+			t.Fatalf("expected prefetched URL %s to be a cache hit, got X-Cache %q", url, got)
+		}
+	}
+	if got := upstreamRequests.Load(); got != 3 {
+		t.Fatalf("expected no further upstream requests, got %d", got)
+	}
+}
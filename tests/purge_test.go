@@ -0,0 +1,146 @@
+package tests
+
+import (
+	"net/http"
+	"reservoir/cachectl"
+	"reservoir/utils/stringlist"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func fetchCacheStatus(t *testing.T, env *TestEnv, url string) string {
+	t.Helper()
+
+	resp, err := env.Client.Get(url)
+	if err != nil {
+		t.Fatalf("request failed: %v", err)
+	}
+	readResponseBody(t, resp)
+	return resp.Header.Get("X-Cache")
+}
+
+func TestPurgeRemovesOnlyMatchingEntries(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	var upstreamRequests atomic.Int64
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamRequests.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	env.Start()
+
+	indexURL := env.Upstream.URL + "/dists/stable/InRelease"
+	packageURL := env.Upstream.URL + "/pool/a.deb"
+	fetchCacheStatus(t, env, indexURL)
+	fetchCacheStatus(t, env, packageURL)
+
+	purged, err := env.Proxy.Purge(cachectl.PurgeFilter{Prefix: env.Upstream.URL + "/dists/"})
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected 1 purged entry, got %d", purged)
+	}
+
+	if got := fetchCacheStatus(t, env, indexURL); got != "MISS" {
+		t.Fatalf("expected purged index to be fetched again, got X-Cache %q", got)
+	}
+	if got := fetchCacheStatus(t, env, packageURL); got != "HIT" {
+		t.Fatalf("expected package outside the prefix to stay cached, got X-Cache %q", got)
+	}
+	if got := upstreamRequests.Load(); got != 3 {
+		t.Fatalf("expected 3 upstream requests, got %d", got)
+	}
+}
+
+func TestPurgeMethodRemovesRequestedURL(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Write([]byte("index"))
+	})
+	env.Start()
+
+	targetURL := env.Upstream.URL + "/dists/stable/InRelease"
+	fetchCacheStatus(t, env, targetURL)
+
+	purge := func() int {
+		req, err := http.NewRequest("PURGE", targetURL, nil)
+		if err != nil {
+			t.Fatalf("failed to create PURGE request: %v", err)
+		}
+		resp, err := env.Client.Do(req)
+		if err != nil {
+			t.Fatalf("PURGE request failed: %v", err)
+		}
+		readResponseBody(t, resp)
+		return resp.StatusCode
+	}
+
+	if status := purge(); status != http.StatusOK {
+		t.Fatalf("expected PURGE of cached URL to return 200, got %d", status)
+	}
+	if status := purge(); status != http.StatusNotFound {
+		t.Fatalf("expected PURGE of uncached URL to return 404, got %d", status)
+	}
+	if got := fetchCacheStatus(t, env, targetURL); got != "MISS" {
+		t.Fatalf("expected purged URL to be fetched again, got X-Cache %q", got)
+	}
+
+	env.Cfg.Proxy.PurgeAllowedClients.Overwrite(stringlist.New("192.0.2.1"))
+	if status := purge(); status != http.StatusForbidden {
+		t.Fatalf("expected PURGE from a client that isn't allowed to return 403, got %d", status)
+	}
+}
+
+func TestPurgeByTag(t *testing.T) {
+	env := SetupTestEnv(t)
+
+	env.Upstream.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("ETag", `"v1"`)
+		if strings.HasPrefix(r.URL.Path, "/dists/") {
+			w.Header().Set("Surrogate-Key", "release stable")
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte("body of " + r.URL.Path))
+	})
+	env.Cfg.Proxy.TagRules.Overwrite(stringlist.New("127.0.0.1/pool=pool"))
+	env.Start()
+
+	indexURL := env.Upstream.URL + "/dists/stable/InRelease"
+	packageURL := env.Upstream.URL + "/pool/a.deb"
+	fetchCacheStatus(t, env, indexURL)
+	fetchCacheStatus(t, env, packageURL)
+
+	purged, err := env.Proxy.Purge(cachectl.PurgeFilter{Tag: "release", Soft: true})
+	if err != nil {
+		t.Fatalf("soft purge failed: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected 1 soft purged entry, got %d", purged)
+	}
+	if got := fetchCacheStatus(t, env, indexURL); got != "REVALIDATED" {
+		t.Fatalf("expected soft purged entry to be revalidated, got X-Cache %q", got)
+	}
+
+	purged, err = env.Proxy.Purge(cachectl.PurgeFilter{Tag: "pool"})
+	if err != nil {
+		t.Fatalf("purge failed: %v", err)
+	}
+	if purged != 1 {
+		t.Fatalf("expected 1 purged entry, got %d", purged)
+	}
+	if got := fetchCacheStatus(t, env, packageURL); got != "MISS" {
+		t.Fatalf("expected entry tagged by a config rule to be purged, got X-Cache %q", got)
+	}
+	if got := fetchCacheStatus(t, env, indexURL); got != "HIT" {
+		t.Fatalf("expected entry with other tags to stay cached, got X-Cache %q", got)
+	}
+}
@@ -0,0 +1,42 @@
+package hostmatch
+
+import (
+	"net"
+	"strings"
+)
+
+// Removes the port from a host, if present.
+func StripPort(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// Reports whether the host matches the pattern.
+// A pattern is either an exact host name or a "*." wildcard that matches any subdomain of the rest of the pattern.
+func Match(pattern string, host string) bool {
+	pattern = strings.ToLower(strings.TrimSpace(pattern))
+	host = strings.ToLower(StripPort(host))
+	if pattern == "" || host == "" {
+		return false
+	}
+	if pattern == "*" {
+		return true
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		return strings.HasSuffix(host, "."+suffix)
+	}
+	return host == StripPort(pattern)
+}
+
+// Reports whether the host matches any of the patterns.
+func MatchAny(patterns []string, host string) bool {
+	for _, pattern := range patterns {
+		if Match(pattern, host) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,26 @@
+package hostmatch
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		host    string
+		want    bool
+	}{
+		{pattern: "example.com", host: "example.com", want: true},
+		{pattern: "example.com", host: "EXAMPLE.com:443", want: true},
+		{pattern: "example.com", host: "cdn.example.com", want: false},
+		{pattern: "*.example.com", host: "cdn.example.com", want: true},
+		{pattern: "*.example.com", host: "a.b.example.com:80", want: true},
+		{pattern: "*.example.com", host: "example.com", want: false},
+		{pattern: "*", host: "anything.test", want: true},
+		{pattern: "", host: "example.com", want: false},
+	}
+
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("Match(%q, %q) = %t, want %t", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package stringlist
+
+// A comparable list of strings that marshals to and from JSON as an array, so it can be used in a ConfigProp.
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+)
+
+const separator = "\x00"
+
+type StringList struct {
+	joined string
+}
+
+func New(values ...string) StringList {
+	return StringList{joined: strings.Join(values, separator)}
+}
+
+func (l StringList) Values() []string {
+	if l.joined == "" {
+		return []string{}
+	}
+	return strings.Split(l.joined, separator)
+}
+
+func (l StringList) Len() int {
+	return len(l.Values())
+}
+
+func (l StringList) Contains(value string) bool {
+	return slices.Contains(l.Values(), value)
+}
+
+func (l StringList) MarshalJSON() ([]byte, error) {
+	return json.Marshal(l.Values())
+}
+
+func (l *StringList) UnmarshalJSON(data []byte) error {
+	var values []string
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	*l = New(values...)
+	return nil
+}
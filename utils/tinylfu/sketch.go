@@ -0,0 +1,86 @@
+// A TinyLFU frequency sketch: approximate access counts for an unbounded set of keys in fixed memory.
+package tinylfu
+
+import (
+	"math/bits"
+	"sync"
+)
+
+const (
+	depth      = 4
+	MaxCount   = 15 // Counters saturate at this value.
+	resetRatio = 10 // The counters are halved after width * resetRatio increments.
+)
+
+// Count-min sketch with 4-bit counters stored in bytes. Estimates never undercount, but may overcount
+// when keys collide. Halving every counter once enough increments were seen lets old popularity fade.
+type Sketch struct {
+	mu         sync.Mutex
+	rows       [depth][]uint8
+	mask       uint64
+	increments int
+	resetAt    int
+}
+
+var rowSeeds = [depth]uint64{0x9e3779b97f4a7c15, 0xbf58476d1ce4e5b9, 0x94d049bb133111eb, 0xd6e8feb86659fd93}
+
+// Creates a sketch with width counters per row, rounded up to a power of two.
+func New(width int) *Sketch {
+	width = 1 << bits.Len(uint(max(width, 2)-1))
+	s := &Sketch{
+		mask:    uint64(width - 1),
+		resetAt: width * resetRatio,
+	}
+	for i := range s.rows {
+		s.rows[i] = make([]uint8, width)
+	}
+	return s
+}
+
+func (s *Sketch) index(row int, hash uint64) uint64 {
+	h := (hash ^ rowSeeds[row]) * rowSeeds[(row+1)%depth]
+	return (h >> 32) & s.mask
+}
+
+// Counts one occurrence of the key with the given hash and returns its new estimate.
+func (s *Sketch) Increment(hash uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := MaxCount
+	for row := range s.rows {
+		i := s.index(row, hash)
+		if s.rows[row][i] < MaxCount {
+			s.rows[row][i]++
+		}
+		estimate = min(estimate, int(s.rows[row][i]))
+	}
+
+	s.increments++
+	if s.increments >= s.resetAt {
+		s.reset()
+	}
+	return estimate
+}
+
+// Returns how often the key with the given hash was counted, at most MaxCount.
+func (s *Sketch) Estimate(hash uint64) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	estimate := MaxCount
+	for row := range s.rows {
+		estimate = min(estimate, int(s.rows[row][s.index(row, hash)]))
+	}
+	return estimate
+}
+
+// Halves every counter, the aging step of TinyLFU.
+func (s *Sketch) reset() {
+	for row := range s.rows {
+		for i := range s.rows[row] {
+			s.rows[row][i] >>= 1
+		}
+	}
+	s.increments /= 2
+}
@@ -0,0 +1,46 @@
+package tinylfu
+
+import "testing"
+
+func TestSketch_CountsOccurrences(t *testing.T) {
+	s := New(1024)
+
+	for i := 1; i <= 3; i++ {
+		if got := s.Increment(42); got != i {
+			t.Fatalf("expected estimate %d after %d increments, got %d", i, i, got)
+		}
+	}
+	if got := s.Estimate(42); got != 3 {
+		t.Fatalf("expected estimate 3, got %d", got)
+	}
+	if got := s.Estimate(7); got != 0 {
+		t.Fatalf("expected unseen key to have estimate 0, got %d", got)
+	}
+}
+
+func TestSketch_SaturatesAtMaxCount(t *testing.T) {
+	s := New(1024)
+
+	for range MaxCount + 5 {
+		s.Increment(42)
+	}
+	if got := s.Estimate(42); got != MaxCount {
+		t.Fatalf("expected estimate to saturate at %d, got %d", MaxCount, got)
+	}
+}
+
+func TestSketch_HalvesCountersAfterResetInterval(t *testing.T) {
+	s := New(16)
+
+	for range 8 {
+		s.Increment(42)
+	}
+	// Fill up the rest of the reset interval with other keys.
+	for i := range s.resetAt - 8 {
+		s.Increment(uint64(1000 + i))
+	}
+
+	if got := s.Estimate(42); got < 4 || got > 7 {
+		t.Fatalf("expected estimate to be about halved from 8, got %d", got)
+	}
+}
@@ -0,0 +1,99 @@
+package tokenbucket
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits a rate of tokens per second, e.g. bytes. A bucket holds at most one second worth of tokens.
+// Takers go into debt when there aren't enough tokens, so concurrent takers wait in turn instead of racing for them.
+type Bucket struct {
+	mu     sync.Mutex
+	rate   float64 // 0 disables the limit.
+	tokens float64
+	last   time.Time
+}
+
+// Creates a full bucket refilled at rate tokens per second. A rate of 0 or below disables the limit.
+func New(rate int64) *Bucket {
+	b := &Bucket{last: time.Now()}
+	b.SetRate(rate)
+	return b
+}
+
+// Changes the rate, keeping the tokens the bucket already holds.
+func (b *Bucket) SetRate(rate int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	newRate := max(float64(rate), 0)
+	if newRate == b.rate {
+		return
+	}
+	b.refill(time.Now())
+	if b.rate == 0 {
+		b.tokens = newRate // A bucket that wasn't limited starts full.
+	}
+	b.rate = newRate
+	b.tokens = min(b.tokens, b.rate)
+}
+
+func (b *Bucket) Rate() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.rate)
+}
+
+// The most tokens that can be taken without waiting longer than a second, at least 1.
+func (b *Bucket) Burst() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == 0 {
+		return 0
+	}
+	return max(int(b.rate), 1)
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = min(b.tokens+elapsed.Seconds()*b.rate, b.rate)
+	}
+	b.last = now
+}
+
+// Takes n tokens and returns how long the caller has to wait until they would have been available.
+func (b *Bucket) Reserve(n int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.rate == 0 {
+		return 0
+	}
+
+	b.refill(time.Now())
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Takes n tokens, blocking until they are available or ctx is done.
+func (b *Bucket) Wait(ctx context.Context, n int) (time.Duration, error) {
+	wait := b.Reserve(n)
+	return wait, sleep(ctx, wait)
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
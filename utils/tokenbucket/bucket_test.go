@@ -0,0 +1,80 @@
+package tokenbucket
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestBucketReserve(t *testing.T) {
+	b := New(1000)
+
+	if wait := b.Reserve(1000); wait != 0 {
+		t.Fatalf("expected a full bucket to hand out a second worth of tokens, waited %v", wait)
+	}
+	wait := b.Reserve(500)
+	if wait < 450*time.Millisecond || wait > 500*time.Millisecond {
+		t.Fatalf("expected to wait about 500ms for 500 more tokens, got %v", wait)
+	}
+	// The next taker waits behind the debt of the previous one.
+	if next := b.Reserve(500); next < wait+450*time.Millisecond {
+		t.Fatalf("expected the next taker to wait behind the previous one, got %v", next)
+	}
+}
+
+func TestBucketUnlimited(t *testing.T) {
+	b := New(0)
+	if wait := b.Reserve(1 << 30); wait != 0 {
+		t.Fatalf("expected an unlimited bucket not to wait, got %v", wait)
+	}
+	if burst := b.Burst(); burst != 0 {
+		t.Fatalf("expected an unlimited bucket to have no burst, got %d", burst)
+	}
+
+	b.SetRate(100)
+	if wait := b.Reserve(100); wait != 0 {
+		t.Fatalf("expected a bucket that just got limited to start full, waited %v", wait)
+	}
+	if wait := b.Reserve(100); wait == 0 {
+		t.Fatal("expected the limit to apply once the bucket is empty")
+	}
+}
+
+func TestReaderLimitsThroughput(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 3000)
+	var waited time.Duration
+	r := NewReader(context.Background(), bytes.NewReader(data), func(d time.Duration) { waited += d }, New(10_000), nil, New(20_000))
+
+	start := time.Now()
+	read, err := io.ReadAll(r)
+	if err != nil || len(read) != len(data) {
+		t.Fatalf("expected to read %d bytes, got %d: %v", len(data), len(read), err)
+	}
+	if waited != 0 || time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("expected a read within the burst not to wait, waited %v", waited)
+	}
+
+	// 3000 bytes at 2000 bytes per second take a full bucket and another half second.
+	r = NewReader(context.Background(), bytes.NewReader(data), func(d time.Duration) { waited += d }, New(2000))
+	start = time.Now()
+	if _, err := io.ReadAll(r); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 450*time.Millisecond || waited == 0 {
+		t.Fatalf("expected the reader to be held back for about 500ms, took %v", elapsed)
+	}
+}
+
+func TestReaderStopsWithContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	b := New(10)
+	b.Reserve(10)
+	r := NewReader(ctx, bytes.NewReader([]byte("some data")), nil, b)
+	if _, err := io.ReadAll(r); err != context.Canceled {
+		t.Fatalf("expected %v, got %v", context.Canceled, err)
+	}
+}
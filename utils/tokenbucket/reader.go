@@ -0,0 +1,54 @@
+package tokenbucket
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Limits the throughput of a reader to the rate of every given bucket. Nil buckets are ignored.
+type Reader struct {
+	ctx     context.Context
+	r       io.Reader
+	buckets []*Bucket
+	onWait  func(time.Duration)
+}
+
+// Creates a reader limited by the buckets. onWait, if not nil, is called with the time every read was held back.
+func NewReader(ctx context.Context, r io.Reader, onWait func(time.Duration), buckets ...*Bucket) *Reader {
+	limited := make([]*Bucket, 0, len(buckets))
+	for _, bucket := range buckets {
+		if bucket != nil {
+			limited = append(limited, bucket)
+		}
+	}
+	return &Reader{ctx: ctx, r: r, buckets: limited, onWait: onWait}
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	// Reads are kept to a single second of the slowest bucket, so the throughput stays smooth.
+	for _, bucket := range r.buckets {
+		if burst := bucket.Burst(); burst > 0 && len(p) > burst {
+			p = p[:burst]
+		}
+	}
+
+	n, err := r.r.Read(p)
+	if n <= 0 {
+		return n, err
+	}
+
+	var wait time.Duration
+	for _, bucket := range r.buckets {
+		wait = max(wait, bucket.Reserve(n))
+	}
+	if wait > 0 {
+		if r.onWait != nil {
+			r.onWait(wait)
+		}
+		if sleepErr := sleep(r.ctx, wait); sleepErr != nil {
+			return n, sleepErr
+		}
+	}
+	return n, err
+}
@@ -52,6 +52,19 @@ func New(cfg *config.Config, sessions *coreauth.SessionManager, users *stores.Us
 			&version.VersionEndpoint{},
 			&cacheEndpoint.StatusEndpoint{},
 			&cacheEndpoint.ClearEndpoint{},
+			&cacheEndpoint.PurgeEndpoint{},
+			&cacheEndpoint.EntriesEndpoint{},
+			&cacheEndpoint.EntryEndpoint{},
+			&cacheEndpoint.EntryBodyEndpoint{},
+			&cacheEndpoint.EntryPinEndpoint{},
+			&cacheEndpoint.EntryExtendEndpoint{},
+			&cacheEndpoint.EntryRevalidateEndpoint{},
+			&cacheEndpoint.ExportEndpoint{},
+			&cacheEndpoint.ImportEndpoint{},
+			&cacheEndpoint.PrefetchEndpoint{},
+			&cacheEndpoint.PeerEntryEndpoint{},
+			&cacheEndpoint.PeerFetchEndpoint{},
+			&cacheEndpoint.CircuitBreakersEndpoint{},
 			&metrics.AllMetricsEndpoint{},
 			&metrics.CacheMetricsEndpoint{},
 			&metrics.RequestsMetricsEndpoint{},
@@ -2,17 +2,35 @@
 package apitypes
 
 import (
+	"context"
+	"io"
 	"net/http"
 	"reservoir/cache"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
 	"reservoir/config"
 	"reservoir/db/models"
 	"reservoir/db/stores"
 	"reservoir/webserver/auth"
+	"time"
 )
 
 type CacheController interface {
 	CacheStats() cache.Stats
 	ClearCache() error
+	Purge(filter cachectl.PurgeFilter) (int, error)
+	ListEntries(query cachectl.EntryQuery) (cachectl.EntryPage, error)
+	GetEntry(key string) (cachectl.EntryInfo, error)
+	OpenEntryBody(key string) (cachectl.EntryInfo, io.ReadCloser, error)
+	PinEntry(key string, pinned bool) (cachectl.EntryInfo, error)
+	ExtendEntryTTL(key string, extension time.Duration) (cachectl.EntryInfo, error)
+	RevalidateEntry(ctx context.Context, key string) (cachectl.RevalidationResult, error)
+	Prefetch(ctx context.Context, urls []string, concurrency int, report func(cachectl.PrefetchResult))
+	ExportBundle(w io.Writer, format bundle.Format, filter cachectl.ExportFilter) (cachectl.ExportResult, error)
+	ImportBundle(r io.Reader, opts cachectl.ImportOptions) (cachectl.ImportResult, error)
+	ExportPeerEntry(w io.Writer, baseKey string, secret string, header http.Header) error
+	FetchForPeer(ctx context.Context, w io.Writer, rawURL string, secret string, header http.Header) error
+	CircuitBreakers() []cachectl.CircuitBreakerInfo
 }
 
 type Context struct {
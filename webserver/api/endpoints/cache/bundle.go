@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"errors"
+	"log/slog"
+	"mime"
+	"net/http"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+	"time"
+)
+
+// Downloads the cache entries as a bundle archive. Supports the query parameters format (tar or tar.zst), host, prefix and tag.
+type ExportEndpoint struct{}
+
+func (e *ExportEndpoint) Path() string {
+	return "/cache/export"
+}
+
+func (e *ExportEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodGet,
+			Func:          e.Get,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+func (e *ExportEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	values := r.URL.Query()
+	format, err := bundle.ParseFormat(values.Get("format"))
+	if err != nil {
+		apihttp.BadRequest(w, err.Error())
+		return
+	}
+	filter := cachectl.ExportFilter{
+		Host:   values.Get("host"),
+		Prefix: values.Get("prefix"),
+		Tag:    values.Get("tag"),
+	}
+
+	fileName := "reservoir-cache-" + time.Now().Format("2006-01-02") + format.Extension()
+	header := w.Header()
+	header.Set("Content-Type", format.ContentType())
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+
+	// The filter is checked before anything is written, later errors can only cut the archive short.
+	if _, err := ctx.Cache.ExportBundle(w, format, filter); err != nil {
+		if errors.Is(err, cachectl.ErrInvalidExportFilter) {
+			header.Del("Content-Disposition")
+			apihttp.BadRequest(w, err.Error())
+			return
+		}
+		slog.Error("Failed to export cache entries", "error", err)
+	}
+}
+
+// Stores the entries of a bundle archive sent as the request body in the cache.
+// The optional query parameter ttl, e.g. "24h", rewrites the expiry of every imported entry.
+type ImportEndpoint struct{}
+
+func (e *ImportEndpoint) Path() string {
+	return "/cache/import"
+}
+
+func (e *ImportEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodPost,
+			Func:          e.Post,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+func (e *ImportEndpoint) Post(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	var opts cachectl.ImportOptions
+	if value := r.URL.Query().Get("ttl"); value != "" {
+		ttl, err := time.ParseDuration(value)
+		if err != nil || ttl <= 0 {
+			apihttp.BadRequest(w, "ttl must be a positive duration, e.g. 24h")
+			return
+		}
+		opts.TTL = ttl
+	}
+
+	result, err := ctx.Cache.ImportBundle(r.Body, opts)
+	if err != nil {
+		if errors.Is(err, bundle.ErrInvalidBundle) || errors.Is(err, bundle.ErrChecksumMismatch) {
+			apihttp.BadRequest(w, err.Error())
+			return
+		}
+		slog.Error("Failed to import cache entries", "error", err)
+		apihttp.InternalServerError(w)
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, result)
+}
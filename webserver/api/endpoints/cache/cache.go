@@ -30,6 +30,7 @@ type statusResponse struct {
 	Bytes          int64            `json:"bytes"`
 	MaxBytes       int64            `json:"max_bytes"`
 	MemoryCapBytes *int64           `json:"memory_cap_bytes,omitempty"`
+	DedupBytes     int64            `json:"dedup_bytes"`
 }
 
 func (e *StatusEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
@@ -41,10 +42,11 @@ func (e *StatusEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitype
 	cacheType := ctx.Config.Cache.Type.Read()
 
 	resp := statusResponse{
-		Type:     cacheType,
-		Entries:  stats.Entries,
-		Bytes:    stats.Bytes,
-		MaxBytes: stats.MaxBytes,
+		Type:       cacheType,
+		Entries:    stats.Entries,
+		Bytes:      stats.Bytes,
+		MaxBytes:   stats.MaxBytes,
+		DedupBytes: stats.DedupBytes,
 	}
 	if cacheType == config.CacheTypeMemory || cacheType == config.CacheTypeHybrid {
 		resp.MemoryCapBytes = &stats.MemoryCapBytes
@@ -0,0 +1,99 @@
+package cache
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reservoir/cache/bundle"
+	"reservoir/cachectl"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+	"strings"
+)
+
+// Serves a fresh cache entry to another instance of the cluster as a single entry tar bundle.
+// The path holds the base cache key of the URL, the variant is picked from the request's Accept-Encoding.
+// Peers authenticate with the cluster secret as a bearer token instead of a session.
+type PeerEntryEndpoint struct{}
+
+func (e *PeerEntryEndpoint) Path() string {
+	return "/cluster/entries/{key}"
+}
+
+func (e *PeerEntryEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method: http.MethodGet,
+			Func:   e.Get,
+		},
+	}
+}
+
+func (e *PeerEntryEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	w.Header().Set("Content-Type", bundle.FormatTar.ContentType())
+	err := ctx.Cache.ExportPeerEntry(w, r.PathValue("key"), secret, r.Header)
+	switch {
+	case err == nil:
+	case errors.Is(err, cachectl.ErrPeerUnauthorized):
+		w.Header().Del("Content-Type")
+		apihttp.Error(w, "Invalid cluster secret", http.StatusUnauthorized)
+	case errors.Is(err, cachectl.ErrClusterDisabled):
+		w.Header().Del("Content-Type")
+		apihttp.Error(w, "Cluster mode is disabled", http.StatusNotFound)
+	default:
+		w.Header().Del("Content-Type")
+		writeEntryError(w, err)
+	}
+}
+
+// Fetches a URL through the cache for another instance of a shard mode cluster, as the owner of its key, and
+// answers with the entry as a single entry tar bundle. The URL is passed as the url query parameter.
+// A response that can't be cached is answered with 409 and an upstream failure with 502,
+// the other instance then fetches it from upstream itself.
+type PeerFetchEndpoint struct{}
+
+func (e *PeerFetchEndpoint) Path() string {
+	return "/cluster/fetch"
+}
+
+func (e *PeerFetchEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method: http.MethodGet,
+			Func:   e.Get,
+		},
+	}
+}
+
+func (e *PeerFetchEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	secret, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	w.Header().Set("Content-Type", bundle.FormatTar.ContentType())
+	err := ctx.Cache.FetchForPeer(r.Context(), w, r.URL.Query().Get("url"), secret, r.Header)
+	if err == nil {
+		return
+	}
+
+	w.Header().Del("Content-Type")
+	switch {
+	case errors.Is(err, cachectl.ErrPeerUnauthorized):
+		apihttp.Error(w, "Invalid cluster secret", http.StatusUnauthorized)
+	case errors.Is(err, cachectl.ErrClusterDisabled):
+		apihttp.Error(w, "Shard mode is disabled", http.StatusNotFound)
+	case errors.Is(err, cachectl.ErrInvalidPrefetchURL):
+		apihttp.BadRequest(w, err.Error())
+	case errors.Is(err, cachectl.ErrPeerFetchNotCacheable):
+		apihttp.Error(w, "Response is not cacheable", http.StatusConflict)
+	default:
+		slog.Error("Failed to fetch URL for cluster peer", "url", r.URL.Query().Get("url"), "error", err)
+		apihttp.Error(w, "Error fetching resource", http.StatusBadGateway)
+	}
+}
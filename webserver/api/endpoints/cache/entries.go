@@ -0,0 +1,197 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	cachecore "reservoir/cache"
+	"reservoir/cachectl"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+	"strconv"
+)
+
+// Lists the cached entries. Supports the query parameters host, search, tag, tier, stale, sort, order, offset and limit.
+type EntriesEndpoint struct{}
+
+func (e *EntriesEndpoint) Path() string {
+	return "/cache/entries"
+}
+
+func (e *EntriesEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:       http.MethodGet,
+			Func:         e.Get,
+			RequiresAuth: true,
+		},
+	}
+}
+
+func (e *EntriesEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	query, err := parseEntryQuery(r.URL.Query())
+	if err != nil {
+		apihttp.BadRequest(w, err.Error())
+		return
+	}
+
+	page, err := ctx.Cache.ListEntries(query)
+	if err != nil {
+		if errors.Is(err, cachectl.ErrInvalidEntryQuery) {
+			apihttp.BadRequest(w, err.Error())
+			return
+		}
+		slog.Error("Failed to list cache entries", "error", err)
+		apihttp.InternalServerError(w)
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, page)
+}
+
+func parseEntryQuery(values url.Values) (cachectl.EntryQuery, error) {
+	query := cachectl.EntryQuery{
+		Host:   values.Get("host"),
+		Search: values.Get("search"),
+		Tag:    values.Get("tag"),
+		Tier:   cachecore.Tier(values.Get("tier")),
+		Sort:   values.Get("sort"),
+	}
+
+	switch values.Get("order") {
+	case "", "asc":
+	case "desc":
+		query.Descending = true
+	default:
+		return query, errors.New("order must be asc or desc")
+	}
+
+	if value := values.Get("stale"); value != "" {
+		stale, err := strconv.ParseBool(value)
+		if err != nil {
+			return query, errors.New("stale must be true or false")
+		}
+		query.Stale = &stale
+	}
+
+	for name, target := range map[string]*int{"offset": &query.Offset, "limit": &query.Limit} {
+		value := values.Get(name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return query, fmt.Errorf("%s must be a number", name)
+		}
+		*target = parsed
+	}
+
+	return query, nil
+}
+
+// Returns the details of a single cache entry.
+type EntryEndpoint struct{}
+
+func (e *EntryEndpoint) Path() string {
+	return "/cache/entries/{key}"
+}
+
+func (e *EntryEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:       http.MethodGet,
+			Func:         e.Get,
+			RequiresAuth: true,
+		},
+	}
+}
+
+func (e *EntryEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	entry, err := ctx.Cache.GetEntry(r.PathValue("key"))
+	if err != nil {
+		writeEntryError(w, err)
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, entry)
+}
+
+// Downloads the stored body of a cache entry.
+type EntryBodyEndpoint struct{}
+
+func (e *EntryBodyEndpoint) Path() string {
+	return "/cache/entries/{key}/body"
+}
+
+func (e *EntryBodyEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:       http.MethodGet,
+			Func:         e.Get,
+			RequiresAuth: true,
+		},
+	}
+}
+
+func (e *EntryBodyEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	entry, body, err := ctx.Cache.OpenEntryBody(r.PathValue("key"))
+	if err != nil {
+		writeEntryError(w, err)
+		return
+	}
+	defer body.Close()
+
+	header := w.Header()
+	header.Set("Content-Type", "application/octet-stream")
+	if contentType := entry.Header.Get("Content-Type"); contentType != "" {
+		header.Set("Content-Type", contentType)
+	}
+	if contentEncoding := entry.Header.Get("Content-Encoding"); contentEncoding != "" {
+		header.Set("Content-Encoding", contentEncoding)
+	}
+	header.Set("Content-Length", strconv.FormatInt(entry.Size, 10))
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": entryFileName(entry.URL)}))
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := io.Copy(w, body); err != nil {
+		slog.Error("Error writing cache entry body", "key", entry.Key, "error", err)
+	}
+}
+
+func entryFileName(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "body"
+	}
+	name := path.Base(u.Path)
+	if name == "/" || name == "." {
+		return "body"
+	}
+	return name
+}
+
+func writeEntryError(w http.ResponseWriter, err error) {
+	if errors.Is(err, cachecore.ErrCacheEntryNotFound) {
+		apihttp.Error(w, "Cache entry not found", http.StatusNotFound)
+		return
+	}
+	slog.Error("Failed to read cache entry", "error", err)
+	apihttp.InternalServerError(w)
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reservoir/cachectl"
+	"reservoir/utils/duration"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+)
+
+// Pins a cache entry with PUT and unpins it with DELETE. Pinned entries are never evicted or expired.
+type EntryPinEndpoint struct{}
+
+func (e *EntryPinEndpoint) Path() string {
+	return "/cache/entries/{key}/pin"
+}
+
+func (e *EntryPinEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodPut,
+			Func:          e.Put,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+		{
+			Method:        http.MethodDelete,
+			Func:          e.Delete,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+func (e *EntryPinEndpoint) Put(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	e.setPinned(w, r, ctx, true)
+}
+
+func (e *EntryPinEndpoint) Delete(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	e.setPinned(w, r, ctx, false)
+}
+
+func (e *EntryPinEndpoint) setPinned(w http.ResponseWriter, r *http.Request, ctx apitypes.Context, pinned bool) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	entry, err := ctx.Cache.PinEntry(r.PathValue("key"), pinned)
+	if err != nil {
+		writeEntryError(w, err)
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, entry)
+}
+
+// Pushes the expiry of a cache entry back, e.g. {"extension": "24h"}.
+type EntryExtendEndpoint struct{}
+
+func (e *EntryExtendEndpoint) Path() string {
+	return "/cache/entries/{key}/extend"
+}
+
+func (e *EntryExtendEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodPost,
+			Func:          e.Post,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+type extendRequest struct {
+	Extension duration.Duration `json:"extension"`
+}
+
+func (e *EntryExtendEndpoint) Post(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+	if !apihttp.RequireJSONContentType(w, r) {
+		return
+	}
+
+	var req extendRequest
+	if !apihttp.DecodeJSON(w, r, &req) {
+		return
+	}
+
+	entry, err := ctx.Cache.ExtendEntryTTL(r.PathValue("key"), req.Extension.Cast())
+	if err != nil {
+		if errors.Is(err, cachectl.ErrInvalidTTLExtension) {
+			apihttp.BadRequest(w, err.Error())
+			return
+		}
+		writeEntryError(w, err)
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, entry)
+}
+
+// Revalidates a cache entry against upstream right away, even if it is still fresh.
+type EntryRevalidateEndpoint struct{}
+
+func (e *EntryRevalidateEndpoint) Path() string {
+	return "/cache/entries/{key}/revalidate"
+}
+
+func (e *EntryRevalidateEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodPost,
+			Func:          e.Post,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+func (e *EntryRevalidateEndpoint) Post(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	result, err := ctx.Cache.RevalidateEntry(r.Context(), r.PathValue("key"))
+	if err != nil {
+		switch {
+		case errors.Is(err, cachectl.ErrEntryNotRevalidatable):
+			apihttp.Error(w, err.Error(), http.StatusConflict)
+		case errors.Is(err, cachectl.ErrRevalidationFailed):
+			slog.Warn("Failed to revalidate cache entry", "key", r.PathValue("key"), "error", err)
+			apihttp.Error(w, err.Error(), http.StatusBadGateway)
+		default:
+			writeEntryError(w, err)
+		}
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, result)
+}
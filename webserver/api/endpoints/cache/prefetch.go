@@ -0,0 +1,243 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"reservoir/cachectl"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+	"reservoir/webserver/streaming"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultPrefetchConcurrency = 4
+	maxPrefetchConcurrency     = 32
+	maxPrefetchURLs            = 10000
+	maxPrefetchManifestBytes   = 8 << 20
+)
+
+var (
+	errNoPrefetchURLs       = errors.New("no URLs to prefetch")
+	errTooManyPrefetchURLs  = errors.New("too many URLs to prefetch")
+	errInvalidConcurrency   = errors.New("concurrency must be a positive number")
+	errPrefetchBodyTooLarge = errors.New("prefetch manifest is too large")
+)
+
+// Warms the cache by fetching a list of URLs through the proxy, streaming the progress over SSE.
+// The body is either JSON ({"urls": [...], "concurrency": 4}) or a plain text manifest with one URL per line.
+// Prefetching stops when the client disconnects.
+type PrefetchEndpoint struct{}
+
+func (e *PrefetchEndpoint) Path() string {
+	return "/cache/prefetch"
+}
+
+func (e *PrefetchEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodPost,
+			Func:          e.Post,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+type prefetchRequest struct {
+	URLs        []string `json:"urls"`
+	Concurrency int      `json:"concurrency"`
+}
+
+type prefetchEvent struct {
+	Type      string                   `json:"type"` // "result" for every finished URL, "done" once all are finished.
+	Result    *cachectl.PrefetchResult `json:"result,omitempty"`
+	Completed int                      `json:"completed"`
+	Failed    int                      `json:"failed"`
+	Total     int                      `json:"total"`
+}
+
+// Collects the prefetch results until the SSE stream writes them out.
+type prefetchProgress struct {
+	mu        sync.Mutex
+	pending   []cachectl.PrefetchResult
+	completed int
+	failed    int
+	total     int
+	done      bool
+	finish    context.CancelFunc
+}
+
+func (p *prefetchProgress) report(result cachectl.PrefetchResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pending = append(p.pending, result)
+}
+
+func (p *prefetchProgress) markDone() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.done = true
+}
+
+func (e *PrefetchEndpoint) Tick(w http.ResponseWriter, writeStream func([]byte) error, progress *prefetchProgress) error {
+	progress.mu.Lock()
+	pending := progress.pending
+	progress.pending = nil
+	done := progress.done
+	progress.mu.Unlock()
+
+	for _, result := range pending {
+		progress.completed++
+		if result.Error != "" {
+			progress.failed++
+		}
+		if err := writePrefetchEvent(writeStream, prefetchEvent{
+			Type:      "result",
+			Result:    &result,
+			Completed: progress.completed,
+			Failed:    progress.failed,
+			Total:     progress.total,
+		}); err != nil {
+			return err
+		}
+	}
+
+	if done {
+		if err := writePrefetchEvent(writeStream, prefetchEvent{
+			Type:      "done",
+			Completed: progress.completed,
+			Failed:    progress.failed,
+			Total:     progress.total,
+		}); err != nil {
+			return err
+		}
+		progress.finish()
+	}
+	return nil
+}
+
+func writePrefetchEvent(writeStream func([]byte) error, event prefetchEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return writeStream(data)
+}
+
+func (e *PrefetchEndpoint) Post(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		slog.Error("response writer does not support flushing, so can't use SSE")
+		apihttp.Error(w, "Streaming Unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	req, err := parsePrefetchRequest(r)
+	if err != nil {
+		apihttp.BadRequest(w, err.Error())
+		return
+	}
+
+	streamCtx, finish := context.WithCancel(r.Context())
+	defer finish()
+
+	progress := &prefetchProgress{total: len(req.URLs), finish: finish}
+	prefetched := make(chan struct{})
+	go func() {
+		defer close(prefetched)
+		ctx.Cache.Prefetch(streamCtx, req.URLs, req.Concurrency, progress.report)
+		progress.markDone()
+	}()
+
+	slog.Info("Prefetching URLs", "count", len(req.URLs), "concurrency", req.Concurrency)
+
+	sse := streaming.NewSseStream(w.Header(), w, flusher, 1*time.Second, 250*time.Millisecond, streamCtx, e, progress)
+	defer sse.Close()
+	err = sse.Start()
+	finish()
+	<-prefetched
+	if err != nil {
+		slog.Error("SSE stream failed", "error", err)
+		return
+	}
+
+	slog.Info("Prefetch finished", "completed", progress.completed, "failed", progress.failed, "total", progress.total)
+}
+
+func parsePrefetchRequest(r *http.Request) (prefetchRequest, error) {
+	body := http.MaxBytesReader(nil, r.Body, maxPrefetchManifestBytes)
+
+	var req prefetchRequest
+	if apihttp.IsJSONContentType(r.Header.Get("Content-Type")) {
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+				return req, errPrefetchBodyTooLarge
+			}
+			return req, errors.New("invalid JSON")
+		}
+	} else {
+		urls, err := parsePrefetchManifest(body)
+		if err != nil {
+			return req, err
+		}
+		req.URLs = urls
+
+		if value := r.URL.Query().Get("concurrency"); value != "" {
+			concurrency, err := strconv.Atoi(value)
+			if err != nil {
+				return req, errInvalidConcurrency
+			}
+			req.Concurrency = concurrency
+		}
+	}
+
+	if len(req.URLs) == 0 {
+		return req, errNoPrefetchURLs
+	}
+	if len(req.URLs) > maxPrefetchURLs {
+		return req, errTooManyPrefetchURLs
+	}
+
+	if req.Concurrency < 0 {
+		return req, errInvalidConcurrency
+	}
+	if req.Concurrency == 0 {
+		req.Concurrency = defaultPrefetchConcurrency
+	}
+	req.Concurrency = min(req.Concurrency, maxPrefetchConcurrency)
+
+	return req, nil
+}
+
+// Reads a manifest with one URL per line. Blank lines and lines starting with # are ignored.
+func parsePrefetchManifest(body io.Reader) ([]string, error) {
+	urls := make([]string, 0)
+	scanner := bufio.NewScanner(body)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		urls = append(urls, line)
+	}
+	if err := scanner.Err(); err != nil {
+		if _, ok := errors.AsType[*http.MaxBytesError](err); ok {
+			return nil, errPrefetchBodyTooLarge
+		}
+		return nil, err
+	}
+	return urls, nil
+}
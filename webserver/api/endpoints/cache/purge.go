@@ -0,0 +1,59 @@
+package cache
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"reservoir/cachectl"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+)
+
+// Removes the cache entries matching a URL, host, URL prefix or regex.
+type PurgeEndpoint struct{}
+
+func (e *PurgeEndpoint) Path() string {
+	return "/cache/purge"
+}
+
+func (e *PurgeEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:        http.MethodPost,
+			Func:          e.Post,
+			RequiresAuth:  true,
+			RequiresAdmin: true,
+		},
+	}
+}
+
+type purgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+func (e *PurgeEndpoint) Post(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+	if !apihttp.RequireJSONContentType(w, r) {
+		return
+	}
+
+	var filter cachectl.PurgeFilter
+	if !apihttp.DecodeJSON(w, r, &filter) {
+		return
+	}
+
+	purged, err := ctx.Cache.Purge(filter)
+	if err != nil {
+		if errors.Is(err, cachectl.ErrInvalidPurgeFilter) {
+			apihttp.BadRequest(w, err.Error())
+			return
+		}
+		slog.Error("Failed to purge cache entries", "error", err)
+		apihttp.InternalServerError(w)
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, purgeResponse{Purged: purged})
+}
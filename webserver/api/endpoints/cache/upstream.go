@@ -0,0 +1,32 @@
+package cache
+
+import (
+	"net/http"
+	"reservoir/webserver/api/apihttp"
+	"reservoir/webserver/api/apitypes"
+)
+
+// Lists the circuit breakers of the upstream hosts that failed, with their state.
+type CircuitBreakersEndpoint struct{}
+
+func (e *CircuitBreakersEndpoint) Path() string {
+	return "/upstream/breakers"
+}
+
+func (e *CircuitBreakersEndpoint) EndpointMethods() []apitypes.EndpointMethod {
+	return []apitypes.EndpointMethod{
+		{
+			Method:       http.MethodGet,
+			Func:         e.Get,
+			RequiresAuth: true,
+		},
+	}
+}
+
+func (e *CircuitBreakersEndpoint) Get(w http.ResponseWriter, r *http.Request, ctx apitypes.Context) {
+	if !requireCacheController(w, ctx) {
+		return
+	}
+
+	apihttp.WriteJSON(w, http.StatusOK, ctx.Cache.CircuitBreakers())
+}
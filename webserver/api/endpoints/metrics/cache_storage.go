@@ -15,10 +15,11 @@ func collectCacheStorage(ctx apitypes.Context) {
 	stats := ctx.Cache.CacheStats()
 	cacheType := ctx.Config.Cache.Type.Read()
 	storage := runtimeMetrics.CacheStorageMetrics{
-		Type:     string(cacheType),
-		Entries:  stats.Entries,
-		Bytes:    stats.Bytes,
-		MaxBytes: stats.MaxBytes,
+		Type:       string(cacheType),
+		Entries:    stats.Entries,
+		Bytes:      stats.Bytes,
+		MaxBytes:   stats.MaxBytes,
+		DedupBytes: stats.DedupBytes,
 	}
 
 	if cacheType == config.CacheTypeMemory || cacheType == config.CacheTypeHybrid {
@@ -11,7 +11,9 @@ import (
 	"testing"
 )
 
+// Only implements what the metrics endpoints use, the other methods of the controller panic.
 type fakeCacheController struct {
+	apitypes.CacheController
 	stats cachecore.Stats
 }
 
@@ -19,10 +21,6 @@ func (f *fakeCacheController) CacheStats() cachecore.Stats {
 	return f.stats
 }
 
-func (f *fakeCacheController) ClearCache() error {
-	return nil
-}
-
 func useFreshMetrics(t *testing.T) {
 	t.Helper()
 